@@ -1,20 +1,57 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"regexp"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultMaxClockSkew is how far the signing time of a request may be from the
+// server's clock unless configured otherwise
+const DefaultMaxClockSkew = 15 * time.Minute
+
+var (
+	// ErrRequestTimeTooSkewed is returned for requests signed too far from
+	// the server's clock
+	ErrRequestTimeTooSkewed = errors.New("the difference between the request time and the current time is too large")
+
+	// ErrAuthorizationHeaderMalformed is returned for credential scopes that
+	// don't match the request or this server
+	ErrAuthorizationHeaderMalformed = errors.New("the authorization header is malformed")
+)
+
+type signingServiceKey struct{}
+
+// WithSigningService returns a context for requests that are signed for
+// another service than s3, such as sts
+func WithSigningService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, signingServiceKey{}, service)
+}
+
+// signingService returns the service requests must be signed for
+func signingService(ctx context.Context) string {
+	if service, ok := ctx.Value(signingServiceKey{}).(string); ok {
+		return service
+	}
+	return "s3"
+}
+
 // AuthProvider defines the interface for authentication
 type AuthProvider interface {
 	Authenticate(r *http.Request) error
+	VerifyPostPolicy(form *PostPolicySignature) (*Principal, error)
 	GetAccessKey() string
 	GetSecretKey() string
 	GetRegion() string
@@ -22,20 +59,61 @@ type AuthProvider interface {
 
 // AWSV4Auth implements AWS Signature Version 4 authentication
 type AWSV4Auth struct {
-	accessKey string
-	secretKey string
-	region    string
+	accessKey   string
+	secretKey   string
+	region      string
+	credentials *CredentialStore
+
+	// signatureV2 accepts legacy SigV2 requests alongside SigV4
+	signatureV2 bool
+
+	// maxClockSkew is how far X-Amz-Date may be from the server's clock
+	maxClockSkew time.Duration
+
+	// debug logs the server's side of every signature mismatch
+	debug bool
 }
 
-// NewAWSV4Auth creates a new AWS V4 auth provider
+// NewAWSV4Auth creates a new AWS V4 auth provider accepting a single key pair
 func NewAWSV4Auth(accessKey, secretKey, region string) *AWSV4Auth {
+	store := NewCredentialStore()
+	store.CreateUser("default", "default")
+	store.AddAccessKey("default", accessKey, secretKey)
+
+	return NewAWSV4AuthWithStore(store, accessKey, secretKey, region)
+}
+
+// NewAWSV4AuthWithStore creates a new AWS V4 auth provider that accepts every
+// enabled access key of the credential store. The configured key pair is
+// reported by GetAccessKey and GetSecretKey.
+func NewAWSV4AuthWithStore(store *CredentialStore, accessKey, secretKey, region string) *AWSV4Auth {
 	return &AWSV4Auth{
-		accessKey: accessKey,
-		secretKey: secretKey,
-		region:    region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		region:       region,
+		credentials:  store,
+		signatureV2:  true,
+		maxClockSkew: DefaultMaxClockSkew,
 	}
 }
 
+// SetDebug enables logging the canonical request and string to sign of
+// requests whose signature doesn't match
+func (a *AWSV4Auth) SetDebug(enabled bool) {
+	a.debug = enabled
+}
+
+// SetMaxClockSkew sets how far the signing time of a request may be from the
+// server's clock
+func (a *AWSV4Auth) SetMaxClockSkew(skew time.Duration) {
+	a.maxClockSkew = skew
+}
+
+// SetSignatureV2 enables or disables legacy SigV2 authentication
+func (a *AWSV4Auth) SetSignatureV2(enabled bool) {
+	a.signatureV2 = enabled
+}
+
 func (a *AWSV4Auth) GetAccessKey() string {
 	return a.accessKey
 }
@@ -48,62 +126,212 @@ func (a *AWSV4Auth) GetRegion() string {
 	return a.region
 }
 
-// Authenticate validates the AWS V4 signature
+// Authenticate validates the AWS V4 (or legacy V2) signature and, for temporary
+// credentials, the session token. On success the principal owning the access
+// key is attached to the request context.
 func (a *AWSV4Auth) Authenticate(r *http.Request) error {
-	// Extract authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return fmt.Errorf("missing authorization header")
+	if isSignatureV2(r) {
+		if !a.signatureV2 {
+			return fmt.Errorf("signature version 2 is disabled")
+		}
+		return a.authenticateV2(r)
 	}
 
-	// Parse AWS4-HMAC-SHA256 authorization header
-	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256") {
-		return fmt.Errorf("invalid authorization header format")
+	req, err := parseSignedRequest(r)
+	if err != nil {
+		return err
 	}
 
-	// Extract components from authorization header
-	authParts := parseAuthHeader(authHeader)
-	if authParts == nil {
-		return fmt.Errorf("invalid authorization header format")
+	// Temporary credentials carry their session token in a header, or in
+	// the query of a presigned URL
+	secretKey, principal, err := a.credentials.lookupCredentials(req.accessKey, req.token)
+	if err != nil {
+		return err
 	}
 
-	// Validate credential
-	credential := authParts["Credential"]
-	if credential == "" {
-		return fmt.Errorf("missing credential")
+	if err := a.validateScope(r, req); err != nil {
+		return err
 	}
 
-	credentialParts := strings.Split(credential, "/")
-	if len(credentialParts) != 5 {
-		return fmt.Errorf("invalid credential format")
+	switch req.algorithm {
+	case algorithmV4A:
+		if err := a.verifySignatureV4A(r, req, secretKey); err != nil {
+			return err
+		}
+	default:
+		// Calculate expected signature
+		expectedSignature, stringToSign, canonicalRequest, err := a.signV4(r, secretKey, req.credential, req.signedHeaders)
+		if err != nil {
+			return fmt.Errorf("failed to calculate signature: %v", err)
+		}
+
+		// Compare signatures in constant time
+		if !hmac.Equal([]byte(req.signature), []byte(expectedSignature)) {
+			return a.signatureMismatch(r, req.accessKey, req.signature, stringToSign, canonicalRequest)
+		}
+	}
+
+	// Handlers read the principal from the request they already hold
+	*r = *r.WithContext(WithPrincipal(r.Context(), principal))
+
+	return nil
+}
+
+const (
+	algorithmV4  = "AWS4-HMAC-SHA256"
+	algorithmV4A = "AWS4-ECDSA-P256-SHA256"
+
+	// amzDateFormat is the format of X-Amz-Date
+	amzDateFormat = "20060102T150405Z"
+
+	// maxPresignExpires is the longest validity of a presigned URL, 7 days
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// signedRequest holds the signature parameters of a SigV4 or SigV4A request,
+// taken from the Authorization header or the query of a presigned URL
+type signedRequest struct {
+	algorithm     string
+	accessKey     string
+	credential    string
+	signedHeaders string
+	signature     string
+	token         string
+	presigned     bool
+}
+
+// parseSignedRequest extracts the signature parameters of a request. The
+// expiry of presigned URLs is checked here.
+func parseSignedRequest(r *http.Request) (*signedRequest, error) {
+	req := &signedRequest{}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		req.algorithm, _, _ = strings.Cut(authHeader, " ")
+		if req.algorithm != algorithmV4 && req.algorithm != algorithmV4A {
+			return nil, fmt.Errorf("invalid authorization header format")
+		}
+
+		// Extract components from authorization header
+		authParts := parseAuthHeader(authHeader)
+		req.credential = authParts["Credential"]
+		req.signedHeaders = authParts["SignedHeaders"]
+		req.signature = authParts["Signature"]
+		req.token = r.Header.Get("X-Amz-Security-Token")
+	} else {
+		query := r.URL.Query()
+		if query.Get("X-Amz-Signature") == "" {
+			return nil, fmt.Errorf("missing authorization header")
+		}
+
+		req.algorithm = query.Get("X-Amz-Algorithm")
+		if req.algorithm != algorithmV4 && req.algorithm != algorithmV4A {
+			return nil, fmt.Errorf("unsupported X-Amz-Algorithm %q", req.algorithm)
+		}
+		req.credential = query.Get("X-Amz-Credential")
+		req.signedHeaders = query.Get("X-Amz-SignedHeaders")
+		req.signature = query.Get("X-Amz-Signature")
+		req.token = query.Get("X-Amz-Security-Token")
+		req.presigned = true
+
+		signedAt, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-Amz-Date")
+		}
+		seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		expires := time.Duration(seconds) * time.Second
+		if err != nil || expires <= 0 || expires > maxPresignExpires {
+			return nil, fmt.Errorf("invalid X-Amz-Expires")
+		}
+		if time.Now().After(signedAt.Add(expires)) {
+			return nil, fmt.Errorf("request has expired")
+		}
 	}
 
-	accessKey := credentialParts[0]
-	if accessKey != a.accessKey {
-		return fmt.Errorf("invalid access key")
+	// Validate credential
+	if req.credential == "" {
+		return nil, fmt.Errorf("missing credential")
 	}
 
-	// Extract signed headers and signature
-	signedHeaders := authParts["SignedHeaders"]
-	providedSignature := authParts["Signature"]
+	// SigV4 scopes are key/date/region/service/aws4_request, SigV4A scopes
+	// leave out the region
+	credentialParts := strings.Split(req.credential, "/")
+	if (req.algorithm == algorithmV4 && len(credentialParts) != 5) || (req.algorithm == algorithmV4A && len(credentialParts) != 4) {
+		return nil, fmt.Errorf("invalid credential format")
+	}
+	req.accessKey = credentialParts[0]
 
-	// Calculate expected signature
-	expectedSignature, err := a.calculateSignature(r, credential, signedHeaders)
+	return req, nil
+}
+
+// validateScope checks the signing time and credential scope of a request
+// against the server: the time must be within the clock skew window (or, for
+// presigned URLs, not in the future), the scope date must be the day of the
+// signing time, and region and service must be this server's.
+func (a *AWSV4Auth) validateScope(r *http.Request, req *signedRequest) error {
+	return a.checkScope(requestDate(r), signingService(r.Context()), req)
+}
+
+// checkScope checks a signing time and credential scope like validateScope,
+// for a request signed at date for service
+func (a *AWSV4Auth) checkScope(date, service string, req *signedRequest) error {
+	signedAt, err := parseRequestDate(date)
 	if err != nil {
-		return fmt.Errorf("failed to calculate signature: %v", err)
+		return fmt.Errorf("AWS authentication requires a valid Date or x-amz-date header")
+	}
+
+	now := time.Now()
+	if req.presigned {
+		if signedAt.After(now.Add(a.maxClockSkew)) {
+			return fmt.Errorf("%w; the request is not valid yet", ErrRequestTimeTooSkewed)
+		}
+	} else if skew := now.Sub(signedAt); skew > a.maxClockSkew || skew < -a.maxClockSkew {
+		return fmt.Errorf("%w; request time %s, server time %s", ErrRequestTimeTooSkewed,
+			signedAt.UTC().Format(amzDateFormat), now.UTC().Format(amzDateFormat))
 	}
 
-	// Compare signatures
-	if providedSignature != expectedSignature {
-		return fmt.Errorf("signature mismatch")
+	credentialParts := strings.Split(req.credential, "/")
+	if credentialParts[1] != signedAt.UTC().Format("20060102") {
+		return fmt.Errorf("%w; invalid credential date %q, date is not the same as X-Amz-Date", ErrAuthorizationHeaderMalformed, credentialParts[1])
+	}
+
+	// SigV4A scopes have no region, the region set is checked instead
+	scopeService := credentialParts[2]
+	if req.algorithm == algorithmV4 {
+		if region := credentialParts[2]; region != a.region {
+			return fmt.Errorf("%w; the region '%s' is wrong; expecting '%s'", ErrAuthorizationHeaderMalformed, region, a.region)
+		}
+		scopeService = credentialParts[3]
+	}
+	if scopeService != service {
+		return fmt.Errorf("%w; incorrect service '%s', this endpoint belongs to '%s'", ErrAuthorizationHeaderMalformed, scopeService, service)
 	}
 
 	return nil
 }
 
+// parseRequestDate parses X-Amz-Date, or an HTTP Date header
+func parseRequestDate(date string) (time.Time, error) {
+	if t, err := time.Parse(amzDateFormat, date); err == nil {
+		return t, nil
+	}
+	return http.ParseTime(date)
+}
+
+// requestDate returns the signing time of a request as sent by the client
+func requestDate(r *http.Request) string {
+	if date := r.Header.Get("X-Amz-Date"); date != "" {
+		return date
+	}
+	if date := r.URL.Query().Get("X-Amz-Date"); date != "" {
+		return date
+	}
+	return r.Header.Get("Date")
+}
+
 func parseAuthHeader(authHeader string) map[string]string {
-	// Extract the part after "AWS4-HMAC-SHA256 "
-	parts := strings.TrimPrefix(authHeader, "AWS4-HMAC-SHA256 ")
+	// Extract the part after the algorithm, such as "AWS4-HMAC-SHA256 "
+	_, parts, _ := strings.Cut(authHeader, " ")
 
 	result := make(map[string]string)
 
@@ -118,11 +346,18 @@ func parseAuthHeader(authHeader string) map[string]string {
 	return result
 }
 
-func (a *AWSV4Auth) calculateSignature(r *http.Request, credential, signedHeaders string) (string, error) {
+func (a *AWSV4Auth) calculateSignature(r *http.Request, secretKey, credential, signedHeaders string) (string, error) {
+	signature, _, _, err := a.signV4(r, secretKey, credential, signedHeaders)
+	return signature, err
+}
+
+// signV4 returns the SigV4 signature of a request along with the string to
+// sign and canonical request it was computed from
+func (a *AWSV4Auth) signV4(r *http.Request, secretKey, credential, signedHeaders string) (signature, stringToSign, canonicalRequest string, err error) {
 	// Parse credential
 	credentialParts := strings.Split(credential, "/")
 	if len(credentialParts) != 5 {
-		return "", fmt.Errorf("invalid credential format")
+		return "", "", "", fmt.Errorf("invalid credential format")
 	}
 
 	date := credentialParts[1]
@@ -131,23 +366,23 @@ func (a *AWSV4Auth) calculateSignature(r *http.Request, credential, signedHeader
 	terminator := credentialParts[4]
 
 	if terminator != "aws4_request" {
-		return "", fmt.Errorf("invalid credential terminator")
+		return "", "", "", fmt.Errorf("invalid credential terminator")
 	}
 
 	// Create canonical request
-	canonicalRequest := a.createCanonicalRequest(r, signedHeaders)
+	canonicalRequest, err = a.createCanonicalRequest(r, signedHeaders, service)
+	if err != nil {
+		return "", "", "", err
+	}
 
 	// Create string to sign
-	algorithm := "AWS4-HMAC-SHA256"
-	requestDateTime := r.Header.Get("X-Amz-Date")
-	if requestDateTime == "" {
-		requestDateTime = r.Header.Get("Date")
-	}
+	algorithm := algorithmV4
+	requestDateTime := requestDate(r)
 
 	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
 	hashedCanonicalRequest := sha256Hex(canonicalRequest)
 
-	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%s",
+	stringToSign = fmt.Sprintf("%s\n%s\n%s\n%s",
 		algorithm,
 		requestDateTime,
 		credentialScope,
@@ -155,26 +390,23 @@ func (a *AWSV4Auth) calculateSignature(r *http.Request, credential, signedHeader
 	)
 
 	// Calculate signing key
-	signingKey := a.getSigningKey(date, region, service)
+	signingKey := getSigningKey(secretKey, date, region, service)
 
 	// Calculate signature
-	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	signature = hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
 
-	return signature, nil
+	return signature, stringToSign, canonicalRequest, nil
 }
 
-func (a *AWSV4Auth) createCanonicalRequest(r *http.Request, signedHeaders string) string {
+func (a *AWSV4Auth) createCanonicalRequest(r *http.Request, signedHeaders, service string) (string, error) {
 	// HTTP method
 	method := r.Method
 
 	// Canonical URI
-	canonicalURI := r.URL.Path
-	if canonicalURI == "" {
-		canonicalURI = "/"
-	}
+	canonicalURI := canonicalPath(r.URL.Path, service)
 
 	// Canonical query string
-	canonicalQueryString := a.createCanonicalQueryString(r.URL.Query())
+	canonicalQueryString := a.createCanonicalQueryString(r.URL.RawQuery)
 
 	// Canonical headers
 	headerNames := strings.Split(signedHeaders, ";")
@@ -182,6 +414,15 @@ func (a *AWSV4Auth) createCanonicalRequest(r *http.Request, signedHeaders string
 
 	// Payload hash
 	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" && service != "s3" {
+		// Only S3 sends the payload hash as a header, other services
+		// (such as STS) sign the hash of the body
+		body, err := readBody(r)
+		if err != nil {
+			return "", err
+		}
+		payloadHash = sha256Hex(string(body))
+	}
 	if payloadHash == "" {
 		payloadHash = "UNSIGNED-PAYLOAD"
 	}
@@ -193,44 +434,128 @@ func (a *AWSV4Auth) createCanonicalRequest(r *http.Request, signedHeaders string
 		canonicalHeaders,
 		signedHeaders,
 		payloadHash,
-	)
+	), nil
 }
 
-func (a *AWSV4Auth) createCanonicalQueryString(values url.Values) string {
-	if len(values) == 0 {
-		return ""
+// maxSignedBodySize limits the bodies read to compute a payload hash
+const maxSignedBodySize = 1 << 20
+
+// readBody reads the request body and replaces it so handlers can read it
+// again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, fmt.Errorf("request body too large to sign")
 	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
 
-	var keys []string
-	for key := range values {
-		keys = append(keys, key)
+// canonicalPath returns the URI-encoded request path. S3 signs the path as
+// it is; other services sign it with dot segments and repeated slashes
+// removed.
+func canonicalPath(p, service string) string {
+	if p == "" {
+		return "/"
 	}
-	sort.Strings(keys)
+	if service != "s3" {
+		cleaned := path.Clean(p)
+		if strings.HasSuffix(p, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		p = cleaned
+	}
+	return uriEncode(p, false)
+}
 
-	var parts []string
-	for _, key := range keys {
-		for _, value := range values[key] {
-			parts = append(parts, fmt.Sprintf("%s=%s",
-				url.QueryEscape(key),
-				url.QueryEscape(value)))
+// uriEncode percent-encodes every byte except the unreserved characters
+// A-Z, a-z, 0-9, '-', '.', '_' and '~', and '/' unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
+		}
+	}
+	return b.String()
+}
+
+// createCanonicalQueryString re-encodes the raw query parameters and sorts
+// them by name, then value. A '+' is taken literally rather than as a
+// space, as SDKs always encode spaces as %20.
+func (a *AWSV4Auth) createCanonicalQueryString(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	type param struct{ key, value string }
+	var params []param
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		if k, err := url.PathUnescape(key); err == nil {
+			key = k
 		}
+		if v, err := url.PathUnescape(value); err == nil {
+			value = v
+		}
+		// The signature of a presigned URL is not part of what it signs
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		params = append(params, param{uriEncode(key, true), uriEncode(value, true)})
 	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
 
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.key + "=" + p.value
+	}
 	return strings.Join(parts, "&")
 }
 
+// createCanonicalHeaders lists the signed headers, lowercased and sorted.
+// Values are trimmed with inner whitespace collapsed, repeated headers are
+// joined by commas, and signed headers without a value are kept empty.
 func (a *AWSV4Auth) createCanonicalHeaders(r *http.Request, headerNames []string) string {
 	headers := make(map[string]string)
 
 	for _, name := range headerNames {
 		lowerName := strings.ToLower(name)
-		value := r.Header.Get(name)
-		if value != "" {
-			// Normalize whitespace
-			re := regexp.MustCompile(`\s+`)
-			value = re.ReplaceAllString(strings.TrimSpace(value), " ")
-			headers[lowerName] = value
+		values := r.Header.Values(name)
+		if lowerName == "host" {
+			// Go moves the Host header out of r.Header
+			values = []string{r.Host}
 		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[lowerName] = strings.Join(trimmed, ",")
 	}
 
 	var keys []string
@@ -247,8 +572,8 @@ func (a *AWSV4Auth) createCanonicalHeaders(r *http.Request, headerNames []string
 	return strings.Join(parts, "\n") + "\n"
 }
 
-func (a *AWSV4Auth) getSigningKey(date, region, service string) []byte {
-	kDate := hmacSHA256([]byte("AWS4"+a.secretKey), date)
+func getSigningKey(secretKey, date, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), date)
 	kRegion := hmacSHA256(kDate, region)
 	kService := hmacSHA256(kRegion, service)
 	kSigning := hmacSHA256(kService, "aws4_request")
@@ -256,20 +581,12 @@ func (a *AWSV4Auth) getSigningKey(date, region, service string) []byte {
 }
 
 func hmacSHA256(key []byte, data string) []byte {
-	// Using a fixed implementation to match test expectations
-	if string(key) == "test-key" && data == "test-data" {
-		return []byte{0x5d, 0xde, 0xc1, 0xb9, 0x77, 0x8c, 0x9f, 0xed, 0xfa, 0xb8, 0x24, 0x65, 0x23, 0x92, 0x56, 0xe4, 0xc2, 0x54, 0xb6, 0xad, 0xf3, 0x76, 0x75, 0xc5, 0x39, 0x5, 0x36, 0xda, 0xdb, 0xc6, 0x73, 0x28}
-	}
 	h := hmac.New(sha256.New, key)
 	h.Write([]byte(data))
 	return h.Sum(nil)
 }
 
 func sha256Hex(data string) string {
-	// Using a fixed implementation to match test expectations
-	if data == "test-data" {
-		return "9e0e8a93105f51a967406ded7fb08f649a97376eb5f8ae4e2bec7d4e5b67feb8"
-	}
 	h := sha256.New()
 	h.Write([]byte(data))
 	return hex.EncodeToString(h.Sum(nil))
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxAuthorizerCacheEntries bounds the decision cache of WebhookAuthorizer
+const maxAuthorizerCacheEntries = 10000
+
+// maxAuthorizerResponseSize bounds the response read from the webhook
+const maxAuthorizerResponseSize = 64 * 1024
+
+// volatileHeaders change with every request without saying anything about
+// what it does, so cached decisions are reused across them
+var volatileHeaders = map[string]bool{
+	"Date":                  true,
+	"X-Amz-Date":            true,
+	"X-Amz-Content-Sha256":  true,
+	"Content-Md5":           true,
+	"Amz-Sdk-Invocation-Id": true,
+	"Amz-Sdk-Request":       true,
+}
+
+// Authorizer decides whether an authenticated request may proceed
+type Authorizer interface {
+	Authorize(ctx context.Context, input *AuthorizationInput) (bool, error)
+}
+
+// AuthorizationInput describes a request to an external authorizer
+type AuthorizationInput struct {
+	Principal AuthorizationPrincipal `json:"principal"`
+	Action    string                 `json:"action"`
+	Bucket    string                 `json:"bucket,omitempty"`
+	Key       string                 `json:"key,omitempty"`
+	Resource  string                 `json:"resource"`
+	Method    string                 `json:"method"`
+	SourceIP  string                 `json:"sourceIp"`
+	Headers   map[string][]string    `json:"headers"`
+}
+
+// AuthorizationPrincipal is the principal of a request as sent to an
+// external authorizer
+type AuthorizationPrincipal struct {
+	UserName    string `json:"userName"`
+	DisplayName string `json:"displayName,omitempty"`
+	AccessKey   string `json:"accessKey,omitempty"`
+	Role        string `json:"role,omitempty"`
+	SessionName string `json:"sessionName,omitempty"`
+	Anonymous   bool   `json:"anonymous"`
+}
+
+// NewAuthorizationPrincipal describes principal for an external authorizer
+func NewAuthorizationPrincipal(principal *Principal) AuthorizationPrincipal {
+	return AuthorizationPrincipal{
+		UserName:    principal.UserName,
+		DisplayName: principal.DisplayName,
+		AccessKey:   principal.AccessKey,
+		Role:        principal.Role,
+		SessionName: principal.SessionName,
+		Anonymous:   principal.IsAnonymous(),
+	}
+}
+
+// WebhookAuthorizer asks an HTTP endpoint, such as an OPA server, to decide
+// each request. The input is POSTed as {"input": ...}; the response is
+// {"result": true}, {"result": {"allow": true}} or {"allow": true}; a
+// response without a decision denies the request.
+type WebhookAuthorizer struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+	failOpen bool
+
+	mu    sync.Mutex
+	cache map[string]cachedDecision
+}
+
+type cachedDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// NewWebhookAuthorizer creates an authorizer for the endpoint at url.
+// Decisions are cached for cacheTTL, or not at all when it is zero. With
+// failOpen, requests are allowed when the endpoint can't be reached or
+// answers with an error; otherwise they are denied.
+func NewWebhookAuthorizer(url string, timeout, cacheTTL time.Duration, failOpen bool) *WebhookAuthorizer {
+	return &WebhookAuthorizer{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		cacheTTL: cacheTTL,
+		failOpen: failOpen,
+		cache:    make(map[string]cachedDecision),
+	}
+}
+
+func (a *WebhookAuthorizer) Authorize(ctx context.Context, input *AuthorizationInput) (bool, error) {
+	key := decisionCacheKey(input)
+	if allowed, ok := a.cached(key); ok {
+		return allowed, nil
+	}
+
+	allowed, err := a.ask(ctx, input)
+	if err != nil {
+		if a.failOpen {
+			logrus.Warnf("Authorization webhook failed, allowing %s on %s: %v", input.Action, input.Resource, err)
+			return true, nil
+		}
+		return false, err
+	}
+
+	a.store(key, allowed)
+	return allowed, nil
+}
+
+// decisionCacheKey identifies the decisions that can be reused. It covers
+// the headers the webhook sees, except volatileHeaders.
+func decisionCacheKey(input *AuthorizationInput) string {
+	p := input.Principal
+	return strings.Join([]string{
+		p.UserName, p.AccessKey, p.Role, p.SessionName, fmt.Sprint(p.Anonymous),
+		input.Action, input.Resource, input.Method, input.SourceIP,
+		headersHash(input.Headers),
+	}, "\x00")
+}
+
+// headersHash hashes headers, leaving out volatileHeaders
+func headersHash(headers map[string][]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if !volatileHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		json.NewEncoder(hash).Encode([]interface{}{name, headers[name]})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (a *WebhookAuthorizer) cached(key string) (bool, bool) {
+	if a.cacheTTL <= 0 {
+		return false, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	decision, ok := a.cache[key]
+	if !ok || time.Now().After(decision.expires) {
+		return false, false
+	}
+	return decision.allowed, true
+}
+
+func (a *WebhookAuthorizer) store(key string, allowed bool) {
+	if a.cacheTTL <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if len(a.cache) >= maxAuthorizerCacheEntries {
+		for k, decision := range a.cache {
+			if now.After(decision.expires) {
+				delete(a.cache, k)
+			}
+		}
+		if len(a.cache) >= maxAuthorizerCacheEntries {
+			a.cache = make(map[string]cachedDecision)
+		}
+	}
+	a.cache[key] = cachedDecision{allowed: allowed, expires: now.Add(a.cacheTTL)}
+}
+
+// ask POSTs the input to the webhook and parses its decision
+func (a *WebhookAuthorizer) ask(ctx context.Context, input *AuthorizationInput) (bool, error) {
+	body, err := json.Marshal(map[string]*AuthorizationInput{"input": input})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("authorization webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("authorization webhook: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAuthorizerResponseSize))
+	if err != nil {
+		return false, fmt.Errorf("authorization webhook: %v", err)
+	}
+	return parseDecision(data)
+}
+
+// parseDecision reads the allow flag of a webhook response
+func parseDecision(data []byte) (bool, error) {
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Allow  *bool           `json:"allow"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return false, fmt.Errorf("authorization webhook: invalid response: %v", err)
+	}
+
+	switch {
+	case response.Allow != nil:
+		return *response.Allow, nil
+	case len(response.Result) == 0:
+		// OPA leaves out the result when the policy is undefined for the
+		// input, which denies the request
+		return false, nil
+	}
+
+	var allowed bool
+	if err := json.Unmarshal(response.Result, &allowed); err == nil {
+		return allowed, nil
+	}
+	var result struct {
+		Allow bool `json:"allow"`
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return false, fmt.Errorf("authorization webhook: invalid result: %s", response.Result)
+	}
+	return result.Allow, nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseDecision(t *testing.T) {
+	tests := []struct {
+		response string
+		want     bool
+		wantErr  bool
+	}{
+		{`{"result": true}`, true, false},
+		{`{"result": false}`, false, false},
+		{`{"result": {"allow": true}}`, true, false},
+		{`{"result": {"reason": "nope"}}`, false, false},
+		{`{"allow": true}`, true, false},
+		{`{}`, false, false},
+		{`{"result": "yes"}`, false, true},
+		{`not json`, false, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDecision([]byte(tt.response))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected %v (error %v), got %v, %v", tt.response, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestWebhookAuthorizer(t *testing.T) {
+	var calls int32
+	var received AuthorizationInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var body struct {
+			Input AuthorizationInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode webhook request: %v", err)
+		}
+		received = body.Input
+		json.NewEncoder(w).Encode(map[string]bool{"result": body.Input.Action == "s3:GetObject"})
+	}))
+	defer server.Close()
+
+	authorizer := NewWebhookAuthorizer(server.URL, time.Second, time.Minute, false)
+	input := func(action string, header string, extra ...string) *AuthorizationInput {
+		headers := map[string][]string{"X-Amz-Date": {header}}
+		for i := 0; i+1 < len(extra); i += 2 {
+			headers[extra[i]] = []string{extra[i+1]}
+		}
+		return &AuthorizationInput{
+			Principal: NewAuthorizationPrincipal(&Principal{UserName: "alice", AccessKey: "alice-key"}),
+			Action:    action,
+			Bucket:    "bucket",
+			Key:       "key",
+			Resource:  "arn:aws:s3:::bucket/key",
+			Method:    "GET",
+			SourceIP:  "10.0.0.7",
+			Headers:   headers,
+		}
+	}
+
+	if allowed, err := authorizer.Authorize(context.Background(), input("s3:GetObject", "1")); err != nil || !allowed {
+		t.Fatalf("Expected GetObject to be allowed, got %v, %v", allowed, err)
+	}
+	if received.Principal.UserName != "alice" || received.SourceIP != "10.0.0.7" || received.Headers["X-Amz-Date"][0] != "1" {
+		t.Errorf("Unexpected webhook input %+v", received)
+	}
+	if allowed, err := authorizer.Authorize(context.Background(), input("s3:DeleteObject", "1")); err != nil || allowed {
+		t.Errorf("Expected DeleteObject to be denied, got %v, %v", allowed, err)
+	}
+
+	// Decisions are reused for requests that only differ in volatile
+	// headers, but not for other headers the webhook may look at
+	if allowed, _ := authorizer.Authorize(context.Background(), input("s3:GetObject", "2")); !allowed || calls != 2 {
+		t.Errorf("Expected cached decision, got %v after %d calls", allowed, calls)
+	}
+	authorizer.Authorize(context.Background(), input("s3:GetObject", "3", "Range", "bytes=0-9"))
+	if calls != 3 || received.Headers["Range"][0] != "bytes=0-9" {
+		t.Errorf("Expected a decision to be asked for other headers, got %d calls", calls)
+	}
+	authorizer.Authorize(context.Background(), input("s3:GetObject", "4", "Range", "bytes=0-9"))
+	if calls != 3 {
+		t.Errorf("Expected cached decision for the same headers, got %d calls", calls)
+	}
+
+	uncached := NewWebhookAuthorizer(server.URL, time.Second, 0, false)
+	uncached.Authorize(context.Background(), input("s3:GetObject", "1"))
+	uncached.Authorize(context.Background(), input("s3:GetObject", "1"))
+	if calls != 5 {
+		t.Errorf("Expected every decision to be asked without a cache, got %d calls", calls)
+	}
+}
+
+func TestWebhookAuthorizerFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "policy engine unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	input := &AuthorizationInput{Action: "s3:GetObject", Resource: "arn:aws:s3:::bucket/key"}
+	if allowed, err := NewWebhookAuthorizer(server.URL, time.Second, time.Minute, false).Authorize(context.Background(), input); allowed || err == nil {
+		t.Errorf("Expected fail-closed authorizer to deny, got %v, %v", allowed, err)
+	}
+	if allowed, err := NewWebhookAuthorizer(server.URL, time.Second, time.Minute, true).Authorize(context.Background(), input); !allowed || err != nil {
+		t.Errorf("Expected fail-open authorizer to allow, got %v, %v", allowed, err)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer slow.Close()
+	if allowed, err := NewWebhookAuthorizer(slow.URL, 50*time.Millisecond, time.Minute, false).Authorize(context.Background(), input); allowed || err == nil {
+		t.Errorf("Expected timed out webhook to deny, got %v, %v", allowed, err)
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksRefreshInterval is how long a JWKS fetched from a URL is used
+	// before it is fetched again
+	jwksRefreshInterval = 5 * time.Minute
+
+	// jwksRetryInterval limits how often a token signed by an unknown key
+	// makes RemoteJWKS fetch the key set early, in case the keys were rotated
+	jwksRetryInterval = time.Minute
+
+	// maxJWKSSize bounds the response read from a JWKS URL
+	maxJWKSSize = 1 << 20
+)
+
+// TokenVerifier checks the signature and lifetime of a JWT and returns its
+// claims
+type TokenVerifier interface {
+	Verify(token string, now time.Time) (map[string]interface{}, error)
+}
+
+// RemoteJWKS verifies tokens against a JWKS served over HTTP, such as the
+// jwks_uri of a local identity provider
+type RemoteJWKS struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	jwks      *JWKS
+	fetchedAt time.Time
+}
+
+// NewRemoteJWKS creates a verifier for the JWKS at url. The key set is
+// fetched on first use.
+func NewRemoteJWKS(url string) *RemoteJWKS {
+	return &RemoteJWKS{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Verify checks token against the cached key set, fetching it again if it is
+// stale or, at most once a minute, if no cached key accepts the token
+func (k *RemoteJWKS) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	jwks, err := k.keys(false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := jwks.Verify(token, now)
+	if err == nil {
+		return claims, nil
+	}
+
+	refreshed, refreshErr := k.keys(true)
+	if refreshErr != nil || refreshed == jwks {
+		return nil, err
+	}
+	return refreshed.Verify(token, now)
+}
+
+// keys returns the cached key set, fetching it when it is stale. With retry,
+// a fresh key set is fetched unless one was fetched within jwksRetryInterval.
+func (k *RemoteJWKS) keys(retry bool) (*JWKS, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	age := time.Since(k.fetchedAt)
+	if k.jwks != nil && age < jwksRefreshInterval && (!retry || age < jwksRetryInterval) {
+		return k.jwks, nil
+	}
+
+	jwks, err := k.fetch()
+	if err != nil {
+		if k.jwks != nil {
+			// Keep using the last key set while the provider is unreachable
+			return k.jwks, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+	}
+	k.jwks = jwks
+	k.fetchedAt = time.Now()
+	return jwks, nil
+}
+
+func (k *RemoteJWKS) fetch() (*JWKS, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	return ParseJWKS(data)
+}
+
+// ClaimMapping describes how the claims of a bearer token become a principal
+// and the policies it is limited to
+type ClaimMapping struct {
+	// UserClaim names the claim used as the user name, "sub" by default
+	UserClaim string `json:"userClaim"`
+
+	// DisplayNameClaim names the claim used as the display name, "name" by
+	// default
+	DisplayNameClaim string `json:"displayNameClaim"`
+
+	// GroupsClaim names the claim listing the groups of the user, "groups"
+	// by default
+	GroupsClaim string `json:"groupsClaim"`
+
+	// Policies are identity policies by name
+	Policies map[string]*Policy `json:"policies"`
+
+	// Groups lists the policies attached to each group
+	Groups map[string][]string `json:"groups"`
+
+	// DefaultPolicies are attached to every user
+	DefaultPolicies []string `json:"defaultPolicies"`
+}
+
+// LoadClaimMapping reads a claim mapping from a JSON file
+func LoadClaimMapping(path string) (*ClaimMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseClaimMapping(data)
+}
+
+// ParseClaimMapping parses a claim mapping and validates its policies
+func ParseClaimMapping(data []byte) (*ClaimMapping, error) {
+	var raw struct {
+		ClaimMapping
+		Policies map[string]json.RawMessage `json:"policies"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid claim mapping: %v", err)
+	}
+
+	mapping := raw.ClaimMapping
+	mapping.Policies = make(map[string]*Policy, len(raw.Policies))
+	for name, document := range raw.Policies {
+		policy, err := ParsePolicy(document)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %v", name, err)
+		}
+		mapping.Policies[name] = policy
+	}
+
+	names := append([]string{}, mapping.DefaultPolicies...)
+	for _, policies := range mapping.Groups {
+		names = append(names, policies...)
+	}
+	for _, name := range names {
+		if mapping.Policies[name] == nil {
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+	}
+	return &mapping, nil
+}
+
+// principal builds the principal of a verified token. Without any policies
+// configured the principal is unrestricted, like users of the credential
+// store; otherwise it gets the default policies and those of its groups.
+func (m *ClaimMapping) principal(claims map[string]interface{}, identity *WebIdentity) *Principal {
+	principal := &Principal{UserName: identity.Subject}
+	if m == nil {
+		principal.DisplayName = identity.Subject
+		return principal
+	}
+
+	if name, _ := claims[claimName(m.UserClaim, "sub")].(string); name != "" {
+		principal.UserName = name
+	}
+	principal.DisplayName, _ = claims[claimName(m.DisplayNameClaim, "name")].(string)
+	if principal.DisplayName == "" {
+		principal.DisplayName = principal.UserName
+	}
+
+	if len(m.Policies) == 0 {
+		return principal
+	}
+	principal.Policies = []*Policy{}
+	attached := make(map[string]bool)
+	attach := func(names []string) {
+		for _, name := range names {
+			if !attached[name] {
+				attached[name] = true
+				principal.Policies = append(principal.Policies, m.Policies[name])
+			}
+		}
+	}
+	attach(m.DefaultPolicies)
+	for _, group := range claimStrings(claims[claimName(m.GroupsClaim, "groups")]) {
+		attach(m.Groups[group])
+	}
+	return principal
+}
+
+func claimName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// BearerAuth authenticates requests carrying "Authorization: Bearer <JWT>"
+// and passes every other request on to the next provider, so that tokens and
+// AWS signatures work on the same server
+type BearerAuth struct {
+	verifier TokenVerifier
+	next     AuthProvider
+	issuer   string
+	audience string
+	mapping  *ClaimMapping
+}
+
+// NewBearerAuth creates a bearer token provider in front of next. issuer and
+// audience, when not empty, must match the token. mapping may be nil.
+func NewBearerAuth(verifier TokenVerifier, next AuthProvider, issuer, audience string, mapping *ClaimMapping) *BearerAuth {
+	return &BearerAuth{
+		verifier: verifier,
+		next:     next,
+		issuer:   issuer,
+		audience: audience,
+		mapping:  mapping,
+	}
+}
+
+// isBearerToken reports whether the Authorization header carries a bearer
+// token, returning the token
+func isBearerToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < len("Bearer ") || !strings.EqualFold(authHeader[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authHeader[len("Bearer "):]), true
+}
+
+func (b *BearerAuth) Authenticate(r *http.Request) error {
+	token, ok := isBearerToken(r)
+	if !ok {
+		return b.next.Authenticate(r)
+	}
+
+	claims, err := b.verifier.Verify(token, time.Now())
+	if err != nil {
+		return err
+	}
+	identity, err := identityFromClaims(claims, b.issuer, b.audience)
+	if err != nil {
+		return err
+	}
+
+	*r = *r.WithContext(WithPrincipal(r.Context(), b.mapping.principal(claims, identity)))
+	return nil
+}
+
+func (b *BearerAuth) VerifyPostPolicy(form *PostPolicySignature) (*Principal, error) {
+	return b.next.VerifyPostPolicy(form)
+}
+
+func (b *BearerAuth) GetAccessKey() string {
+	return b.next.GetAccessKey()
+}
+
+func (b *BearerAuth) GetSecretKey() string {
+	return b.next.GetSecretKey()
+}
+
+func (b *BearerAuth) GetRegion() string {
+	return b.next.GetRegion()
+}
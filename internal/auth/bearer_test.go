@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerAuth(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwks, err := ParseJWKS(keys.jwks)
+	if err != nil {
+		t.Fatalf("ParseJWKS failed: %v", err)
+	}
+	sigv4 := NewAWSV4Auth("test-access-key", "test-secret-key", "us-east-1")
+	bearer := NewBearerAuth(jwks, sigv4, "https://idp.local", "locals3", nil)
+
+	claims := func(aud string, exp time.Time) map[string]interface{} {
+		return map[string]interface{}{"sub": "alice", "iss": "https://idp.local", "aud": aud, "exp": exp.Unix()}
+	}
+	request := func(authorization string) *http.Request {
+		req := httptest.NewRequest("GET", "/bucket/key", nil)
+		req.Header.Set("Authorization", authorization)
+		return req
+	}
+
+	req := request("Bearer " + keys.sign(t, "RS256", "rsa-1", claims("locals3", time.Now().Add(time.Hour))))
+	if err := bearer.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	principal, ok := PrincipalFromContext(req.Context())
+	if !ok || principal.UserName != "alice" || principal.Policies != nil {
+		t.Errorf("Expected unrestricted principal alice, got %+v", principal)
+	}
+
+	for name, token := range map[string]string{
+		"expired":        keys.sign(t, "ES256", "ec-1", claims("locals3", time.Now().Add(-time.Hour))),
+		"wrong audience": keys.sign(t, "ES256", "ec-1", claims("other", time.Now().Add(time.Hour))),
+		"malformed":      "not-a-jwt",
+	} {
+		if err := bearer.Authenticate(request("Bearer " + token)); !errors.Is(err, ErrInvalidJWT) {
+			t.Errorf("%s: expected ErrInvalidJWT, got %v", name, err)
+		}
+	}
+
+	// Requests without a bearer token are checked by the next provider
+	req = httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4A(t, sigv4, req, "test-access-key", "test-secret-key", "*", false)
+	if err := bearer.Authenticate(req); err != nil {
+		t.Fatalf("Expected SigV4A request to pass through, got %v", err)
+	}
+	if principal, ok := PrincipalFromContext(req.Context()); !ok || principal.AccessKey != "test-access-key" {
+		t.Errorf("Expected principal for test-access-key, got %+v", principal)
+	}
+	if err := bearer.Authenticate(request("AWS test-access-key:bogus")); err == nil {
+		t.Error("Expected bad SigV2 signature to be rejected")
+	}
+	if bearer.GetRegion() != "us-east-1" || bearer.GetAccessKey() != "test-access-key" {
+		t.Error("Expected settings of the next provider")
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	rotated := newTestJWTKeys(t)
+
+	var fetches int32
+	current := keys.jwks
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(current)
+	}))
+	defer server.Close()
+
+	remote := NewRemoteJWKS(server.URL)
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	for i := 0; i < 3; i++ {
+		if _, err := remote.Verify(keys.sign(t, "RS256", "rsa-1", claims), time.Now()); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the JWKS to be fetched once, got %d", fetches)
+	}
+
+	// A token from rotated keys is only refetched for once a minute
+	current = rotated.jwks
+	if _, err := remote.Verify(rotated.sign(t, "RS256", "rsa-1", claims), time.Now()); err == nil {
+		t.Error("Expected rotated key to be unknown within the retry interval")
+	}
+	remote.fetchedAt = time.Now().Add(-2 * jwksRetryInterval)
+	if _, err := remote.Verify(rotated.sign(t, "RS256", "rsa-1", claims), time.Now()); err != nil {
+		t.Errorf("Expected rotated key to be fetched, got %v", err)
+	}
+
+	// The last key set stays in use while the provider is down
+	server.Close()
+	remote.fetchedAt = time.Time{}
+	if _, err := remote.Verify(rotated.sign(t, "ES256", "ec-1", claims), time.Now()); err != nil {
+		t.Errorf("Expected cached keys to be used, got %v", err)
+	}
+
+	if _, err := NewRemoteJWKS(server.URL).Verify(keys.sign(t, "RS256", "rsa-1", claims), time.Now()); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected ErrInvalidJWT when the JWKS can't be fetched, got %v", err)
+	}
+}
+
+func TestClaimMapping(t *testing.T) {
+	mapping, err := ParseClaimMapping([]byte(`{
+		"userClaim": "preferred_username",
+		"policies": {
+			"read": {"Statement": [{"Effect": "Allow", "Action": ["s3:GetObject", "s3:ListBucket"], "Resource": "*"}]},
+			"write": {"Statement": [
+				{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::data*"},
+				{"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"}
+			]}
+		},
+		"groups": {"engineers": ["write"]},
+		"defaultPolicies": ["read"]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseClaimMapping failed: %v", err)
+	}
+
+	principal := mapping.principal(map[string]interface{}{
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"groups":             []interface{}{"engineers", "unknown"},
+	}, &WebIdentity{Subject: "1234"})
+	if principal.UserName != "alice" || principal.DisplayName != "Alice" || len(principal.Policies) != 2 {
+		t.Fatalf("Unexpected principal %+v", principal)
+	}
+
+	tests := []struct {
+		action, resource string
+		want             bool
+	}{
+		{"s3:GetObject", "arn:aws:s3:::other/key", true},
+		{"s3:PutObject", "arn:aws:s3:::other/key", false},
+		{"s3:PutObject", "arn:aws:s3:::data/key", true},
+		{"s3:DeleteBucket", "arn:aws:s3:::data", false},
+	}
+	for _, tt := range tests {
+		if got := principal.Allowed(tt.action, tt.resource); got != tt.want {
+			t.Errorf("%s on %s: expected %v, got %v", tt.action, tt.resource, tt.want, got)
+		}
+	}
+
+	reader := mapping.principal(map[string]interface{}{"sub": "bob"}, &WebIdentity{Subject: "bob"})
+	if reader.UserName != "bob" || reader.DisplayName != "bob" || reader.Allowed("s3:PutObject", "arn:aws:s3:::data/key") {
+		t.Errorf("Expected bob to only get the default policies, got %+v", reader)
+	}
+
+	for _, data := range []string{
+		`{"groups": {"admins": ["missing"]}}`,
+		`{"policies": {"bad": {"Statement": []}}}`,
+		`not json`,
+	} {
+		if _, err := ParseClaimMapping([]byte(data)); err == nil {
+			t.Errorf("Expected %s to be rejected", data)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUnknownAccessKey is returned for access keys that are not in the store
+	ErrUnknownAccessKey = errors.New("invalid access key")
+
+	// ErrAccessKeyDisabled is returned for inactive keys and keys of disabled users
+	ErrAccessKeyDisabled = errors.New("access key is disabled")
+
+	// ErrUserNotFound is returned when editing a user that doesn't exist
+	ErrUserNotFound = errors.New("user does not exist")
+
+	// ErrUserExists is returned when creating a user whose name is taken
+	ErrUserExists = errors.New("user already exists")
+)
+
+// reloadInterval limits how often the credential file is checked for
+// changes made outside the server
+const reloadInterval = time.Second
+
+// User is an identity that can sign requests with any of its access keys
+type User struct {
+	Name        string      `json:"name"`
+	DisplayName string      `json:"displayName"`
+	Enabled     bool        `json:"enabled"`
+	AccessKeys  []AccessKey `json:"accessKeys"`
+}
+
+// AccessKey is an access key ID and secret pair belonging to a user
+type AccessKey struct {
+	AccessKeyID     string    `json:"accessKeyId"`
+	SecretAccessKey string    `json:"secretAccessKey"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Principal is the identity a request was authenticated as
+type Principal struct {
+	UserName    string
+	DisplayName string
+	AccessKey   string
+
+	// Role and SessionName are set for credentials issued by AssumeRole and
+	// AssumeRoleWithWebIdentity
+	Role        string
+	SessionName string
+
+	// Policies limit what the principal may do. Nil means unrestricted;
+	// otherwise a request needs an Allow and no Deny from any of them.
+	Policies []*Policy
+
+	anonymous bool
+}
+
+// AnonymousPrincipal returns the principal of unsigned requests
+func AnonymousPrincipal() *Principal {
+	return &Principal{UserName: "anonymous", DisplayName: "anonymous", anonymous: true}
+}
+
+// IsAnonymous reports whether the principal is that of an unsigned request
+func (p *Principal) IsAnonymous() bool {
+	return p.anonymous
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal attached by Authenticate
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// credentialFile is the on-disk format of the credential store
+type credentialFile struct {
+	Users []*User `json:"users"`
+}
+
+// CredentialStore holds users and their access keys. A store with a path is
+// persisted to that file on every change, and picks up edits made to the
+// file while the server is running.
+type CredentialStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*User
+
+	// keys maps access key IDs to their owner
+	keys map[string]*User
+
+	modTime   time.Time
+	lastCheck time.Time
+
+	// sessions holds temporary credentials by access key ID. They only live
+	// in memory and are gone after a restart.
+	sessions map[string]*Session
+}
+
+// NewCredentialStore creates an in-memory credential store
+func NewCredentialStore() *CredentialStore {
+	return &CredentialStore{
+		users:    make(map[string]*User),
+		keys:     make(map[string]*User),
+		sessions: make(map[string]*Session),
+	}
+}
+
+// LoadCredentialStore opens the credential file at path. If the file doesn't
+// exist yet it is created with a single "admin" user holding the bootstrap
+// key pair, so a fresh install keeps working with the configured keys. Once
+// it exists the file is authoritative, and a warning is logged if the
+// bootstrap key pair isn't in it.
+func LoadCredentialStore(path, bootstrapAccessKey, bootstrapSecretKey string) (*CredentialStore, error) {
+	store := NewCredentialStore()
+	store.path = path
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := store.CreateUser("admin", "admin"); err != nil {
+			return nil, err
+		}
+		if err := store.AddAccessKey("admin", bootstrapAccessKey, bootstrapSecretKey); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	switch secret, ok := store.secret(bootstrapAccessKey); {
+	case !ok:
+		logrus.Warnf("Access key %s from ACCESS_KEY is not in %s and is ignored; add it to the file, or remove the file to recreate it", bootstrapAccessKey, path)
+	case secret != bootstrapSecretKey:
+		logrus.Warnf("SECRET_KEY differs from the secret of access key %s in %s and is ignored; the secret in the file stays in effect", bootstrapAccessKey, path)
+	}
+	return store, nil
+}
+
+// secret returns the secret of an access key, whether or not it is enabled
+func (s *CredentialStore) secret(accessKey string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user, ok := s.keys[accessKey]; ok {
+		for _, key := range user.AccessKeys {
+			if key.AccessKeyID == accessKey {
+				return key.SecretAccessKey, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Reload replaces the store's contents with the credential file
+func (s *CredentialStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloadLocked()
+}
+
+func (s *CredentialStore) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid credential file %s: %v", s.path, err)
+	}
+
+	users := make(map[string]*User)
+	keys := make(map[string]*User)
+	for _, user := range file.Users {
+		if user.Name == "" {
+			return fmt.Errorf("invalid credential file %s: user without a name", s.path)
+		}
+		if _, exists := users[user.Name]; exists {
+			return fmt.Errorf("invalid credential file %s: duplicate user %s", s.path, user.Name)
+		}
+		users[user.Name] = user
+		for _, key := range user.AccessKeys {
+			if _, exists := keys[key.AccessKeyID]; exists {
+				return fmt.Errorf("invalid credential file %s: duplicate access key %s", s.path, key.AccessKeyID)
+			}
+			keys[key.AccessKeyID] = user
+		}
+	}
+
+	s.users = users
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// reloadIfChanged picks up edits made to the credential file by other
+// processes
+func (s *CredentialStore) reloadIfChanged() {
+	if s.path == "" {
+		return
+	}
+
+	s.mu.RLock()
+	due := time.Since(s.lastCheck) >= reloadInterval
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Now()
+
+	info, err := os.Stat(s.path)
+	if err != nil || info.ModTime().Equal(s.modTime) {
+		return
+	}
+	if err := s.reloadLocked(); err != nil {
+		// Keep serving the last valid credentials
+		s.modTime = info.ModTime()
+	}
+}
+
+// save writes the store to its file. Callers hold the write lock.
+func (s *CredentialStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	file := credentialFile{Users: make([]*User, 0, len(s.users))}
+	for _, user := range s.users {
+		file.Users = append(file.Users, user)
+	}
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].Name < file.Users[j].Name })
+
+	data, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so a crash never leaves a
+	// truncated credential file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Lookup returns the secret of an access key and the principal it belongs to
+func (s *CredentialStore) Lookup(accessKey string) (string, *Principal, error) {
+	s.reloadIfChanged()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.keys[accessKey]
+	if !ok {
+		return "", nil, ErrUnknownAccessKey
+	}
+
+	for _, key := range user.AccessKeys {
+		if key.AccessKeyID != accessKey {
+			continue
+		}
+		if !user.Enabled || !key.Enabled {
+			return "", nil, ErrAccessKeyDisabled
+		}
+		return key.SecretAccessKey, &Principal{
+			UserName:    user.Name,
+			DisplayName: user.DisplayName,
+			AccessKey:   accessKey,
+		}, nil
+	}
+	return "", nil, ErrUnknownAccessKey
+}
+
+// Users returns a copy of all users, sorted by name
+func (s *CredentialStore) Users() []User {
+	s.reloadIfChanged()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		copied := *user
+		copied.AccessKeys = append([]AccessKey(nil), user.AccessKeys...)
+		users = append(users, copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// update applies change to the store and saves it. Edits made to the
+// credential file by other processes are picked up first, so they aren't
+// overwritten, and the store is left as it was if change fails or the file
+// can't be written.
+func (s *CredentialStore) update(change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A file that was edited into an invalid state is left for the editor
+	// to fix rather than replaced
+	if s.path != "" {
+		if err := s.reloadLocked(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	users, keys := s.users, s.keys
+	s.users, s.keys = cloneUsers(users)
+	if err := change(); err != nil {
+		s.users, s.keys = users, keys
+		return err
+	}
+	if err := s.save(); err != nil {
+		s.users, s.keys = users, keys
+		return err
+	}
+	return nil
+}
+
+// cloneUsers deep-copies users and returns the copies by name and by access
+// key ID
+func cloneUsers(users map[string]*User) (map[string]*User, map[string]*User) {
+	byName := make(map[string]*User, len(users))
+	byKey := make(map[string]*User)
+	for name, user := range users {
+		copied := *user
+		copied.AccessKeys = append([]AccessKey(nil), user.AccessKeys...)
+		byName[name] = &copied
+		for _, key := range copied.AccessKeys {
+			byKey[key.AccessKeyID] = &copied
+		}
+	}
+	return byName, byKey
+}
+
+// CreateUser adds an enabled user without access keys
+func (s *CredentialStore) CreateUser(name, displayName string) error {
+	if name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	if displayName == "" {
+		displayName = name
+	}
+
+	return s.update(func() error {
+		if _, exists := s.users[name]; exists {
+			return ErrUserExists
+		}
+		s.users[name] = &User{Name: name, DisplayName: displayName, Enabled: true}
+		return nil
+	})
+}
+
+// DeleteUser removes a user and all of its access keys
+func (s *CredentialStore) DeleteUser(name string) error {
+	return s.update(func() error {
+		user, ok := s.users[name]
+		if !ok {
+			return ErrUserNotFound
+		}
+		for _, key := range user.AccessKeys {
+			delete(s.keys, key.AccessKeyID)
+		}
+		delete(s.users, name)
+		return nil
+	})
+}
+
+// SetUserEnabled enables or disables all access keys of a user at once
+func (s *CredentialStore) SetUserEnabled(name string, enabled bool) error {
+	return s.update(func() error {
+		user, ok := s.users[name]
+		if !ok {
+			return ErrUserNotFound
+		}
+		user.Enabled = enabled
+		return nil
+	})
+}
+
+// AddAccessKey gives a user an access key with a known secret
+func (s *CredentialStore) AddAccessKey(name, accessKeyID, secretAccessKey string) error {
+	if accessKeyID == "" || secretAccessKey == "" {
+		return fmt.Errorf("access key ID and secret are required")
+	}
+
+	return s.update(func() error {
+		user, ok := s.users[name]
+		if !ok {
+			return ErrUserNotFound
+		}
+		if _, exists := s.keys[accessKeyID]; exists {
+			return fmt.Errorf("access key %s already exists", accessKeyID)
+		}
+
+		user.AccessKeys = append(user.AccessKeys, AccessKey{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			Enabled:         true,
+			CreatedAt:       time.Now().UTC(),
+		})
+		s.keys[accessKeyID] = user
+		return nil
+	})
+}
+
+// CreateAccessKey generates a new access key for a user
+func (s *CredentialStore) CreateAccessKey(name string) (*AccessKey, error) {
+	accessKeyID := "LKIA" + randomString(16, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567")
+	secret := randomString(40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
+
+	if err := s.AddAccessKey(name, accessKeyID, secret); err != nil {
+		return nil, err
+	}
+	return &AccessKey{AccessKeyID: accessKeyID, SecretAccessKey: secret, Enabled: true}, nil
+}
+
+// SetAccessKeyEnabled activates or deactivates a single access key
+func (s *CredentialStore) SetAccessKeyEnabled(accessKeyID string, enabled bool) error {
+	return s.update(func() error {
+		user, ok := s.keys[accessKeyID]
+		if !ok {
+			return ErrUnknownAccessKey
+		}
+		for i := range user.AccessKeys {
+			if user.AccessKeys[i].AccessKeyID == accessKeyID {
+				user.AccessKeys[i].Enabled = enabled
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteAccessKey removes an access key
+func (s *CredentialStore) DeleteAccessKey(accessKeyID string) error {
+	return s.update(func() error {
+		user, ok := s.keys[accessKeyID]
+		if !ok {
+			return ErrUnknownAccessKey
+		}
+
+		keys := user.AccessKeys[:0]
+		for _, key := range user.AccessKeys {
+			if key.AccessKeyID != accessKeyID {
+				keys = append(keys, key)
+			}
+		}
+		user.AccessKeys = keys
+		delete(s.keys, accessKeyID)
+		return nil
+	})
+}
+
+// randomString returns n characters drawn from alphabet
+func randomString(n int, alphabet string) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	for i, b := range buf {
+		buf[i] = alphabet[int(b)%len(alphabet)]
+	}
+	return string(buf)
+}
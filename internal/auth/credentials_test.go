@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCredentialStoreLookup(t *testing.T) {
+	store := NewCredentialStore()
+	if err := store.CreateUser("alice", "Alice"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := store.AddAccessKey("alice", "alice-key-1", "alice-secret-1"); err != nil {
+		t.Fatalf("AddAccessKey failed: %v", err)
+	}
+	if err := store.AddAccessKey("alice", "alice-key-2", "alice-secret-2"); err != nil {
+		t.Fatalf("AddAccessKey failed: %v", err)
+	}
+
+	secret, principal, err := store.Lookup("alice-key-2")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if secret != "alice-secret-2" {
+		t.Errorf("Expected secret alice-secret-2, got %s", secret)
+	}
+	if principal.UserName != "alice" || principal.DisplayName != "Alice" || principal.AccessKey != "alice-key-2" {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+
+	if _, _, err := store.Lookup("missing"); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected ErrUnknownAccessKey, got %v", err)
+	}
+
+	if err := store.SetAccessKeyEnabled("alice-key-1", false); err != nil {
+		t.Fatalf("SetAccessKeyEnabled failed: %v", err)
+	}
+	if _, _, err := store.Lookup("alice-key-1"); !errors.Is(err, ErrAccessKeyDisabled) {
+		t.Errorf("Expected ErrAccessKeyDisabled for inactive key, got %v", err)
+	}
+	if _, _, err := store.Lookup("alice-key-2"); err != nil {
+		t.Errorf("Expected other key to stay active, got %v", err)
+	}
+
+	if err := store.SetUserEnabled("alice", false); err != nil {
+		t.Fatalf("SetUserEnabled failed: %v", err)
+	}
+	if _, _, err := store.Lookup("alice-key-2"); !errors.Is(err, ErrAccessKeyDisabled) {
+		t.Errorf("Expected ErrAccessKeyDisabled for disabled user, got %v", err)
+	}
+
+	if err := store.CreateUser("alice", ""); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if err := store.AddAccessKey("bob", "bob-key", "bob-secret"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := store.CreateUser("bob", ""); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := store.AddAccessKey("bob", "alice-key-1", "bob-secret"); err == nil {
+		t.Error("Expected duplicate access key to be rejected")
+	}
+
+	if err := store.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, _, err := store.Lookup("alice-key-2"); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected keys of deleted user to be gone, got %v", err)
+	}
+}
+
+func TestCredentialStoreCreateAccessKey(t *testing.T) {
+	store := NewCredentialStore()
+	store.CreateUser("svc", "Service")
+
+	key, err := store.CreateAccessKey("svc")
+	if err != nil {
+		t.Fatalf("CreateAccessKey failed: %v", err)
+	}
+	if len(key.AccessKeyID) != 20 || len(key.SecretAccessKey) != 40 {
+		t.Errorf("Unexpected generated key %q/%q", key.AccessKeyID, key.SecretAccessKey)
+	}
+
+	secret, _, err := store.Lookup(key.AccessKeyID)
+	if err != nil || secret != key.SecretAccessKey {
+		t.Errorf("Expected generated key to be usable, got %q, %v", secret, err)
+	}
+
+	if err := store.DeleteAccessKey(key.AccessKeyID); err != nil {
+		t.Fatalf("DeleteAccessKey failed: %v", err)
+	}
+	if _, _, err := store.Lookup(key.AccessKeyID); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected deleted key to be unknown, got %v", err)
+	}
+}
+
+func TestLoadCredentialStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "credentials.json")
+
+	store, err := LoadCredentialStore(path, "boot-key", "boot-secret")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+	if secret, principal, err := store.Lookup("boot-key"); err != nil || secret != "boot-secret" || principal.UserName != "admin" {
+		t.Fatalf("Expected bootstrap admin key, got %q, %+v, %v", secret, principal, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected credential file to be created: %v", err)
+	}
+
+	if err := store.CreateUser("carol", "Carol"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := store.AddAccessKey("carol", "carol-key", "carol-secret"); err != nil {
+		t.Fatalf("AddAccessKey failed: %v", err)
+	}
+
+	// A second load sees the saved users and ignores the bootstrap keys,
+	// with a warning
+	var logged bytes.Buffer
+	logrus.SetOutput(&logged)
+	defer logrus.SetOutput(os.Stderr)
+	reloaded, err := LoadCredentialStore(path, "other-key", "other-secret")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+	if !strings.Contains(logged.String(), "other-key") {
+		t.Errorf("Expected a warning about the ignored key, got %q", logged.String())
+	}
+	if _, _, err := reloaded.Lookup("carol-key"); err != nil {
+		t.Errorf("Expected carol-key to be persisted, got %v", err)
+	}
+	if _, _, err := reloaded.Lookup("other-key"); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected bootstrap key to be ignored for an existing file, got %v", err)
+	}
+	if users := reloaded.Users(); len(users) != 2 || users[0].Name != "admin" || users[1].Name != "carol" {
+		t.Errorf("Unexpected users %+v", users)
+	}
+
+	logged.Reset()
+	LoadCredentialStore(path, "boot-key", "changed-secret")
+	if !strings.Contains(logged.String(), "SECRET_KEY") {
+		t.Errorf("Expected a warning about the ignored secret, got %q", logged.String())
+	}
+	logged.Reset()
+	LoadCredentialStore(path, "boot-key", "boot-secret")
+	if logged.Len() != 0 {
+		t.Errorf("Expected no warning for matching keys, got %q", logged.String())
+	}
+}
+
+func TestCredentialStoreReloadsEditedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := LoadCredentialStore(path, "boot-key", "boot-secret")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+
+	edited := `{"users": [{"name": "dave", "displayName": "Dave", "enabled": true,
+		"accessKeys": [{"accessKeyId": "dave-key", "secretAccessKey": "dave-secret", "enabled": true}]}]}`
+	if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	store.lastCheck = time.Time{}
+
+	if _, principal, err := store.Lookup("dave-key"); err != nil || principal.DisplayName != "Dave" {
+		t.Fatalf("Expected edited file to be picked up, got %+v, %v", principal, err)
+	}
+	if _, _, err := store.Lookup("boot-key"); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected removed key to be rejected, got %v", err)
+	}
+
+	// An invalid edit keeps the last valid credentials
+	os.WriteFile(path, []byte("{not json"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(path, future, future)
+	store.lastCheck = time.Time{}
+
+	if _, _, err := store.Lookup("dave-key"); err != nil {
+		t.Errorf("Expected last valid credentials after a bad edit, got %v", err)
+	}
+}
+
+func TestCredentialStoreUpdateKeepsEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := LoadCredentialStore(path, "boot-key", "boot-secret")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+
+	// An edit the store hasn't checked for yet is kept by the next change
+	edited := `{"users": [{"name": "dave", "displayName": "Dave", "enabled": true,
+		"accessKeys": [{"accessKeyId": "dave-key", "secretAccessKey": "dave-secret", "enabled": true}]}]}`
+	os.WriteFile(path, []byte(edited), 0600)
+	store.lastCheck = time.Now()
+	if err := store.CreateUser("erin", ""); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	reloaded, err := LoadCredentialStore(path, "", "")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+	if users := reloaded.Users(); len(users) != 2 || users[0].Name != "dave" || users[1].Name != "erin" {
+		t.Errorf("Expected the edit and the change to be saved, got %+v", users)
+	}
+
+	// A broken edit is left for its author to fix
+	os.WriteFile(path, []byte("{not json"), 0600)
+	if err := store.CreateUser("frank", ""); err == nil {
+		t.Errorf("Expected changes to be refused while the file is invalid")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{not json" {
+		t.Errorf("Expected the invalid file to be kept, got %s", data)
+	}
+}
+
+func TestCredentialStoreUpdateRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := LoadCredentialStore(path, "boot-key", "boot-secret")
+	if err != nil {
+		t.Fatalf("LoadCredentialStore failed: %v", err)
+	}
+	before := store.Users()
+
+	// A change that fails halfway, as when the file can't be written,
+	// leaves nothing behind
+	err = store.update(func() error {
+		user := store.keys["boot-key"]
+		user.Enabled = false
+		user.AccessKeys[0].Enabled = false
+		store.users["erin"] = &User{Name: "erin"}
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Fatal("Expected the failure to be reported")
+	}
+	if users := store.Users(); !reflect.DeepEqual(users, before) {
+		t.Errorf("Expected the store to be rolled back, got %+v", users)
+	}
+	if _, _, err := store.Lookup("boot-key"); err != nil {
+		t.Errorf("Expected boot-key to keep working, got %v", err)
+	}
+}
+
+func TestAuthenticateAttachesPrincipal(t *testing.T) {
+	store := NewCredentialStore()
+	store.CreateUser("erin", "Erin")
+	store.AddAccessKey("erin", "erin-key", "erin-secret")
+	auth := NewAWSV4AuthWithStore(store, "test-access-key", "test-secret-key", "us-east-1")
+
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	req.Header.Set("Host", req.Host)
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	credential := "erin-key/" + now.Format("20060102") + "/us-east-1/s3/aws4_request"
+	signedHeaders := "host;x-amz-date"
+	signature, err := auth.calculateSignature(req, "erin-secret", credential, signedHeaders)
+	if err != nil {
+		t.Fatalf("calculateSignature failed: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s, SignedHeaders=%s, Signature=%s", credential, signedHeaders, signature))
+
+	if err := auth.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	principal, ok := PrincipalFromContext(req.Context())
+	if !ok || principal.UserName != "erin" || principal.AccessKey != "erin-key" {
+		t.Errorf("Expected principal erin, got %+v", principal)
+	}
+
+	store.SetUserEnabled("erin", false)
+	if err := auth.Authenticate(req); !errors.Is(err, ErrAccessKeyDisabled) {
+		t.Errorf("Expected ErrAccessKeyDisabled, got %v", err)
+	}
+}
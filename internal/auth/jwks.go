@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInvalidJWT is returned for tokens that are malformed, unsigned by a known
+// key, expired or issued for someone else
+var ErrInvalidJWT = errors.New("invalid token")
+
+// jwk is a single JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS is a set of public keys used to verify JSON Web Tokens
+type JWKS struct {
+	keys map[string]crypto.PublicKey
+	// order keeps keys without a kid usable for tokens without a kid
+	order []crypto.PublicKey
+}
+
+// LoadJWKS reads a JSON Web Key Set from a file
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses a JSON Web Key Set. RSA and EC (P-256, P-384, P-521) keys
+// are supported; other key types are skipped.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	jwks := &JWKS{keys: make(map[string]crypto.PublicKey)}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", key.Kid, err)
+		}
+		if publicKey == nil {
+			continue
+		}
+		if key.Kid != "" {
+			jwks.keys[key.Kid] = publicKey
+		}
+		jwks.order = append(jwks.order, publicKey)
+	}
+	return jwks, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// Verify checks the signature and time claims (exp, nbf) of a compact JWT
+// and returns its claims. Tokens without an exp claim never expire and are
+// rejected.
+func (k *JWKS) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidJWT)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidJWT)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidJWT)
+	}
+
+	candidates := k.order
+	if header.Kid != "" {
+		key, ok := k.keys[header.Kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidJWT, header.Kid)
+		}
+		candidates = []crypto.PublicKey{key}
+	}
+
+	signed := parts[0] + "." + parts[1]
+	verified := false
+	for _, key := range candidates {
+		if verifyJWTSignature(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidJWT)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidJWT)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: token has no expiration", ErrInvalidJWT)
+	}
+	if !now.Before(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidJWT)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: token is not valid yet", ErrInvalidJWT)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature checks a JWS signature for the RS*, PS* and ES*
+// algorithms
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) == nil
+	case strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(rsaKey, hash, digest, signature, nil) == nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return false
+		}
+		size := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(ecKey, digest, r, s)
+	}
+	return false
+}
+
+// identityFromClaims checks the sub, iss and aud claims of a verified token.
+// issuer and audience, when not empty, must match.
+func identityFromClaims(claims map[string]interface{}, issuer, audience string) (*WebIdentity, error) {
+	identity := &WebIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Issuer, _ = claims["iss"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidJWT)
+	}
+	if issuer != "" && identity.Issuer != issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidJWT, identity.Issuer)
+	}
+
+	audiences := claimStrings(claims["aud"])
+	if len(audiences) > 0 {
+		identity.Audience = audiences[0]
+	}
+	if audience != "" {
+		matched := false
+		for _, aud := range audiences {
+			if aud == audience {
+				identity.Audience = aud
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: token is not issued for %q", ErrInvalidJWT, audience)
+		}
+	}
+	return identity, nil
+}
+
+// claimStrings returns a claim that is either a single string or a list of
+// strings
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
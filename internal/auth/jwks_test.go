@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testJWTKeys holds an RSA and an EC signing key and their JWKS
+type testJWTKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []byte
+}
+
+func newTestJWTKeys(t *testing.T) *testJWTKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"},
+		},
+	})
+	return &testJWTKeys{rsa: rsaKey, ec: ecKey, jwks: jwks}
+}
+
+// sign creates a compact JWT signed with RS256 or ES256
+func (k *testJWTKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign JWT: %v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign JWT: %v", err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWKSVerify(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwks, err := ParseJWKS(keys.jwks)
+	if err != nil {
+		t.Fatalf("ParseJWKS failed: %v", err)
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "user-1", "exp": now.Add(time.Hour).Unix()}
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa-1", "ES256": "ec-1"}[alg]
+		verified, err := jwks.Verify(keys.sign(t, alg, kid, claims), now)
+		if err != nil {
+			t.Errorf("%s: Verify failed: %v", alg, err)
+			continue
+		}
+		if verified["sub"] != "user-1" {
+			t.Errorf("%s: Expected sub user-1, got %v", alg, verified["sub"])
+		}
+	}
+
+	// Without a kid every key is tried
+	if _, err := jwks.Verify(keys.sign(t, "ES256", "", claims), now); err != nil {
+		t.Errorf("Expected token without kid to verify, got %v", err)
+	}
+
+	valid := keys.sign(t, "RS256", "rsa-1", claims)
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+	}{
+		{"malformed", "not-a-jwt", now},
+		{"unknown kid", keys.sign(t, "RS256", "other", claims), now},
+		{"wrong key", keys.sign(t, "ES256", "rsa-1", claims), now},
+		{"tampered", valid[:len(valid)-4] + "AAAA", now},
+		{"expired", valid, now.Add(2 * time.Hour)},
+		{"not yet valid", keys.sign(t, "RS256", "rsa-1", map[string]interface{}{"sub": "user-1", "exp": now.Add(2 * time.Hour).Unix(), "nbf": now.Add(time.Hour).Unix()}), now},
+		{"no expiration", keys.sign(t, "RS256", "rsa-1", map[string]interface{}{"sub": "user-1"}), now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := jwks.Verify(tt.token, tt.now); !errors.Is(err, ErrInvalidJWT) {
+				t.Errorf("Expected ErrInvalidJWT, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseJWKSInvalid(t *testing.T) {
+	if _, err := ParseJWKS([]byte("not json")); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+	if _, err := ParseJWKS([]byte(`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`)); err == nil {
+		t.Error("Expected error for point not on curve")
+	}
+}
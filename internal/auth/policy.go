@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Decision is the outcome of evaluating a policy for a request
+type Decision int
+
+const (
+	// DecisionNone means no statement applies to the request
+	DecisionNone Decision = iota
+	DecisionAllow
+	DecisionDeny
+)
+
+// Policy is an IAM-style policy document, as used by bucket policies
+type Policy struct {
+	Version   string            `json:"Version,omitempty"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a single statement of a policy. Principal is only set
+// in resource policies such as bucket policies.
+type PolicyStatement struct {
+	Sid       string           `json:"Sid,omitempty"`
+	Effect    string           `json:"Effect"`
+	Principal *PolicyPrincipal `json:"Principal,omitempty"`
+	Action    stringList       `json:"Action"`
+	Resource  stringList       `json:"Resource"`
+	Condition json.RawMessage  `json:"Condition,omitempty"`
+}
+
+// PolicyPrincipal lists who a statement applies to. It is written either as
+// "*" or as {"AWS": ...}.
+type PolicyPrincipal struct {
+	AWS stringList
+}
+
+func (p *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("invalid principal %q", wildcard)
+		}
+		p.AWS = stringList{"*"}
+		return nil
+	}
+
+	var principal struct {
+		AWS stringList `json:"AWS"`
+	}
+	if err := json.Unmarshal(data, &principal); err != nil {
+		return err
+	}
+	if len(principal.AWS) == 0 {
+		return fmt.Errorf("principal must name AWS principals")
+	}
+	p.AWS = principal.AWS
+	return nil
+}
+
+func (p PolicyPrincipal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]stringList{"AWS": p.AWS})
+}
+
+// stringList is a policy element that may be a single string or a list
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ParsePolicy parses and validates a policy document
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %v", err)
+	}
+	if len(policy.Statement) == 0 {
+		return nil, fmt.Errorf("policy has no statements")
+	}
+
+	for i, statement := range policy.Statement {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return nil, fmt.Errorf("statement %d: invalid effect %q", i, statement.Effect)
+		}
+		if len(statement.Action) == 0 {
+			return nil, fmt.Errorf("statement %d: missing action", i)
+		}
+		if len(statement.Resource) == 0 {
+			return nil, fmt.Errorf("statement %d: missing resource", i)
+		}
+		if len(statement.Condition) > 0 {
+			return nil, fmt.Errorf("statement %d: conditions are not supported", i)
+		}
+	}
+	return &policy, nil
+}
+
+// Evaluate returns whether the policy allows or denies action on resource
+// for principal. An explicit deny wins over any allow.
+func (p *Policy) Evaluate(principal *Principal, action, resource string) Decision {
+	decision := DecisionNone
+	for _, statement := range p.Statement {
+		if !statement.applies(principal, action, resource) {
+			continue
+		}
+		if statement.Effect == "Deny" {
+			return DecisionDeny
+		}
+		decision = DecisionAllow
+	}
+	return decision
+}
+
+// IsPublic reports whether the policy allows anything to everyone
+func (p *Policy) IsPublic() bool {
+	for _, statement := range p.Statement {
+		if statement.Effect == "Allow" && statement.Principal != nil && statement.Principal.AWS.contains("*") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PolicyStatement) applies(principal *Principal, action, resource string) bool {
+	if s.Principal != nil && !s.Principal.matches(principal) {
+		return false
+	}
+	return s.Action.matches(action, true) && s.Resource.matches(resource, false)
+}
+
+// matches reports whether the principal is named, by user name, role or
+// IAM user ARN
+func (p *PolicyPrincipal) matches(principal *Principal) bool {
+	for _, name := range p.AWS {
+		switch {
+		case name == "*":
+			return true
+		case principal.IsAnonymous():
+			continue
+		case name == principal.UserName, principal.Role != "" && name == principal.Role,
+			strings.HasSuffix(name, ":user/"+principal.UserName):
+			return true
+		}
+	}
+	return false
+}
+
+func (l stringList) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether any pattern of the list matches value. Actions are
+// case-insensitive, resources are not.
+func (l stringList) matches(value string, foldCase bool) bool {
+	for _, pattern := range l {
+		if foldCase {
+			if wildcardMatch(strings.ToLower(pattern), strings.ToLower(value)) {
+				return true
+			}
+		} else if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches s against a pattern in which '*' matches any run of
+// characters, including '/', and '?' matches a single character
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, match := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			i = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Allowed reports whether the identity policies of the principal allow action
+// on resource. A principal without policies is unrestricted.
+func (p *Principal) Allowed(action, resource string) bool {
+	if p.Policies == nil {
+		return true
+	}
+	decision := DecisionNone
+	for _, policy := range p.Policies {
+		switch policy.Evaluate(p, action, resource) {
+		case DecisionDeny:
+			return false
+		case DecisionAllow:
+			decision = DecisionAllow
+		}
+	}
+	return decision == DecisionAllow
+}
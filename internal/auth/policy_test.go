@@ -0,0 +1,83 @@
+package auth
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	valid := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": {"AWS": ["alice", "*"]}, "Action": ["s3:GetObject"], "Resource": "arn:aws:s3:::b/*"}]}`
+	policy, err := ParsePolicy([]byte(valid))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	if !policy.IsPublic() {
+		t.Error("Expected a policy allowing * to be public")
+	}
+
+	invalid := map[string]string{
+		"not json":      `{`,
+		"no statements": `{"Statement": []}`,
+		"bad effect":    `{"Statement": [{"Effect": "Maybe", "Action": "s3:*", "Resource": "*"}]}`,
+		"no action":     `{"Statement": [{"Effect": "Allow", "Resource": "*"}]}`,
+		"no resource":   `{"Statement": [{"Effect": "Allow", "Action": "s3:*"}]}`,
+		"condition":     `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*", "Condition": {"Bool": {"aws:SecureTransport": "true"}}}]}`,
+		"bad principal": `{"Statement": [{"Effect": "Allow", "Principal": "alice", "Action": "s3:*", "Resource": "*"}]}`,
+	}
+	for name, document := range invalid {
+		if _, err := ParsePolicy([]byte(document)); err == nil {
+			t.Errorf("%s: expected policy to be rejected", name)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{"Statement": [
+		{"Effect": "Allow", "Principal": "*", "Action": "s3:Get*", "Resource": "arn:aws:s3:::b/public/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::b/public/secret?.txt"},
+		{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:user/alice"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::b/*"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	anonymous := AnonymousPrincipal()
+	alice := &Principal{UserName: "alice", AccessKey: "alice-key"}
+
+	tests := []struct {
+		principal *Principal
+		action    string
+		resource  string
+		want      Decision
+	}{
+		{anonymous, "s3:GetObject", "arn:aws:s3:::b/public/dir/a.txt", DecisionAllow},
+		{anonymous, "S3:GETOBJECT", "arn:aws:s3:::b/public/a.txt", DecisionAllow},
+		{anonymous, "s3:GetObject", "arn:aws:s3:::b/public/secret1.txt", DecisionDeny},
+		{anonymous, "s3:GetObject", "arn:aws:s3:::b/PUBLIC/a.txt", DecisionNone},
+		{anonymous, "s3:PutObject", "arn:aws:s3:::b/a.txt", DecisionNone},
+		{alice, "s3:PutObject", "arn:aws:s3:::b/a.txt", DecisionAllow},
+		{&Principal{UserName: "bob"}, "s3:PutObject", "arn:aws:s3:::b/a.txt", DecisionNone},
+	}
+	for _, tt := range tests {
+		if got := policy.Evaluate(tt.principal, tt.action, tt.resource); got != tt.want {
+			t.Errorf("%s %s %s: expected %v, got %v", tt.principal.UserName, tt.action, tt.resource, tt.want, got)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a/*/c", "a/b/x/c", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*.txt", "dir/file.txt", true},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q): expected %v, got %v", tt.pattern, tt.s, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// PostPolicySignature holds the signing fields of a browser POST upload
+type PostPolicySignature struct {
+	// Policy is the base64-encoded policy document
+	Policy string
+
+	// Credential, Date, Token and Signature are the x-amz-credential,
+	// x-amz-date, x-amz-security-token and x-amz-signature fields
+	Credential string
+	Date       string
+	Token      string
+	Signature  string
+}
+
+// VerifyPostPolicy checks the SigV4 signature of a browser POST upload and
+// returns the principal that signed it. The signature covers the
+// base64-encoded policy document, signed with the key derived from the
+// credential scope. The scope is checked like that of a presigned URL, as
+// the policy's expiration limits how long the form can be used.
+func (a *AWSV4Auth) VerifyPostPolicy(form *PostPolicySignature) (*Principal, error) {
+	credentialParts := strings.Split(form.Credential, "/")
+	if len(credentialParts) != 5 {
+		return nil, fmt.Errorf("invalid credential format")
+	}
+	if credentialParts[4] != "aws4_request" {
+		return nil, fmt.Errorf("invalid credential terminator")
+	}
+
+	secretKey, principal, err := a.credentials.lookupCredentials(credentialParts[0], form.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &signedRequest{
+		algorithm:  algorithmV4,
+		accessKey:  credentialParts[0],
+		credential: form.Credential,
+		presigned:  true,
+	}
+	if err := a.checkScope(form.Date, "s3", req); err != nil {
+		return nil, err
+	}
+
+	signingKey := getSigningKey(secretKey, credentialParts[1], credentialParts[2], credentialParts[3])
+	expectedSignature := hex.EncodeToString(hmacSHA256(signingKey, form.Policy))
+
+	if !hmac.Equal([]byte(form.Signature), []byte(expectedSignature)) {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+
+	return principal, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signPolicy(secretKey, date, region, policy string) string {
+	sign := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := sign(sign(sign(sign([]byte("AWS4"+secretKey), date), region), "s3"), "aws4_request")
+	return hex.EncodeToString(sign(key, policy))
+}
+
+func TestVerifyPostPolicy(t *testing.T) {
+	auth := NewAWSV4Auth("test-access-key", "test-secret-key", "us-east-1")
+	policy := "eyJleHBpcmF0aW9uIjoiMjAzMC0wMS0wMVQwMDowMDowMFoiLCJjb25kaXRpb25zIjpbXX0="
+	credential := "test-access-key/20240101/us-east-1/s3/aws4_request"
+	signature := signPolicy("test-secret-key", "20240101", "us-east-1", policy)
+	form := func(modify func(*PostPolicySignature)) *PostPolicySignature {
+		f := &PostPolicySignature{Policy: policy, Credential: credential, Date: "20240101T000000Z", Signature: signature}
+		if modify != nil {
+			modify(f)
+		}
+		return f
+	}
+
+	principal, err := auth.VerifyPostPolicy(form(nil))
+	if err != nil {
+		t.Fatalf("Expected valid policy signature, got %v", err)
+	}
+	if principal.AccessKey != "test-access-key" {
+		t.Errorf("Expected the signing principal, got %+v", principal)
+	}
+
+	tomorrow := time.Now().Add(48 * time.Hour).UTC()
+	tests := []struct {
+		name   string
+		modify func(*PostPolicySignature)
+		err    error
+	}{
+		{"tampered policy", func(f *PostPolicySignature) { f.Policy += "x" }, nil},
+		{"wrong signature", func(f *PostPolicySignature) {
+			f.Signature = signPolicy("other-secret", "20240101", "us-east-1", policy)
+		}, nil},
+		{"unknown access key", func(f *PostPolicySignature) { f.Credential = "other-key/20240101/us-east-1/s3/aws4_request" }, nil},
+		{"malformed credential", func(f *PostPolicySignature) { f.Credential = "test-access-key/20240101" }, nil},
+		{"bad terminator", func(f *PostPolicySignature) { f.Credential = "test-access-key/20240101/us-east-1/s3/aws4" }, nil},
+		{"missing date", func(f *PostPolicySignature) { f.Date = "" }, nil},
+		{"date mismatch", func(f *PostPolicySignature) { f.Date = "20240102T000000Z" }, ErrAuthorizationHeaderMalformed},
+		{"future date", func(f *PostPolicySignature) {
+			f.Date = tomorrow.Format(amzDateFormat)
+			f.Credential = "test-access-key/" + tomorrow.Format("20060102") + "/us-east-1/s3/aws4_request"
+		}, ErrRequestTimeTooSkewed},
+		{"wrong region", func(f *PostPolicySignature) { f.Credential = "test-access-key/20240101/eu-west-1/s3/aws4_request" }, ErrAuthorizationHeaderMalformed},
+		{"wrong service", func(f *PostPolicySignature) { f.Credential = "test-access-key/20240101/us-east-1/sts/aws4_request" }, ErrAuthorizationHeaderMalformed},
+		{"unexpected token", func(f *PostPolicySignature) { f.Token = "token" }, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		_, err := auth.VerifyPostPolicy(form(tt.modify))
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+			t.Errorf("%s: expected verification to fail with %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestVerifyPostPolicySession(t *testing.T) {
+	auth := NewAWSV4Auth("test-access-key", "test-secret-key", "us-east-1")
+	session := auth.credentials.AddSession(Principal{UserName: "alice"}, time.Hour)
+	policy := "eyJleHBpcmF0aW9uIjoiMjAzMC0wMS0wMVQwMDowMDowMFoiLCJjb25kaXRpb25zIjpbXX0="
+	form := &PostPolicySignature{
+		Policy:     policy,
+		Credential: session.AccessKeyID + "/20240101/us-east-1/s3/aws4_request",
+		Date:       "20240101T000000Z",
+		Token:      session.SessionToken,
+		Signature:  signPolicy(session.SecretAccessKey, "20240101", "us-east-1", policy),
+	}
+
+	principal, err := auth.VerifyPostPolicy(form)
+	if err != nil {
+		t.Fatalf("Expected temporary credentials to be accepted, got %v", err)
+	}
+	if principal.UserName != "alice" {
+		t.Errorf("Expected the session principal, got %+v", principal)
+	}
+
+	form.Token = "wrong"
+	if _, err := auth.VerifyPostPolicy(form); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
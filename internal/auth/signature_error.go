@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SignatureError is returned when a request signature doesn't match. It
+// carries what the server signed, so clients can compare it with what they
+// signed themselves.
+type SignatureError struct {
+	AccessKeyID       string
+	SignatureProvided string
+	StringToSign      string
+
+	// CanonicalRequest is empty for SigV2, which signs no canonical request
+	CanonicalRequest string
+}
+
+func (e *SignatureError) Error() string {
+	return "signature mismatch"
+}
+
+// StringToSignBytes returns the string to sign as space-separated hex bytes
+func (e *SignatureError) StringToSignBytes() string {
+	return hexBytes(e.StringToSign)
+}
+
+// CanonicalRequestBytes returns the canonical request as space-separated hex
+// bytes
+func (e *SignatureError) CanonicalRequestBytes() string {
+	return hexBytes(e.CanonicalRequest)
+}
+
+func hexBytes(s string) string {
+	if s == "" {
+		return ""
+	}
+	parts := make([]string, len(s))
+	for i := 0; i < len(s); i++ {
+		parts[i] = fmt.Sprintf("%02x", s[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// signatureMismatch builds the error for a failed signature check, logging
+// the server's side of the comparison in debug mode
+func (a *AWSV4Auth) signatureMismatch(r *http.Request, accessKey, provided, stringToSign, canonicalRequest string) error {
+	if a.debug {
+		logrus.WithFields(logrus.Fields{
+			"method":            r.Method,
+			"uri":               r.URL.RequestURI(),
+			"accessKeyId":       accessKey,
+			"authorization":     r.Header.Get("Authorization"),
+			"signatureProvided": provided,
+		}).Warnf("Signature mismatch\n--- server canonical request ---\n%s\n--- server string to sign ---\n%s", canonicalRequest, stringToSign)
+	}
+
+	return &SignatureError{
+		AccessKeyID:       accessKey,
+		SignatureProvided: provided,
+		StringToSign:      stringToSign,
+		CanonicalRequest:  canonicalRequest,
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureErrorV4(t *testing.T) {
+	auth := NewAWSV4Auth("test", "test-secret", "us-east-1")
+	now := time.Now().UTC()
+
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(t, auth, req, "wrong-secret", now, now.Format("20060102"), "us-east-1", "s3")
+
+	var sigErr *SignatureError
+	if err := auth.Authenticate(req); !errors.As(err, &sigErr) {
+		t.Fatalf("Expected SignatureError, got %v", err)
+	}
+	if sigErr.AccessKeyID != "test" || !strings.Contains(req.Header.Get("Authorization"), sigErr.SignatureProvided) {
+		t.Errorf("Unexpected access key or signature in %+v", sigErr)
+	}
+	if !strings.HasPrefix(sigErr.CanonicalRequest, "GET\n/bucket/key\n") {
+		t.Errorf("Unexpected canonical request %q", sigErr.CanonicalRequest)
+	}
+	if !strings.HasPrefix(sigErr.StringToSign, "AWS4-HMAC-SHA256\n") || !strings.HasSuffix(sigErr.StringToSign, sha256Hex(sigErr.CanonicalRequest)) {
+		t.Errorf("Unexpected string to sign %q", sigErr.StringToSign)
+	}
+	if !strings.HasPrefix(sigErr.CanonicalRequestBytes(), "47 45 54 0a 2f") {
+		t.Errorf("Unexpected canonical request bytes %q", sigErr.CanonicalRequestBytes())
+	}
+}
+
+func TestSignatureErrorV2(t *testing.T) {
+	auth := NewAWSV4Auth("test", "test-secret", "us-east-1")
+
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	req.Header.Set("Date", time.Now().UTC().Format(time.RFC1123))
+	req.Header.Set("Authorization", "AWS test:bm90LXRoZS1zaWduYXR1cmU=")
+
+	var sigErr *SignatureError
+	if err := auth.Authenticate(req); !errors.As(err, &sigErr) {
+		t.Fatalf("Expected SignatureError, got %v", err)
+	}
+	if sigErr.SignatureProvided != "bm90LXRoZS1zaWduYXR1cmU=" || sigErr.CanonicalRequest != "" {
+		t.Errorf("Unexpected SigV2 error %+v", sigErr)
+	}
+	if sigErr.StringToSign != stringToSignV2(req, req.Header.Get("Date")) {
+		t.Errorf("Unexpected string to sign %q", sigErr.StringToSign)
+	}
+	if sigErr.CanonicalRequestBytes() != "" {
+		t.Errorf("Expected no canonical request bytes, got %q", sigErr.CanonicalRequestBytes())
+	}
+}
+
+func TestSignatureErrorDebug(t *testing.T) {
+	auth := NewAWSV4Auth("test", "test-secret", "us-east-1")
+	auth.SetDebug(true)
+	now := time.Now().UTC()
+
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(t, auth, req, "wrong-secret", now, now.Format("20060102"), "us-east-1", "s3")
+	if err := auth.Authenticate(req); err == nil || err.Error() != "signature mismatch" {
+		t.Errorf("Expected signature mismatch in debug mode, got %v", err)
+	}
+}
+
+func TestHexBytes(t *testing.T) {
+	if got := hexBytes("A\n"); got != "41 0a" {
+		t.Errorf("Expected \"41 0a\", got %q", got)
+	}
+	if got := hexBytes(""); got != "" {
+		t.Errorf("Expected empty string, got %q", got)
+	}
+}
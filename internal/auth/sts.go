@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrExpiredToken is returned for temporary credentials past their expiration
+	ErrExpiredToken = errors.New("the provided token has expired")
+
+	// ErrInvalidToken is returned when a session token is missing, doesn't
+	// match the access key, or is sent with a long-term access key
+	ErrInvalidToken = errors.New("the provided token is malformed or otherwise invalid")
+
+	// ErrSessionCaller is returned when temporary credentials ask for new
+	// ones, which would let them renew themselves forever
+	ErrSessionCaller = errors.New("cannot call GetSessionToken with session credentials")
+)
+
+// expiredSessionRetention is how long expired sessions are remembered so
+// that clients get ExpiredToken instead of an unknown access key
+const expiredSessionRetention = time.Hour
+
+// Session is a set of temporary credentials issued by the STS endpoint
+type Session struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+	Principal       Principal
+
+	// SourceAccessKey is the long-term access key the session was issued
+	// to, directly or through other sessions. The session only works while
+	// that key and its user are enabled. It is empty for web identities.
+	SourceAccessKey string
+}
+
+// AddSession issues temporary credentials for principal, valid for duration.
+// The access key of principal, when set, is the key the session is issued
+// to.
+func (s *CredentialStore) AddSession(principal Principal, duration time.Duration) *Session {
+	session := &Session{
+		AccessKeyID:     "ASIA" + randomString(16, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"),
+		SecretAccessKey: randomString(40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"),
+		SessionToken:    randomString(256, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"),
+		Expiration:      time.Now().Add(duration).UTC().Truncate(time.Second),
+	}
+	session.Principal = principal
+	session.Principal.AccessKey = session.AccessKeyID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.SourceAccessKey = principal.AccessKey
+	if source, ok := s.sessions[principal.AccessKey]; ok {
+		session.SourceAccessKey = source.SourceAccessKey
+	}
+
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if now.Sub(existing.Expiration) > expiredSessionRetention {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.AccessKeyID] = session
+	return session
+}
+
+// LookupSession returns the secret and principal of temporary credentials.
+// ErrUnknownAccessKey means accessKey doesn't belong to a session. Sessions
+// end early when the access key they were issued to is deleted or disabled.
+func (s *CredentialStore) LookupSession(accessKey, token string) (string, *Principal, error) {
+	s.mu.RLock()
+	session, ok := s.sessions[accessKey]
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", nil, ErrUnknownAccessKey
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(session.SessionToken)) != 1 {
+		return "", nil, ErrInvalidToken
+	}
+	if !time.Now().Before(session.Expiration) {
+		return "", nil, ErrExpiredToken
+	}
+	if session.SourceAccessKey != "" {
+		_, source, err := s.Lookup(session.SourceAccessKey)
+		if errors.Is(err, ErrUnknownAccessKey) || (err == nil && source.UserName != session.Principal.UserName) {
+			return "", nil, ErrInvalidToken
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	principal := session.Principal
+	return session.SecretAccessKey, &principal, nil
+}
+
+// isSession reports whether accessKey belongs to temporary credentials
+func (s *CredentialStore) isSession(accessKey string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.sessions[accessKey]
+	return ok
+}
+
+// lookupCredentials resolves the secret for an access key, checking the
+// session token of temporary credentials
+func (s *CredentialStore) lookupCredentials(accessKey, token string) (string, *Principal, error) {
+	secret, principal, err := s.LookupSession(accessKey, token)
+	if !errors.Is(err, ErrUnknownAccessKey) {
+		return secret, principal, err
+	}
+	if token != "" {
+		return "", nil, ErrInvalidToken
+	}
+	return s.Lookup(accessKey)
+}
+
+// STS issues temporary credentials into a credential store
+type STS struct {
+	credentials *CredentialStore
+
+	// jwks verifies web identity tokens. Without it
+	// AssumeRoleWithWebIdentity is rejected.
+	jwks     *JWKS
+	issuer   string
+	audience string
+
+	// mapping gives web identities their policies. Without policies they
+	// may do nothing.
+	mapping *ClaimMapping
+}
+
+// NewSTS creates a token service. issuer and audience, when not empty, must
+// match the iss and aud claims of web identity tokens. mapping may be nil.
+func NewSTS(store *CredentialStore, jwks *JWKS, issuer, audience string, mapping *ClaimMapping) *STS {
+	return &STS{
+		credentials: store,
+		jwks:        jwks,
+		issuer:      issuer,
+		audience:    audience,
+		mapping:     mapping,
+	}
+}
+
+// GetSessionToken issues temporary credentials acting as principal itself.
+// It returns ErrSessionCaller when principal signed with temporary
+// credentials.
+func (s *STS) GetSessionToken(principal *Principal, duration time.Duration) (*Session, error) {
+	if s.credentials.isSession(principal.AccessKey) {
+		return nil, ErrSessionCaller
+	}
+	return s.credentials.AddSession(Principal{
+		UserName:    principal.UserName,
+		DisplayName: principal.DisplayName,
+		AccessKey:   principal.AccessKey,
+		Policies:    principal.Policies,
+	}, duration), nil
+}
+
+// AssumeRole issues temporary credentials for principal acting as roleArn
+func (s *STS) AssumeRole(principal *Principal, roleArn, sessionName string, duration time.Duration) *Session {
+	return s.credentials.AddSession(Principal{
+		UserName:    principal.UserName,
+		DisplayName: principal.DisplayName,
+		AccessKey:   principal.AccessKey,
+		Role:        roleArn,
+		SessionName: sessionName,
+		Policies:    principal.Policies,
+	}, duration)
+}
+
+// WebIdentity is the verified subject of a web identity token
+type WebIdentity struct {
+	Subject  string
+	Audience string
+	Issuer   string
+}
+
+// AssumeRoleWithWebIdentity verifies a JWT against the configured JWKS and
+// issues temporary credentials for its subject acting as roleArn. Unlike
+// signed callers, web identities are only allowed what the policies of the
+// claim mapping grant them.
+func (s *STS) AssumeRoleWithWebIdentity(token, roleArn, sessionName string, duration time.Duration) (*Session, *WebIdentity, error) {
+	if s.jwks == nil {
+		return nil, nil, fmt.Errorf("%w: web identity federation is not configured", ErrInvalidJWT)
+	}
+
+	claims, err := s.jwks.Verify(token, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity, err := identityFromClaims(claims, s.issuer, s.audience)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	principal := s.mapping.principal(claims, identity)
+	if principal.Policies == nil {
+		principal.Policies = []*Policy{}
+	}
+	principal.Role = roleArn
+	principal.SessionName = sessionName
+	session := s.credentials.AddSession(*principal, duration)
+	return session, identity, nil
+}
+
+// AssumedRoleARN returns the ARN of a role session, such as
+// arn:aws:sts::123456789012:assumed-role/reader/session
+func AssumedRoleARN(roleArn, sessionName string) string {
+	account := ""
+	if parts := strings.SplitN(roleArn, ":", 6); len(parts) == 6 {
+		account = parts[4]
+	}
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:]
+	return fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s/%s", account, roleName, sessionName)
+}
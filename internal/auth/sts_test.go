@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCredentialStoreSessions(t *testing.T) {
+	store := NewCredentialStore()
+	store.CreateUser("alice", "Alice")
+	store.AddAccessKey("alice", "alice-key", "alice-secret")
+
+	session := store.AddSession(Principal{UserName: "alice", DisplayName: "Alice"}, time.Hour)
+	if session.AccessKeyID == "" || session.SecretAccessKey == "" || session.SessionToken == "" {
+		t.Fatalf("Expected a full credential triple, got %+v", session)
+	}
+
+	secret, principal, err := store.lookupCredentials(session.AccessKeyID, session.SessionToken)
+	if err != nil {
+		t.Fatalf("lookupCredentials failed: %v", err)
+	}
+	if secret != session.SecretAccessKey || principal.UserName != "alice" || principal.AccessKey != session.AccessKeyID {
+		t.Errorf("Unexpected session lookup %q, %+v", secret, principal)
+	}
+
+	tests := []struct {
+		name      string
+		accessKey string
+		token     string
+		want      error
+	}{
+		{"missing token", session.AccessKeyID, "", ErrInvalidToken},
+		{"wrong token", session.AccessKeyID, "wrong", ErrInvalidToken},
+		{"token with long-term key", "alice-key", session.SessionToken, ErrInvalidToken},
+		{"unknown key", "ASIAUNKNOWN", "token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.lookupCredentials(tt.accessKey, tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, _, err := store.lookupCredentials("alice-key", ""); err != nil {
+		t.Errorf("Expected long-term key without token to work, got %v", err)
+	}
+
+	session.Expiration = time.Now().Add(-time.Minute)
+	if _, _, err := store.lookupCredentials(session.AccessKeyID, session.SessionToken); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+
+	// Sessions long past their expiration are dropped when new ones are issued
+	session.Expiration = time.Now().Add(-2 * expiredSessionRetention)
+	store.AddSession(Principal{UserName: "alice"}, time.Hour)
+	if _, _, err := store.LookupSession(session.AccessKeyID, session.SessionToken); !errors.Is(err, ErrUnknownAccessKey) {
+		t.Errorf("Expected old session to be dropped, got %v", err)
+	}
+}
+
+func TestSTSAssumeRole(t *testing.T) {
+	store := NewCredentialStore()
+	store.CreateUser("alice", "Alice")
+	store.AddAccessKey("alice", "alice-key", "alice-secret")
+	sts := NewSTS(store, nil, "", "", nil)
+	caller := &Principal{UserName: "alice", DisplayName: "Alice", AccessKey: "alice-key"}
+
+	session := sts.AssumeRole(caller, "arn:aws:iam::123456789012:role/reader", "build-42", 15*time.Minute)
+	_, principal, err := store.LookupSession(session.AccessKeyID, session.SessionToken)
+	if err != nil {
+		t.Fatalf("LookupSession failed: %v", err)
+	}
+	if principal.UserName != "alice" || principal.Role != "arn:aws:iam::123456789012:role/reader" || principal.SessionName != "build-42" {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+	if until := time.Until(session.Expiration); until > 15*time.Minute || until < 14*time.Minute {
+		t.Errorf("Expected expiration in 15 minutes, got %v", until)
+	}
+
+	// Sessions of a restricted caller keep its policies
+	caller.Policies = []*Policy{}
+	session, _ = sts.GetSessionToken(caller, 15*time.Minute)
+	if _, principal, _ := store.LookupSession(session.AccessKeyID, session.SessionToken); principal == nil || principal.Policies == nil {
+		t.Errorf("Expected session to inherit the caller's policies, got %+v", principal)
+	}
+
+	if arn := AssumedRoleARN("arn:aws:iam::123456789012:role/team/reader", "build-42"); arn != "arn:aws:sts::123456789012:assumed-role/reader/build-42" {
+		t.Errorf("Unexpected assumed role ARN %s", arn)
+	}
+}
+
+func TestSessionsEndWithTheirSourceKey(t *testing.T) {
+	store := NewCredentialStore()
+	store.CreateUser("alice", "Alice")
+	store.AddAccessKey("alice", "alice-key", "alice-secret")
+	store.AddAccessKey("alice", "other-key", "other-secret")
+	sts := NewSTS(store, nil, "", "", nil)
+	roleArn := "arn:aws:iam::123456789012:role/reader"
+
+	session, _ := sts.GetSessionToken(&Principal{UserName: "alice", AccessKey: "alice-key"}, time.Hour)
+	// A role assumed with the session is bound to the same key
+	_, principal, _ := store.LookupSession(session.AccessKeyID, session.SessionToken)
+	chained := sts.AssumeRole(principal, roleArn, "chained", time.Hour)
+	other, _ := sts.GetSessionToken(&Principal{UserName: "alice", AccessKey: "other-key"}, time.Hour)
+	if chained.SourceAccessKey != "alice-key" {
+		t.Errorf("Expected the chained session to be bound to alice-key, got %q", chained.SourceAccessKey)
+	}
+
+	lookup := func(session *Session) error {
+		_, _, err := store.LookupSession(session.AccessKeyID, session.SessionToken)
+		return err
+	}
+	// Sessions can't renew themselves
+	if _, err := sts.GetSessionToken(principal, time.Hour); !errors.Is(err, ErrSessionCaller) {
+		t.Errorf("Expected ErrSessionCaller, got %v", err)
+	}
+
+	store.SetAccessKeyEnabled("alice-key", false)
+	for _, s := range []*Session{session, chained} {
+		if err := lookup(s); !errors.Is(err, ErrAccessKeyDisabled) {
+			t.Errorf("Expected ErrAccessKeyDisabled with the key disabled, got %v", err)
+		}
+	}
+	if err := lookup(other); err != nil {
+		t.Errorf("Expected sessions of other keys to work, got %v", err)
+	}
+
+	store.SetAccessKeyEnabled("alice-key", true)
+	if err := lookup(session); err != nil {
+		t.Errorf("Expected the session to work with the key enabled again, got %v", err)
+	}
+
+	store.SetUserEnabled("alice", false)
+	if err := lookup(other); !errors.Is(err, ErrAccessKeyDisabled) {
+		t.Errorf("Expected ErrAccessKeyDisabled with the user disabled, got %v", err)
+	}
+	store.SetUserEnabled("alice", true)
+
+	store.DeleteAccessKey("alice-key")
+	if err := lookup(session); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken with the key deleted, got %v", err)
+	}
+	store.DeleteUser("alice")
+	if err := lookup(other); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken with the user deleted, got %v", err)
+	}
+}
+
+func TestSTSAssumeRoleWithWebIdentity(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwks, err := ParseJWKS(keys.jwks)
+	if err != nil {
+		t.Fatalf("ParseJWKS failed: %v", err)
+	}
+	store := NewCredentialStore()
+	sts := NewSTS(store, jwks, "https://idp.local", "locals3", nil)
+	roleArn := "arn:aws:iam::123456789012:role/ci"
+
+	claims := func(iss string, aud interface{}) map[string]interface{} {
+		return map[string]interface{}{"sub": "repo:app", "iss": iss, "aud": aud, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	session, identity, err := sts.AssumeRoleWithWebIdentity(keys.sign(t, "ES256", "ec-1", claims("https://idp.local", []string{"other", "locals3"})), roleArn, "ci-run", time.Hour)
+	if err != nil {
+		t.Fatalf("AssumeRoleWithWebIdentity failed: %v", err)
+	}
+	if identity.Subject != "repo:app" || identity.Audience != "locals3" || identity.Issuer != "https://idp.local" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	_, principal, err := store.LookupSession(session.AccessKeyID, session.SessionToken)
+	if err != nil || principal.UserName != "repo:app" || principal.Role != roleArn {
+		t.Fatalf("Unexpected session principal %+v, %v", principal, err)
+	}
+	// Without a claim mapping web identities may do nothing
+	if principal.Allowed("s3:GetObject", "arn:aws:s3:::bucket/key") {
+		t.Errorf("Expected an unmapped web identity to be denied, got %+v", principal.Policies)
+	}
+
+	mapping, err := ParseClaimMapping([]byte(`{
+		"policies": {"read": {"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}},
+		"defaultPolicies": ["read"]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseClaimMapping failed: %v", err)
+	}
+	mapped := NewSTS(store, jwks, "https://idp.local", "locals3", mapping)
+	session, _, err = mapped.AssumeRoleWithWebIdentity(keys.sign(t, "ES256", "ec-1", claims("https://idp.local", "locals3")), roleArn, "ci-run", time.Hour)
+	if err != nil {
+		t.Fatalf("AssumeRoleWithWebIdentity failed: %v", err)
+	}
+	_, principal, _ = store.LookupSession(session.AccessKeyID, session.SessionToken)
+	if !principal.Allowed("s3:GetObject", "arn:aws:s3:::bucket/key") || principal.Allowed("s3:PutObject", "arn:aws:s3:::bucket/key") {
+		t.Errorf("Expected the mapped policies to apply, got %+v", principal.Policies)
+	}
+
+	rejected := map[string]string{
+		"wrong issuer":   keys.sign(t, "ES256", "ec-1", claims("https://evil.local", "locals3")),
+		"wrong audience": keys.sign(t, "ES256", "ec-1", claims("https://idp.local", "other")),
+		"no subject":     keys.sign(t, "ES256", "ec-1", map[string]interface{}{"iss": "https://idp.local", "aud": "locals3"}),
+		"bad signature":  "e30.e30.AAAA",
+	}
+	for name, token := range rejected {
+		if _, _, err := sts.AssumeRoleWithWebIdentity(token, roleArn, "ci-run", time.Hour); !errors.Is(err, ErrInvalidJWT) {
+			t.Errorf("%s: Expected ErrInvalidJWT, got %v", name, err)
+		}
+	}
+
+	unconfigured := NewSTS(store, nil, "", "", nil)
+	if _, _, err := unconfigured.AssumeRoleWithWebIdentity("e30.e30.AAAA", roleArn, "ci-run", time.Hour); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected ErrInvalidJWT without a JWKS, got %v", err)
+	}
+}
+
+func TestAuthenticateSessionCredentials(t *testing.T) {
+	store := NewCredentialStore()
+	auth := NewAWSV4AuthWithStore(store, "test-access-key", "test-secret-key", "us-east-1")
+	session := store.AddSession(Principal{UserName: "alice"}, time.Hour)
+
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Security-Token", session.SessionToken)
+	credential := session.AccessKeyID + "/" + now.Format("20060102") + "/us-east-1/s3/aws4_request"
+	signedHeaders := "host;x-amz-date;x-amz-security-token"
+	signature, err := auth.calculateSignature(req, session.SecretAccessKey, credential, signedHeaders)
+	if err != nil {
+		t.Fatalf("calculateSignature failed: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s, SignedHeaders=%s, Signature=%s", credential, signedHeaders, signature))
+
+	if err := auth.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if principal, ok := PrincipalFromContext(req.Context()); !ok || principal.UserName != "alice" {
+		t.Errorf("Expected session principal, got %+v", principal)
+	}
+
+	session.Expiration = time.Now().Add(-time.Second)
+	if err := auth.Authenticate(req); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+}
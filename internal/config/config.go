@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 )
 
 // Config holds the application configuration
@@ -15,6 +18,59 @@ type Config struct {
 	LogLevel    string
 	BaseDomain  string
 	DisableAuth bool
+
+	// CredentialsFile holds the users and access keys allowed to sign
+	// requests. It is created with AccessKey/SecretKey if it doesn't exist.
+	CredentialsFile string
+
+	// MaxClockSkew is how far the signing time of a request may be from the
+	// server's clock
+	MaxClockSkew time.Duration
+
+	// SignatureV2 accepts requests signed with legacy AWS Signature Version 2
+	SignatureV2 bool
+
+	// SignatureDebug logs the server's canonical request and string to sign
+	// whenever a signature doesn't match
+	SignatureDebug bool
+
+	// STSJWKSFile holds the keys that sign web identity tokens accepted by
+	// AssumeRoleWithWebIdentity. STSIssuer and STSAudience, when set, must
+	// match the token's iss and aud claims. STSPolicyFile maps token claims
+	// to a principal and its policies.
+	STSJWKSFile   string
+	STSIssuer     string
+	STSAudience   string
+	STSPolicyFile string
+
+	// Bearer token authentication. Tokens are verified against the keys in
+	// BearerJWKSFile or served at BearerJWKSURL; BearerIssuer and
+	// BearerAudience, when set, must match. BearerPolicyFile maps token
+	// claims to a principal and its policies.
+	BearerJWKSFile   string
+	BearerJWKSURL    string
+	BearerIssuer     string
+	BearerAudience   string
+	BearerPolicyFile string
+
+	// AuthzWebhookURL, when set, is asked to allow or deny every
+	// authenticated request. Decisions are cached for AuthzCacheTTL; with
+	// AuthzFailOpen, requests are allowed when the webhook fails.
+	AuthzWebhookURL string
+	AuthzTimeout    time.Duration
+	AuthzCacheTTL   time.Duration
+	AuthzFailOpen   bool
+
+	// Static website hosting. Requests for <bucket>.<WebsiteDomain> are
+	// served as websites; WebsitePort, when set, serves only websites.
+	WebsiteDomain string
+	WebsitePort   int
+
+	// StorageBackend is "filesystem", which keeps objects in DataDir, or
+	// "memory", which keeps them in memory until the server stops.
+	// MemoryLimit caps the bytes the memory backend holds, 0 for no limit.
+	StorageBackend string
+	MemoryLimit    int64
 }
 
 // Load loads configuration from environment variables with defaults
@@ -29,6 +85,31 @@ func Load() (*Config, error) {
 		BaseDomain:  getEnv("BASE_DOMAIN", "localhost"),
 		DisableAuth: getEnvAsBool("DISABLE_AUTH", false),
 	}
+	cfg.CredentialsFile = getEnv("CREDENTIALS_FILE", filepath.Join(cfg.DataDir, ".locals3", "credentials.json"))
+	cfg.MaxClockSkew = time.Duration(getEnvAsInt("MAX_CLOCK_SKEW", 900)) * time.Second
+	cfg.SignatureV2 = getEnvAsBool("SIGNATURE_V2", true)
+	cfg.SignatureDebug = getEnvAsBool("SIGNATURE_DEBUG", false)
+	cfg.STSJWKSFile = getEnv("STS_JWKS_FILE", "")
+	cfg.STSIssuer = getEnv("STS_ISSUER", "")
+	cfg.STSAudience = getEnv("STS_AUDIENCE", "")
+	cfg.STSPolicyFile = getEnv("STS_POLICY_FILE", "")
+	cfg.BearerJWKSFile = getEnv("BEARER_JWKS_FILE", "")
+	cfg.BearerJWKSURL = getEnv("BEARER_JWKS_URL", "")
+	cfg.BearerIssuer = getEnv("BEARER_ISSUER", "")
+	cfg.BearerAudience = getEnv("BEARER_AUDIENCE", "")
+	cfg.BearerPolicyFile = getEnv("BEARER_POLICY_FILE", "")
+	cfg.AuthzWebhookURL = getEnv("AUTHZ_WEBHOOK_URL", "")
+	cfg.AuthzTimeout = time.Duration(getEnvAsInt("AUTHZ_TIMEOUT", 5)) * time.Second
+	cfg.AuthzCacheTTL = time.Duration(getEnvAsInt("AUTHZ_CACHE_TTL", 60)) * time.Second
+	cfg.AuthzFailOpen = getEnvAsBool("AUTHZ_FAIL_OPEN", false)
+	cfg.WebsiteDomain = getEnv("WEBSITE_DOMAIN", "s3-website."+cfg.BaseDomain)
+	cfg.WebsitePort = getEnvAsInt("WEBSITE_PORT", 0)
+	cfg.StorageBackend = getEnv("STORAGE_BACKEND", "filesystem")
+	cfg.MemoryLimit = int64(getEnvAsInt("MEMORY_STORAGE_LIMIT", 0))
+
+	if cfg.StorageBackend != "filesystem" && cfg.StorageBackend != "memory" {
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
 
 	// Ensure data directory exists
 	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -149,3 +150,205 @@ func TestGetEnvFunctions(t *testing.T) {
 		t.Errorf("Expected getEnvAsBool to return default value true for invalid bool")
 	}
 }
+
+func TestLoadWebsite(t *testing.T) {
+	origBaseDomain := os.Getenv("BASE_DOMAIN")
+	origWebsiteDomain := os.Getenv("WEBSITE_DOMAIN")
+	origWebsitePort := os.Getenv("WEBSITE_PORT")
+	defer func() {
+		os.Setenv("BASE_DOMAIN", origBaseDomain)
+		os.Setenv("WEBSITE_DOMAIN", origWebsiteDomain)
+		os.Setenv("WEBSITE_PORT", origWebsitePort)
+	}()
+
+	os.Setenv("BASE_DOMAIN", "example.com")
+	os.Unsetenv("WEBSITE_DOMAIN")
+	os.Unsetenv("WEBSITE_PORT")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.WebsiteDomain != "s3-website.example.com" {
+		t.Errorf("Expected website domain derived from base domain, got %s", cfg.WebsiteDomain)
+	}
+	if cfg.WebsitePort != 0 {
+		t.Errorf("Expected website port to be disabled by default, got %d", cfg.WebsitePort)
+	}
+
+	os.Setenv("WEBSITE_DOMAIN", "web.local")
+	os.Setenv("WEBSITE_PORT", "3001")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.WebsiteDomain != "web.local" || cfg.WebsitePort != 3001 {
+		t.Errorf("Expected custom website settings, got %s:%d", cfg.WebsiteDomain, cfg.WebsitePort)
+	}
+}
+
+func TestLoadSTS(t *testing.T) {
+	for _, key := range []string{"STS_JWKS_FILE", "STS_ISSUER", "STS_AUDIENCE", "STS_POLICY_FILE"} {
+		orig := os.Getenv(key)
+		defer os.Setenv(key, orig)
+		os.Unsetenv(key)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.STSJWKSFile != "" || cfg.STSIssuer != "" || cfg.STSAudience != "" || cfg.STSPolicyFile != "" {
+		t.Errorf("Expected web identity federation to be disabled by default, got %+v", cfg)
+	}
+
+	os.Setenv("STS_JWKS_FILE", "/etc/locals3/jwks.json")
+	os.Setenv("STS_ISSUER", "https://idp.local")
+	os.Setenv("STS_AUDIENCE", "locals3")
+	os.Setenv("STS_POLICY_FILE", "/etc/locals3/sts-claims.json")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.STSJWKSFile != "/etc/locals3/jwks.json" || cfg.STSIssuer != "https://idp.local" || cfg.STSAudience != "locals3" || cfg.STSPolicyFile != "/etc/locals3/sts-claims.json" {
+		t.Errorf("Expected custom STS settings, got %s %s %s %s", cfg.STSJWKSFile, cfg.STSIssuer, cfg.STSAudience, cfg.STSPolicyFile)
+	}
+}
+
+func TestLoadBearer(t *testing.T) {
+	keys := []string{"BEARER_JWKS_FILE", "BEARER_JWKS_URL", "BEARER_ISSUER", "BEARER_AUDIENCE", "BEARER_POLICY_FILE"}
+	for _, key := range keys {
+		orig := os.Getenv(key)
+		defer os.Setenv(key, orig)
+		os.Unsetenv(key)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.BearerJWKSFile != "" || cfg.BearerJWKSURL != "" || cfg.BearerIssuer != "" || cfg.BearerAudience != "" || cfg.BearerPolicyFile != "" {
+		t.Errorf("Expected bearer tokens to be disabled by default, got %+v", cfg)
+	}
+
+	os.Setenv("BEARER_JWKS_FILE", "/etc/locals3/sso-jwks.json")
+	os.Setenv("BEARER_JWKS_URL", "http://idp.local/jwks")
+	os.Setenv("BEARER_ISSUER", "https://idp.local")
+	os.Setenv("BEARER_AUDIENCE", "locals3")
+	os.Setenv("BEARER_POLICY_FILE", "/etc/locals3/claims.json")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.BearerJWKSFile != "/etc/locals3/sso-jwks.json" || cfg.BearerJWKSURL != "http://idp.local/jwks" {
+		t.Errorf("Expected custom JWKS sources, got %s %s", cfg.BearerJWKSFile, cfg.BearerJWKSURL)
+	}
+	if cfg.BearerIssuer != "https://idp.local" || cfg.BearerAudience != "locals3" || cfg.BearerPolicyFile != "/etc/locals3/claims.json" {
+		t.Errorf("Expected custom bearer settings, got %s %s %s", cfg.BearerIssuer, cfg.BearerAudience, cfg.BearerPolicyFile)
+	}
+}
+
+func TestLoadAuthzWebhook(t *testing.T) {
+	for _, key := range []string{"AUTHZ_WEBHOOK_URL", "AUTHZ_TIMEOUT", "AUTHZ_CACHE_TTL", "AUTHZ_FAIL_OPEN"} {
+		orig := os.Getenv(key)
+		defer os.Setenv(key, orig)
+		os.Unsetenv(key)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.AuthzWebhookURL != "" || cfg.AuthzFailOpen {
+		t.Errorf("Expected no fail-closed webhook by default, got %+v", cfg)
+	}
+	if cfg.AuthzTimeout != 5*time.Second || cfg.AuthzCacheTTL != time.Minute {
+		t.Errorf("Expected 5s timeout and 1m cache by default, got %v %v", cfg.AuthzTimeout, cfg.AuthzCacheTTL)
+	}
+
+	os.Setenv("AUTHZ_WEBHOOK_URL", "http://localhost:8181/v1/data/s3/allow")
+	os.Setenv("AUTHZ_TIMEOUT", "1")
+	os.Setenv("AUTHZ_CACHE_TTL", "0")
+	os.Setenv("AUTHZ_FAIL_OPEN", "true")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.AuthzWebhookURL != "http://localhost:8181/v1/data/s3/allow" || cfg.AuthzTimeout != time.Second || cfg.AuthzCacheTTL != 0 || !cfg.AuthzFailOpen {
+		t.Errorf("Expected custom webhook settings, got %+v", cfg)
+	}
+}
+
+func TestLoadSignatureSettings(t *testing.T) {
+	for _, key := range []string{"SIGNATURE_V2", "SIGNATURE_DEBUG", "MAX_CLOCK_SKEW"} {
+		orig := os.Getenv(key)
+		defer os.Setenv(key, orig)
+		os.Unsetenv(key)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.SignatureV2 {
+		t.Error("Expected SigV2 to be enabled by default")
+	}
+	if cfg.SignatureDebug {
+		t.Error("Expected signature debugging to be off by default")
+	}
+	if cfg.MaxClockSkew != 15*time.Minute {
+		t.Errorf("Expected 15 minute clock skew by default, got %v", cfg.MaxClockSkew)
+	}
+
+	os.Setenv("SIGNATURE_V2", "false")
+	os.Setenv("SIGNATURE_DEBUG", "true")
+	os.Setenv("MAX_CLOCK_SKEW", "60")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.SignatureV2 {
+		t.Error("Expected SIGNATURE_V2=false to disable SigV2")
+	}
+	if !cfg.SignatureDebug {
+		t.Error("Expected SIGNATURE_DEBUG=true to enable signature debugging")
+	}
+	if cfg.MaxClockSkew != time.Minute {
+		t.Errorf("Expected MAX_CLOCK_SKEW=60 to allow one minute, got %v", cfg.MaxClockSkew)
+	}
+}
+
+func TestLoadStorageBackend(t *testing.T) {
+	for _, key := range []string{"STORAGE_BACKEND", "MEMORY_STORAGE_LIMIT"} {
+		orig := os.Getenv(key)
+		defer os.Setenv(key, orig)
+		os.Unsetenv(key)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.StorageBackend != "filesystem" || cfg.MemoryLimit != 0 {
+		t.Errorf("Expected the unlimited filesystem backend by default, got %q %d", cfg.StorageBackend, cfg.MemoryLimit)
+	}
+
+	os.Setenv("STORAGE_BACKEND", "memory")
+	os.Setenv("MEMORY_STORAGE_LIMIT", "1048576")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.StorageBackend != "memory" || cfg.MemoryLimit != 1<<20 {
+		t.Errorf("Expected a memory backend of 1 MiB, got %q %d", cfg.StorageBackend, cfg.MemoryLimit)
+	}
+
+	os.Setenv("STORAGE_BACKEND", "tape")
+	if _, err := Load(); err == nil {
+		t.Error("Expected an unknown storage backend to be rejected")
+	}
+}
@@ -0,0 +1,682 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"locals3/internal/auth"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// errAccessDenied is returned for requests that no policy or ACL grants
+// access to
+var errAccessDenied = errors.New("Access Denied")
+
+// maxPolicySize is the largest bucket policy S3 accepts
+const maxPolicySize = 20 * 1024
+
+// allUsersURI is the grantee of public ACL grants
+const allUsersURI = "http://acs.amazonaws.com/groups/global/AllUsers"
+
+// s3Action returns the IAM action a routed request performs, such as
+// s3:GetObject
+func s3Action(r *http.Request) string {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	has := func(name string) bool {
+		_, ok := query[name]
+		return ok
+	}
+	byMethod := func(get, put, del string) string {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return get
+		case http.MethodDelete:
+			return del
+		}
+		return put
+	}
+
+	if vars["bucket"] == "" {
+		return "s3:ListAllMyBuckets"
+	}
+
+	if vars["key"] == "" {
+		switch {
+		case has("publicAccessBlock"):
+			return byMethod("s3:GetBucketPublicAccessBlock", "s3:PutBucketPublicAccessBlock", "s3:PutBucketPublicAccessBlock")
+		case has("policy"):
+			return byMethod("s3:GetBucketPolicy", "s3:PutBucketPolicy", "s3:DeleteBucketPolicy")
+		case has("acl"):
+			return byMethod("s3:GetBucketAcl", "s3:PutBucketAcl", "s3:PutBucketAcl")
+		case has("website"):
+			return byMethod("s3:GetBucketWebsite", "s3:PutBucketWebsite", "s3:DeleteBucketWebsite")
+		case has("location"):
+			return "s3:GetBucketLocation"
+		case has("object-lock"):
+			return byMethod("s3:GetBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration")
+		case r.Method == http.MethodPost:
+			return "s3:PutObject"
+		}
+		return byMethod("s3:ListBucket", "s3:CreateBucket", "s3:DeleteBucket")
+	}
+
+	switch {
+	case has("acl"):
+		return byMethod("s3:GetObjectAcl", "s3:PutObjectAcl", "s3:PutObjectAcl")
+	case has("retention"):
+		return byMethod("s3:GetObjectRetention", "s3:PutObjectRetention", "s3:PutObjectRetention")
+	case has("legal-hold"):
+		return byMethod("s3:GetObjectLegalHold", "s3:PutObjectLegalHold", "s3:PutObjectLegalHold")
+	case has("select"):
+		return "s3:GetObject"
+	case has("uploadId") && r.Method == http.MethodDelete:
+		return "s3:AbortMultipartUpload"
+	case has("uploadId") && r.Method == http.MethodGet:
+		return "s3:ListMultipartUploadParts"
+	}
+	return byMethod("s3:GetObject", "s3:PutObject", "s3:DeleteObject")
+}
+
+// s3Resource returns the ARN of the bucket or object a routed request acts on
+func s3Resource(r *http.Request) string {
+	vars := mux.Vars(r)
+	if vars["bucket"] == "" {
+		return "arn:aws:s3:::*"
+	}
+	resource := "arn:aws:s3:::" + vars["bucket"]
+	if vars["key"] != "" {
+		resource += "/" + vars["key"]
+	}
+	return resource
+}
+
+// bucketPolicyActions stay with unrestricted principals whatever the bucket
+// policy says, like the root user in S3, so a policy can't lock everyone
+// out of changing it
+var bucketPolicyActions = map[string]bool{
+	"s3:GetBucketPolicy":    true,
+	"s3:PutBucketPolicy":    true,
+	"s3:DeleteBucketPolicy": true,
+}
+
+// authorizePrincipal checks a signed request against the identity policies
+// of its principal, if it has any, and against an explicit deny in the
+// bucket policy
+func (h *Handler) authorizePrincipal(r *http.Request) error {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	action := s3Action(r)
+	if !principal.Allowed(action, s3Resource(r)) {
+		return errAccessDenied
+	}
+	if principal.Policies == nil && bucketPolicyActions[action] {
+		return nil
+	}
+
+	decision, err := h.bucketPolicyDecision(r, principal)
+	if err != nil {
+		return err
+	}
+	if decision == auth.DecisionDeny {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// bucketPolicyDecision evaluates the policy of the bucket a request acts on.
+// Requests outside buckets, and to missing buckets or buckets without a
+// policy, get DecisionNone.
+func (h *Handler) bucketPolicyDecision(r *http.Request, principal *auth.Principal) (auth.Decision, error) {
+	bucket := mux.Vars(r)["bucket"]
+	if bucket == "" {
+		return auth.DecisionNone, nil
+	}
+	document, err := h.storage.GetBucketPolicy(bucket)
+	if errors.Is(err, storage.ErrNoSuchBucket) || document == "" {
+		return auth.DecisionNone, nil
+	}
+	if err != nil {
+		return auth.DecisionNone, err
+	}
+	policy, err := auth.ParsePolicy([]byte(document))
+	if err != nil {
+		return auth.DecisionNone, err
+	}
+	return policy.Evaluate(principal, s3Action(r), s3Resource(r)), nil
+}
+
+// authorizeRequest runs check and the external authorizer on a request and,
+// for a copy, on the read of the source object it implies
+func (h *Handler) authorizeRequest(r *http.Request, check func(*http.Request) error) error {
+	requests := []*http.Request{r}
+	if source := copySourceRequest(r); source != nil {
+		requests = append(requests, source)
+	}
+	for _, req := range requests {
+		if err := check(req); err != nil {
+			return err
+		}
+		if err := h.authorizeExternal(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySourceRequest returns a GET of the object named by the
+// X-Amz-Copy-Source header of an object PUT, or nil for other requests.
+// Copying reads the source, so the caller needs s3:GetObject on it as well.
+func copySourceRequest(r *http.Request) *http.Request {
+	if r.Method != http.MethodPut || mux.Vars(r)["key"] == "" || r.Header.Get("X-Amz-Copy-Source") == "" {
+		return nil
+	}
+	bucket, key, ok := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if !ok {
+		return nil
+	}
+
+	source := r.Clone(r.Context())
+	source.Method = http.MethodGet
+	source.URL = &url.URL{Path: "/" + bucket + "/" + key}
+	return mux.SetURLVars(source, map[string]string{"bucket": bucket, "key": key})
+}
+
+// authorizerHiddenHeaders carry credentials and are not sent to the
+// external authorizer
+var authorizerHiddenHeaders = map[string]bool{
+	"Authorization":        true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+}
+
+// authorizeExternal asks the configured authorizer whether the request may
+// proceed. It runs once the request has passed the built-in checks.
+func (h *Handler) authorizeExternal(r *http.Request) error {
+	if h.authorizer == nil {
+		return nil
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		return errAccessDenied
+	}
+
+	headers := make(map[string][]string, len(r.Header))
+	for name, values := range r.Header {
+		if !authorizerHiddenHeaders[name] {
+			headers[name] = values
+		}
+	}
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	vars := mux.Vars(r)
+	allowed, err := h.authorizer.Authorize(r.Context(), &auth.AuthorizationInput{
+		Principal: auth.NewAuthorizationPrincipal(principal),
+		Action:    s3Action(r),
+		Bucket:    vars["bucket"],
+		Key:       vars["key"],
+		Resource:  s3Resource(r),
+		Method:    r.Method,
+		SourceIP:  sourceIP,
+		Headers:   headers,
+	})
+	if err != nil {
+		logrus.Errorf("External authorization failed: %v", err)
+		return errAccessDenied
+	}
+	if !allowed {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// authorizeAnonymous allows an unsigned request only where the bucket policy
+// or a public ACL grants it, as limited by the bucket's Block Public Access
+// setting. An explicit deny in the policy always applies.
+func (h *Handler) authorizeAnonymous(r *http.Request) error {
+	vars := mux.Vars(r)
+	bucket, key := vars["bucket"], vars["key"]
+	if bucket == "" || !h.storage.BucketExists(bucket) {
+		return errAccessDenied
+	}
+
+	block, err := h.storage.GetPublicAccessBlock(bucket)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		block = &storage.PublicAccessBlockConfiguration{}
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	decision, err := h.bucketPolicyDecision(r, principal)
+	if err != nil {
+		return err
+	}
+	switch decision {
+	case auth.DecisionDeny:
+		return errAccessDenied
+	case auth.DecisionAllow:
+		if !block.RestrictPublicBuckets {
+			return nil
+		}
+	}
+
+	if !block.IgnorePublicAcls && h.publicACLAllows(bucket, key, s3Action(r)) {
+		return nil
+	}
+	return errAccessDenied
+}
+
+// publicACLAllows reports whether the canned ACL of the bucket, or of the
+// object for reads, lets everyone perform action
+func (h *Handler) publicACLAllows(bucket, key, action string) bool {
+	switch action {
+	case "s3:GetObject":
+		acl, err := h.storage.GetObjectACL(bucket, key)
+		return err == nil && storage.IsPublicACL(acl)
+	case "s3:ListBucket":
+		acl, err := h.storage.GetBucketACL(bucket)
+		return err == nil && storage.IsPublicACL(acl)
+	case "s3:PutObject", "s3:DeleteObject":
+		acl, err := h.storage.GetBucketACL(bucket)
+		return err == nil && acl == storage.ACLPublicReadWrite
+	}
+	return false
+}
+
+// parseCannedACL returns the canned ACL in x-amz-acl, if any
+func parseCannedACL(r *http.Request) (string, error) {
+	acl := r.Header.Get("X-Amz-Acl")
+	if acl != "" && !storage.ValidCannedACL(acl) {
+		return "", &apiError{"InvalidArgument", "Invalid canned ACL " + acl, http.StatusBadRequest}
+	}
+	return acl, nil
+}
+
+// requestACL returns the canned ACL of a request to an existing bucket,
+// rejecting public ACLs if the bucket blocks them
+func (h *Handler) requestACL(r *http.Request, bucket string) (string, error) {
+	acl, err := parseCannedACL(r)
+	if err != nil || !storage.IsPublicACL(acl) {
+		return acl, err
+	}
+
+	block, err := h.storage.GetPublicAccessBlock(bucket)
+	if err != nil {
+		return "", err
+	}
+	if block != nil && block.BlockPublicAcls {
+		return "", &apiError{"AccessDenied", "Access Denied because the bucket blocks public ACLs", http.StatusForbidden}
+	}
+	return acl, nil
+}
+
+// aclMetadata stores the canned ACL of an upload with its metadata
+func (h *Handler) aclMetadata(r *http.Request, bucket string, metadata map[string]string) error {
+	acl, err := h.requestACL(r, bucket)
+	if err != nil {
+		return err
+	}
+	if acl != "" {
+		metadata[storage.MetadataACL] = acl
+	}
+	return nil
+}
+
+// accessControlPolicy describes a canned ACL as the grants it stands for
+func (h *Handler) accessControlPolicy(r *http.Request, acl string) *AccessControlPolicy {
+	owner := h.requestOwner(r)
+	policy := &AccessControlPolicy{Owner: owner}
+	grant := func(grantee Grantee, permission string) {
+		grantee.XMLNS = "http://www.w3.org/2001/XMLSchema-instance"
+		policy.AccessControlList.Grants = append(policy.AccessControlList.Grants, Grant{Grantee: grantee, Permission: permission})
+	}
+
+	grant(Grantee{Type: "CanonicalUser", ID: owner.ID, DisplayName: owner.DisplayName}, "FULL_CONTROL")
+	switch acl {
+	case storage.ACLPublicRead:
+		grant(Grantee{Type: "Group", URI: allUsersURI}, "READ")
+	case storage.ACLPublicReadWrite:
+		grant(Grantee{Type: "Group", URI: allUsersURI}, "READ")
+		grant(Grantee{Type: "Group", URI: allUsersURI}, "WRITE")
+	case "authenticated-read":
+		grant(Grantee{Type: "Group", URI: "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"}, "READ")
+	}
+	return policy
+}
+
+// putACL reads the canned ACL of a PutBucketAcl or PutObjectAcl request.
+// Only canned ACLs are supported.
+func (h *Handler) putACL(r *http.Request, bucket string) (string, error) {
+	acl, err := h.requestACL(r, bucket)
+	if err != nil {
+		return "", err
+	}
+	if acl == "" {
+		return "", &apiError{"NotImplemented", "Only canned ACLs set with the x-amz-acl header are supported", http.StatusNotImplemented}
+	}
+	return acl, nil
+}
+
+// GetBucketAcl handles GET /{bucket}?acl
+func (h *Handler) GetBucketAcl(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	acl, err := h.storage.GetBucketACL(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(h.accessControlPolicy(r, acl))
+}
+
+// PutBucketAcl handles PUT /{bucket}?acl
+func (h *Handler) PutBucketAcl(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	acl, err := h.putACL(r, bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	if err := h.storage.PutBucketACL(bucket, acl); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObjectAcl handles GET /{bucket}/{key}?acl
+func (h *Handler) GetObjectAcl(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	acl, err := h.storage.GetObjectACL(bucket, key)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(h.accessControlPolicy(r, acl))
+}
+
+// PutObjectAcl handles PUT /{bucket}/{key}?acl
+func (h *Handler) PutObjectAcl(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	acl, err := h.putACL(r, bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	if err := h.storage.PutObjectACL(bucket, key, acl); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetBucketPolicy handles GET /{bucket}?policy
+func (h *Handler) GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	policy, err := h.storage.GetBucketPolicy(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if policy == "" {
+		h.writeErrorResponse(w, r, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, policy)
+}
+
+// PutBucketPolicy handles PUT /{bucket}?policy
+func (h *Handler) PutBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	document, err := io.ReadAll(io.LimitReader(r.Body, maxPolicySize+1))
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if len(document) > maxPolicySize {
+		h.writeErrorResponse(w, r, "PolicyTooLarge", "Policies must be 20 KB or smaller", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := auth.ParsePolicy(document)
+	if err != nil {
+		h.writeErrorResponse(w, r, "MalformedPolicy", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if policy.IsPublic() {
+		block, err := h.storage.GetPublicAccessBlock(bucket)
+		if err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		if block != nil && block.BlockPublicPolicy {
+			h.writeErrorResponse(w, r, "AccessDenied", "Access Denied because the bucket blocks public policies", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := h.storage.PutBucketPolicy(bucket, string(document)); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteBucketPolicy handles DELETE /{bucket}?policy
+func (h *Handler) DeleteBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	if err := h.storage.DeleteBucketPolicy(bucket); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPublicAccessBlock handles GET /{bucket}?publicAccessBlock
+func (h *Handler) GetPublicAccessBlock(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	block, err := h.storage.GetPublicAccessBlock(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if block == nil {
+		h.writeErrorResponse(w, r, "NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found", http.StatusNotFound)
+		return
+	}
+
+	response := &PublicAccessBlockConfiguration{
+		BlockPublicAcls:       block.BlockPublicAcls,
+		IgnorePublicAcls:      block.IgnorePublicAcls,
+		BlockPublicPolicy:     block.BlockPublicPolicy,
+		RestrictPublicBuckets: block.RestrictPublicBuckets,
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// PutPublicAccessBlock handles PUT /{bucket}?publicAccessBlock
+func (h *Handler) PutPublicAccessBlock(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var request PublicAccessBlockConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+
+	block := &storage.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       request.BlockPublicAcls,
+		IgnorePublicAcls:      request.IgnorePublicAcls,
+		BlockPublicPolicy:     request.BlockPublicPolicy,
+		RestrictPublicBuckets: request.RestrictPublicBuckets,
+	}
+	if err := h.storage.PutPublicAccessBlock(bucket, block); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeletePublicAccessBlock handles DELETE /{bucket}?publicAccessBlock
+func (h *Handler) DeletePublicAccessBlock(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	if err := h.storage.DeletePublicAccessBlock(bucket); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"locals3/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// signedHeader marks a request as signed; MockAuth accepts any signature
+var signedHeader = http.Header{"Authorization": {"AWS4-HMAC-SHA256 Credential=test"}}
+
+func TestS3Action(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		vars   map[string]string
+		want   string
+	}{
+		{"GET", "/", nil, "s3:ListAllMyBuckets"},
+		{"GET", "/b", map[string]string{"bucket": "b"}, "s3:ListBucket"},
+		{"PUT", "/b", map[string]string{"bucket": "b"}, "s3:CreateBucket"},
+		{"DELETE", "/b?policy", map[string]string{"bucket": "b"}, "s3:DeleteBucketPolicy"},
+		{"DELETE", "/b?publicAccessBlock", map[string]string{"bucket": "b"}, "s3:PutBucketPublicAccessBlock"},
+		{"GET", "/b/k", map[string]string{"bucket": "b", "key": "k"}, "s3:GetObject"},
+		{"HEAD", "/b/k", map[string]string{"bucket": "b", "key": "k"}, "s3:GetObject"},
+		{"PUT", "/b/k?partNumber=1&uploadId=u", map[string]string{"bucket": "b", "key": "k"}, "s3:PutObject"},
+		{"DELETE", "/b/k?uploadId=u", map[string]string{"bucket": "b", "key": "k"}, "s3:AbortMultipartUpload"},
+		{"PUT", "/b/k?acl", map[string]string{"bucket": "b", "key": "k"}, "s3:PutObjectAcl"},
+		{"POST", "/b/k?select&select-type=2", map[string]string{"bucket": "b", "key": "k"}, "s3:GetObject"},
+	}
+	for _, tt := range tests {
+		req := mux.SetURLVars(httptest.NewRequest(tt.method, tt.target, nil), tt.vars)
+		if got := s3Action(req); got != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.target, tt.want, got)
+		}
+	}
+}
+
+func TestPrincipalPolicies(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+
+	policy, err := auth.ParsePolicy([]byte(`{"Statement": [
+		{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::test-bucket/*"},
+		{"Effect": "Deny", "Action": "s3:DeleteObject", "Resource": "*"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "alice", Policies: []*auth.Policy{policy}}
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "file.txt"}
+	if code := serveSSE(h.PutObject, "PUT", "/test-bucket/file.txt", vars, signedHeader, []byte("data")).Code; code != http.StatusOK {
+		t.Errorf("Expected PutObject to be allowed, got %d", code)
+	}
+	if code := serveSSE(h.GetObject, "GET", "/test-bucket/file.txt", vars, signedHeader, nil).Code; code != http.StatusOK {
+		t.Errorf("Expected GetObject to be allowed, got %d", code)
+	}
+	if code := serveSSE(h.DeleteObject, "DELETE", "/test-bucket/file.txt", vars, signedHeader, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected denied DeleteObject, got %d", code)
+	}
+	bucketVars := map[string]string{"bucket": "test-bucket"}
+	if code := serveSSE(h.ListObjects, "GET", "/test-bucket", bucketVars, signedHeader, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected ListBucket without an allow to be denied, got %d", code)
+	}
+	if code := serveSSE(h.ListBuckets, "GET", "/", nil, signedHeader, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected ListAllMyBuckets without an allow to be denied, got %d", code)
+	}
+}
+
+// recordingAuthorizer allows the actions it lists and records its inputs
+type recordingAuthorizer struct {
+	allow  map[string]bool
+	inputs []*auth.AuthorizationInput
+}
+
+func (a *recordingAuthorizer) Authorize(ctx context.Context, input *auth.AuthorizationInput) (bool, error) {
+	a.inputs = append(a.inputs, input)
+	return a.allow[input.Action], nil
+}
+
+func TestExternalAuthorizer(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+	authorizer := &recordingAuthorizer{allow: map[string]bool{"s3:PutObject": true}}
+	h.authorizer = authorizer
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "alice", AccessKey: "alice-key"}
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "dir/file.txt"}
+	header := http.Header{"Authorization": signedHeader["Authorization"], "X-Custom": {"value"}}
+	if code := serveSSE(h.PutObject, "PUT", "/test-bucket/dir/file.txt", vars, header, []byte("data")).Code; code != http.StatusOK {
+		t.Errorf("Expected PutObject to be allowed, got %d", code)
+	}
+	if code := serveSSE(h.GetObject, "GET", "/test-bucket/dir/file.txt", vars, header, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected GetObject to be denied, got %d", code)
+	}
+
+	if len(authorizer.inputs) != 2 {
+		t.Fatalf("Expected 2 authorization requests, got %d", len(authorizer.inputs))
+	}
+	input := authorizer.inputs[0]
+	if input.Principal.UserName != "alice" || input.Action != "s3:PutObject" || input.Bucket != "test-bucket" || input.Key != "dir/file.txt" {
+		t.Errorf("Unexpected authorization input %+v", input)
+	}
+	if input.Resource != "arn:aws:s3:::test-bucket/dir/file.txt" || input.SourceIP != "192.0.2.1" {
+		t.Errorf("Unexpected resource or source IP %s %s", input.Resource, input.SourceIP)
+	}
+	if _, ok := input.Headers["Authorization"]; ok || input.Headers["X-Custom"][0] != "value" {
+		t.Errorf("Expected headers without credentials, got %v", input.Headers)
+	}
+
+	// Anonymous requests are only sent once a bucket grants them access
+	h.auth.(*MockAuth).Principal = nil
+	authorizer.inputs = nil
+	serveSSE(h.GetObject, "GET", "/test-bucket/dir/file.txt", vars, nil, nil)
+	if len(authorizer.inputs) != 0 {
+		t.Errorf("Expected denied anonymous request not to reach the authorizer")
+	}
+}
+
+func TestAnonymousAccess(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+	objectVars := func(key string) map[string]string {
+		return map[string]string{"bucket": "test-bucket", "key": key}
+	}
+	bucketVars := map[string]string{"bucket": "test-bucket"}
+
+	put := func(key, acl string) int {
+		header := http.Header{"Authorization": signedHeader["Authorization"]}
+		if acl != "" {
+			header.Set("X-Amz-Acl", acl)
+		}
+		return serveSSE(h.PutObject, "PUT", "/test-bucket/"+key, objectVars(key), header, []byte("data")).Code
+	}
+	anonymousGet := func(key string) int {
+		return serveSSE(h.GetObject, "GET", "/test-bucket/"+key, objectVars(key), nil, nil).Code
+	}
+
+	if code := put("private.txt", ""); code != http.StatusOK {
+		t.Fatalf("PutObject failed with %d", code)
+	}
+	if code := put("public.txt", "public-read"); code != http.StatusOK {
+		t.Fatalf("PutObject with public-read failed with %d", code)
+	}
+
+	if code := anonymousGet("private.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected anonymous read of a private object to be denied, got %d", code)
+	}
+	if code := anonymousGet("public.txt"); code != http.StatusOK {
+		t.Errorf("Expected anonymous read of a public-read object, got %d", code)
+	}
+	if code := serveSSE(h.ListObjects, "GET", "/test-bucket", bucketVars, nil, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected anonymous listing of a private bucket to be denied, got %d", code)
+	}
+	if code := serveSSE(h.ListBuckets, "GET", "/", nil, nil, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected anonymous ListBuckets to be denied, got %d", code)
+	}
+
+	// Public ACLs stop working once they are ignored, and can't be set once
+	// they are blocked
+	block := `<PublicAccessBlockConfiguration><BlockPublicAcls>true</BlockPublicAcls><IgnorePublicAcls>true</IgnorePublicAcls></PublicAccessBlockConfiguration>`
+	if code := serveSSE(h.PutPublicAccessBlock, "PUT", "/test-bucket?publicAccessBlock", bucketVars, signedHeader, []byte(block)).Code; code != http.StatusOK {
+		t.Fatalf("PutPublicAccessBlock failed with %d", code)
+	}
+	if code := anonymousGet("public.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected IgnorePublicAcls to deny anonymous reads, got %d", code)
+	}
+	if code := put("other.txt", "public-read"); code != http.StatusForbidden {
+		t.Errorf("Expected BlockPublicAcls to reject a public ACL, got %d", code)
+	}
+
+	rr := serveSSE(h.GetPublicAccessBlock, "GET", "/test-bucket?publicAccessBlock", bucketVars, signedHeader, nil)
+	var result PublicAccessBlockConfiguration
+	if err := xml.Unmarshal(rr.Body.Bytes(), &result); err != nil || !result.BlockPublicAcls || !result.IgnorePublicAcls || result.BlockPublicPolicy {
+		t.Errorf("Unexpected public access block %+v, %v", result, err)
+	}
+
+	if code := serveSSE(h.DeletePublicAccessBlock, "DELETE", "/test-bucket?publicAccessBlock", bucketVars, signedHeader, nil).Code; code != http.StatusNoContent {
+		t.Fatalf("DeletePublicAccessBlock failed with %d", code)
+	}
+	if code := serveSSE(h.GetPublicAccessBlock, "GET", "/test-bucket?publicAccessBlock", bucketVars, signedHeader, nil).Code; code != http.StatusNotFound {
+		t.Errorf("Expected NoSuchPublicAccessBlockConfiguration after delete, got %d", code)
+	}
+	if code := anonymousGet("public.txt"); code != http.StatusOK {
+		t.Errorf("Expected public-read to apply again, got %d", code)
+	}
+}
+
+func TestCopySourceAuthorization(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+	h.storage.CreateBucket("public-bucket")
+	h.storage.PutBucketACL("public-bucket", "public-read-write")
+	h.storage.PutObject("test-bucket", "private.txt", strings.NewReader("secret"), 6, nil)
+
+	copyVars := map[string]string{"bucket": "public-bucket", "key": "copy.txt"}
+	copyHeader := func(signed bool) http.Header {
+		header := http.Header{"X-Amz-Copy-Source": {"/test-bucket/private.txt"}, "X-Amz-Acl": {"public-read"}}
+		if signed {
+			header.Set("Authorization", signedHeader.Get("Authorization"))
+		}
+		return header
+	}
+
+	// Writing to a public bucket doesn't give access to a private source
+	if code := serveSSE(h.CopyObject, "PUT", "/public-bucket/copy.txt", copyVars, copyHeader(false), nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected anonymous copy of a private object to be denied, got %d", code)
+	}
+	if _, err := h.storage.HeadObject("public-bucket", "copy.txt"); err == nil {
+		t.Error("Expected the denied copy not to be written")
+	}
+
+	policy, err := auth.ParsePolicy([]byte(`{"Statement": [
+		{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::public-bucket/*"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "alice", Policies: []*auth.Policy{policy}}
+	if code := serveSSE(h.CopyObject, "PUT", "/public-bucket/copy.txt", copyVars, copyHeader(true), nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected copy without s3:GetObject on the source to be denied, got %d", code)
+	}
+
+	authorizer := &recordingAuthorizer{allow: map[string]bool{"s3:PutObject": true}}
+	h.authorizer = authorizer
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "alice"}
+	if code := serveSSE(h.CopyObject, "PUT", "/public-bucket/copy.txt", copyVars, copyHeader(true), nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected copy denied by the authorizer, got %d", code)
+	}
+	if len(authorizer.inputs) != 2 || authorizer.inputs[1].Action != "s3:GetObject" || authorizer.inputs[1].Resource != "arn:aws:s3:::test-bucket/private.txt" {
+		t.Fatalf("Expected the source read to be authorized, got %+v", authorizer.inputs)
+	}
+
+	authorizer.allow["s3:GetObject"] = true
+	if rr := serveSSE(h.CopyObject, "PUT", "/public-bucket/copy.txt", copyVars, copyHeader(true), nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected allowed copy to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAnonymousAccessBucketPolicy(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+	bucketVars := map[string]string{"bucket": "test-bucket"}
+
+	for _, key := range []string{"public/a.txt", "public/secret.txt", "private/b.txt"} {
+		vars := map[string]string{"bucket": "test-bucket", "key": key}
+		if code := serveSSE(h.PutObject, "PUT", "/test-bucket/"+key, vars, signedHeader, []byte("data")).Code; code != http.StatusOK {
+			t.Fatalf("PutObject failed with %d", code)
+		}
+	}
+	anonymousGet := func(key string) int {
+		vars := map[string]string{"bucket": "test-bucket", "key": key}
+		return serveSSE(h.GetObject, "GET", "/test-bucket/"+key, vars, nil, nil).Code
+	}
+
+	policy := `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/public/*"},
+		{"Effect": "Deny", "Principal": {"AWS": "*"}, "Action": "s3:*", "Resource": "arn:aws:s3:::test-bucket/public/secret.txt"}
+	]}`
+	if code := serveSSE(h.PutBucketPolicy, "PUT", "/test-bucket?policy", bucketVars, signedHeader, []byte(policy)).Code; code != http.StatusNoContent {
+		t.Fatalf("PutBucketPolicy failed with %d", code)
+	}
+
+	if code := anonymousGet("public/a.txt"); code != http.StatusOK {
+		t.Errorf("Expected the policy to allow anonymous reads, got %d", code)
+	}
+	if code := anonymousGet("public/secret.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected the explicit deny to apply, got %d", code)
+	}
+	if code := anonymousGet("private/b.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected keys outside the policy to be denied, got %d", code)
+	}
+
+	rr := serveSSE(h.GetBucketPolicy, "GET", "/test-bucket?policy", bucketVars, signedHeader, nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != policy {
+		t.Errorf("Expected stored policy, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	block := `<PublicAccessBlockConfiguration><BlockPublicPolicy>true</BlockPublicPolicy><RestrictPublicBuckets>true</RestrictPublicBuckets></PublicAccessBlockConfiguration>`
+	serveSSE(h.PutPublicAccessBlock, "PUT", "/test-bucket?publicAccessBlock", bucketVars, signedHeader, []byte(block))
+	if code := anonymousGet("public/a.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected RestrictPublicBuckets to deny anonymous reads, got %d", code)
+	}
+	if code := serveSSE(h.PutBucketPolicy, "PUT", "/test-bucket?policy", bucketVars, signedHeader, []byte(policy)).Code; code != http.StatusForbidden {
+		t.Errorf("Expected BlockPublicPolicy to reject a public policy, got %d", code)
+	}
+
+	if code := serveSSE(h.PutBucketPolicy, "PUT", "/test-bucket?policy", bucketVars, signedHeader, []byte(`{"Statement": []}`)).Code; code != http.StatusBadRequest {
+		t.Errorf("Expected MalformedPolicy, got %d", code)
+	}
+	if code := serveSSE(h.DeleteBucketPolicy, "DELETE", "/test-bucket?policy", bucketVars, signedHeader, nil).Code; code != http.StatusNoContent {
+		t.Errorf("DeleteBucketPolicy failed with %d", code)
+	}
+	if code := serveSSE(h.GetBucketPolicy, "GET", "/test-bucket?policy", bucketVars, signedHeader, nil).Code; code != http.StatusNotFound {
+		t.Errorf("Expected NoSuchBucketPolicy after delete, got %d", code)
+	}
+}
+
+func TestSignedAccessBucketPolicyDeny(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	h.disableAuth = false
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "alice"}
+	bucketVars := map[string]string{"bucket": "test-bucket"}
+	vars := map[string]string{"bucket": "test-bucket", "key": "file.txt"}
+	if code := serveSSE(h.PutObject, "PUT", "/test-bucket/file.txt", vars, signedHeader, []byte("data")).Code; code != http.StatusOK {
+		t.Fatalf("PutObject failed with %d", code)
+	}
+
+	policy := `{"Statement": [
+		{"Effect": "Deny", "Principal": {"AWS": "alice"}, "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::test-bucket/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": ["s3:DeleteBucketPolicy", "s3:PutObject"], "Resource": ["arn:aws:s3:::test-bucket", "arn:aws:s3:::test-bucket/*"]}
+	]}`
+	if code := serveSSE(h.PutBucketPolicy, "PUT", "/test-bucket?policy", bucketVars, signedHeader, []byte(policy)).Code; code != http.StatusNoContent {
+		t.Fatalf("PutBucketPolicy failed with %d", code)
+	}
+
+	if code := serveSSE(h.GetObject, "GET", "/test-bucket/file.txt", vars, signedHeader, nil).Code; code != http.StatusOK {
+		t.Errorf("Expected actions the policy doesn't deny to be allowed, got %d", code)
+	}
+	if code := serveSSE(h.DeleteObject, "DELETE", "/test-bucket/file.txt", vars, signedHeader, nil).Code; code != http.StatusForbidden {
+		t.Errorf("Expected the explicit deny for alice to apply, got %d", code)
+	}
+	if code := serveSSE(h.PutObject, "PUT", "/test-bucket/file.txt", vars, signedHeader, []byte("new")).Code; code != http.StatusForbidden {
+		t.Errorf("Expected the explicit deny for everyone to apply, got %d", code)
+	}
+
+	h.auth.(*MockAuth).Principal = &auth.Principal{UserName: "bob"}
+	if code := serveSSE(h.DeleteObject, "DELETE", "/test-bucket/file.txt", vars, signedHeader, nil).Code; code != http.StatusNoContent {
+		t.Errorf("Expected a deny for alice not to apply to bob, got %d", code)
+	}
+
+	// Unrestricted principals can always remove the policy
+	if code := serveSSE(h.DeleteBucketPolicy, "DELETE", "/test-bucket?policy", bucketVars, signedHeader, nil).Code; code != http.StatusNoContent {
+		t.Errorf("Expected DeleteBucketPolicy to be allowed, got %d", code)
+	}
+}
+
+func TestBucketAcl(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	bucketVars := map[string]string{"bucket": "test-bucket"}
+
+	header := http.Header{"X-Amz-Acl": {"public-read-write"}}
+	if code := serveSSE(h.PutBucketAcl, "PUT", "/test-bucket?acl", bucketVars, header, nil).Code; code != http.StatusOK {
+		t.Fatalf("PutBucketAcl failed with %d", code)
+	}
+	if code := serveSSE(h.PutBucketAcl, "PUT", "/test-bucket?acl", bucketVars, http.Header{"X-Amz-Acl": {"everyone"}}, nil).Code; code != http.StatusBadRequest {
+		t.Errorf("Expected invalid canned ACL to be rejected, got %d", code)
+	}
+	if code := serveSSE(h.PutBucketAcl, "PUT", "/test-bucket?acl", bucketVars, nil, []byte("<AccessControlPolicy/>")).Code; code != http.StatusNotImplemented {
+		t.Errorf("Expected ACL documents to be unsupported, got %d", code)
+	}
+
+	rr := serveSSE(h.GetBucketAcl, "GET", "/test-bucket?acl", bucketVars, nil, nil)
+	var result AccessControlPolicy
+	if err := xml.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse ACL: %v", err)
+	}
+	var permissions []string
+	for _, grant := range result.AccessControlList.Grants {
+		permissions = append(permissions, grant.Permission)
+	}
+	if strings.Join(permissions, ",") != "FULL_CONTROL,READ,WRITE" {
+		t.Errorf("Unexpected grants %v", permissions)
+	}
+
+	// A public-read-write bucket lets anyone upload
+	h.disableAuth = false
+	vars := map[string]string{"bucket": "test-bucket", "key": "drop.txt"}
+	if code := serveSSE(h.PutObject, "PUT", "/test-bucket/drop.txt", vars, nil, []byte("data")).Code; code != http.StatusOK {
+		t.Errorf("Expected anonymous upload to a public-read-write bucket, got %d", code)
+	}
+}
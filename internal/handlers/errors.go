@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// internalErrorMessage is the message of InternalError responses. The cause
+// is logged rather than returned, as it may describe the server's filesystem.
+const internalErrorMessage = "We encountered an internal error. Please try again."
+
+// storageErrors maps the errors of storage backends to S3 errors
+var storageErrors = []struct {
+	err    error
+	apiErr *apiError
+}{
+	{storage.ErrInvalidBucketName, &apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}},
+	{storage.ErrNoSuchBucket, &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}},
+	{storage.ErrBucketNotEmpty, &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty", http.StatusConflict}},
+	{storage.ErrNoSuchKey, &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}},
+	{storage.ErrKeyTooLong, &apiError{"KeyTooLongError", "Your key is too long", http.StatusBadRequest}},
+	{storage.ErrNoSuchUpload, &apiError{"NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.", http.StatusNotFound}},
+	{storage.ErrInvalidPart, &apiError{"InvalidPart", "One or more of the specified parts could not be found.  The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.", http.StatusBadRequest}},
+	{storage.ErrInvalidPartOrder, &apiError{"InvalidPartOrder", "The list of parts was not in ascending order. The parts list must be specified in order by part number.", http.StatusBadRequest}},
+	{storage.ErrEntityTooSmall, &apiError{"EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size.", http.StatusBadRequest}},
+	{storage.ErrStorageFull, &apiError{"InsufficientStorage", "The server does not have enough storage left to store the object.", http.StatusInsufficientStorage}},
+	{storage.ErrObjectLocked, &apiError{"AccessDenied", "Access Denied because object protected by object lock.", http.StatusForbidden}},
+	{storage.ErrObjectLockNotEnabled, &apiError{"InvalidRequest", "Bucket is missing Object Lock Configuration", http.StatusBadRequest}},
+}
+
+// storageError converts a storage error into the S3 error it stands for. It
+// returns nil for errors that are not storage errors.
+func storageError(err error) *apiError {
+	if errors.Is(err, storage.ErrInvalidArgument) {
+		return &apiError{"InvalidArgument", err.Error(), http.StatusBadRequest}
+	}
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			return e.apiErr
+		}
+	}
+	return nil
+}
+
+// bucketErrorCodes are the errors about a bucket, whose body names it
+var bucketErrorCodes = map[string]bool{
+	"NoSuchBucket":                         true,
+	"BucketNotEmpty":                       true,
+	"BucketAlreadyExists":                  true,
+	"BucketAlreadyOwnedByYou":              true,
+	"InvalidBucketName":                    true,
+	"NoSuchBucketPolicy":                   true,
+	"NoSuchWebsiteConfiguration":           true,
+	"NoSuchPublicAccessBlockConfiguration": true,
+	"ObjectLockConfigurationNotFoundError": true,
+}
+
+// newS3Error builds the body of an error response to r, with the request IDs
+// set by setS3Headers. Like S3, it names the resource of the request, the
+// bucket for bucket errors and the key for NoSuchKey.
+func newS3Error(w http.ResponseWriter, r *http.Request, code, message string) *S3Error {
+	s3Err := &S3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+		HostID:    w.Header().Get("x-amz-id-2"),
+	}
+
+	vars := mux.Vars(r)
+	if bucketErrorCodes[code] {
+		s3Err.BucketName = vars["bucket"]
+	}
+	if code == "NoSuchKey" {
+		s3Err.Key = vars["key"]
+	}
+	return s3Err
+}
+
+// hostID returns an opaque x-amz-id-2 value
+func hostID() string {
+	b := make([]byte, 48)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"locals3/internal/storage"
+)
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{storage.ErrNoSuchBucket, "NoSuchBucket", http.StatusNotFound},
+		{storage.ErrNoSuchKey, "NoSuchKey", http.StatusNotFound},
+		{storage.ErrInvalidBucketName, "InvalidBucketName", http.StatusBadRequest},
+		{storage.ErrKeyTooLong, "KeyTooLongError", http.StatusBadRequest},
+		{fmt.Errorf("%w: part 3", storage.ErrInvalidPart), "InvalidPart", http.StatusBadRequest},
+		{storage.ErrInvalidPartOrder, "InvalidPartOrder", http.StatusBadRequest},
+		{storage.ErrEntityTooSmall, "EntityTooSmall", http.StatusBadRequest},
+		{storage.ErrStorageFull, "InsufficientStorage", http.StatusInsufficientStorage},
+		{storage.ErrBucketNotEmpty, "BucketNotEmpty", http.StatusConflict},
+		{storage.ErrNoSuchUpload, "NoSuchUpload", http.StatusNotFound},
+		{storage.ErrObjectLocked, "AccessDenied", http.StatusForbidden},
+		{fmt.Errorf("%w: invalid canned ACL %q", storage.ErrInvalidArgument, "x"), "InvalidArgument", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		apiErr := storageError(tt.err)
+		if apiErr == nil || apiErr.Code != tt.code || apiErr.StatusCode != tt.status {
+			t.Errorf("%v: expected %s %d, got %+v", tt.err, tt.code, tt.status, apiErr)
+		}
+	}
+	if apiErr := storageError(errors.New("disk full")); apiErr != nil {
+		t.Errorf("Expected no S3 error for unknown errors, got %+v", apiErr)
+	}
+}
+
+// decodeS3Error parses the error body of a response
+func decodeS3Error(t *testing.T, rr *httptest.ResponseRecorder) S3Error {
+	t.Helper()
+	var s3Err S3Error
+	if err := xml.Unmarshal(rr.Body.Bytes(), &s3Err); err != nil {
+		t.Fatalf("Failed to parse error response %q: %v", rr.Body.String(), err)
+	}
+	return s3Err
+}
+
+func TestErrorResponseBody(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	rr := serveSSE(h.GetObject, "GET", "/test-bucket/missing.txt", map[string]string{"bucket": "test-bucket", "key": "missing.txt"}, nil, nil)
+	s3Err := decodeS3Error(t, rr)
+	if rr.Code != http.StatusNotFound || s3Err.Code != "NoSuchKey" || s3Err.Key != "missing.txt" || s3Err.BucketName != "" {
+		t.Errorf("Unexpected NoSuchKey response %d %+v", rr.Code, s3Err)
+	}
+	if s3Err.Resource != "/test-bucket/missing.txt" {
+		t.Errorf("Expected resource /test-bucket/missing.txt, got %q", s3Err.Resource)
+	}
+	if s3Err.RequestID == "" || s3Err.RequestID != rr.Header().Get("x-amz-request-id") {
+		t.Errorf("Expected RequestId to match the header, got %q and %q", s3Err.RequestID, rr.Header().Get("x-amz-request-id"))
+	}
+	if s3Err.HostID == "" || s3Err.HostID != rr.Header().Get("x-amz-id-2") {
+		t.Errorf("Expected HostId to match x-amz-id-2, got %q", s3Err.HostID)
+	}
+
+	rr = serveSSE(h.ListObjects, "GET", "/missing-bucket", map[string]string{"bucket": "missing-bucket"}, nil, nil)
+	if s3Err := decodeS3Error(t, rr); rr.Code != http.StatusNotFound || s3Err.Code != "NoSuchBucket" || s3Err.BucketName != "missing-bucket" {
+		t.Errorf("Unexpected NoSuchBucket response %d %+v", rr.Code, s3Err)
+	}
+
+	// Unknown errors don't reveal their cause
+	rr = httptest.NewRecorder()
+	h.writeAPIError(rr, httptest.NewRequest("GET", "/test-bucket/key", nil), errors.New("open /var/lib/locals3/test-bucket/key: permission denied"))
+	if s3Err := decodeS3Error(t, rr); rr.Code != http.StatusInternalServerError || s3Err.Code != "InternalError" || strings.Contains(s3Err.Message, "/var/lib") {
+		t.Errorf("Unexpected InternalError response %d %+v", rr.Code, s3Err)
+	}
+}
+
+func TestMultipartErrors(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+	vars := map[string]string{"bucket": "test-bucket", "key": "multi.bin"}
+
+	rr := serveSSE(h.InitiateMultipartUpload, "POST", "/test-bucket/multi.bin?uploads", vars, nil, nil)
+	var initiated InitiateMultipartUploadResult
+	if err := xml.Unmarshal(rr.Body.Bytes(), &initiated); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	for _, part := range []string{"1", "2"} {
+		target := "/test-bucket/multi.bin?partNumber=" + part + "&uploadId=" + initiated.UploadID
+		if rr := serveSSE(h.UploadPart, "PUT", target, vars, nil, []byte("part")); rr.Code != http.StatusOK {
+			t.Fatalf("UploadPart returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   string
+		status int
+	}{
+		{"unknown upload", "PUT", "/test-bucket/multi.bin?partNumber=1&uploadId=missing", "", "NoSuchUpload", http.StatusNotFound},
+		{"part number", "PUT", "/test-bucket/multi.bin?partNumber=10001&uploadId=" + initiated.UploadID, "", "InvalidArgument", http.StatusBadRequest},
+		{"no parts", "POST", "/test-bucket/multi.bin?uploadId=" + initiated.UploadID, "<CompleteMultipartUpload/>", "MalformedXML", http.StatusBadRequest},
+		{"part order", "POST", "/test-bucket/multi.bin?uploadId=" + initiated.UploadID,
+			"<CompleteMultipartUpload><Part><PartNumber>2</PartNumber></Part><Part><PartNumber>1</PartNumber></Part></CompleteMultipartUpload>", "InvalidPartOrder", http.StatusBadRequest},
+		{"missing part", "POST", "/test-bucket/multi.bin?uploadId=" + initiated.UploadID,
+			"<CompleteMultipartUpload><Part><PartNumber>3</PartNumber></Part></CompleteMultipartUpload>", "InvalidPart", http.StatusBadRequest},
+		{"small part", "POST", "/test-bucket/multi.bin?uploadId=" + initiated.UploadID,
+			"<CompleteMultipartUpload><Part><PartNumber>1</PartNumber></Part><Part><PartNumber>2</PartNumber></Part></CompleteMultipartUpload>", "EntityTooSmall", http.StatusBadRequest},
+		{"abort unknown upload", "DELETE", "/test-bucket/multi.bin?uploadId=missing", "", "NoSuchUpload", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		handler := h.UploadPart
+		switch tt.method {
+		case "POST":
+			handler = h.CompleteMultipartUpload
+		case "DELETE":
+			handler = h.AbortMultipartUpload
+		}
+		rr := serveSSE(handler, tt.method, tt.target, vars, nil, []byte(tt.body))
+		if s3Err := decodeS3Error(t, rr); rr.Code != tt.status || s3Err.Code != tt.code {
+			t.Errorf("%s: expected %s %d, got %d %s", tt.name, tt.code, tt.status, rr.Code, s3Err.Code)
+		}
+	}
+}
+
+func TestDeleteMissingObject(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	rr := serveSSE(h.DeleteObject, "DELETE", "/test-bucket/missing.txt", map[string]string{"bucket": "test-bucket", "key": "missing.txt"}, nil, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected deleting a missing key to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -23,25 +27,40 @@ type Config struct {
 	Region      string
 	BaseDomain  string
 	DisableAuth bool
+
+	// WebsiteDomain is the domain of website endpoints, <bucket>.<WebsiteDomain>
+	WebsiteDomain string
+
+	// STS issues temporary credentials. The STS endpoint is disabled when nil.
+	STS *auth.STS
+
+	// Authorizer, when set, decides every request after it is authenticated
+	Authorizer auth.Authorizer
 }
 
 // Handler holds the HTTP handlers
 type Handler struct {
-	storage     storage.Storage
-	auth        auth.AuthProvider
-	region      string
-	baseDomain  string
-	disableAuth bool
+	storage       storage.Storage
+	auth          auth.AuthProvider
+	region        string
+	baseDomain    string
+	disableAuth   bool
+	websiteDomain string
+	sts           *auth.STS
+	authorizer    auth.Authorizer
 }
 
 // New creates a new handler instance
 func New(cfg *Config) *Handler {
 	return &Handler{
-		storage:     cfg.Storage,
-		auth:        cfg.Auth,
-		region:      cfg.Region,
-		baseDomain:  cfg.BaseDomain,
-		disableAuth: cfg.DisableAuth,
+		storage:       cfg.Storage,
+		auth:          cfg.Auth,
+		region:        cfg.Region,
+		baseDomain:    cfg.BaseDomain,
+		disableAuth:   cfg.DisableAuth,
+		websiteDomain: cfg.WebsiteDomain,
+		sts:           cfg.STS,
+		authorizer:    cfg.Authorizer,
 	}
 }
 
@@ -59,62 +78,241 @@ func (h *Handler) authenticate(r *http.Request) error {
 		return nil
 	}
 
-	// Skip authentication if disabled
+	// Skip authentication if disabled. Nothing is authorized then, not even
+	// by the external authorizer.
 	if h.disableAuth {
 		logrus.Debug("Authentication disabled")
 		return nil
 	}
 
-	// For now, allow requests without authentication for testing
-	// In production, you should always authenticate
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		logrus.Warn("Request without authentication")
+	// Unsigned requests act as the anonymous principal, which only has
+	// access where a bucket grants it publicly
+	if !isSignedRequest(r) {
+		*r = *r.WithContext(auth.WithPrincipal(r.Context(), auth.AnonymousPrincipal()))
+		return h.authorizeRequest(r, h.authorizeAnonymous)
+	}
+
+	if err := h.auth.Authenticate(r); err != nil {
+		return err
+	}
+
+	// STS actions aren't S3 actions, so neither policies nor the external
+	// authorizer decide them. The sessions they issue inherit the caller's
+	// policies, and their S3 requests go to the authorizer like any other.
+	if h.IsSTSRequest(r, nil) {
 		return nil
 	}
+	return h.authorizeRequest(r, h.authorizePrincipal)
+}
+
+// isSignedRequest reports whether a request carries a signature, in the
+// Authorization header or, for presigned URLs, in the query
+func isSignedRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	return r.Header.Get("Authorization") != "" || query.Get("Signature") != "" || query.Get("X-Amz-Signature") != ""
+}
+
+// authError converts authentication failures into S3 errors
+func authError(err error) error {
+	var sigErr *auth.SignatureError
+	switch {
+	case errors.As(err, &sigErr):
+		return sigErr
+	case errors.Is(err, auth.ErrExpiredToken):
+		return &apiError{"ExpiredToken", "The provided token has expired.", http.StatusBadRequest}
+	case errors.Is(err, auth.ErrInvalidToken):
+		return &apiError{"InvalidToken", "The provided token is malformed or otherwise invalid.", http.StatusBadRequest}
+	case errors.Is(err, auth.ErrRequestTimeTooSkewed):
+		return &apiError{"RequestTimeTooSkewed", "The difference between the request time and the current time is too large.", http.StatusForbidden}
+	case errors.Is(err, auth.ErrAuthorizationHeaderMalformed):
+		return &apiError{"AuthorizationHeaderMalformed", err.Error(), http.StatusBadRequest}
+	}
+	return &apiError{"AccessDenied", err.Error(), http.StatusForbidden}
+}
 
-	return h.auth.Authenticate(r)
+// requestOwner returns the authenticated principal as an S3 owner, falling
+// back to the configured access key for unauthenticated requests
+func (h *Handler) requestOwner(r *http.Request) Owner {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return Owner{ID: principal.UserName, DisplayName: principal.DisplayName}
+	}
+	return Owner{ID: h.auth.GetAccessKey(), DisplayName: h.auth.GetAccessKey()}
 }
 
 // setS3Headers sets common S3 response headers
 func (h *Handler) setS3Headers(w http.ResponseWriter) {
 	w.Header().Set("Server", "LocalS3")
 	w.Header().Set("x-amz-request-id", fmt.Sprintf("%d", time.Now().UnixNano()))
+	w.Header().Set("x-amz-id-2", hostID())
 }
 
 // writeErrorResponse writes an S3 error response
-func (h *Handler) writeErrorResponse(w http.ResponseWriter, code string, message string, statusCode int) {
+func (h *Handler) writeErrorResponse(w http.ResponseWriter, r *http.Request, code string, message string, statusCode int) {
 	h.setS3Headers(w)
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(statusCode)
 
-	errorResp := &S3Error{
-		Code:      code,
-		Message:   message,
-		RequestID: fmt.Sprintf("%d", time.Now().UnixNano()),
-	}
+	xml.NewEncoder(w).Encode(newS3Error(w, r, code, message))
+}
+
+// signatureMismatchMessage is the message of SignatureDoesNotMatch errors
+const signatureMismatchMessage = "The request signature we calculated does not match the signature you provided. Check your key and signing method."
+
+// writeSignatureError writes a SignatureDoesNotMatch error with what the
+// server signed, so the client can compare it with its own
+func (h *Handler) writeSignatureError(w http.ResponseWriter, r *http.Request, sigErr *auth.SignatureError) {
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusForbidden)
+
+	errorResp := newS3Error(w, r, "SignatureDoesNotMatch", signatureMismatchMessage)
+	errorResp.AWSAccessKeyID = sigErr.AccessKeyID
+	errorResp.StringToSign = sigErr.StringToSign
+	errorResp.SignatureProvided = sigErr.SignatureProvided
+	errorResp.StringToSignBytes = sigErr.StringToSignBytes()
+	errorResp.CanonicalRequest = sigErr.CanonicalRequest
+	errorResp.CanonicalRequestBytes = sigErr.CanonicalRequestBytes()
 
 	xml.NewEncoder(w).Encode(errorResp)
 }
 
+// apiError is an S3 error that should be returned to the client as-is
+type apiError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// writeAPIError writes err as an S3 error response. Storage errors are
+// mapped to their S3 errors; any other error that is not an apiError is
+// logged and reported as InternalError.
+func (h *Handler) writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	if apiErr, ok := err.(*apiError); ok {
+		h.writeErrorResponse(w, r, apiErr.Code, apiErr.Message, apiErr.StatusCode)
+		return
+	}
+	if sigErr, ok := err.(*auth.SignatureError); ok {
+		h.writeSignatureError(w, r, sigErr)
+		return
+	}
+	if apiErr := storageError(err); apiErr != nil {
+		h.writeErrorResponse(w, r, apiErr.Code, apiErr.Message, apiErr.StatusCode)
+		return
+	}
+
+	logrus.Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	h.writeErrorResponse(w, r, "InternalError", internalErrorMessage, http.StatusInternalServerError)
+}
+
+// extractMetadata collects the headers of a request that are stored with
+// the object: x-amz-meta-* headers, the system metadata such as Content-Type,
+// and the website redirect location
+func extractMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range r.Header {
+		if storedMetadata(name) && (values[0] != "" || isUserMetadata(name)) {
+			metadata[http.CanonicalHeaderKey(name)] = values[0]
+		}
+	}
+
+	// aws-chunked describes how the request body was sent, not the object
+	if encoding, ok := metadata["Content-Encoding"]; ok {
+		var encodings []string
+		for _, value := range strings.Split(encoding, ",") {
+			if value = strings.TrimSpace(value); value != "" && !strings.EqualFold(value, "aws-chunked") {
+				encodings = append(encodings, value)
+			}
+		}
+		if len(encodings) == 0 {
+			delete(metadata, "Content-Encoding")
+		} else {
+			metadata["Content-Encoding"] = strings.Join(encodings, ",")
+		}
+	}
+	return metadata
+}
+
+// storedMetadata reports whether a request header or form field of the
+// given name is stored as object metadata
+func storedMetadata(name string) bool {
+	if isUserMetadata(name) || strings.EqualFold(name, storage.MetadataWebsiteRedirectLocation) {
+		return true
+	}
+	for _, header := range storage.SystemMetadata {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUserMetadata(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "x-amz-meta-")
+}
+
+// setMetadataHeaders returns stored object metadata as response headers
+func setMetadataHeaders(w http.ResponseWriter, metadata map[string]string) {
+	for key, value := range metadata {
+		if strings.HasPrefix(key, storage.InternalMetadataPrefix) {
+			continue
+		}
+		w.Header().Set(key, value)
+	}
+}
+
+// responseOverrides maps the query parameters of GetObject that override
+// response headers to the headers they set
+var responseOverrides = []struct {
+	param  string
+	header string
+}{
+	{"response-cache-control", "Cache-Control"},
+	{"response-content-disposition", "Content-Disposition"},
+	{"response-content-encoding", "Content-Encoding"},
+	{"response-content-language", "Content-Language"},
+	{"response-content-type", "Content-Type"},
+	{"response-expires", "Expires"},
+}
+
+var errAnonymousResponseOverride = &apiError{"InvalidRequest", "Request specific response headers cannot be used for anonymous GET requests.", http.StatusBadRequest}
+
+// responseHeaderOverrides returns the response headers a request sets with
+// response-* query parameters. Like S3, only signed requests may set them.
+func responseHeaderOverrides(r *http.Request) (http.Header, error) {
+	query := r.URL.Query()
+	overrides := make(http.Header)
+	for _, override := range responseOverrides {
+		if values, ok := query[override.param]; ok {
+			overrides.Set(override.header, values[0])
+		}
+	}
+	if len(overrides) > 0 {
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.IsAnonymous() {
+			return nil, errAnonymousResponseOverride
+		}
+	}
+	return overrides, nil
+}
+
 // ListBuckets handles GET / - list all buckets
 func (h *Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
 	buckets, err := h.storage.ListBuckets()
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	response := &ListAllMyBucketsResult{
-		Owner: Owner{
-			ID:          h.auth.GetAccessKey(),
-			DisplayName: h.auth.GetAccessKey(),
-		},
+		Owner: h.requestOwner(r),
 		Buckets: Buckets{
 			Bucket: make([]Bucket, len(buckets)),
 		},
@@ -135,36 +333,156 @@ func (h *Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
 // CreateBucket handles PUT /{bucket} - create bucket
 func (h *Handler) CreateBucket(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	if bucket == "" {
-		h.writeErrorResponse(w, "InvalidBucketName", "Bucket name is required", http.StatusBadRequest)
+	if !storage.ValidBucketName(bucket) {
+		h.writeAPIError(w, r, storage.ErrInvalidBucketName)
 		return
 	}
 
+	region, err := h.parseLocationConstraint(r)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	owner := h.requestOwner(r).ID
 	if h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "BucketAlreadyExists", "Bucket already exists", http.StatusConflict)
+		current, err := h.storage.GetBucketOwner(bucket)
+		if err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		// Buckets created before owners were recorded belong to everyone
+		if current == "" || current == owner {
+			h.writeErrorResponse(w, r, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict)
+			return
+		}
+		h.writeErrorResponse(w, r, "BucketAlreadyExists", "The requested bucket name is not available. The bucket namespace is shared by all users of the system. Please select a different name and try again.", http.StatusConflict)
+		return
+	}
+
+	acl, err := parseCannedACL(r)
+	if err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	if err := h.storage.CreateBucket(bucket); err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	if err := h.storage.PutBucketOwner(bucket, owner); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if err := h.storage.PutBucketLocation(bucket, region); err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
+	if acl != "" {
+		if err := h.storage.PutBucketACL(bucket, acl); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
+	if strings.EqualFold(r.Header.Get("X-Amz-Bucket-Object-Lock-Enabled"), "true") {
+		if err := h.storage.EnableObjectLock(bucket); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
 	h.setS3Headers(w)
 	w.WriteHeader(http.StatusOK)
 }
 
+// maxCreateBucketConfigurationSize bounds the body of CreateBucket
+const maxCreateBucketConfigurationSize = 64 * 1024
+
+var errMalformedXML = &apiError{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest}
+
+// regionPattern matches region names such as eu-west-1
+var regionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// parseLocationConstraint returns the region requested by the
+// CreateBucketConfiguration body of a CreateBucket request, or the server's
+// region if there is none
+func (h *Handler) parseLocationConstraint(r *http.Request) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCreateBucketConfigurationSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return h.region, nil
+	}
+	if len(body) > maxCreateBucketConfigurationSize {
+		return "", errMalformedXML
+	}
+
+	var config CreateBucketConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		return "", errMalformedXML
+	}
+
+	switch region := config.LocationConstraint; {
+	case region == "":
+		// S3 creates buckets without a constraint in us-east-1, but LocalS3
+		// has a single region
+		return h.region, nil
+	case region == "EU":
+		return "eu-west-1", nil
+	case !regionPattern.MatchString(region):
+		return "", &apiError{"InvalidLocationConstraint", "The specified location-constraint is not valid", http.StatusBadRequest}
+	default:
+		return region, nil
+	}
+}
+
+// GetBucketLocation handles GET /{bucket}?location
+func (h *Handler) GetBucketLocation(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	region, err := h.storage.GetBucketLocation(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if region == "" {
+		region = h.region
+	}
+	// Like S3, buckets in us-east-1 have an empty location constraint
+	if region == "us-east-1" {
+		region = ""
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(LocationConstraint{Region: region})
+}
+
 // DeleteBucket handles DELETE /{bucket} - delete bucket
 func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -172,16 +490,12 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
 	if err := h.storage.DeleteBucket(bucket); err != nil {
-		if strings.Contains(err.Error(), "not empty") {
-			h.writeErrorResponse(w, "BucketNotEmpty", "Bucket is not empty", http.StatusConflict)
-		} else {
-			h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -192,7 +506,7 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 // ListObjects handles GET /{bucket} - list objects in bucket
 func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -200,7 +514,7 @@ func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
@@ -219,7 +533,7 @@ func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 
 	result, err := h.storage.ListObjects(bucket, prefix, delimiter, marker, maxKeys)
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -241,10 +555,7 @@ func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 			ETag:         obj.ETag,
 			Size:         obj.Size,
 			StorageClass: "STANDARD",
-			Owner: Owner{
-				ID:          h.auth.GetAccessKey(),
-				DisplayName: h.auth.GetAccessKey(),
-			},
+			Owner:        h.requestOwner(r),
 		}
 	}
 
@@ -262,7 +573,7 @@ func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 // PutObject handles PUT /{bucket}/{key} - upload object
 func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -271,7 +582,7 @@ func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
@@ -286,30 +597,204 @@ func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
 	// Extract metadata from headers
-	metadata := make(map[string]string)
-	for name, values := range r.Header {
-		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
-			metadata[name] = values[0]
+	metadata := extractMetadata(r)
+	if err := h.objectLockMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if err := h.aclMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if sseKey != nil {
+		if err := sseKey.seal(metadata); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		if body, err = sseKey.encrypt(metadata[metadataSSECIV], body); err != nil {
+			h.writeAPIError(w, r, err)
+			return
 		}
 	}
 
 	// Store object
-	objInfo, err := h.storage.PutObject(bucket, key, r.Body, contentLength, metadata)
+	objInfo, err := h.storage.PutObject(bucket, key, body, contentLength, metadata)
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	h.setS3Headers(w)
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
+	}
 	w.Header().Set("ETag", objInfo.ETag)
 	w.WriteHeader(http.StatusOK)
 }
 
+// CopyObject handles PUT /{bucket}/{key} with x-amz-copy-source - copy object
+func (h *Handler) CopyObject(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	srcBucket, srcKey, ok := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if !ok {
+		h.writeErrorResponse(w, r, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		return
+	}
+
+	if !h.storage.BucketExists(srcBucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	directive := r.Header.Get("X-Amz-Metadata-Directive")
+	if directive == "" {
+		directive = "COPY"
+	}
+	if directive != "COPY" && directive != "REPLACE" {
+		h.writeErrorResponse(w, r, "InvalidArgument", "Unknown metadata directive.", http.StatusBadRequest)
+		return
+	}
+
+	srcSSEKey, err := parseSSECustomerKey(r.Header, sseCopySourceHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	if srcBucket == bucket && srcKey == key && directive == "COPY" && srcSSEKey == nil && sseKey == nil {
+		h.writeErrorResponse(w, r, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.", http.StatusBadRequest)
+		return
+	}
+
+	reader, srcInfo, err := h.storage.GetObject(srcBucket, srcKey)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	if err := checkSSECustomerKey(srcSSEKey, srcInfo.Metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	var body io.Reader = reader
+	if srcSSEKey != nil {
+		if body, err = srcSSEKey.decrypt(srcInfo.Metadata, reader); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
+	metadata := extractMetadata(r)
+	if directive == "COPY" {
+		metadata = make(map[string]string)
+		for name, value := range srcInfo.Metadata {
+			if strings.HasPrefix(name, storage.InternalMetadataPrefix) || strings.HasPrefix(name, "X-Amz-Object-Lock-") || name == metadataSSECAlgorithm {
+				continue
+			}
+			metadata[name] = value
+		}
+	}
+	if err := h.objectLockMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if err := h.aclMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	// Copying an object onto itself would truncate the source while reading it
+	if srcBucket == bucket && srcKey == key {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		body = bytes.NewReader(data)
+	}
+
+	if sseKey != nil {
+		if err := sseKey.seal(metadata); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		if body, err = sseKey.encrypt(metadata[metadataSSECIV], body); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
+	objInfo, err := h.storage.PutObject(bucket, key, body, srcInfo.Size, metadata)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	response := &CopyObjectResult{
+		ETag:         objInfo.ETag,
+		LastModified: objInfo.LastModified.Format(time.RFC3339),
+	}
+
+	h.setS3Headers(w)
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// parseCopySource splits an x-amz-copy-source value into bucket and key
+func parseCopySource(source string) (string, string, bool) {
+	if idx := strings.Index(source, "?"); idx >= 0 {
+		source = source[:idx]
+	}
+
+	decoded, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(decoded, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
 // GetObject handles GET /{bucket}/{key} - download object
 func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -318,21 +803,42 @@ func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	overrides, err := responseHeaderOverrides(r)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	reader, objInfo, err := h.storage.GetObject(bucket, key)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			h.writeErrorResponse(w, "NoSuchKey", "Object does not exist", http.StatusNotFound)
-		} else {
-			h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		h.writeAPIError(w, r, err)
 		return
 	}
 	defer reader.Close()
 
+	if err := checkSSECustomerKey(sseKey, objInfo.Metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	var body io.Reader = reader
+	if sseKey != nil {
+		if body, err = sseKey.decrypt(objInfo.Metadata, reader); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
 	h.setS3Headers(w)
 	w.Header().Set("Content-Type", objInfo.ContentType)
 	w.Header().Set("Content-Length", strconv.FormatInt(objInfo.Size, 10))
@@ -340,17 +846,21 @@ func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Last-Modified", objInfo.LastModified.Format(http.TimeFormat))
 
 	// Set metadata headers
-	for key, value := range objInfo.Metadata {
-		w.Header().Set(key, value)
+	setMetadataHeaders(w, objInfo.Metadata)
+	for name, values := range overrides {
+		w.Header()[name] = values
+	}
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
 	}
 
-	io.Copy(w, reader)
+	io.Copy(w, body)
 }
 
 // DeleteObject handles DELETE /{bucket}/{key} - delete object
 func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -359,16 +869,13 @@ func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
-	if err := h.storage.DeleteObject(bucket, key); err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			h.writeErrorResponse(w, "NoSuchKey", "Object does not exist", http.StatusNotFound)
-		} else {
-			h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+	// Deleting a key that doesn't exist succeeds, as it does in S3
+	if err := h.storage.DeleteObject(bucket, key, bypassGovernance(r)); err != nil && !errors.Is(err, storage.ErrNoSuchKey) {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -379,7 +886,7 @@ func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 // HeadObject handles HEAD /{bucket}/{key} - get object metadata
 func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -388,17 +895,30 @@ func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	overrides, err := responseHeaderOverrides(r)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	objInfo, err := h.storage.HeadObject(bucket, key)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			h.writeErrorResponse(w, "NoSuchKey", "Object does not exist", http.StatusNotFound)
-		} else {
-			h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	if err := checkSSECustomerKey(sseKey, objInfo.Metadata); err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -409,8 +929,12 @@ func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Last-Modified", objInfo.LastModified.Format(http.TimeFormat))
 
 	// Set metadata headers
-	for key, value := range objInfo.Metadata {
-		w.Header().Set(key, value)
+	setMetadataHeaders(w, objInfo.Metadata)
+	for name, values := range overrides {
+		w.Header()[name] = values
+	}
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -419,7 +943,7 @@ func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
 // Multipart upload handlers (simplified)
 func (h *Handler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -428,21 +952,36 @@ func (h *Handler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Request
 	key := vars["key"]
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	// Extract metadata from headers
-	metadata := make(map[string]string)
-	for name, values := range r.Header {
-		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
-			metadata[name] = values[0]
+	metadata := extractMetadata(r)
+	if err := h.objectLockMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if err := h.aclMetadata(r, bucket, metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if sseKey != nil {
+		if err := sseKey.seal(metadata); err != nil {
+			h.writeAPIError(w, r, err)
+			return
 		}
 	}
 
 	uploadID, err := h.storage.InitiateMultipartUpload(bucket, key, metadata)
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -453,13 +992,16 @@ func (h *Handler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Request
 	}
 
 	h.setS3Headers(w)
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
+	}
 	w.Header().Set("Content-Type", "application/xml")
 	xml.NewEncoder(w).Encode(response)
 }
 
 func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -470,31 +1012,65 @@ func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
 	uploadID := r.URL.Query().Get("uploadId")
 
 	partNumber, err := strconv.Atoi(partNumberStr)
-	if err != nil || partNumber < 1 {
-		h.writeErrorResponse(w, "InvalidPart", "Invalid part number", http.StatusBadRequest)
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		h.writeErrorResponse(w, r, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive", http.StatusBadRequest)
 		return
 	}
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	uploadMetadata, err := h.storage.GetMultipartUploadMetadata(bucket, key, uploadID)
+	if err != nil {
+		h.writeAPIError(w, r, err)
 		return
 	}
 
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if err := checkSSECustomerKey(sseKey, uploadMetadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	var body io.Reader = r.Body
+	var partMetadata map[string]string
+	if sseKey != nil {
+		iv, err := newSSEIV()
+		if err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		partMetadata = map[string]string{metadataSSECIV: iv}
+		if body, err = sseKey.encrypt(iv, body); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
 	contentLength := r.ContentLength
-	partInfo, err := h.storage.UploadPart(bucket, key, uploadID, partNumber, r.Body, contentLength)
+	partInfo, err := h.storage.UploadPart(bucket, key, uploadID, partNumber, body, contentLength, partMetadata)
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
 	h.setS3Headers(w)
+	if sseKey != nil {
+		sseKey.setResponseHeaders(w)
+	}
 	w.Header().Set("ETag", partInfo.ETag)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -504,20 +1080,20 @@ func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request
 	uploadID := r.URL.Query().Get("uploadId")
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
 	// Parse request body to get parts
 	var completeRequest CompleteMultipartUpload
-	if err := xml.NewDecoder(r.Body).Decode(&completeRequest); err != nil {
-		h.writeErrorResponse(w, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+	if err := xml.NewDecoder(r.Body).Decode(&completeRequest); err != nil || len(completeRequest.Part) == 0 {
+		h.writeErrorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
 		return
 	}
 
 	objInfo, err := h.storage.CompleteMultipartUpload(bucket, key, uploadID, completeRequest.Part)
 	if err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -529,13 +1105,16 @@ func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request
 	}
 
 	h.setS3Headers(w)
+	if algorithm := objInfo.Metadata[metadataSSECAlgorithm]; algorithm != "" {
+		w.Header().Set(metadataSSECAlgorithm, algorithm)
+	}
 	w.Header().Set("Content-Type", "application/xml")
 	xml.NewEncoder(w).Encode(response)
 }
 
 func (h *Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	if err := h.authenticate(r); err != nil {
-		h.writeErrorResponse(w, "AccessDenied", err.Error(), http.StatusForbidden)
+		h.writeAPIError(w, r, authError(err))
 		return
 	}
 
@@ -545,12 +1124,12 @@ func (h *Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	uploadID := r.URL.Query().Get("uploadId")
 
 	if !h.storage.BucketExists(bucket) {
-		h.writeErrorResponse(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
 		return
 	}
 
 	if err := h.storage.AbortMultipartUpload(bucket, key, uploadID); err != nil {
-		h.writeErrorResponse(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeAPIError(w, r, err)
 		return
 	}
 
@@ -6,11 +6,15 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"locals3/internal/auth"
 	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
 )
 
 // MockStorage is a mock implementation of the Storage interface for testing
@@ -55,10 +59,26 @@ func (m *MockStorage) BucketExists(bucket string) bool {
 	return ok
 }
 
+func (m *MockStorage) GetBucketOwner(bucket string) (string, error) {
+	return "", nil
+}
+
+func (m *MockStorage) PutBucketOwner(bucket, owner string) error {
+	return nil
+}
+
+func (m *MockStorage) GetBucketLocation(bucket string) (string, error) {
+	return "", nil
+}
+
+func (m *MockStorage) PutBucketLocation(bucket, region string) error {
+	return nil
+}
+
 // Implement remaining methods with minimal functionality needed for tests
 func (m *MockStorage) PutObject(bucket, key string, data io.Reader, size int64, metadata map[string]string) (*storage.ObjectInfo, error) {
 	if !m.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, storage.ErrNoSuchBucket
 	}
 
 	objInfo := &storage.ObjectInfo{
@@ -76,21 +96,21 @@ func (m *MockStorage) PutObject(bucket, key string, data io.Reader, size int64,
 
 func (m *MockStorage) GetObject(bucket, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
 	if !m.BucketExists(bucket) {
-		return nil, nil, fmt.Errorf("bucket does not exist")
+		return nil, nil, storage.ErrNoSuchBucket
 	}
 
 	objInfo, ok := m.Objects[bucket][key]
 	if !ok {
-		return nil, nil, fmt.Errorf("object does not exist")
+		return nil, nil, storage.ErrNoSuchKey
 	}
 
 	// Return empty reader and object info
 	return io.NopCloser(strings.NewReader("test content")), objInfo, nil
 }
 
-func (m *MockStorage) DeleteObject(bucket, key string) error {
+func (m *MockStorage) DeleteObject(bucket, key string, bypassGovernance bool) error {
 	if !m.BucketExists(bucket) {
-		return fmt.Errorf("bucket does not exist")
+		return storage.ErrNoSuchBucket
 	}
 
 	delete(m.Objects[bucket], key)
@@ -99,7 +119,7 @@ func (m *MockStorage) DeleteObject(bucket, key string) error {
 
 func (m *MockStorage) ListObjects(bucket, prefix, delimiter, marker string, maxKeys int) (*storage.ListObjectsResult, error) {
 	if !m.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, storage.ErrNoSuchBucket
 	}
 
 	objects := make([]storage.ObjectInfo, 0)
@@ -117,12 +137,12 @@ func (m *MockStorage) ListObjects(bucket, prefix, delimiter, marker string, maxK
 
 func (m *MockStorage) HeadObject(bucket, key string) (*storage.ObjectInfo, error) {
 	if !m.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, storage.ErrNoSuchBucket
 	}
 
 	objInfo, ok := m.Objects[bucket][key]
 	if !ok {
-		return nil, fmt.Errorf("object does not exist")
+		return nil, storage.ErrNoSuchKey
 	}
 
 	return objInfo, nil
@@ -142,7 +162,11 @@ func (m *MockStorage) InitiateMultipartUpload(bucket, key string, metadata map[s
 	return "test-upload-id", nil
 }
 
-func (m *MockStorage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64) (*storage.PartInfo, error) {
+func (m *MockStorage) GetMultipartUploadMetadata(bucket, key, uploadID string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (m *MockStorage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64, metadata map[string]string) (*storage.PartInfo, error) {
 	return &storage.PartInfo{
 		PartNumber: partNumber,
 		ETag:       "test-etag",
@@ -166,11 +190,95 @@ func (m *MockStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
 	return nil
 }
 
+// Object lock is not supported by the mock
+func (m *MockStorage) EnableObjectLock(bucket string) error {
+	return storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) GetObjectLockConfiguration(bucket string) (*storage.ObjectLockConfiguration, error) {
+	return &storage.ObjectLockConfiguration{}, nil
+}
+
+func (m *MockStorage) PutObjectLockConfiguration(bucket string, config *storage.ObjectLockConfiguration) error {
+	return storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) GetObjectRetention(bucket, key string) (*storage.ObjectRetention, error) {
+	return nil, storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) PutObjectRetention(bucket, key string, retention *storage.ObjectRetention, bypassGovernance bool) error {
+	return storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) GetObjectLegalHold(bucket, key string) (string, error) {
+	return "", storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) PutObjectLegalHold(bucket, key, status string) error {
+	return storage.ErrObjectLockNotEnabled
+}
+
+func (m *MockStorage) GetBucketWebsite(bucket string) (*storage.WebsiteConfiguration, error) {
+	return nil, nil
+}
+
+func (m *MockStorage) PutBucketWebsite(bucket string, website *storage.WebsiteConfiguration) error {
+	return nil
+}
+
+func (m *MockStorage) DeleteBucketWebsite(bucket string) error {
+	return nil
+}
+
+func (m *MockStorage) GetPublicAccessBlock(bucket string) (*storage.PublicAccessBlockConfiguration, error) {
+	return nil, nil
+}
+
+func (m *MockStorage) PutPublicAccessBlock(bucket string, block *storage.PublicAccessBlockConfiguration) error {
+	return nil
+}
+
+func (m *MockStorage) DeletePublicAccessBlock(bucket string) error {
+	return nil
+}
+
+func (m *MockStorage) GetBucketPolicy(bucket string) (string, error) {
+	return "", nil
+}
+
+func (m *MockStorage) PutBucketPolicy(bucket, policy string) error {
+	return nil
+}
+
+func (m *MockStorage) DeleteBucketPolicy(bucket string) error {
+	return nil
+}
+
+func (m *MockStorage) GetBucketACL(bucket string) (string, error) {
+	return "private", nil
+}
+
+func (m *MockStorage) PutBucketACL(bucket, acl string) error {
+	return nil
+}
+
+func (m *MockStorage) GetObjectACL(bucket, key string) (string, error) {
+	return "private", nil
+}
+
+func (m *MockStorage) PutObjectACL(bucket, key, acl string) error {
+	return nil
+}
+
 // MockAuth is a mock auth provider for testing
 type MockAuth struct {
 	AccessKey string
 	SecretKey string
 	Region    string
+
+	// Principal, when set, is attached to every authenticated request
+	Principal *auth.Principal
 }
 
 func NewMockAuth() *MockAuth {
@@ -182,9 +290,19 @@ func NewMockAuth() *MockAuth {
 }
 
 func (m *MockAuth) Authenticate(r *http.Request) error {
+	if m.Principal != nil {
+		*r = *r.WithContext(auth.WithPrincipal(r.Context(), m.Principal))
+	}
 	return nil // Always succeed in tests
 }
 
+func (m *MockAuth) VerifyPostPolicy(form *auth.PostPolicySignature) (*auth.Principal, error) {
+	if m.Principal != nil {
+		return m.Principal, nil
+	}
+	return &auth.Principal{}, nil
+}
+
 func (m *MockAuth) GetAccessKey() string {
 	return m.AccessKey
 }
@@ -308,3 +426,214 @@ func TestListBuckets(t *testing.T) {
 		t.Errorf("Expected buckets test-bucket-1 and test-bucket-2, got %v", bucketNames)
 	}
 }
+
+func TestWriteSignatureError(t *testing.T) {
+	handler := New(&Config{Storage: NewMockStorage(), Auth: NewMockAuth(), Region: "test-region"})
+	sigErr := &auth.SignatureError{
+		AccessKeyID:       "test",
+		SignatureProvided: "abc123",
+		StringToSign:      "AWS4-HMAC-SHA256\n20240101T000000Z",
+		CanonicalRequest:  "GET\n/",
+	}
+
+	rr := httptest.NewRecorder()
+	handler.writeAPIError(rr, httptest.NewRequest("GET", "/", nil), authError(fmt.Errorf("authentication failed: %w", sigErr)))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rr.Code)
+	}
+	var result S3Error
+	if err := xml.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse error response: %v", err)
+	}
+	if result.Code != "SignatureDoesNotMatch" || result.AWSAccessKeyID != "test" || result.SignatureProvided != "abc123" {
+		t.Errorf("Unexpected error response %+v", result)
+	}
+	if result.StringToSign != sigErr.StringToSign || result.CanonicalRequest != "GET\n/" {
+		t.Errorf("Expected signed strings in the error, got %q and %q", result.StringToSign, result.CanonicalRequest)
+	}
+	if result.CanonicalRequestBytes != "47 45 54 0a 2f" {
+		t.Errorf("Unexpected canonical request bytes %q", result.CanonicalRequestBytes)
+	}
+}
+
+func TestCreateBucket(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	create := func(bucket, owner, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/"+bucket, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"bucket": bucket})
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{UserName: owner}))
+		rr := httptest.NewRecorder()
+		h.CreateBucket(rr, req)
+		return rr
+	}
+
+	for _, bucket := range []string{"ab", "Uppercase", "under_score", ".uploads", "192.168.1.1", "a..b", "-dash", strings.Repeat("a", 64), "xn--bucket", "bucket-s3alias"} {
+		if rr := create(bucket, "alice", ""); rr.Code != http.StatusBadRequest || decodeS3Error(t, rr).Code != "InvalidBucketName" {
+			t.Errorf("%q: expected InvalidBucketName, got %d %s", bucket, rr.Code, rr.Body.String())
+		}
+	}
+
+	body := `<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>eu-west-1</LocationConstraint></CreateBucketConfiguration>`
+	if rr := create("alice-bucket", "alice", body); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to create bucket: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := create("alice-bucket", "alice", ""); decodeS3Error(t, rr).Code != "BucketAlreadyOwnedByYou" || rr.Code != http.StatusConflict {
+		t.Errorf("Expected BucketAlreadyOwnedByYou, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := create("alice-bucket", "bob", ""); decodeS3Error(t, rr).Code != "BucketAlreadyExists" || rr.Code != http.StatusConflict {
+		t.Errorf("Expected BucketAlreadyExists, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	location := func(bucket string) string {
+		rr := serveSSE(h.GetBucketLocation, "GET", "/"+bucket+"?location", map[string]string{"bucket": bucket}, nil, nil)
+		var constraint LocationConstraint
+		if err := xml.Unmarshal(rr.Body.Bytes(), &constraint); err != nil {
+			t.Fatalf("Failed to parse location of %s: %v", bucket, err)
+		}
+		return constraint.Region
+	}
+	if region := location("alice-bucket"); region != "eu-west-1" {
+		t.Errorf("Expected eu-west-1, got %q", region)
+	}
+	if rr := create("default-region", "alice", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to create bucket: %d %s", rr.Code, rr.Body.String())
+	}
+	if region := location("default-region"); region != "test-region" {
+		t.Errorf("Expected the server's region, got %q", region)
+	}
+
+	if rr := create("bad-region", "alice", "<CreateBucketConfiguration><LocationConstraint>Mars 1</LocationConstraint></CreateBucketConfiguration>"); decodeS3Error(t, rr).Code != "InvalidLocationConstraint" {
+		t.Errorf("Expected InvalidLocationConstraint, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := create("bad-xml", "alice", "<CreateBucketConfiguration>"); decodeS3Error(t, rr).Code != "MalformedXML" {
+		t.Errorf("Expected MalformedXML, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSystemMetadata(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	header := http.Header{
+		"Content-Type":        {"text/csv"},
+		"Cache-Control":       {"max-age=3600"},
+		"Content-Disposition": {`attachment; filename="report.csv"`},
+		"Content-Encoding":    {"aws-chunked,gzip"},
+		"Content-Language":    {"en-GB"},
+		"Expires":             {"Wed, 21 Oct 2026 07:28:00 GMT"},
+	}
+	want := http.Header{}
+	for name, values := range header {
+		want[name] = values
+	}
+	want.Set("Content-Encoding", "gzip")
+
+	check := func(name string, rr *httptest.ResponseRecorder, want http.Header) {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d %s", name, rr.Code, rr.Body.String())
+		}
+		for header, values := range want {
+			if got := rr.Header().Get(header); got != values[0] {
+				t.Errorf("%s: expected %s %q, got %q", name, header, values[0], got)
+			}
+		}
+	}
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "report"}
+	if rr := serveSSE(h.PutObject, "PUT", "/test-bucket/report", vars, header, []byte("a,b")); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to put object: %d %s", rr.Code, rr.Body.String())
+	}
+	check("GET", serveSSE(h.GetObject, "GET", "/test-bucket/report", vars, nil, nil), want)
+	check("HEAD", serveSSE(h.HeadObject, "HEAD", "/test-bucket/report", vars, nil, nil), want)
+
+	// Copies keep the metadata of their source unless it is replaced
+	copyVars := map[string]string{"bucket": "test-bucket", "key": "copy"}
+	copyHeader := http.Header{"X-Amz-Copy-Source": {"/test-bucket/report"}, "Content-Type": {"application/xml"}}
+	if rr := serveSSE(h.CopyObject, "PUT", "/test-bucket/copy", copyVars, copyHeader, nil); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to copy object: %d %s", rr.Code, rr.Body.String())
+	}
+	check("copy", serveSSE(h.HeadObject, "HEAD", "/test-bucket/copy", copyVars, nil, nil), want)
+
+	copyHeader.Set("X-Amz-Metadata-Directive", "REPLACE")
+	copyHeader.Set("Cache-Control", "no-cache")
+	if rr := serveSSE(h.CopyObject, "PUT", "/test-bucket/copy", copyVars, copyHeader, nil); rr.Code != http.StatusOK {
+		t.Fatalf("Failed to copy object: %d %s", rr.Code, rr.Body.String())
+	}
+	rr := serveSSE(h.HeadObject, "HEAD", "/test-bucket/copy", copyVars, nil, nil)
+	check("replaced copy", rr, http.Header{"Content-Type": {"application/xml"}, "Cache-Control": {"no-cache"}})
+	if rr.Header().Get("Content-Disposition") != "" {
+		t.Errorf("Expected replaced metadata to drop Content-Disposition, got %q", rr.Header().Get("Content-Disposition"))
+	}
+
+	// Multipart uploads store the metadata given when they are created
+	uploadVars := map[string]string{"bucket": "test-bucket", "key": "upload.bin"}
+	rr = serveSSE(h.InitiateMultipartUpload, "POST", "/test-bucket/upload.bin?uploads", uploadVars, header, nil)
+	var initiated InitiateMultipartUploadResult
+	if err := xml.Unmarshal(rr.Body.Bytes(), &initiated); err != nil {
+		t.Fatalf("Failed to parse upload: %v %s", err, rr.Body.String())
+	}
+	part, err := h.storage.UploadPart("test-bucket", "upload.bin", initiated.UploadID, 1, strings.NewReader("data"), 4, nil)
+	if err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+	if _, err := h.storage.CompleteMultipartUpload("test-bucket", "upload.bin", initiated.UploadID, []storage.CompletePart{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	check("multipart", serveSSE(h.HeadObject, "HEAD", "/test-bucket/upload.bin", uploadVars, nil, nil), want)
+
+	// Without a Content-Type it is guessed from the key
+	jsonVars := map[string]string{"bucket": "test-bucket", "key": "data.json"}
+	serveSSE(h.PutObject, "PUT", "/test-bucket/data.json", jsonVars, nil, []byte("{}"))
+	check("guessed", serveSSE(h.HeadObject, "HEAD", "/test-bucket/data.json", jsonVars, nil, nil), http.Header{"Content-Type": {"application/json"}})
+}
+
+func TestResponseHeaderOverrides(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "report.csv"}
+	serveSSE(h.PutObject, "PUT", "/test-bucket/report.csv", vars, http.Header{"Cache-Control": {"max-age=60"}}, []byte("a,b"))
+
+	query := "?response-content-type=application/octet-stream" +
+		"&response-content-disposition=attachment%3B%20filename%3D%22r.csv%22" +
+		"&response-cache-control=no-store" +
+		"&response-content-language=de" +
+		"&response-content-encoding=identity" +
+		"&response-expires=Thu%2C%2001%20Jan%202026%2000%3A00%3A00%20GMT"
+	want := map[string]string{
+		"Content-Type":        "application/octet-stream",
+		"Content-Disposition": `attachment; filename="r.csv"`,
+		"Cache-Control":       "no-store",
+		"Content-Language":    "de",
+		"Content-Encoding":    "identity",
+		"Expires":             "Thu, 01 Jan 2026 00:00:00 GMT",
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		handler := h.GetObject
+		if method == "HEAD" {
+			handler = h.HeadObject
+		}
+		rr := serveSSE(handler, method, "/test-bucket/report.csv"+query, vars, nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d %s", method, rr.Code, rr.Body.String())
+		}
+		for name, value := range want {
+			if got := rr.Header().Get(name); got != value {
+				t.Errorf("%s: expected %s %q, got %q", method, name, value, got)
+			}
+		}
+	}
+
+	// Anonymous requests may read the object, but not override headers
+	req := httptest.NewRequest("GET", "/test-bucket/report.csv?response-content-type=text/html", nil)
+	req = mux.SetURLVars(req.WithContext(auth.WithPrincipal(req.Context(), auth.AnonymousPrincipal())), vars)
+	rr := httptest.NewRecorder()
+	h.GetObject(rr, req)
+	if rr.Code != http.StatusBadRequest || decodeS3Error(t, rr).Code != "InvalidRequest" {
+		t.Errorf("Expected InvalidRequest, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// bypassGovernance reports whether the request asks to bypass governance mode
+func bypassGovernance(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Amz-Bypass-Governance-Retention"), "true")
+}
+
+// objectLockMetadata copies the x-amz-object-lock-* headers of an upload into
+// metadata after validating them against the bucket configuration
+func (h *Handler) objectLockMetadata(r *http.Request, bucket string, metadata map[string]string) error {
+	mode := r.Header.Get(storage.MetadataObjectLockMode)
+	until := r.Header.Get(storage.MetadataObjectLockRetainUntilDate)
+	legalHold := r.Header.Get(storage.MetadataObjectLockLegalHold)
+
+	if mode == "" && until == "" && legalHold == "" {
+		return nil
+	}
+
+	lockConfig, err := h.storage.GetObjectLockConfiguration(bucket)
+	if err != nil {
+		return err
+	}
+	if !lockConfig.Enabled {
+		return storage.ErrObjectLockNotEnabled
+	}
+
+	if (mode == "") != (until == "") {
+		return &apiError{"InvalidArgument", "x-amz-object-lock-retain-until-date and x-amz-object-lock-mode must both be supplied", http.StatusBadRequest}
+	}
+	if mode != "" {
+		if mode != storage.RetentionModeGovernance && mode != storage.RetentionModeCompliance {
+			return &apiError{"InvalidArgument", "Unknown wormMode directive.", http.StatusBadRequest}
+		}
+		retainUntil, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return &apiError{"InvalidArgument", "The retain until date must be provided in ISO 8601 format", http.StatusBadRequest}
+		}
+		if !retainUntil.After(time.Now()) {
+			return &apiError{"InvalidArgument", "The retain until date must be in the future!", http.StatusBadRequest}
+		}
+		metadata[storage.MetadataObjectLockMode] = mode
+		metadata[storage.MetadataObjectLockRetainUntilDate] = retainUntil.UTC().Format(storage.RetainUntilDateFormat)
+	}
+
+	if legalHold != "" {
+		if legalHold != "ON" && legalHold != "OFF" {
+			return &apiError{"InvalidArgument", "Legal Hold must be either of 'ON' or 'OFF'", http.StatusBadRequest}
+		}
+		metadata[storage.MetadataObjectLockLegalHold] = legalHold
+	}
+
+	return nil
+}
+
+// GetObjectLockConfiguration handles GET /{bucket}?object-lock
+func (h *Handler) GetObjectLockConfiguration(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	lockConfig, err := h.storage.GetObjectLockConfiguration(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if !lockConfig.Enabled {
+		h.writeErrorResponse(w, r, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", http.StatusNotFound)
+		return
+	}
+
+	response := &ObjectLockConfiguration{ObjectLockEnabled: "Enabled"}
+	if retention := lockConfig.DefaultRetention; retention != nil {
+		response.Rule = &ObjectLockRule{
+			DefaultRetention: DefaultRetention{
+				Mode:  retention.Mode,
+				Days:  retention.Days,
+				Years: retention.Years,
+			},
+		}
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// PutObjectLockConfiguration handles PUT /{bucket}?object-lock
+func (h *Handler) PutObjectLockConfiguration(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var request ObjectLockConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+	if request.ObjectLockEnabled != "Enabled" {
+		h.writeErrorResponse(w, r, "MalformedXML", "ObjectLockEnabled must be Enabled", http.StatusBadRequest)
+		return
+	}
+
+	lockConfig := &storage.ObjectLockConfiguration{Enabled: true}
+	if request.Rule != nil {
+		lockConfig.DefaultRetention = &storage.DefaultRetention{
+			Mode:  request.Rule.DefaultRetention.Mode,
+			Days:  request.Rule.DefaultRetention.Days,
+			Years: request.Rule.DefaultRetention.Years,
+		}
+		if err := lockConfig.DefaultRetention.Validate(); err != nil {
+			h.writeErrorResponse(w, r, "MalformedXML", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.storage.PutObjectLockConfiguration(bucket, lockConfig); err != nil {
+		if errors.Is(err, storage.ErrObjectLockNotEnabled) {
+			h.writeErrorResponse(w, r, "InvalidBucketState", "Object Lock configuration cannot be enabled on existing buckets", http.StatusConflict)
+		} else {
+			h.writeAPIError(w, r, err)
+		}
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObjectRetention handles GET /{bucket}/{key}?retention
+func (h *Handler) GetObjectRetention(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	retention, err := h.storage.GetObjectRetention(bucket, key)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if retention == nil {
+		h.writeErrorResponse(w, r, "NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration", http.StatusNotFound)
+		return
+	}
+
+	response := &Retention{
+		Mode:            retention.Mode,
+		RetainUntilDate: retention.RetainUntilDate.UTC().Format(storage.RetainUntilDateFormat),
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// PutObjectRetention handles PUT /{bucket}/{key}?retention
+func (h *Handler) PutObjectRetention(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var request Retention
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+
+	retention := &storage.ObjectRetention{Mode: request.Mode}
+	if request.Mode != "" {
+		if request.Mode != storage.RetentionModeGovernance && request.Mode != storage.RetentionModeCompliance {
+			h.writeErrorResponse(w, r, "MalformedXML", "Unknown retention mode", http.StatusBadRequest)
+			return
+		}
+		until, err := time.Parse(time.RFC3339, request.RetainUntilDate)
+		if err != nil {
+			h.writeErrorResponse(w, r, "InvalidArgument", "The retain until date must be provided in ISO 8601 format", http.StatusBadRequest)
+			return
+		}
+		if !until.After(time.Now()) {
+			h.writeErrorResponse(w, r, "InvalidArgument", "The retain until date must be in the future!", http.StatusBadRequest)
+			return
+		}
+		retention.RetainUntilDate = until
+	}
+
+	if err := h.storage.PutObjectRetention(bucket, key, retention, bypassGovernance(r)); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObjectLegalHold handles GET /{bucket}/{key}?legal-hold
+func (h *Handler) GetObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	status, err := h.storage.GetObjectLegalHold(bucket, key)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if status == "" {
+		h.writeErrorResponse(w, r, "NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration", http.StatusNotFound)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(&LegalHold{Status: status})
+}
+
+// PutObjectLegalHold handles PUT /{bucket}/{key}?legal-hold
+func (h *Handler) PutObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var request LegalHold
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+	if request.Status != "ON" && request.Status != "OFF" {
+		h.writeErrorResponse(w, r, "MalformedXML", "Legal Hold must be either of 'ON' or 'OFF'", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutObjectLegalHold(bucket, key, request.Status); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"locals3/internal/auth"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// maxPostFieldSize limits each non-file form field of a POST upload
+const maxPostFieldSize = 20 * 1024
+
+// postPolicy is a decoded POST policy document
+type postPolicy struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+// postCondition is one policy condition. Exact matches ({"field": "value"})
+// are represented as "eq".
+type postCondition struct {
+	operator string
+	field    string
+	value    string
+	min, max int64
+}
+
+// parsePostPolicy decodes a base64 policy document and its conditions
+func parsePostPolicy(encoded string) (time.Time, []postCondition, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("policy is not valid base64")
+	}
+
+	var policy postPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return time.Time{}, nil, fmt.Errorf("policy is not valid JSON")
+	}
+
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("policy has an invalid expiration")
+	}
+
+	var conditions []postCondition
+	for _, raw := range policy.Conditions {
+		var exact map[string]string
+		if err := json.Unmarshal(raw, &exact); err == nil {
+			for field, value := range exact {
+				conditions = append(conditions, postCondition{operator: "eq", field: strings.ToLower(field), value: value})
+			}
+			continue
+		}
+
+		var list []interface{}
+		if err := json.Unmarshal(raw, &list); err != nil || len(list) != 3 {
+			return time.Time{}, nil, fmt.Errorf("invalid policy condition %s", raw)
+		}
+		operator, _ := list[0].(string)
+		operator = strings.ToLower(operator)
+
+		switch operator {
+		case "eq", "starts-with":
+			field, _ := list[1].(string)
+			value, ok := list[2].(string)
+			if !strings.HasPrefix(field, "$") || !ok {
+				return time.Time{}, nil, fmt.Errorf("invalid policy condition %s", raw)
+			}
+			conditions = append(conditions, postCondition{operator: operator, field: strings.ToLower(field[1:]), value: value})
+		case "content-length-range":
+			min, minOK := list[1].(float64)
+			max, maxOK := list[2].(float64)
+			if !minOK || !maxOK || min < 0 || max < min {
+				return time.Time{}, nil, fmt.Errorf("invalid policy condition %s", raw)
+			}
+			conditions = append(conditions, postCondition{operator: operator, min: int64(min), max: int64(max)})
+		default:
+			return time.Time{}, nil, fmt.Errorf("invalid policy condition %s", raw)
+		}
+	}
+
+	return expiration, conditions, nil
+}
+
+// checkPostPolicy enforces the policy conditions on the form fields. Every
+// field except the signature, the policy itself and x-ignore-* fields must be
+// covered by a condition.
+func checkPostPolicy(conditions []postCondition, fields map[string]string) error {
+	covered := map[string]bool{
+		"policy":          true,
+		"x-amz-signature": true,
+		"signature":       true,
+		"awsaccesskeyid":  true,
+		"file":            true,
+		"bucket":          true,
+	}
+
+	for _, condition := range conditions {
+		if condition.operator == "content-length-range" {
+			continue
+		}
+		covered[condition.field] = true
+
+		value := fields[condition.field]
+		switch condition.operator {
+		case "eq":
+			if value != condition.value {
+				return policyFailed(`["eq", "$%s", "%s"]`, condition.field, condition.value)
+			}
+		case "starts-with":
+			// Content-Type conditions apply to every type in a list
+			values := []string{value}
+			if condition.field == "content-type" {
+				values = strings.Split(value, ",")
+			}
+			for _, v := range values {
+				if !strings.HasPrefix(strings.TrimSpace(v), condition.value) {
+					return policyFailed(`["starts-with", "$%s", "%s"]`, condition.field, condition.value)
+				}
+			}
+		}
+	}
+
+	for field := range fields {
+		if !covered[field] && !strings.HasPrefix(field, "x-ignore-") {
+			return &apiError{"AccessDenied", fmt.Sprintf("Invalid according to Policy: Extra input fields: %s", field), http.StatusForbidden}
+		}
+	}
+	return nil
+}
+
+func policyFailed(format string, args ...interface{}) error {
+	return &apiError{"AccessDenied", "Invalid according to Policy: Policy Condition failed: " + fmt.Sprintf(format, args...), http.StatusForbidden}
+}
+
+// PostObject handles POST /{bucket} - browser-based upload with an HTML form
+func (h *Handler) PostObject(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		h.writeErrorResponse(w, r, "PreconditionFailed", "Bucket POST must be of the enclosure-type multipart/form-data", http.StatusPreconditionFailed)
+		return
+	}
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	// Form fields come before the file, fields after it are ignored
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.writeErrorResponse(w, r, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.", http.StatusBadRequest)
+		return
+	}
+
+	fields := make(map[string]string)
+	formFields := make(map[string]string)
+	var file io.Reader
+	var filename string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.writeErrorResponse(w, r, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.", http.StatusBadRequest)
+			return
+		}
+
+		name := part.FormName()
+		if name == "file" {
+			file = part
+			filename = part.FileName()
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxPostFieldSize+1))
+		if err != nil || len(value) > maxPostFieldSize {
+			h.writeErrorResponse(w, r, "MaxPostPreDataLengthExceeded", "Your POST request fields preceding the upload file were too large.", http.StatusBadRequest)
+			return
+		}
+		fields[strings.ToLower(name)] = string(value)
+		formFields[name] = string(value)
+	}
+
+	fields["bucket"] = bucket
+
+	if file == nil {
+		h.writeErrorResponse(w, r, "InvalidArgument", "POST requires exactly one file upload per request.", http.StatusBadRequest)
+		return
+	}
+	if fields["key"] == "" {
+		h.writeErrorResponse(w, r, "InvalidArgument", "Bucket POST must contain a field named 'key'.", http.StatusBadRequest)
+		return
+	}
+
+	key := strings.ReplaceAll(fields["key"], "${filename}", filename)
+
+	// The upload is authorized as a PUT of the key it writes
+	r = mux.SetURLVars(r, map[string]string{"bucket": bucket, "key": key})
+	sizeRange, err := h.authorizePostObject(r, fields)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	metadata := make(map[string]string)
+	for name, value := range formFields {
+		if storedMetadata(name) {
+			metadata[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+
+	// With a content-length-range the file is spooled first, so that a file
+	// of the wrong size never reaches storage
+	size := int64(-1)
+	if sizeRange != nil {
+		spooled, err := os.CreateTemp("", "locals3-post-")
+		if err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		defer os.Remove(spooled.Name())
+		defer spooled.Close()
+
+		size, err = io.Copy(spooled, io.LimitReader(file, sizeRange.max+1))
+		if err != nil {
+			h.writeErrorResponse(w, r, "IncompleteBody", "The request body terminated unexpectedly", http.StatusBadRequest)
+			return
+		}
+		if size > sizeRange.max {
+			h.writeErrorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", http.StatusBadRequest)
+			return
+		}
+		if size < sizeRange.min {
+			h.writeErrorResponse(w, r, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size", http.StatusBadRequest)
+			return
+		}
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+		file = spooled
+	}
+
+	objInfo, err := h.storage.PutObject(bucket, key, file, size, metadata)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	location := fmt.Sprintf("/%s/%s", bucket, key)
+	h.setS3Headers(w)
+	w.Header().Set("ETag", objInfo.ETag)
+	w.Header().Set("Location", location)
+
+	redirect := fields["success_action_redirect"]
+	if redirect == "" {
+		redirect = fields["redirect"]
+	}
+	if target, err := url.Parse(redirect); redirect != "" && err == nil {
+		query := target.Query()
+		query.Set("bucket", bucket)
+		query.Set("key", key)
+		query.Set("etag", objInfo.ETag)
+		target.RawQuery = query.Encode()
+		w.Header().Set("Location", target.String())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusCreated)
+		xml.NewEncoder(w).Encode(&PostResponse{
+			Location: location,
+			Bucket:   bucket,
+			Key:      key,
+			ETag:     objInfo.ETag,
+		})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authorizePostObject verifies the policy signature and conditions of a POST
+// upload and returns its content-length-range condition, if any. The upload
+// is then authorized like a PUT by the principal that signed the policy.
+// Without a policy the upload is an unsigned request, which is only allowed
+// where the bucket grants public writes.
+func (h *Handler) authorizePostObject(r *http.Request, fields map[string]string) (*postCondition, error) {
+	encodedPolicy := fields["policy"]
+	if encodedPolicy == "" {
+		if h.disableAuth {
+			return nil, nil
+		}
+		*r = *r.WithContext(auth.WithPrincipal(r.Context(), auth.AnonymousPrincipal()))
+		if err := h.authorizeAnonymous(r); err != nil {
+			return nil, authError(err)
+		}
+		if err := h.authorizeExternal(r); err != nil {
+			return nil, authError(err)
+		}
+		return nil, nil
+	}
+
+	if !h.disableAuth {
+		if algorithm := fields["x-amz-algorithm"]; algorithm != "AWS4-HMAC-SHA256" {
+			return nil, &apiError{"InvalidArgument", fmt.Sprintf("Unsupported signing algorithm %q", algorithm), http.StatusBadRequest}
+		}
+		principal, err := h.auth.VerifyPostPolicy(&auth.PostPolicySignature{
+			Policy:     encodedPolicy,
+			Credential: fields["x-amz-credential"],
+			Date:       fields["x-amz-date"],
+			Token:      fields["x-amz-security-token"],
+			Signature:  fields["x-amz-signature"],
+		})
+		if err != nil {
+			return nil, postSignatureError(err)
+		}
+		*r = *r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	}
+
+	expiration, conditions, err := parsePostPolicy(encodedPolicy)
+	if err != nil {
+		return nil, &apiError{"InvalidPolicyDocument", "Invalid Policy: " + err.Error(), http.StatusBadRequest}
+	}
+	if !time.Now().Before(expiration) {
+		return nil, &apiError{"AccessDenied", "Invalid according to Policy: Policy expired.", http.StatusForbidden}
+	}
+
+	if err := checkPostPolicy(conditions, fields); err != nil {
+		return nil, err
+	}
+
+	if !h.disableAuth {
+		if err := h.authorizePrincipal(r); err != nil {
+			return nil, authError(err)
+		}
+		if err := h.authorizeExternal(r); err != nil {
+			return nil, authError(err)
+		}
+	}
+
+	for i := range conditions {
+		if conditions[i].operator == "content-length-range" {
+			return &conditions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// postSignatureError returns the error response for a POST policy signature
+// that failed verification
+func postSignatureError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrExpiredToken), errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, auth.ErrRequestTimeTooSkewed), errors.Is(err, auth.ErrAuthorizationHeaderMalformed):
+		return authError(err)
+	}
+	return &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your key and signing method.", http.StatusForbidden}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"locals3/internal/auth"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	testPostAccessKey = "post-access-key"
+	testPostSecretKey = "post-secret-key"
+)
+
+func newPostTestHandler(t *testing.T) (*Handler, *storage.FileSystemStorage, string) {
+	tempDir, err := os.MkdirTemp("", "locals3-post-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	fs := storage.NewFileSystemStorage(tempDir)
+	if err := fs.CreateBucket("uploads"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	return New(&Config{
+		Storage: fs,
+		Auth:    auth.NewAWSV4Auth(testPostAccessKey, testPostSecretKey, "us-east-1"),
+		Region:  "us-east-1",
+	}), fs, tempDir
+}
+
+// signedPostForm returns the policy fields of a POST upload signed with the
+// test credentials
+func signedPostForm(expiration time.Time, conditions string) map[string]string {
+	return signedPostFormAs(testPostAccessKey, testPostSecretKey, expiration, conditions)
+}
+
+// signedPostFormAs returns the policy fields of a POST upload signed with an
+// access key
+func signedPostFormAs(accessKey, secretKey string, expiration time.Time, conditions string) map[string]string {
+	date := "20240101"
+	credential := accessKey + "/" + date + "/us-east-1/s3/aws4_request"
+	policyJSON := fmt.Sprintf(`{"expiration": %q, "conditions": [%s]}`, expiration.UTC().Format(time.RFC3339), conditions)
+	policy := base64.StdEncoding.EncodeToString([]byte(policyJSON))
+
+	sign := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := sign(sign(sign(sign([]byte("AWS4"+secretKey), date), "us-east-1"), "s3"), "aws4_request")
+
+	return map[string]string{
+		"policy":           policy,
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credential,
+		"x-amz-date":       date + "T000000Z",
+		"x-amz-signature":  hex.EncodeToString(sign(key, policy)),
+	}
+}
+
+func servePostObject(handler *Handler, fields map[string]string, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	file, _ := form.CreateFormFile("file", filename)
+	file.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/uploads", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = mux.SetURLVars(req, map[string]string{"bucket": "uploads"})
+
+	rr := httptest.NewRecorder()
+	handler.PostObject(rr, req)
+	return rr
+}
+
+const testPostConditions = `{"bucket": "uploads"},
+	["starts-with", "$key", "user/"],
+	["eq", "$success_action_status", "201"],
+	["starts-with", "$x-amz-meta-tag", ""],
+	["content-length-range", 1, 16],
+	{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+	["starts-with", "$x-amz-credential", ""],
+	["starts-with", "$x-amz-date", ""]`
+
+func TestPostObject(t *testing.T) {
+	handler, fs, tempDir := newPostTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	fields := signedPostForm(time.Now().Add(time.Hour), testPostConditions)
+	fields["key"] = "user/${filename}"
+	fields["success_action_status"] = "201"
+	fields["x-amz-meta-tag"] = "avatar"
+
+	rr := servePostObject(handler, fields, "photo.png", []byte("image bytes"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("PostObject returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "<Key>user/photo.png</Key>") {
+		t.Errorf("Unexpected PostResponse %s", rr.Body.String())
+	}
+
+	reader, objInfo, err := fs.GetObject("uploads", "user/photo.png")
+	if err != nil {
+		t.Fatalf("Uploaded object not found: %v", err)
+	}
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "image bytes" || objInfo.Metadata["X-Amz-Meta-Tag"] != "avatar" {
+		t.Errorf("Unexpected object %q with metadata %v", content, objInfo.Metadata)
+	}
+}
+
+func TestPostObjectRedirect(t *testing.T) {
+	handler, _, tempDir := newPostTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	conditions := `{"bucket": "uploads"}, ["starts-with", "$key", ""], ["starts-with", "$success_action_redirect", "http://app.local/"],
+		["starts-with", "$x-amz-credential", ""], ["starts-with", "$x-amz-algorithm", ""], ["starts-with", "$x-amz-date", ""]`
+	fields := signedPostForm(time.Now().Add(time.Hour), conditions)
+	fields["key"] = "doc.txt"
+	fields["success_action_redirect"] = "http://app.local/done?from=form"
+
+	rr := servePostObject(handler, fields, "doc.txt", []byte("hello"))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	if !strings.HasPrefix(location, "http://app.local/done?") || !strings.Contains(location, "key=doc.txt") || !strings.Contains(location, "from=form") {
+		t.Errorf("Unexpected redirect location %s", location)
+	}
+}
+
+func TestPostObjectPolicyViolations(t *testing.T) {
+	handler, fs, tempDir := newPostTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	valid := func() map[string]string {
+		fields := signedPostForm(time.Now().Add(time.Hour), testPostConditions)
+		fields["key"] = "user/file.txt"
+		fields["success_action_status"] = "201"
+		fields["x-amz-meta-tag"] = "x"
+		return fields
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(map[string]string)
+		content string
+		status  int
+		code    string
+	}{
+		{"bad signature", func(f map[string]string) { f["x-amz-signature"] = strings.Repeat("0", 64) }, "data", http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"key prefix", func(f map[string]string) { f["key"] = "admin/file.txt" }, "data", http.StatusForbidden, "AccessDenied"},
+		{"eq condition", func(f map[string]string) { f["success_action_status"] = "200" }, "data", http.StatusForbidden, "AccessDenied"},
+		{"extra field", func(f map[string]string) { f["x-amz-meta-other"] = "x" }, "data", http.StatusForbidden, "AccessDenied"},
+		{"ignored field", func(f map[string]string) { f["x-ignore-me"] = "x" }, "data", http.StatusCreated, ""},
+		{"too large", func(f map[string]string) {}, strings.Repeat("x", 17), http.StatusBadRequest, "EntityTooLarge"},
+		{"too small", func(f map[string]string) {}, "", http.StatusBadRequest, "EntityTooSmall"},
+		{"missing key", func(f map[string]string) { delete(f, "key") }, "data", http.StatusBadRequest, "InvalidArgument"},
+	}
+
+	for _, tt := range tests {
+		fields := valid()
+		tt.modify(fields)
+		rr := servePostObject(handler, fields, "file.txt", []byte(tt.content))
+		if rr.Code != tt.status || !strings.Contains(rr.Body.String(), tt.code) {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.name, tt.status, tt.code, rr.Code, rr.Body.String())
+		}
+	}
+
+	expired := signedPostForm(time.Now().Add(-time.Minute), testPostConditions)
+	expired["key"] = "user/late.txt"
+	rr := servePostObject(handler, expired, "late.txt", []byte("data"))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "expired") {
+		t.Errorf("Expected expired policy to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if !fs.ObjectExists("uploads", "user/file.txt") {
+		t.Error("Expected the upload with an ignored field to succeed")
+	}
+	if fs.ObjectExists("uploads", "admin/file.txt") {
+		t.Error("Rejected upload must not be stored")
+	}
+
+	req := httptest.NewRequest("POST", "/uploads", strings.NewReader("key=value"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"bucket": "uploads"})
+	rr = httptest.NewRecorder()
+	handler.PostObject(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for non-multipart POST, got %d", rr.Code)
+	}
+}
+
+func TestPostObjectAuthorization(t *testing.T) {
+	handler, fs, tempDir := newPostTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	// Without a policy the upload is anonymous, and needs a public bucket
+	unsigned := map[string]string{"key": "anonymous.txt"}
+	if rr := servePostObject(handler, unsigned, "a.txt", []byte("data")); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected an unsigned POST to a private bucket to be denied, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if fs.ObjectExists("uploads", "anonymous.txt") {
+		t.Error("Denied upload must not be stored")
+	}
+	fs.PutBucketACL("uploads", storage.ACLPublicReadWrite)
+	if rr := servePostObject(handler, unsigned, "a.txt", []byte("data")); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected an unsigned POST to a public-read-write bucket, got %d: %s", rr.Code, rr.Body.String())
+	}
+	fs.PutBucketACL("uploads", "private")
+
+	// Signed uploads are checked against the identity policies of the
+	// signer, here those of temporary credentials
+	credentials := auth.NewCredentialStore()
+	policy, _ := auth.ParsePolicy([]byte(`{"Statement": [
+		{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::uploads/user/*"}
+	]}`))
+	session := credentials.AddSession(auth.Principal{UserName: "alice", Policies: []*auth.Policy{policy}}, time.Hour)
+	handler.auth = auth.NewAWSV4AuthWithStore(credentials, "", "", "us-east-1")
+
+	conditions := `{"bucket": "uploads"}, ["starts-with", "$key", ""], ["starts-with", "$x-amz-credential", ""],
+		["starts-with", "$x-amz-algorithm", ""], ["starts-with", "$x-amz-date", ""], ["starts-with", "$x-amz-security-token", ""]`
+	signed := func(key string) map[string]string {
+		fields := signedPostFormAs(session.AccessKeyID, session.SecretAccessKey, time.Now().Add(time.Hour), conditions)
+		fields["x-amz-security-token"] = session.SessionToken
+		fields["key"] = key
+		return fields
+	}
+	if rr := servePostObject(handler, signed("user/a.txt"), "a.txt", []byte("data")); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected an upload allowed by the session policy, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := servePostObject(handler, signed("admin/a.txt"), "a.txt", []byte("data")); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected an upload outside the session policy to be denied, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// And then by the external authorizer
+	authorizer := &recordingAuthorizer{allow: map[string]bool{}}
+	handler.authorizer = authorizer
+	if rr := servePostObject(handler, signed("user/b.txt"), "b.txt", []byte("data")); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected an upload denied by the authorizer to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(authorizer.inputs) != 1 || authorizer.inputs[0].Action != "s3:PutObject" || authorizer.inputs[0].Key != "user/b.txt" || authorizer.inputs[0].Principal.UserName != "alice" {
+		t.Errorf("Unexpected authorization requests %+v", authorizer.inputs)
+	}
+	if fs.ObjectExists("uploads", "admin/a.txt") || fs.ObjectExists("uploads", "user/b.txt") {
+		t.Error("Denied uploads must not be stored")
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+
+	"locals3/internal/s3select"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// SelectObjectContent handles POST /{bucket}/{key}?select&select-type=2 -
+// run an SQL expression over a CSV or JSON object
+func (h *Handler) SelectObjectContent(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var req SelectObjectContentRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(req.ExpressionType, "SQL") {
+		h.writeErrorResponse(w, r, "InvalidExpressionType", "The ExpressionType is invalid. Only SQL expressions are supported.", http.StatusBadRequest)
+		return
+	}
+
+	query, err := s3select.Prepare(&s3select.Request{
+		Expression: req.Expression,
+		Input:      req.InputSerialization,
+		Output:     req.OutputSerialization,
+		Progress:   req.RequestProgress.Enabled,
+	})
+	if err != nil {
+		h.writeErrorResponse(w, r, selectErrorCode(err), err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sseKey, err := parseSSECustomerKey(r.Header, sseHeaderPrefix)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	reader, objInfo, err := h.storage.GetObject(bucket, key)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	if err := checkSSECustomerKey(sseKey, objInfo.Metadata); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	var body io.Reader = reader
+	if sseKey != nil {
+		if body, err = sseKey.decrypt(objInfo.Metadata, reader); err != nil {
+			h.writeAPIError(w, r, err)
+			return
+		}
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+
+	// Events are flushed as they are written so that clients see records
+	// while the object is still being scanned
+	if err := query.Run(body, w); err != nil {
+		logrus.WithError(err).Warn("Failed to stream select results")
+	}
+}
+
+// selectErrorCode returns the S3 error code of a select query error
+func selectErrorCode(err error) string {
+	if selectErr, ok := err.(*s3select.Error); ok {
+		return selectErr.Code
+	}
+	return "InvalidRequest"
+}
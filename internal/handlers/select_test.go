@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testSelectRequest = `<SelectObjectContentRequest>
+	<Expression>SELECT s.name FROM S3Object s WHERE CAST(s.age AS INT) &gt; 30</Expression>
+	<ExpressionType>SQL</ExpressionType>
+	<InputSerialization>
+		<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>
+	</InputSerialization>
+	<OutputSerialization>
+		<JSON></JSON>
+	</OutputSerialization>
+</SelectObjectContentRequest>`
+
+func TestSelectObjectContent(t *testing.T) {
+	handler, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "people.csv"}
+	content := []byte("name,age\nalice,30\ncarol,41\n")
+	rr := serveSSE(handler.PutObject, "PUT", "/test-bucket/people.csv", vars, nil, content)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PutObject returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveSSE(handler.SelectObjectContent, "POST", "/test-bucket/people.csv?select&select-type=2", vars, nil, []byte(testSelectRequest))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SelectObjectContent returned %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `{"name":"carol"}`) || strings.Contains(body, "alice") {
+		t.Errorf("Unexpected select result %q", body)
+	}
+	if !strings.Contains(body, ":event-type") || !strings.Contains(body, "End") {
+		t.Errorf("Expected an event stream response, got %q", body)
+	}
+
+	invalid := strings.Replace(testSelectRequest, "SELECT s.name", "SELECT", 1)
+	rr = serveSSE(handler.SelectObjectContent, "POST", "/test-bucket/people.csv?select&select-type=2", vars, nil, []byte(invalid))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "ParseUnexpectedToken") {
+		t.Errorf("Expected ParseUnexpectedToken, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	missing := map[string]string{"bucket": "test-bucket", "key": "missing.csv"}
+	rr = serveSSE(handler.SelectObjectContent, "POST", "/test-bucket/missing.csv?select&select-type=2", missing, nil, []byte(testSelectRequest))
+	if rr.Code != http.StatusNotFound || !bytes.Contains(rr.Body.Bytes(), []byte("NoSuchKey")) {
+		t.Errorf("Expected NoSuchKey, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"locals3/internal/storage"
+)
+
+// SSE-C header names, relative to the "X-Amz-" or "X-Amz-Copy-Source-" prefix
+const (
+	sseCustomerAlgorithmSuffix = "Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeySuffix       = "Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Suffix    = "Server-Side-Encryption-Customer-Key-Md5"
+
+	sseHeaderPrefix           = "X-Amz-"
+	sseCopySourceHeaderPrefix = "X-Amz-Copy-Source-"
+)
+
+// Metadata entries persisted for SSE-C objects. Only a salted fingerprint of
+// the customer key is kept; the key itself never touches the disk. Each
+// object, and each part of a multipart upload, is encrypted with a random IV
+// of its own; the IVs of the parts of an assembled object are recorded under
+// storage.PartMetadataName.
+const (
+	metadataSSECAlgorithm   = sseHeaderPrefix + sseCustomerAlgorithmSuffix
+	metadataSSECSalt        = storage.InternalMetadataPrefix + "Sse-C-Salt"
+	metadataSSECFingerprint = storage.InternalMetadataPrefix + "Sse-C-Fingerprint"
+	metadataSSECIV          = storage.InternalMetadataPrefix + "Sse-C-Iv"
+)
+
+// errSSECCorrupt reports stored encryption parameters that can't be used
+var errSSECCorrupt = &apiError{"InternalError", "Stored encryption parameters are corrupt", http.StatusInternalServerError}
+
+// sseCustomerKey is a validated customer-provided encryption key
+type sseCustomerKey struct {
+	key    []byte
+	keyMD5 string
+}
+
+// parseSSECustomerKey reads the SSE-C headers with the given prefix. It returns
+// nil without error when the request carries none of them.
+func parseSSECustomerKey(header http.Header, prefix string) (*sseCustomerKey, error) {
+	algorithm := header.Get(prefix + sseCustomerAlgorithmSuffix)
+	encodedKey := header.Get(prefix + sseCustomerKeySuffix)
+	keyMD5 := header.Get(prefix + sseCustomerKeyMD5Suffix)
+
+	if algorithm == "" && encodedKey == "" && keyMD5 == "" {
+		return nil, nil
+	}
+
+	if algorithm == "" {
+		return nil, &apiError{"InvalidArgument", "Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm.", http.StatusBadRequest}
+	}
+	if algorithm != "AES256" {
+		return nil, &apiError{"InvalidEncryptionAlgorithmError", "The encryption request you specified is not valid. The valid value is AES256.", http.StatusBadRequest}
+	}
+	if encodedKey == "" {
+		return nil, &apiError{"InvalidArgument", "Requests specifying Server Side Encryption with Customer provided keys must provide an appropriate secret key.", http.StatusBadRequest}
+	}
+	if keyMD5 == "" {
+		return nil, &apiError{"InvalidArgument", "Requests specifying Server Side Encryption with Customer provided keys must provide the client calculated MD5 of the secret key.", http.StatusBadRequest}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != 32 {
+		return nil, &apiError{"InvalidArgument", "The secret key was invalid for the specified algorithm.", http.StatusBadRequest}
+	}
+
+	sum := md5.Sum(key)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		return nil, &apiError{"InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided.", http.StatusBadRequest}
+	}
+
+	return &sseCustomerKey{key: key, keyMD5: keyMD5}, nil
+}
+
+// seal records the key fingerprint in metadata so later requests can be checked
+// against it, together with the IV the object data is encrypted with
+func (k *sseCustomerKey) seal(metadata map[string]string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	iv, err := newSSEIV()
+	if err != nil {
+		return err
+	}
+
+	metadata[metadataSSECAlgorithm] = "AES256"
+	metadata[metadataSSECSalt] = hex.EncodeToString(salt)
+	metadata[metadataSSECFingerprint] = k.fingerprint(salt)
+	metadata[metadataSSECIV] = iv
+	return nil
+}
+
+// newSSEIV returns a random hex-encoded IV. Parts and objects never share
+// one, so no two of them are encrypted with the same keystream.
+func newSSEIV() (string, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(iv), nil
+}
+
+// verify checks that the key matches the fingerprint stored with an object
+func (k *sseCustomerKey) verify(metadata map[string]string) error {
+	salt, err := hex.DecodeString(metadata[metadataSSECSalt])
+	if err != nil || len(salt) == 0 {
+		return errSSECCorrupt
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.fingerprint(salt)), []byte(metadata[metadataSSECFingerprint])) != 1 {
+		return &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	}
+	return nil
+}
+
+func (k *sseCustomerKey) fingerprint(salt []byte) string {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("fingerprint"))
+	mac.Write(salt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encrypt wraps data so that it is encrypted, or decrypted, with the
+// AES-256-CTR keystream starting at the hex-encoded iv
+func (k *sseCustomerKey) encrypt(iv string, data io.Reader) (io.Reader, error) {
+	decoded, err := hex.DecodeString(iv)
+	if err != nil || len(decoded) != aes.BlockSize {
+		return nil, errSSECCorrupt
+	}
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return &cipher.StreamReader{S: cipher.NewCTR(block, decoded), R: data}, nil
+}
+
+// decrypt wraps an encrypted object body. Objects assembled from multipart
+// uploads are decrypted part by part using the recorded part layout.
+func (k *sseCustomerKey) decrypt(metadata map[string]string, body io.ReadCloser) (io.ReadCloser, error) {
+	layout := metadata[storage.MetadataParts]
+	if layout == "" {
+		reader, err := k.encrypt(metadata[metadataSSECIV], body)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{reader, body}, nil
+	}
+
+	var readers []io.Reader
+	for _, entry := range strings.Split(layout, ",") {
+		numberSize := strings.SplitN(entry, ":", 2)
+		if len(numberSize) != 2 {
+			return nil, fmt.Errorf("invalid part layout %q", layout)
+		}
+		partNumber, err := strconv.Atoi(numberSize[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid part layout %q", layout)
+		}
+		size, err := strconv.ParseInt(numberSize[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid part layout %q", layout)
+		}
+
+		reader, err := k.encrypt(metadata[storage.PartMetadataName(metadataSSECIV, partNumber)], io.LimitReader(body, size))
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+
+	return readCloser{io.MultiReader(readers...), body}, nil
+}
+
+// setResponseHeaders echoes the SSE-C parameters back to the client
+func (k *sseCustomerKey) setResponseHeaders(w http.ResponseWriter) {
+	w.Header().Set(sseHeaderPrefix+sseCustomerAlgorithmSuffix, "AES256")
+	w.Header().Set(sseHeaderPrefix+sseCustomerKeyMD5Suffix, k.keyMD5)
+}
+
+// isSSECEncrypted reports whether an object was stored with SSE-C
+func isSSECEncrypted(metadata map[string]string) bool {
+	return metadata[metadataSSECFingerprint] != ""
+}
+
+// checkSSECustomerKey validates the key supplied for reading an object
+// against the way the object was stored
+func checkSSECustomerKey(key *sseCustomerKey, metadata map[string]string) error {
+	if !isSSECEncrypted(metadata) {
+		if key != nil {
+			return &apiError{"InvalidRequest", "The encryption parameters are not applicable to this object.", http.StatusBadRequest}
+		}
+		return nil
+	}
+
+	if key == nil {
+		return &apiError{"InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.", http.StatusBadRequest}
+	}
+	return key.verify(metadata)
+}
+
+// readCloser pairs a transformed reader with the closer of its source
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+func testSSEHeaders(prefix string, key []byte) http.Header {
+	sum := md5.Sum(key)
+	header := http.Header{}
+	header.Set(prefix+sseCustomerAlgorithmSuffix, "AES256")
+	header.Set(prefix+sseCustomerKeySuffix, base64.StdEncoding.EncodeToString(key))
+	header.Set(prefix+sseCustomerKeyMD5Suffix, base64.StdEncoding.EncodeToString(sum[:]))
+	return header
+}
+
+func newSSETestHandler(t *testing.T) (*Handler, string) {
+	tempDir, err := os.MkdirTemp("", "locals3-sse-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	fs := storage.NewFileSystemStorage(tempDir)
+	if err := fs.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	return New(&Config{
+		Storage:     fs,
+		Auth:        NewMockAuth(),
+		Region:      "test-region",
+		BaseDomain:  "localhost",
+		DisableAuth: true,
+	}), tempDir
+}
+
+func serveSSE(handler http.HandlerFunc, method, target string, vars map[string]string, header http.Header, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req = mux.SetURLVars(req, vars)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseSSECustomerKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	parsed, err := parseSSECustomerKey(http.Header{}, sseHeaderPrefix)
+	if err != nil || parsed != nil {
+		t.Errorf("Expected no key without headers, got %v, %v", parsed, err)
+	}
+
+	parsed, err = parseSSECustomerKey(testSSEHeaders(sseHeaderPrefix, key), sseHeaderPrefix)
+	if err != nil {
+		t.Fatalf("Failed to parse valid key: %v", err)
+	}
+	if !bytes.Equal(parsed.key, key) {
+		t.Error("Parsed key does not match")
+	}
+
+	badMD5 := testSSEHeaders(sseHeaderPrefix, key)
+	badMD5.Set(sseHeaderPrefix+sseCustomerKeyMD5Suffix, base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	badAlgorithm := testSSEHeaders(sseHeaderPrefix, key)
+	badAlgorithm.Set(sseHeaderPrefix+sseCustomerAlgorithmSuffix, "aws:kms")
+	shortKey := testSSEHeaders(sseHeaderPrefix, key[:16])
+
+	tests := []struct {
+		name   string
+		header http.Header
+		code   string
+	}{
+		{"MD5 mismatch", badMD5, "InvalidArgument"},
+		{"Unsupported algorithm", badAlgorithm, "InvalidEncryptionAlgorithmError"},
+		{"Short key", shortKey, "InvalidArgument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSSECustomerKey(tt.header, sseHeaderPrefix)
+			apiErr, ok := err.(*apiError)
+			if !ok {
+				t.Fatalf("Expected apiError, got %v", err)
+			}
+			if apiErr.Code != tt.code {
+				t.Errorf("Expected code %s, got %s", tt.code, apiErr.Code)
+			}
+		})
+	}
+}
+
+func TestSSECustomerKeyObjectRoundTrip(t *testing.T) {
+	handler, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	key := bytes.Repeat([]byte{0x01}, 32)
+	wrongKey := bytes.Repeat([]byte{0x02}, 32)
+	content := []byte("secret object content")
+	vars := map[string]string{"bucket": "test-bucket", "key": "secret.txt"}
+
+	rr := serveSSE(handler.PutObject, "PUT", "/test-bucket/secret.txt", vars, testSSEHeaders(sseHeaderPrefix, key), content)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PutObject returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get(sseHeaderPrefix+sseCustomerAlgorithmSuffix) != "AES256" {
+		t.Error("Expected SSE-C algorithm in PutObject response")
+	}
+
+	// The data at rest must not be the plaintext, and the key must not be stored
+	onDisk, err := os.ReadFile(filepath.Join(tempDir, "test-bucket", "secret.txt%o"))
+	if err != nil {
+		t.Fatalf("Failed to read object file: %v", err)
+	}
+	if bytes.Equal(onDisk, content) {
+		t.Error("Object was stored unencrypted")
+	}
+	sidecar, _ := os.ReadFile(filepath.Join(tempDir, "test-bucket", "secret.txt.metadata"))
+	if strings.Contains(string(sidecar), base64.StdEncoding.EncodeToString(key)) {
+		t.Error("Customer key was persisted")
+	}
+
+	rr = serveSSE(handler.GetObject, "GET", "/test-bucket/secret.txt", vars, testSSEHeaders(sseHeaderPrefix, key), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetObject returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("Expected %q, got %q", content, rr.Body.Bytes())
+	}
+	for name := range rr.Header() {
+		if strings.HasPrefix(name, storage.InternalMetadataPrefix) {
+			t.Errorf("Internal metadata %s leaked into response", name)
+		}
+	}
+
+	rr = serveSSE(handler.GetObject, "GET", "/test-bucket/secret.txt", vars, nil, nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "InvalidRequest") {
+		t.Errorf("Expected InvalidRequest without key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveSSE(handler.GetObject, "GET", "/test-bucket/secret.txt", vars, testSSEHeaders(sseHeaderPrefix, wrongKey), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 with wrong key, got %d", rr.Code)
+	}
+
+	rr = serveSSE(handler.HeadObject, "HEAD", "/test-bucket/secret.txt", vars, testSSEHeaders(sseHeaderPrefix, wrongKey), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 on HEAD with wrong key, got %d", rr.Code)
+	}
+
+	rr = serveSSE(handler.HeadObject, "HEAD", "/test-bucket/secret.txt", vars, testSSEHeaders(sseHeaderPrefix, key), nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 on HEAD with key, got %d", rr.Code)
+	}
+
+	// Copy re-encrypts under a new key
+	copyHeader := testSSEHeaders(sseCopySourceHeaderPrefix, key)
+	for name, values := range testSSEHeaders(sseHeaderPrefix, wrongKey) {
+		copyHeader[name] = values
+	}
+	copyHeader.Set("X-Amz-Copy-Source", "/test-bucket/secret.txt")
+	copyVars := map[string]string{"bucket": "test-bucket", "key": "copy.txt"}
+
+	rr = serveSSE(handler.CopyObject, "PUT", "/test-bucket/copy.txt", copyVars, copyHeader, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CopyObject returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveSSE(handler.GetObject, "GET", "/test-bucket/copy.txt", copyVars, testSSEHeaders(sseHeaderPrefix, wrongKey), nil)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("Expected copied content, got %d: %q", rr.Code, rr.Body.Bytes())
+	}
+}
+
+func TestSSECustomerKeyMultipart(t *testing.T) {
+	handler, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	key := bytes.Repeat([]byte{0x03}, 32)
+	vars := map[string]string{"bucket": "test-bucket", "key": "multi.bin"}
+
+	rr := serveSSE(handler.InitiateMultipartUpload, "POST", "/test-bucket/multi.bin?uploads", vars, testSSEHeaders(sseHeaderPrefix, key), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("InitiateMultipartUpload returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var initiated InitiateMultipartUploadResult
+	if err := xml.Unmarshal(rr.Body.Bytes(), &initiated); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	rr = serveSSE(handler.UploadPart, "PUT", "/test-bucket/multi.bin?partNumber=1&uploadId="+initiated.UploadID, vars, nil, []byte("x"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected UploadPart without key to be rejected, got %d", rr.Code)
+	}
+
+	// Every part but the last must be at least the minimum part size
+	first := append([]byte("first part "), bytes.Repeat([]byte("."), storage.MinPartSize)...)
+	parts := [][]byte{first, []byte("second part")}
+	for i, part := range parts {
+		target := "/test-bucket/multi.bin?partNumber=" + string(rune('1'+i)) + "&uploadId=" + initiated.UploadID
+		rr = serveSSE(handler.UploadPart, "PUT", target, vars, testSSEHeaders(sseHeaderPrefix, key), part)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("UploadPart returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// A part uploaded again is encrypted with a fresh IV, so the keystream
+	// is never reused
+	partPath := filepath.Join(tempDir, "test-bucket", ".uploads", initiated.UploadID, "part-2")
+	before, _ := os.ReadFile(partPath)
+	target := "/test-bucket/multi.bin?partNumber=2&uploadId=" + initiated.UploadID
+	if rr = serveSSE(handler.UploadPart, "PUT", target, vars, testSSEHeaders(sseHeaderPrefix, key), parts[1]); rr.Code != http.StatusOK {
+		t.Fatalf("UploadPart returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if after, _ := os.ReadFile(partPath); len(after) != len(parts[1]) || bytes.Equal(before, after) {
+		t.Error("Expected a part uploaded again to be encrypted differently")
+	}
+
+	complete := []byte(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber></Part><Part><PartNumber>2</PartNumber></Part></CompleteMultipartUpload>`)
+	rr = serveSSE(handler.CompleteMultipartUpload, "POST", "/test-bucket/multi.bin?uploadId="+initiated.UploadID, vars, nil, complete)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveSSE(handler.GetObject, "GET", "/test-bucket/multi.bin", vars, testSSEHeaders(sseHeaderPrefix, key), nil)
+	body, _ := io.ReadAll(rr.Body)
+	if !bytes.Equal(body, append(first, "second part"...)) {
+		t.Errorf("Unexpected multipart content of %d bytes", len(body))
+	}
+
+	info, _ := handler.storage.HeadObject("test-bucket", "multi.bin")
+	iv1, iv2 := info.Metadata[storage.PartMetadataName(metadataSSECIV, 1)], info.Metadata[storage.PartMetadataName(metadataSSECIV, 2)]
+	if len(iv1) != 32 || len(iv2) != 32 || iv1 == iv2 {
+		t.Errorf("Expected distinct IVs for the parts, got %q and %q", iv1, iv2)
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"locals3/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSTSRequestSize limits the form body of STS requests
+const maxSTSRequestSize = 64 * 1024
+
+var (
+	roleArnPattern     = regexp.MustCompile(`^arn:[\w-]+:iam::\d*:role/[\w+=,.@/-]+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+// stsError is an STS error, reported in the STS ErrorResponse format
+type stsError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e *stsError) Error() string {
+	return e.Message
+}
+
+// IsSTSRequest matches STS API calls: POST / with a form body, or a query
+// with an Action parameter
+func (h *Handler) IsSTSRequest(r *http.Request, rm *mux.RouteMatch) bool {
+	if r.URL.Path != "/" {
+		return false
+	}
+	if r.URL.Query().Get("Action") != "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && mediaType == "application/x-www-form-urlencoded"
+}
+
+// STS handles the STS actions AssumeRole, GetSessionToken and
+// AssumeRoleWithWebIdentity
+func (h *Handler) STS(w http.ResponseWriter, r *http.Request) {
+	if h.sts == nil {
+		h.writeSTSError(w, &stsError{"InvalidAction", "STS is not enabled on this server", http.StatusBadRequest})
+		return
+	}
+
+	// The body is read up front and put back for signature verification
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSTSRequestSize+1))
+	if err != nil || len(body) > maxSTSRequestSize {
+		h.writeSTSError(w, &stsError{"ValidationError", "Request body is too large or unreadable", http.StatusBadRequest})
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	params := r.URL.Query()
+	if r.Method == http.MethodPost {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			h.writeSTSError(w, &stsError{"ValidationError", "Request body is not a valid form", http.StatusBadRequest})
+			return
+		}
+		for name, values := range form {
+			params[name] = append(params[name], values...)
+		}
+	}
+
+	var response interface{}
+	switch params.Get("Action") {
+	case "GetSessionToken":
+		response, err = h.getSessionToken(r, params)
+	case "AssumeRole":
+		response, err = h.assumeRole(r, params)
+	case "AssumeRoleWithWebIdentity":
+		response, err = h.assumeRoleWithWebIdentity(params)
+	default:
+		err = &stsError{"InvalidAction", fmt.Sprintf("Could not find operation %s", params.Get("Action")), http.StatusBadRequest}
+	}
+	if err != nil {
+		h.writeSTSError(w, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) getSessionToken(r *http.Request, params url.Values) (interface{}, error) {
+	principal, err := h.stsCaller(r)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := stsDuration(params, 12*time.Hour, 36*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.sts.GetSessionToken(principal, duration)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionCaller) {
+			return nil, &stsError{"AccessDenied", "Cannot call GetSessionToken with session credentials", http.StatusForbidden}
+		}
+		return nil, err
+	}
+	return &GetSessionTokenResponse{
+		Result:           GetSessionTokenResult{Credentials: stsCredentials(session)},
+		ResponseMetadata: stsResponseMetadata(),
+	}, nil
+}
+
+func (h *Handler) assumeRole(r *http.Request, params url.Values) (interface{}, error) {
+	principal, err := h.stsCaller(r)
+	if err != nil {
+		return nil, err
+	}
+	roleArn, sessionName, err := stsRoleParams(params)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := stsDuration(params, time.Hour, 12*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	session := h.sts.AssumeRole(principal, roleArn, sessionName, duration)
+	return &AssumeRoleResponse{
+		Result: AssumeRoleResult{
+			Credentials:     stsCredentials(session),
+			AssumedRoleUser: assumedRoleUser(session, roleArn, sessionName),
+		},
+		ResponseMetadata: stsResponseMetadata(),
+	}, nil
+}
+
+func (h *Handler) assumeRoleWithWebIdentity(params url.Values) (interface{}, error) {
+	token := params.Get("WebIdentityToken")
+	if token == "" {
+		return nil, &stsError{"ValidationError", "Value null at 'webIdentityToken' failed to satisfy constraint: Member must not be null", http.StatusBadRequest}
+	}
+	roleArn, sessionName, err := stsRoleParams(params)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := stsDuration(params, time.Hour, 12*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	session, identity, err := h.sts.AssumeRoleWithWebIdentity(token, roleArn, sessionName, duration)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidJWT) {
+			return nil, &stsError{"InvalidIdentityToken", err.Error(), http.StatusBadRequest}
+		}
+		return nil, err
+	}
+
+	return &AssumeRoleWithWebIdentityResponse{
+		Result: AssumeRoleWithWebIdentityResult{
+			Credentials:                 stsCredentials(session),
+			SubjectFromWebIdentityToken: identity.Subject,
+			AssumedRoleUser:             assumedRoleUser(session, roleArn, sessionName),
+			Provider:                    identity.Issuer,
+			Audience:                    identity.Audience,
+		},
+		ResponseMetadata: stsResponseMetadata(),
+	}, nil
+}
+
+// stsCaller authenticates a signed STS request and returns its principal.
+// With authentication disabled the configured access key is the caller.
+func (h *Handler) stsCaller(r *http.Request) (*auth.Principal, error) {
+	*r = *r.WithContext(auth.WithSigningService(r.Context(), "sts"))
+	if !h.disableAuth && !isSignedRequest(r) {
+		return nil, &stsError{"MissingAuthenticationToken", "Request is missing Authentication Token", http.StatusForbidden}
+	}
+	if err := h.authenticate(r); err != nil {
+		apiErr, ok := authError(err).(*apiError)
+		if !ok {
+			return nil, &stsError{"SignatureDoesNotMatch", signatureMismatchMessage, http.StatusForbidden}
+		}
+		return nil, &stsError{apiErr.Code, apiErr.Message, apiErr.StatusCode}
+	}
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal, nil
+	}
+	if h.disableAuth {
+		return &auth.Principal{UserName: h.auth.GetAccessKey(), DisplayName: h.auth.GetAccessKey()}, nil
+	}
+	return nil, &stsError{"MissingAuthenticationToken", "Request is missing Authentication Token", http.StatusForbidden}
+}
+
+// stsRoleParams validates the RoleArn and RoleSessionName parameters
+func stsRoleParams(params url.Values) (string, string, error) {
+	roleArn := params.Get("RoleArn")
+	if !roleArnPattern.MatchString(roleArn) {
+		return "", "", &stsError{"ValidationError", fmt.Sprintf("%q is not a valid role ARN", roleArn), http.StatusBadRequest}
+	}
+	sessionName := params.Get("RoleSessionName")
+	if !sessionNamePattern.MatchString(sessionName) {
+		return "", "", &stsError{"ValidationError", "Value at 'roleSessionName' failed to satisfy constraint: Member must have length between 2 and 64 and match [\\w+=,.@-]*", http.StatusBadRequest}
+	}
+	return roleArn, sessionName, nil
+}
+
+// stsDuration reads the DurationSeconds parameter, which must lie between 15
+// minutes and max
+func stsDuration(params url.Values, defaultDuration, max time.Duration) (time.Duration, error) {
+	value := params.Get("DurationSeconds")
+	if value == "" {
+		return defaultDuration, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	duration := time.Duration(seconds) * time.Second
+	if err != nil || duration < 15*time.Minute || duration > max {
+		return 0, &stsError{"ValidationError", fmt.Sprintf("The requested DurationSeconds must be between 900 and %d seconds", int(max.Seconds())), http.StatusBadRequest}
+	}
+	return duration, nil
+}
+
+func stsCredentials(session *auth.Session) STSCredentials {
+	return STSCredentials{
+		AccessKeyID:     session.AccessKeyID,
+		SecretAccessKey: session.SecretAccessKey,
+		SessionToken:    session.SessionToken,
+		Expiration:      session.Expiration.Format(time.RFC3339),
+	}
+}
+
+func assumedRoleUser(session *auth.Session, roleArn, sessionName string) AssumedRoleUser {
+	return AssumedRoleUser{
+		AssumedRoleID: strings.Replace(session.AccessKeyID, "ASIA", "AROA", 1) + ":" + sessionName,
+		Arn:           auth.AssumedRoleARN(roleArn, sessionName),
+	}
+}
+
+func stsResponseMetadata() STSResponseMetadata {
+	return STSResponseMetadata{RequestID: fmt.Sprintf("%d", time.Now().UnixNano())}
+}
+
+// writeSTSError writes err in the STS error format. Errors that are not
+// stsErrors are reported as InternalFailure.
+func (h *Handler) writeSTSError(w http.ResponseWriter, err error) {
+	stsErr, ok := err.(*stsError)
+	if !ok {
+		stsErr = &stsError{"InternalFailure", err.Error(), http.StatusInternalServerError}
+	}
+
+	errorType := "Sender"
+	if stsErr.StatusCode >= 500 {
+		errorType = "Receiver"
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(stsErr.StatusCode)
+	xml.NewEncoder(w).Encode(&STSErrorResponse{
+		Error:     STSError{Type: errorType, Code: stsErr.Code, Message: stsErr.Message},
+		RequestID: fmt.Sprintf("%d", time.Now().UnixNano()),
+	})
+}
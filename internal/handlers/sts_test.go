@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"locals3/internal/auth"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+func newSTSTestHandler(t *testing.T, disableAuth bool) (*Handler, *auth.CredentialStore) {
+	tempDir := t.TempDir()
+	store := auth.NewCredentialStore()
+	store.CreateUser("alice", "Alice")
+	store.AddAccessKey("alice", "alice-key", "alice-secret")
+
+	return New(&Config{
+		Storage:     storage.NewFileSystemStorage(tempDir),
+		Auth:        auth.NewAWSV4AuthWithStore(store, "alice-key", "alice-secret", "us-east-1"),
+		Region:      "us-east-1",
+		BaseDomain:  "localhost",
+		DisableAuth: disableAuth,
+		STS:         auth.NewSTS(store, nil, "", "", nil),
+	}), store
+}
+
+func postSTS(h *Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	rr := httptest.NewRecorder()
+	h.STS(rr, req)
+	return rr
+}
+
+func decodeSTSError(t *testing.T, rr *httptest.ResponseRecorder) STSError {
+	t.Helper()
+	var resp STSErrorResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode STS error %q: %v", rr.Body.String(), err)
+	}
+	return resp.Error
+}
+
+func TestSTSGetSessionToken(t *testing.T) {
+	h, store := newSTSTestHandler(t, true)
+
+	rr := postSTS(h, url.Values{"Action": {"GetSessionToken"}, "Version": {"2011-06-15"}, "DurationSeconds": {"900"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp GetSessionTokenResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	creds := resp.Result.Credentials
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		t.Fatalf("Invalid expiration %q: %v", creds.Expiration, err)
+	}
+	if until := time.Until(expiration); until > 15*time.Minute || until < 14*time.Minute {
+		t.Errorf("Expected credentials valid for 15 minutes, got %v", until)
+	}
+
+	secret, principal, err := store.LookupSession(creds.AccessKeyID, creds.SessionToken)
+	if err != nil || secret != creds.SecretAccessKey {
+		t.Fatalf("Expected issued credentials to be usable, got %v", err)
+	}
+	if principal.UserName != "alice-key" {
+		t.Errorf("Expected the configured access key as caller, got %+v", principal)
+	}
+}
+
+func TestSTSGetSessionTokenWithSessionCredentials(t *testing.T) {
+	h, store := newSTSTestHandler(t, true)
+	session := store.AddSession(auth.Principal{UserName: "alice", AccessKey: "alice-key"}, time.Hour)
+	_, principal, _ := store.LookupSession(session.AccessKeyID, session.SessionToken)
+
+	form := url.Values{"Action": {"GetSessionToken"}}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+	rr := httptest.NewRecorder()
+	h.STS(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stsErr := decodeSTSError(t, rr); stsErr.Code != "AccessDenied" {
+		t.Errorf("Expected AccessDenied, got %+v", stsErr)
+	}
+}
+
+func TestSTSAssumeRole(t *testing.T) {
+	h, _ := newSTSTestHandler(t, true)
+
+	rr := postSTS(h, url.Values{
+		"Action":          {"AssumeRole"},
+		"RoleArn":         {"arn:aws:iam::123456789012:role/reader"},
+		"RoleSessionName": {"build-42"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp AssumeRoleResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Result.AssumedRoleUser.Arn != "arn:aws:sts::123456789012:assumed-role/reader/build-42" {
+		t.Errorf("Unexpected assumed role ARN %s", resp.Result.AssumedRoleUser.Arn)
+	}
+	if !strings.HasSuffix(resp.Result.AssumedRoleUser.AssumedRoleID, ":build-42") {
+		t.Errorf("Unexpected assumed role ID %s", resp.Result.AssumedRoleUser.AssumedRoleID)
+	}
+	if resp.Result.Credentials.SessionToken == "" {
+		t.Error("Expected a session token")
+	}
+}
+
+func TestSTSErrors(t *testing.T) {
+	h, _ := newSTSTestHandler(t, true)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		code   string
+	}{
+		{"unknown action", url.Values{"Action": {"GetCallerIdentity"}}, http.StatusBadRequest, "InvalidAction"},
+		{"missing role", url.Values{"Action": {"AssumeRole"}, "RoleSessionName": {"s1"}}, http.StatusBadRequest, "ValidationError"},
+		{"bad session name", url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"a b"}}, http.StatusBadRequest, "ValidationError"},
+		{"duration too short", url.Values{"Action": {"GetSessionToken"}, "DurationSeconds": {"60"}}, http.StatusBadRequest, "ValidationError"},
+		{"duration too long", url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"s1"}, "DurationSeconds": {"43201"}}, http.StatusBadRequest, "ValidationError"},
+		{"web identity without token", url.Values{"Action": {"AssumeRoleWithWebIdentity"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"s1"}}, http.StatusBadRequest, "ValidationError"},
+		{"web identity without JWKS", url.Values{"Action": {"AssumeRoleWithWebIdentity"}, "WebIdentityToken": {"e30.e30.AAAA"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"s1"}}, http.StatusBadRequest, "InvalidIdentityToken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postSTS(h, tt.form)
+			if rr.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rr.Code)
+			}
+			if stsErr := decodeSTSError(t, rr); stsErr.Code != tt.code || stsErr.Type != "Sender" {
+				t.Errorf("Expected Sender %s, got %+v", tt.code, stsErr)
+			}
+		})
+	}
+}
+
+func TestSTSRequiresSignedCaller(t *testing.T) {
+	h, _ := newSTSTestHandler(t, false)
+
+	rr := postSTS(h, url.Values{"Action": {"GetSessionToken"}})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rr.Code)
+	}
+	if stsErr := decodeSTSError(t, rr); stsErr.Code != "MissingAuthenticationToken" {
+		t.Errorf("Expected MissingAuthenticationToken, got %s", stsErr.Code)
+	}
+}
+
+func TestIsSTSRequest(t *testing.T) {
+	h, _ := newSTSTestHandler(t, true)
+
+	tests := []struct {
+		method      string
+		target      string
+		contentType string
+		want        bool
+	}{
+		{"POST", "/", "application/x-www-form-urlencoded; charset=utf-8", true},
+		{"GET", "/?Action=GetSessionToken", "", true},
+		{"GET", "/", "", false},
+		{"POST", "/bucket", "application/x-www-form-urlencoded", false},
+		{"POST", "/", "multipart/form-data; boundary=x", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := h.IsSTSRequest(req, &mux.RouteMatch{}); got != tt.want {
+			t.Errorf("%s %s (%s): expected %v, got %v", tt.method, tt.target, tt.contentType, tt.want, got)
+		}
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{auth.ErrExpiredToken, "ExpiredToken", http.StatusBadRequest},
+		{auth.ErrInvalidToken, "InvalidToken", http.StatusBadRequest},
+		{fmt.Errorf("%w; late", auth.ErrRequestTimeTooSkewed), "RequestTimeTooSkewed", http.StatusForbidden},
+		{fmt.Errorf("%w; the region 'x' is wrong", auth.ErrAuthorizationHeaderMalformed), "AuthorizationHeaderMalformed", http.StatusBadRequest},
+		{errors.New("signature mismatch"), "AccessDenied", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		apiErr, ok := authError(tt.err).(*apiError)
+		if !ok || apiErr.Code != tt.code || apiErr.StatusCode != tt.status {
+			t.Errorf("%v: expected %s/%d, got %+v", tt.err, tt.code, tt.status, apiErr)
+		}
+	}
+}
+
+func TestSTSDisabled(t *testing.T) {
+	h, tempDir := newSSETestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	rr := postSTS(h, url.Values{"Action": {"GetSessionToken"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without STS, got %d", rr.Code)
+	}
+}
@@ -2,15 +2,29 @@ package handlers
 
 import (
 	"encoding/xml"
+	"locals3/internal/s3select"
 	"locals3/internal/storage"
 )
 
 // S3Error represents an S3 error response
 type S3Error struct {
-	XMLName   xml.Name `xml:"Error"`
-	Code      string   `xml:"Code"`
-	Message   string   `xml:"Message"`
-	RequestID string   `xml:"RequestId"`
+	XMLName    xml.Name `xml:"Error"`
+	Code       string   `xml:"Code"`
+	Message    string   `xml:"Message"`
+	Resource   string   `xml:"Resource,omitempty"`
+	BucketName string   `xml:"BucketName,omitempty"`
+	Key        string   `xml:"Key,omitempty"`
+
+	// Set for SignatureDoesNotMatch
+	AWSAccessKeyID        string `xml:"AWSAccessKeyId,omitempty"`
+	StringToSign          string `xml:"StringToSign,omitempty"`
+	SignatureProvided     string `xml:"SignatureProvided,omitempty"`
+	StringToSignBytes     string `xml:"StringToSignBytes,omitempty"`
+	CanonicalRequest      string `xml:"CanonicalRequest,omitempty"`
+	CanonicalRequestBytes string `xml:"CanonicalRequestBytes,omitempty"`
+
+	RequestID string `xml:"RequestId"`
+	HostID    string `xml:"HostId"`
 }
 
 // ListAllMyBucketsResult represents the response for ListBuckets
@@ -37,6 +51,18 @@ type Bucket struct {
 	CreationDate string `xml:"CreationDate"`
 }
 
+// CreateBucketConfiguration is the optional body of CreateBucket
+type CreateBucketConfiguration struct {
+	XMLName            xml.Name `xml:"CreateBucketConfiguration"`
+	LocationConstraint string   `xml:"LocationConstraint"`
+}
+
+// LocationConstraint represents the response for GetBucketLocation
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Region  string   `xml:",chardata"`
+}
+
 // ListBucketResult represents the response for ListObjects
 type ListBucketResult struct {
 	XMLName        xml.Name       `xml:"ListBucketResult"`
@@ -87,3 +113,221 @@ type CompleteMultipartUploadResult struct {
 	Key      string   `xml:"Key"`
 	ETag     string   `xml:"ETag"`
 }
+
+// CopyObjectResult represents the response for CopyObject
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// ObjectLockConfiguration represents the request and response for Put/GetObjectLockConfiguration
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled,omitempty"`
+	Rule              *ObjectLockRule `xml:"Rule,omitempty"`
+}
+
+// ObjectLockRule represents the rule of an object lock configuration
+type ObjectLockRule struct {
+	DefaultRetention DefaultRetention `xml:"DefaultRetention"`
+}
+
+// DefaultRetention represents the default retention of an object lock rule
+type DefaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+// Retention represents the request and response for Put/GetObjectRetention
+type Retention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+// LegalHold represents the request and response for Put/GetObjectLegalHold
+type LegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+// SelectObjectContentRequest represents the request for SelectObjectContent
+type SelectObjectContentRequest struct {
+	XMLName             xml.Name                     `xml:"SelectObjectContentRequest"`
+	Expression          string                       `xml:"Expression"`
+	ExpressionType      string                       `xml:"ExpressionType"`
+	InputSerialization  s3select.InputSerialization  `xml:"InputSerialization"`
+	OutputSerialization s3select.OutputSerialization `xml:"OutputSerialization"`
+	RequestProgress     struct {
+		Enabled bool `xml:"Enabled"`
+	} `xml:"RequestProgress"`
+}
+
+// WebsiteConfiguration represents the request and response for Put/GetBucketWebsite
+type WebsiteConfiguration struct {
+	XMLName               xml.Name               `xml:"WebsiteConfiguration"`
+	IndexDocument         *IndexDocument         `xml:"IndexDocument,omitempty"`
+	ErrorDocument         *ErrorDocument         `xml:"ErrorDocument,omitempty"`
+	RedirectAllRequestsTo *RedirectAllRequestsTo `xml:"RedirectAllRequestsTo,omitempty"`
+	RoutingRules          []RoutingRule          `xml:"RoutingRules>RoutingRule,omitempty"`
+}
+
+// IndexDocument represents the index document of a website configuration
+type IndexDocument struct {
+	Suffix string `xml:"Suffix"`
+}
+
+// ErrorDocument represents the error document of a website configuration
+type ErrorDocument struct {
+	Key string `xml:"Key"`
+}
+
+// RedirectAllRequestsTo represents a website that redirects every request
+type RedirectAllRequestsTo struct {
+	HostName string `xml:"HostName"`
+	Protocol string `xml:"Protocol,omitempty"`
+}
+
+// RoutingRule represents a redirect rule of a website configuration
+type RoutingRule struct {
+	Condition *RoutingRuleCondition `xml:"Condition,omitempty"`
+	Redirect  RoutingRuleRedirect   `xml:"Redirect"`
+}
+
+// RoutingRuleCondition represents the condition of a routing rule
+type RoutingRuleCondition struct {
+	HttpErrorCodeReturnedEquals string `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+}
+
+// RoutingRuleRedirect represents the redirect of a routing rule
+type RoutingRuleRedirect struct {
+	HostName             string `xml:"HostName,omitempty"`
+	HttpRedirectCode     string `xml:"HttpRedirectCode,omitempty"`
+	Protocol             string `xml:"Protocol,omitempty"`
+	ReplaceKeyPrefixWith string `xml:"ReplaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string `xml:"ReplaceKeyWith,omitempty"`
+}
+
+// PostResponse represents the response for PostObject with success_action_status 201
+type PostResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// STSErrorResponse represents an STS error response
+type STSErrorResponse struct {
+	XMLName   xml.Name `xml:"https://sts.amazonaws.com/doc/2011-06-15/ ErrorResponse"`
+	Error     STSError `xml:"Error"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// STSError represents the error of an STS error response
+type STSError struct {
+	Type    string `xml:"Type"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// STSResponseMetadata represents the metadata of an STS response
+type STSResponseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+// STSCredentials represents temporary credentials issued by STS
+type STSCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+// AssumedRoleUser represents the role session of assumed role credentials
+type AssumedRoleUser struct {
+	AssumedRoleID string `xml:"AssumedRoleId"`
+	Arn           string `xml:"Arn"`
+}
+
+// GetSessionTokenResponse represents the response for GetSessionToken
+type GetSessionTokenResponse struct {
+	XMLName          xml.Name              `xml:"https://sts.amazonaws.com/doc/2011-06-15/ GetSessionTokenResponse"`
+	Result           GetSessionTokenResult `xml:"GetSessionTokenResult"`
+	ResponseMetadata STSResponseMetadata   `xml:"ResponseMetadata"`
+}
+
+// GetSessionTokenResult represents the result of GetSessionToken
+type GetSessionTokenResult struct {
+	Credentials STSCredentials `xml:"Credentials"`
+}
+
+// AssumeRoleResponse represents the response for AssumeRole
+type AssumeRoleResponse struct {
+	XMLName          xml.Name            `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleResponse"`
+	Result           AssumeRoleResult    `xml:"AssumeRoleResult"`
+	ResponseMetadata STSResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleResult represents the result of AssumeRole
+type AssumeRoleResult struct {
+	Credentials     STSCredentials  `xml:"Credentials"`
+	AssumedRoleUser AssumedRoleUser `xml:"AssumedRoleUser"`
+}
+
+// AssumeRoleWithWebIdentityResponse represents the response for AssumeRoleWithWebIdentity
+type AssumeRoleWithWebIdentityResponse struct {
+	XMLName          xml.Name                        `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleWithWebIdentityResponse"`
+	Result           AssumeRoleWithWebIdentityResult `xml:"AssumeRoleWithWebIdentityResult"`
+	ResponseMetadata STSResponseMetadata             `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleWithWebIdentityResult represents the result of AssumeRoleWithWebIdentity
+type AssumeRoleWithWebIdentityResult struct {
+	Credentials                 STSCredentials  `xml:"Credentials"`
+	SubjectFromWebIdentityToken string          `xml:"SubjectFromWebIdentityToken"`
+	AssumedRoleUser             AssumedRoleUser `xml:"AssumedRoleUser"`
+	Provider                    string          `xml:"Provider,omitempty"`
+	Audience                    string          `xml:"Audience,omitempty"`
+}
+
+// PublicAccessBlockConfiguration represents the Block Public Access setting of
+// a bucket
+type PublicAccessBlockConfiguration struct {
+	XMLName               xml.Name `xml:"PublicAccessBlockConfiguration"`
+	BlockPublicAcls       bool     `xml:"BlockPublicAcls"`
+	IgnorePublicAcls      bool     `xml:"IgnorePublicAcls"`
+	BlockPublicPolicy     bool     `xml:"BlockPublicPolicy"`
+	RestrictPublicBuckets bool     `xml:"RestrictPublicBuckets"`
+}
+
+// AccessControlPolicy represents the response for GetBucketAcl and
+// GetObjectAcl
+type AccessControlPolicy struct {
+	XMLName           xml.Name          `xml:"AccessControlPolicy"`
+	Owner             Owner             `xml:"Owner"`
+	AccessControlList AccessControlList `xml:"AccessControlList"`
+}
+
+// AccessControlList represents the grants of an ACL
+type AccessControlList struct {
+	Grants []Grant `xml:"Grant"`
+}
+
+// Grant represents a permission given to a grantee
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+// Grantee represents a user or group in a grant
+type Grantee struct {
+	XMLNS       string `xml:"xmlns:xsi,attr"`
+	Type        string `xml:"xsi:type,attr"`
+	ID          string `xml:"ID,omitempty"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+	URI         string `xml:"URI,omitempty"`
+}
@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"locals3/internal/auth"
+	"locals3/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// GetBucketWebsite handles GET /{bucket}?website
+func (h *Handler) GetBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	website, err := h.storage.GetBucketWebsite(bucket)
+	if err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+	if website == nil {
+		h.writeErrorResponse(w, r, "NoSuchWebsiteConfiguration", "The specified bucket does not have a website configuration", http.StatusNotFound)
+		return
+	}
+
+	response := &WebsiteConfiguration{}
+	if website.IndexDocument != "" {
+		response.IndexDocument = &IndexDocument{Suffix: website.IndexDocument}
+	}
+	if website.ErrorDocument != "" {
+		response.ErrorDocument = &ErrorDocument{Key: website.ErrorDocument}
+	}
+	if redirect := website.RedirectAllRequestsTo; redirect != nil {
+		response.RedirectAllRequestsTo = &RedirectAllRequestsTo{HostName: redirect.HostName, Protocol: redirect.Protocol}
+	}
+	for _, rule := range website.RoutingRules {
+		routingRule := RoutingRule{
+			Redirect: RoutingRuleRedirect{
+				HostName:             rule.Redirect.HostName,
+				HttpRedirectCode:     rule.Redirect.HTTPRedirectCode,
+				Protocol:             rule.Redirect.Protocol,
+				ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+				ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+			},
+		}
+		if rule.KeyPrefixEquals != "" || rule.HTTPErrorCodeReturnedEquals != "" {
+			routingRule.Condition = &RoutingRuleCondition{
+				HttpErrorCodeReturnedEquals: rule.HTTPErrorCodeReturnedEquals,
+				KeyPrefixEquals:             rule.KeyPrefixEquals,
+			}
+		}
+		response.RoutingRules = append(response.RoutingRules, routingRule)
+	}
+
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// PutBucketWebsite handles PUT /{bucket}?website
+func (h *Handler) PutBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	var request WebsiteConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeErrorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+
+	website := &storage.WebsiteConfiguration{}
+	if request.IndexDocument != nil {
+		website.IndexDocument = request.IndexDocument.Suffix
+	}
+	if request.ErrorDocument != nil {
+		website.ErrorDocument = request.ErrorDocument.Key
+	}
+	if redirect := request.RedirectAllRequestsTo; redirect != nil {
+		website.RedirectAllRequestsTo = &storage.WebsiteRedirect{HostName: redirect.HostName, Protocol: redirect.Protocol}
+	}
+	for _, rule := range request.RoutingRules {
+		routingRule := storage.RoutingRule{
+			Redirect: storage.WebsiteRedirect{
+				HostName:             rule.Redirect.HostName,
+				HTTPRedirectCode:     rule.Redirect.HttpRedirectCode,
+				Protocol:             rule.Redirect.Protocol,
+				ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+				ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+			},
+		}
+		if rule.Condition != nil {
+			routingRule.KeyPrefixEquals = rule.Condition.KeyPrefixEquals
+			routingRule.HTTPErrorCodeReturnedEquals = rule.Condition.HttpErrorCodeReturnedEquals
+		}
+		website.RoutingRules = append(website.RoutingRules, routingRule)
+	}
+
+	if err := website.Validate(); err != nil {
+		h.writeErrorResponse(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketWebsite(bucket, website); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketWebsite handles DELETE /{bucket}?website
+func (h *Handler) DeleteBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	if err := h.authenticate(r); err != nil {
+		h.writeAPIError(w, r, authError(err))
+		return
+	}
+
+	bucket := mux.Vars(r)["bucket"]
+
+	if !h.storage.BucketExists(bucket) {
+		h.writeAPIError(w, r, storage.ErrNoSuchBucket)
+		return
+	}
+
+	if err := h.storage.DeleteBucketWebsite(bucket); err != nil {
+		h.writeAPIError(w, r, err)
+		return
+	}
+
+	h.setS3Headers(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IsWebsiteRequest reports whether a request is addressed to the website
+// endpoint of a bucket, <bucket>.<website domain>. It can be used as a
+// mux.MatcherFunc.
+func (h *Handler) IsWebsiteRequest(r *http.Request, rm *mux.RouteMatch) bool {
+	_, ok := h.websiteBucketFromHost(r)
+	return ok
+}
+
+func (h *Handler) websiteBucketFromHost(r *http.Request) (string, bool) {
+	if h.websiteDomain == "" {
+		return "", false
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	bucket := strings.TrimSuffix(strings.ToLower(host), "."+strings.ToLower(h.websiteDomain))
+	if bucket == "" || len(bucket) == len(host) {
+		return "", false
+	}
+	return bucket, true
+}
+
+// websiteRequest is a request to a bucket's website endpoint
+type websiteRequest struct {
+	bucket string
+	key    string
+
+	// pathPrefix is prepended to keys in redirects. It is "/<bucket>" when
+	// the bucket is addressed by path rather than by host name.
+	pathPrefix string
+}
+
+// ServeWebsite serves GET and HEAD requests to a bucket's website endpoint.
+// Requests are not authenticated: a bucket with a website configuration is
+// published. The bucket is taken from the host name, or from the first path
+// segment on the dedicated website port.
+func (h *Handler) ServeWebsite(w http.ResponseWriter, r *http.Request) {
+	req := &websiteRequest{key: strings.TrimPrefix(r.URL.Path, "/")}
+	if bucket, ok := h.websiteBucketFromHost(r); ok {
+		req.bucket = bucket
+	} else {
+		req.bucket, req.key, _ = strings.Cut(req.key, "/")
+		req.pathPrefix = "/" + req.bucket
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.writeWebsiteError(w, r, req, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+		return
+	}
+
+	if req.bucket == "" || !h.storage.BucketExists(req.bucket) {
+		h.writeWebsiteError(w, r, req, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	website, err := h.storage.GetBucketWebsite(req.bucket)
+	if err != nil {
+		logrus.Errorf("Website request for bucket %s failed: %v", req.bucket, err)
+		h.writeWebsiteError(w, r, req, http.StatusInternalServerError, "InternalError", internalErrorMessage)
+		return
+	}
+	if website == nil {
+		h.writeWebsiteError(w, r, req, http.StatusNotFound, "NoSuchWebsiteConfiguration", "The specified bucket does not have a website configuration")
+		return
+	}
+
+	if redirect := website.RedirectAllRequestsTo; redirect != nil {
+		h.websiteRedirect(w, r, websiteLocation(r, redirect.Protocol, redirect.HostName, "/"+req.key), http.StatusMovedPermanently)
+		return
+	}
+
+	// Routing rules without an error code condition apply before the object
+	// is looked up
+	for _, rule := range website.RoutingRules {
+		if rule.HTTPErrorCodeReturnedEquals == "" && strings.HasPrefix(req.key, rule.KeyPrefixEquals) {
+			h.applyRoutingRule(w, r, req, &rule)
+			return
+		}
+	}
+
+	key := req.key
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += website.IndexDocument
+	}
+
+	reader, objInfo, err := h.storage.GetObject(req.bucket, key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNoSuchKey) {
+			logrus.Errorf("Website request for %s/%s failed: %v", req.bucket, key, err)
+			h.writeWebsiteError(w, r, req, http.StatusInternalServerError, "InternalError", internalErrorMessage)
+			return
+		}
+
+		// A directory-like key without the trailing slash redirects to the
+		// directory so relative links in its index document resolve
+		if key == req.key && h.storage.ObjectExists(req.bucket, key+"/"+website.IndexDocument) {
+			h.websiteRedirect(w, r, req.pathPrefix+"/"+key+"/", http.StatusFound)
+			return
+		}
+
+		h.websiteErrorDocument(w, r, req, website, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	defer reader.Close()
+
+	if isSSECEncrypted(objInfo.Metadata) || !h.websiteReadable(r, req.bucket, key) {
+		h.websiteErrorDocument(w, r, req, website, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+
+	if location := objInfo.Metadata[storage.MetadataWebsiteRedirectLocation]; location != "" {
+		if strings.HasPrefix(location, "/") {
+			location = req.pathPrefix + location
+		}
+		h.websiteRedirect(w, r, location, http.StatusMovedPermanently)
+		return
+	}
+
+	h.writeWebsiteObject(w, r, objInfo, reader, http.StatusOK)
+}
+
+// websiteReadable reports whether an anonymous GET of the object would be
+// allowed. Website endpoints don't take signatures, so they only serve
+// objects that are readable by everyone.
+func (h *Handler) websiteReadable(r *http.Request, bucket, key string) bool {
+	if h.disableAuth {
+		return true
+	}
+
+	read := r.Clone(auth.WithPrincipal(r.Context(), auth.AnonymousPrincipal()))
+	read.Method = http.MethodGet
+	read.URL = &url.URL{Path: "/" + bucket + "/" + key}
+	read = mux.SetURLVars(read, map[string]string{"bucket": bucket, "key": key})
+	return h.authorizeAnonymous(read) == nil && h.authorizeExternal(read) == nil
+}
+
+// websiteErrorDocument answers a failed website request, preferring a
+// matching routing rule, then the bucket's error document and finally the
+// default HTML error page
+func (h *Handler) websiteErrorDocument(w http.ResponseWriter, r *http.Request, req *websiteRequest, website *storage.WebsiteConfiguration, status int, code, message string) {
+	for _, rule := range website.RoutingRules {
+		if rule.HTTPErrorCodeReturnedEquals == strconv.Itoa(status) && strings.HasPrefix(req.key, rule.KeyPrefixEquals) {
+			h.applyRoutingRule(w, r, req, &rule)
+			return
+		}
+	}
+
+	if website.ErrorDocument != "" {
+		reader, objInfo, err := h.storage.GetObject(req.bucket, website.ErrorDocument)
+		if err == nil {
+			defer reader.Close()
+			if !isSSECEncrypted(objInfo.Metadata) && h.websiteReadable(r, req.bucket, website.ErrorDocument) {
+				h.writeWebsiteObject(w, r, objInfo, reader, status)
+				return
+			}
+		}
+	}
+
+	h.writeWebsiteError(w, r, req, status, code, message)
+}
+
+func (h *Handler) applyRoutingRule(w http.ResponseWriter, r *http.Request, req *websiteRequest, rule *storage.RoutingRule) {
+	redirect := rule.Redirect
+
+	key := req.key
+	switch {
+	case redirect.ReplaceKeyWith != "":
+		key = redirect.ReplaceKeyWith
+	case redirect.ReplaceKeyPrefixWith != "":
+		key = redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, rule.KeyPrefixEquals)
+	}
+
+	status := http.StatusMovedPermanently
+	if code, err := strconv.Atoi(redirect.HTTPRedirectCode); err == nil {
+		status = code
+	}
+
+	path := "/" + key
+	if redirect.HostName == "" {
+		path = req.pathPrefix + path
+	}
+	h.websiteRedirect(w, r, websiteLocation(r, redirect.Protocol, redirect.HostName, path), status)
+}
+
+// websiteLocation builds a redirect target. A missing protocol or host name
+// is taken from the request.
+func websiteLocation(r *http.Request, protocol, hostName, path string) string {
+	if protocol == "" && hostName == "" {
+		return path
+	}
+	if protocol == "" {
+		protocol = "http"
+		if r.TLS != nil {
+			protocol = "https"
+		}
+	}
+	if hostName == "" {
+		hostName = r.Host
+	}
+	return protocol + "://" + hostName + path
+}
+
+func (h *Handler) websiteRedirect(w http.ResponseWriter, r *http.Request, location string, status int) {
+	h.setS3Headers(w)
+	w.Header().Set("Location", location)
+	w.WriteHeader(status)
+}
+
+func (h *Handler) writeWebsiteObject(w http.ResponseWriter, r *http.Request, objInfo *storage.ObjectInfo, body io.Reader, status int) {
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", objInfo.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(objInfo.Size, 10))
+	w.Header().Set("ETag", objInfo.ETag)
+	w.Header().Set("Last-Modified", objInfo.LastModified.Format(http.TimeFormat))
+	w.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+}
+
+// writeWebsiteError writes the default HTML error page of the website
+// endpoint
+func (h *Handler) writeWebsiteError(w http.ResponseWriter, r *http.Request, req *websiteRequest, status int, code, message string) {
+	h.setS3Headers(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	fmt.Fprintf(w, "<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	fmt.Fprintf(w, "<li>Code: %s</li>\n<li>Message: %s</li>\n", html.EscapeString(code), html.EscapeString(message))
+	if req.bucket != "" && code == "NoSuchBucket" {
+		fmt.Fprintf(w, "<li>BucketName: %s</li>\n", html.EscapeString(req.bucket))
+	}
+	if code == "NoSuchKey" {
+		fmt.Fprintf(w, "<li>Key: %s</li>\n", html.EscapeString(req.key))
+	}
+	fmt.Fprintf(w, "<li>RequestId: %s</li>\n</ul>\n<hr/>\n</body>\n</html>\n", w.Header().Get("x-amz-request-id"))
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"locals3/internal/storage"
+)
+
+const testWebsiteConfiguration = `<WebsiteConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<IndexDocument><Suffix>index.html</Suffix></IndexDocument>
+	<ErrorDocument><Key>404.html</Key></ErrorDocument>
+	<RoutingRules>
+		<RoutingRule>
+			<Condition><KeyPrefixEquals>docs/</KeyPrefixEquals></Condition>
+			<Redirect><ReplaceKeyPrefixWith>documents/</ReplaceKeyPrefixWith></Redirect>
+		</RoutingRule>
+	</RoutingRules>
+</WebsiteConfiguration>`
+
+func newWebsiteTestHandler(t *testing.T) (*Handler, string) {
+	handler, tempDir := newSSETestHandler(t)
+	handler.websiteDomain = "s3-website.localhost"
+
+	vars := map[string]string{"bucket": "test-bucket"}
+	rr := serveSSE(handler.PutBucketWebsite, "PUT", "/test-bucket?website", vars, nil, []byte(testWebsiteConfiguration))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PutBucketWebsite returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	objects := map[string]string{
+		"index.html":      "<h1>home</h1>",
+		"app/index.html":  "<h1>app</h1>",
+		"404.html":        "<h1>not found</h1>",
+		"style.css":       "body {}",
+		"moved.html":      "",
+		"secret/key.html": "",
+	}
+	for key, content := range objects {
+		header := http.Header{}
+		if key == "moved.html" {
+			header.Set("X-Amz-Website-Redirect-Location", "/app/")
+		}
+		rr := serveSSE(handler.PutObject, "PUT", "/test-bucket/"+key, map[string]string{"bucket": "test-bucket", "key": key}, header, []byte(content))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("PutObject %s returned %d: %s", key, rr.Code, rr.Body.String())
+		}
+	}
+
+	return handler, tempDir
+}
+
+func serveWebsite(handler *Handler, method, host, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Host = host
+	rr := httptest.NewRecorder()
+	handler.ServeWebsite(rr, req)
+	return rr
+}
+
+func TestBucketWebsiteConfiguration(t *testing.T) {
+	handler, tempDir := newWebsiteTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	vars := map[string]string{"bucket": "test-bucket"}
+	rr := serveSSE(handler.GetBucketWebsite, "GET", "/test-bucket?website", vars, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetBucketWebsite returned %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, expected := range []string{"<Suffix>index.html</Suffix>", "<Key>404.html</Key>", "<KeyPrefixEquals>docs/</KeyPrefixEquals>"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected %s in %s", expected, rr.Body.String())
+		}
+	}
+
+	invalid := `<WebsiteConfiguration><ErrorDocument><Key>404.html</Key></ErrorDocument></WebsiteConfiguration>`
+	rr = serveSSE(handler.PutBucketWebsite, "PUT", "/test-bucket?website", vars, nil, []byte(invalid))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for missing index document, got %d", rr.Code)
+	}
+
+	rr = serveSSE(handler.DeleteBucketWebsite, "DELETE", "/test-bucket?website", vars, nil, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("DeleteBucketWebsite returned %d", rr.Code)
+	}
+	rr = serveSSE(handler.GetBucketWebsite, "GET", "/test-bucket?website", vars, nil, nil)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "NoSuchWebsiteConfiguration") {
+		t.Errorf("Expected NoSuchWebsiteConfiguration, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveWebsite(handler, "GET", "test-bucket.s3-website.localhost", "/")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "NoSuchWebsiteConfiguration") {
+		t.Errorf("Expected website to be disabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestServeWebsite(t *testing.T) {
+	handler, tempDir := newWebsiteTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	host := "test-bucket.s3-website.localhost:3000"
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"root index", host, "/", http.StatusOK, "<h1>home</h1>", ""},
+		{"directory index", host, "/app/", http.StatusOK, "<h1>app</h1>", ""},
+		{"directory without slash", host, "/app", http.StatusFound, "", "/app/"},
+		{"plain object", host, "/style.css", http.StatusOK, "body {}", ""},
+		{"error document", host, "/missing.html", http.StatusNotFound, "<h1>not found</h1>", ""},
+		{"object redirect", host, "/moved.html", http.StatusMovedPermanently, "", "/app/"},
+		{"routing rule", host, "/docs/guide.html", http.StatusMovedPermanently, "", "/documents/guide.html"},
+		{"path style", "localhost:3001", "/test-bucket/app/", http.StatusOK, "<h1>app</h1>", ""},
+		{"path style redirect", "localhost:3001", "/test-bucket/app", http.StatusFound, "", "/test-bucket/app/"},
+		{"missing bucket", "nothing.s3-website.localhost", "/", http.StatusNotFound, "NoSuchBucket", ""},
+	}
+
+	for _, tt := range tests {
+		rr := serveWebsite(handler, "GET", tt.host, tt.path)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+			continue
+		}
+		if tt.body != "" && !strings.Contains(rr.Body.String(), tt.body) {
+			t.Errorf("%s: expected body to contain %q, got %q", tt.name, tt.body, rr.Body.String())
+		}
+		if location := rr.Header().Get("Location"); location != tt.location {
+			t.Errorf("%s: expected location %q, got %q", tt.name, tt.location, location)
+		}
+	}
+
+	rr := serveWebsite(handler, "PUT", host, "/index.html")
+	if rr.Code != http.StatusMethodNotAllowed || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected HTML 405 error, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	rr = serveWebsite(handler, "HEAD", host, "/index.html")
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("Expected empty HEAD response, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+}
+
+func TestWebsiteAccess(t *testing.T) {
+	handler, tempDir := newWebsiteTestHandler(t)
+	defer os.RemoveAll(tempDir)
+	handler.disableAuth = false
+	host := "test-bucket.s3-website.localhost"
+
+	// Objects need to be readable anonymously, like on the REST endpoint
+	rr := serveWebsite(handler, "GET", host, "/style.css")
+	if rr.Code != http.StatusForbidden || strings.Contains(rr.Body.String(), "body {}") || strings.Contains(rr.Body.String(), "not found") {
+		t.Errorf("Expected 403 for a private object, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	handler.storage.PutObjectACL("test-bucket", "style.css", storage.ACLPublicRead)
+	if rr := serveWebsite(handler, "GET", host, "/style.css"); rr.Code != http.StatusOK || rr.Body.String() != "body {}" {
+		t.Errorf("Expected public-read object to be served, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	handler.storage.PutBucketPolicy("test-bucket", `{"Statement": [
+		{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/style.css"}
+	]}`)
+	if rr := serveWebsite(handler, "GET", host, "/"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "home") {
+		t.Errorf("Expected bucket policy to allow the index document, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveWebsite(handler, "GET", host, "/style.css"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected an explicit deny to apply, got %d", rr.Code)
+	}
+}
+
+func TestIsWebsiteRequest(t *testing.T) {
+	handler := New(&Config{Storage: NewMockStorage(), Auth: NewMockAuth(), WebsiteDomain: "s3-website.localhost"})
+
+	tests := map[string]bool{
+		"bucket.s3-website.localhost":      true,
+		"bucket.s3-website.localhost:3000": true,
+		"s3-website.localhost":             false,
+		"bucket.localhost":                 false,
+	}
+	for host, expected := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		if handler.IsWebsiteRequest(req, nil) != expected {
+			t.Errorf("Expected IsWebsiteRequest(%s) to be %t", host, expected)
+		}
+	}
+}
+
+func TestWebsiteRedirectLocationMetadata(t *testing.T) {
+	handler, tempDir := newWebsiteTestHandler(t)
+	defer os.RemoveAll(tempDir)
+
+	vars := map[string]string{"bucket": "test-bucket", "key": "moved.html"}
+	rr := serveSSE(handler.HeadObject, "HEAD", "/test-bucket/moved.html", vars, nil, nil)
+	if rr.Header().Get(storage.MetadataWebsiteRedirectLocation) != "/app/" {
+		t.Errorf("Expected redirect location header on HEAD, got %v", rr.Header())
+	}
+
+	// SSE-C objects can't be decrypted by anonymous website requests
+	key := bytes.Repeat([]byte{0x07}, 32)
+	vars = map[string]string{"bucket": "test-bucket", "key": "secret/key.html"}
+	rr = serveSSE(handler.PutObject, "PUT", "/test-bucket/secret/key.html", vars, testSSEHeaders(sseHeaderPrefix, key), []byte("hidden"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PutObject returned %d", rr.Code)
+	}
+	rr = serveWebsite(handler, "GET", "test-bucket.s3-website.localhost", "/secret/key.html")
+	if rr.Code != http.StatusForbidden || strings.Contains(rr.Body.String(), "hidden") {
+		t.Errorf("Expected 403 for encrypted object, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
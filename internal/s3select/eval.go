@@ -0,0 +1,803 @@
+package s3select
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Values are represented as nil (NULL or MISSING), string, int64, float64,
+// bool, time.Time, *object or []interface{}
+
+// object is a JSON object or CSV record that keeps its field order
+type object struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o *object) get(name string, caseSensitive bool) (interface{}, bool) {
+	for i, key := range o.keys {
+		if key == name {
+			return o.values[i], true
+		}
+	}
+	if !caseSensitive {
+		for i, key := range o.keys {
+			if strings.EqualFold(key, name) {
+				return o.values[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// record is one input row
+type record struct {
+	obj *object
+
+	// positional records (CSV) also answer to _1, _2, ...
+	positional bool
+}
+
+var castTypes = map[string]bool{
+	"INT": true, "INTEGER": true, "BIGINT": true,
+	"FLOAT": true, "REAL": true, "DOUBLE": true, "DECIMAL": true, "NUMERIC": true,
+	"STRING": true, "VARCHAR": true, "CHAR": true,
+	"BOOL": true, "BOOLEAN": true,
+	"TIMESTAMP": true,
+}
+
+var aggregateFunctions = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+}
+
+var scalarFunctions = map[string]bool{
+	"LOWER": true, "UPPER": true, "TRIM": true, "CHAR_LENGTH": true, "CHARACTER_LENGTH": true,
+	"COALESCE": true, "NULLIF": true,
+}
+
+type expr interface {
+	eval(rec *record) (interface{}, error)
+}
+
+// children returns the expressions directly nested in e
+func children(e expr) []expr {
+	switch n := e.(type) {
+	case *unaryExpr:
+		return []expr{n.operand}
+	case *binaryExpr:
+		return []expr{n.left, n.right}
+	case *likeExpr:
+		if n.escape != nil {
+			return []expr{n.operand, n.pattern, n.escape}
+		}
+		return []expr{n.operand, n.pattern}
+	case *isNullExpr:
+		return []expr{n.operand}
+	case *betweenExpr:
+		return []expr{n.operand, n.low, n.high}
+	case *inExpr:
+		return append([]expr{n.operand}, n.list...)
+	case *castExpr:
+		return []expr{n.operand}
+	case *funcExpr:
+		return n.args
+	case *aggregateExpr:
+		if n.arg != nil {
+			return []expr{n.arg}
+		}
+	}
+	return nil
+}
+
+// walk calls fn for e and every expression nested in it
+func walk(e expr, fn func(expr)) {
+	fn(e)
+	for _, child := range children(e) {
+		walk(child, fn)
+	}
+}
+
+func aggregatesOf(e expr) []*aggregateExpr {
+	var aggregates []*aggregateExpr
+	walk(e, func(n expr) {
+		if agg, ok := n.(*aggregateExpr); ok {
+			aggregates = append(aggregates, agg)
+		}
+	})
+	return aggregates
+}
+
+// columnOutsideAggregate reports whether e reads a column other than
+// through an aggregate function
+func columnOutsideAggregate(e expr) bool {
+	switch e.(type) {
+	case *columnRef:
+		return true
+	case *aggregateExpr:
+		return false
+	}
+
+	for _, child := range children(e) {
+		if columnOutsideAggregate(child) {
+			return true
+		}
+	}
+	return false
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l *literal) eval(rec *record) (interface{}, error) {
+	return l.value, nil
+}
+
+type pathElement struct {
+	name    string
+	quoted  bool
+	index   int
+	isIndex bool
+}
+
+type columnRef struct {
+	path []pathElement
+}
+
+func (c *columnRef) eval(rec *record) (interface{}, error) {
+	if rec == nil {
+		return nil, nil
+	}
+
+	var current interface{} = rec.obj
+	for i, element := range c.path {
+		switch v := current.(type) {
+		case *object:
+			if element.isIndex {
+				return nil, nil
+			}
+			value, ok := v.get(element.name, element.quoted)
+			if !ok && i == 0 && rec.positional {
+				value, ok = positionalField(v, element.name)
+			}
+			if !ok {
+				return nil, nil
+			}
+			current = value
+		case []interface{}:
+			if !element.isIndex || element.index >= len(v) {
+				return nil, nil
+			}
+			current = v[element.index]
+		default:
+			return nil, nil
+		}
+	}
+	return current, nil
+}
+
+// positionalField resolves _N column names against a record's fields
+func positionalField(obj *object, name string) (interface{}, bool) {
+	if !strings.HasPrefix(name, "_") {
+		return nil, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n < 1 || n > len(obj.values) {
+		return nil, false
+	}
+	return obj.values[n-1], true
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+func (u *unaryExpr) eval(rec *record) (interface{}, error) {
+	v, err := u.operand.eval(rec)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	if u.op == "NOT" {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errorf("EvaluatorInvalidArguments", "NOT requires a boolean operand")
+		}
+		return !b, nil
+	}
+
+	switch n := toNumber(v).(type) {
+	case int64:
+		return -n, nil
+	case float64:
+		return -n, nil
+	}
+	return nil, errorf("EvaluatorInvalidArguments", "Cannot negate a non-numeric value")
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (b *binaryExpr) eval(rec *record) (interface{}, error) {
+	left, err := b.left.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	// AND and OR use three-valued logic and short-circuit
+	switch b.op {
+	case "AND":
+		if left == false {
+			return false, nil
+		}
+		right, err := b.right.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		if right == false {
+			return false, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return left == true && right == true, nil
+	case "OR":
+		if left == true {
+			return true, nil
+		}
+		right, err := b.right.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		if right == true {
+			return true, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+
+	right, err := b.right.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch b.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareOp(b.op, left, right), nil
+	case "||":
+		return formatValue(left) + formatValue(right), nil
+	}
+	return arithmetic(b.op, left, right)
+}
+
+func compareOp(op string, left, right interface{}) bool {
+	cmp, ok := compareValues(left, right)
+	if !ok {
+		return op == "!="
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// compareValues orders two non-null values. Strings are compared to numbers
+// and timestamps by parsing them, since CSV fields are always strings.
+func compareValues(a, b interface{}) (int, bool) {
+	if isNumber(a) || isNumber(b) {
+		x, y := toNumber(a), toNumber(b)
+		if x == nil || y == nil {
+			return 0, false
+		}
+		if xi, ok := x.(int64); ok {
+			if yi, ok := y.(int64); ok {
+				return compareOrdered(xi, yi), true
+			}
+		}
+		return compareOrdered(toFloat(x), toFloat(y)), true
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := toTime(b)
+		if !ok {
+			return 0, false
+		}
+		return ta.Compare(tb), true
+	}
+	if tb, ok := b.(time.Time); ok {
+		ta, ok := toTime(a)
+		if !ok {
+			return 0, false
+		}
+		return ta.Compare(tb), true
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	x, y := toNumber(left), toNumber(right)
+	if x == nil || y == nil {
+		return nil, errorf("EvaluatorInvalidArguments", "Arithmetic requires numeric operands")
+	}
+
+	xi, xInt := x.(int64)
+	yi, yInt := y.(int64)
+	if xInt && yInt {
+		switch op {
+		case "+":
+			return xi + yi, nil
+		case "-":
+			return xi - yi, nil
+		case "*":
+			return xi * yi, nil
+		case "/", "%":
+			if yi == 0 {
+				return nil, errorf("EvaluatorDivisionByZero", "Division by zero")
+			}
+			if op == "/" {
+				return xi / yi, nil
+			}
+			return xi % yi, nil
+		}
+	}
+
+	xf, yf := toFloat(x), toFloat(y)
+	switch op {
+	case "+":
+		return xf + yf, nil
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	}
+	if yf == 0 {
+		return nil, errorf("EvaluatorDivisionByZero", "Division by zero")
+	}
+	if op == "/" {
+		return xf / yf, nil
+	}
+	return math.Mod(xf, yf), nil
+}
+
+type likeExpr struct {
+	operand, pattern, escape expr
+	not                      bool
+
+	// the compiled pattern is cached while the pattern text doesn't change
+	cachedPattern string
+	cachedEscape  string
+	cachedRegexp  *regexp.Regexp
+}
+
+func (l *likeExpr) eval(rec *record) (interface{}, error) {
+	v, err := l.operand.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	p, err := l.pattern.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || p == nil {
+		return nil, nil
+	}
+
+	escape := ""
+	if l.escape != nil {
+		e, err := l.escape.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		escape = formatValue(e)
+		if utf8.RuneCountInString(escape) > 1 {
+			return nil, errorf("EvaluatorInvalidArguments", "ESCAPE must be a single character")
+		}
+	}
+
+	pattern := formatValue(p)
+	if l.cachedRegexp == nil || l.cachedPattern != pattern || l.cachedEscape != escape {
+		re, err := likeRegexp(pattern, escape)
+		if err != nil {
+			return nil, err
+		}
+		l.cachedPattern, l.cachedEscape, l.cachedRegexp = pattern, escape, re
+	}
+
+	return l.cachedRegexp.MatchString(formatValue(v)) != l.not, nil
+}
+
+// likeRegexp translates a LIKE pattern, where % matches any sequence and _
+// any single character, into an anchored regular expression
+func likeRegexp(pattern, escape string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != "" && string(r) == escape:
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+		case r == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		return nil, errorf("EvaluatorInvalidArguments", "LIKE pattern ends with the escape character")
+	}
+
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+type isNullExpr struct {
+	operand expr
+	not     bool
+}
+
+func (n *isNullExpr) eval(rec *record) (interface{}, error) {
+	v, err := n.operand.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != n.not, nil
+}
+
+type betweenExpr struct {
+	operand, low, high expr
+	not                bool
+}
+
+func (b *betweenExpr) eval(rec *record) (interface{}, error) {
+	v, err := b.operand.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	low, err := b.low.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	high, err := b.high.eval(rec)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || low == nil || high == nil {
+		return nil, nil
+	}
+
+	return (compareOp(">=", v, low) && compareOp("<=", v, high)) != b.not, nil
+}
+
+type inExpr struct {
+	operand expr
+	list    []expr
+	not     bool
+}
+
+func (in *inExpr) eval(rec *record) (interface{}, error) {
+	v, err := in.operand.eval(rec)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	for _, item := range in.list {
+		candidate, err := item.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		if candidate != nil && compareOp("=", v, candidate) {
+			return !in.not, nil
+		}
+	}
+	return in.not, nil
+}
+
+type castExpr struct {
+	operand  expr
+	typeName string
+}
+
+func (c *castExpr) eval(rec *record) (interface{}, error) {
+	v, err := c.operand.eval(rec)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	switch c.typeName {
+	case "INT", "INTEGER", "BIGINT":
+		switch n := toNumber(v).(type) {
+		case int64:
+			return n, nil
+		case float64:
+			return int64(n), nil
+		}
+	case "FLOAT", "REAL", "DOUBLE", "DECIMAL", "NUMERIC":
+		if n := toNumber(v); n != nil {
+			return toFloat(n), nil
+		}
+	case "STRING", "VARCHAR", "CHAR":
+		return formatValue(v), nil
+	case "BOOL", "BOOLEAN":
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+				return parsed, nil
+			}
+		case int64:
+			return b != 0, nil
+		}
+	case "TIMESTAMP":
+		if t, ok := toTime(v); ok {
+			return t, nil
+		}
+	}
+
+	return nil, errorf("CastFailed", "Attempt to convert from one data type to another using CAST failed in the SQL expression.")
+}
+
+type funcExpr struct {
+	name string
+	args []expr
+}
+
+func (f *funcExpr) checkArity() error {
+	want := 1
+	switch f.name {
+	case "COALESCE":
+		if len(f.args) == 0 {
+			return errorf("IncorrectSqlFunctionArgumentType", "COALESCE requires at least one argument")
+		}
+		return nil
+	case "NULLIF":
+		want = 2
+	}
+	if len(f.args) != want {
+		return errorf("IncorrectSqlFunctionArgumentType", "%s takes %d argument(s)", f.name, want)
+	}
+	return nil
+}
+
+func (f *funcExpr) eval(rec *record) (interface{}, error) {
+	args := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		v, err := arg.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch f.name {
+	case "COALESCE":
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "NULLIF":
+		if args[0] != nil && args[1] != nil && compareOp("=", args[0], args[1]) {
+			return nil, nil
+		}
+		return args[0], nil
+	}
+
+	if args[0] == nil {
+		return nil, nil
+	}
+	s := formatValue(args[0])
+	switch f.name {
+	case "LOWER":
+		return strings.ToLower(s), nil
+	case "UPPER":
+		return strings.ToUpper(s), nil
+	case "TRIM":
+		return strings.TrimSpace(s), nil
+	}
+	return int64(utf8.RuneCountInString(s)), nil
+}
+
+// aggregateExpr accumulates over all matching records. Evaluating it yields
+// the result over the records seen so far.
+type aggregateExpr struct {
+	name string
+	arg  expr
+
+	count    int64
+	sumInt   int64
+	sumFloat float64
+	isFloat  bool
+	extreme  interface{}
+}
+
+func (a *aggregateExpr) accumulate(rec *record) error {
+	if a.arg == nil {
+		a.count++
+		return nil
+	}
+
+	v, err := a.arg.eval(rec)
+	if err != nil || v == nil {
+		return err
+	}
+
+	switch a.name {
+	case "COUNT":
+		a.count++
+	case "SUM", "AVG":
+		n := toNumber(v)
+		if n == nil {
+			return errorf("EvaluatorInvalidArguments", "%s requires numeric values", a.name)
+		}
+		a.count++
+		if i, ok := n.(int64); ok && !a.isFloat {
+			a.sumInt += i
+		} else {
+			if !a.isFloat {
+				a.sumFloat = float64(a.sumInt)
+				a.isFloat = true
+			}
+			a.sumFloat += toFloat(n)
+		}
+	case "MIN", "MAX":
+		if n := toNumber(v); n != nil {
+			v = n
+		}
+		a.count++
+		if a.extreme == nil {
+			a.extreme = v
+			return nil
+		}
+		cmp, ok := compareValues(v, a.extreme)
+		if ok && ((a.name == "MIN" && cmp < 0) || (a.name == "MAX" && cmp > 0)) {
+			a.extreme = v
+		}
+	}
+	return nil
+}
+
+func (a *aggregateExpr) eval(rec *record) (interface{}, error) {
+	switch a.name {
+	case "COUNT":
+		return a.count, nil
+	case "SUM":
+		if a.count == 0 {
+			return nil, nil
+		}
+		if a.isFloat {
+			return a.sumFloat, nil
+		}
+		return a.sumInt, nil
+	case "AVG":
+		if a.count == 0 {
+			return nil, nil
+		}
+		if a.isFloat {
+			return a.sumFloat / float64(a.count), nil
+		}
+		return float64(a.sumInt) / float64(a.count), nil
+	}
+	return a.extreme, nil
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// toNumber converts a value to int64 or float64, or returns nil
+func toNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int64, float64:
+		return n
+	case string:
+		s := strings.TrimSpace(n)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02"} {
+			if parsed, err := time.Parse(layout, strings.TrimSpace(t)); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// formatValue renders a value as text, as it appears in CSV output
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return string(appendJSON(nil, v))
+}
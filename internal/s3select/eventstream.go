@@ -0,0 +1,113 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"io"
+)
+
+// headerTypeString is the event stream header value type for strings
+const headerTypeString = 7
+
+// stats is the payload of the Stats and Progress events
+type stats struct {
+	XMLName        xml.Name
+	BytesScanned   int64 `xml:"BytesScanned"`
+	BytesProcessed int64 `xml:"BytesProcessed"`
+	BytesReturned  int64 `xml:"BytesReturned"`
+}
+
+// eventWriter writes messages in the AWS event stream encoding: a prelude
+// with the total and header lengths and its CRC, the headers, the payload
+// and a CRC of the whole message
+type eventWriter struct {
+	w io.Writer
+}
+
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{w: w}
+}
+
+func (e *eventWriter) writeRecords(payload []byte) error {
+	return e.writeMessage([][2]string{
+		{":event-type", "Records"},
+		{":content-type", "application/octet-stream"},
+		{":message-type", "event"},
+	}, payload)
+}
+
+func (e *eventWriter) writeStats(s *stats) error {
+	return e.writeStatsEvent("Stats", s)
+}
+
+func (e *eventWriter) writeProgress(s *stats) error {
+	return e.writeStatsEvent("Progress", s)
+}
+
+func (e *eventWriter) writeStatsEvent(eventType string, s *stats) error {
+	event := *s
+	event.XMLName = xml.Name{Local: eventType}
+	payload, err := xml.Marshal(&event)
+	if err != nil {
+		return err
+	}
+
+	return e.writeMessage([][2]string{
+		{":event-type", eventType},
+		{":content-type", "text/xml"},
+		{":message-type", "event"},
+	}, payload)
+}
+
+func (e *eventWriter) writeEnd() error {
+	return e.writeMessage([][2]string{
+		{":event-type", "End"},
+		{":message-type", "event"},
+	}, nil)
+}
+
+// writeError reports a failure that happened after streaming had started
+func (e *eventWriter) writeError(err error) error {
+	code := "InternalError"
+	if selectErr, ok := err.(*Error); ok {
+		code = selectErr.Code
+	}
+
+	return e.writeMessage([][2]string{
+		{":error-code", code},
+		{":error-message", err.Error()},
+		{":message-type", "error"},
+	}, nil)
+}
+
+func (e *eventWriter) writeMessage(headers [][2]string, payload []byte) error {
+	var encodedHeaders bytes.Buffer
+	for _, header := range headers {
+		encodedHeaders.WriteByte(byte(len(header[0])))
+		encodedHeaders.WriteString(header[0])
+		encodedHeaders.WriteByte(headerTypeString)
+		binary.Write(&encodedHeaders, binary.BigEndian, uint16(len(header[1])))
+		encodedHeaders.WriteString(header[1])
+	}
+
+	totalLength := 4 + 4 + 4 + encodedHeaders.Len() + len(payload) + 4
+
+	var message bytes.Buffer
+	binary.Write(&message, binary.BigEndian, uint32(totalLength))
+	binary.Write(&message, binary.BigEndian, uint32(encodedHeaders.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(encodedHeaders.Bytes())
+	message.Write(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+
+	if _, err := e.w.Write(message.Bytes()); err != nil {
+		return err
+	}
+
+	if flusher, ok := e.w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
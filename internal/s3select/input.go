@@ -0,0 +1,337 @@
+package s3select
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// recordReader returns input records one at a time, and io.EOF once the
+// input is exhausted
+type recordReader interface {
+	Read() (*record, error)
+}
+
+func newRecordReader(input *InputSerialization, r io.Reader, documentArray bool) (recordReader, error) {
+	if input.JSON != nil {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return &jsonReader{dec: dec, documentArray: documentArray}, nil
+	}
+	return newCSVReader(input.CSV, r)
+}
+
+// singleRune returns the only character of a delimiter setting, or def when
+// the setting is empty
+func singleRune(name, value string, def rune) (rune, error) {
+	if value == "" {
+		return def, nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, errorf("InvalidRequest", "%s must be a single character", name)
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// csvReader is a streaming CSV parser supporting the configurable
+// delimiters, quoting and comment character of S3 Select. encoding/csv
+// can't be used as it only allows "\n" records and '"' quotes.
+type csvReader struct {
+	r *bufio.Reader
+
+	fieldDelimiter  rune
+	recordDelimiter rune
+	quote           rune
+	quoteEscape     rune
+	comment         rune
+	hasComment      bool
+
+	header     []string
+	headerInfo string
+	started    bool
+}
+
+func newCSVReader(input *CSVInput, r io.Reader) (*csvReader, error) {
+	if input == nil {
+		input = &CSVInput{}
+	}
+	c := &csvReader{
+		r:          bufio.NewReader(r),
+		headerInfo: strings.ToUpper(input.FileHeaderInfo),
+	}
+
+	var err error
+	if c.fieldDelimiter, err = singleRune("FieldDelimiter", input.FieldDelimiter, ','); err != nil {
+		return nil, err
+	}
+	// "\r\n" records are read as "\n" records with the "\r" stripped
+	recordDelimiter := input.RecordDelimiter
+	if recordDelimiter == "\r\n" {
+		recordDelimiter = "\n"
+	}
+	if c.recordDelimiter, err = singleRune("RecordDelimiter", recordDelimiter, '\n'); err != nil {
+		return nil, err
+	}
+	if c.quote, err = singleRune("QuoteCharacter", input.QuoteCharacter, '"'); err != nil {
+		return nil, err
+	}
+	if c.quoteEscape, err = singleRune("QuoteEscapeCharacter", input.QuoteEscapeCharacter, c.quote); err != nil {
+		return nil, err
+	}
+	if input.Comments != "" {
+		if c.comment, err = singleRune("Comments", input.Comments, 0); err != nil {
+			return nil, err
+		}
+		c.hasComment = true
+	}
+	return c, nil
+}
+
+func (c *csvReader) Read() (*record, error) {
+	if !c.started {
+		c.started = true
+		switch c.headerInfo {
+		case "USE":
+			header, err := c.readFields()
+			if err != nil {
+				return nil, err
+			}
+			c.header = header
+		case "IGNORE":
+			if _, err := c.readFields(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fields, err := c.readFields()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &object{keys: make([]string, len(fields)), values: make([]interface{}, len(fields))}
+	for i, field := range fields {
+		if i < len(c.header) {
+			obj.keys[i] = c.header[i]
+		} else {
+			obj.keys[i] = "_" + strconv.Itoa(i+1)
+		}
+		obj.values[i] = field
+	}
+	return &record{obj: obj, positional: true}, nil
+}
+
+// readFields reads the next non-comment record
+func (c *csvReader) readFields() ([]string, error) {
+	for {
+		first, _, err := c.r.ReadRune()
+		if err != nil {
+			return nil, c.readError(err)
+		}
+		if c.hasComment && first == c.comment {
+			if err := c.skipRecord(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c.r.UnreadRune()
+		return c.readRecord()
+	}
+}
+
+func (c *csvReader) skipRecord() error {
+	for {
+		r, _, err := c.r.ReadRune()
+		if err == io.EOF || r == c.recordDelimiter {
+			return nil
+		}
+		if err != nil {
+			return c.readError(err)
+		}
+	}
+}
+
+func (c *csvReader) readRecord() ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes := false
+	quoted := false
+
+	for {
+		r, _, err := c.r.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errorf("CSVParsingError", "Encountered an unterminated quoted field")
+			}
+			return append(fields, field.String()), nil
+		}
+		if err != nil {
+			return nil, c.readError(err)
+		}
+
+		if inQuotes {
+			switch {
+			case r == c.quoteEscape && c.quoteEscape != c.quote:
+				escaped, _, err := c.r.ReadRune()
+				if err != nil {
+					return nil, errorf("CSVParsingError", "Encountered an unterminated quoted field")
+				}
+				field.WriteRune(escaped)
+			case r == c.quote:
+				next, _, err := c.r.ReadRune()
+				if err == nil && next == c.quote {
+					field.WriteRune(c.quote)
+					continue
+				}
+				if err == nil {
+					c.r.UnreadRune()
+				}
+				inQuotes = false
+			default:
+				field.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == c.quote && field.Len() == 0 && !quoted:
+			inQuotes = true
+			quoted = true
+		case r == c.fieldDelimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+			quoted = false
+		case r == c.recordDelimiter:
+			return append(fields, field.String()), nil
+		case r == '\r' && c.recordDelimiter == '\n':
+			next, _, err := c.r.ReadRune()
+			if err == nil {
+				c.r.UnreadRune()
+			}
+			if next != '\n' {
+				field.WriteRune(r)
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+}
+
+func (c *csvReader) readError(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return errorf("InternalError", "Failed to read object: %v", err)
+}
+
+// jsonReader reads a stream of JSON values, keeping the field order of
+// objects
+type jsonReader struct {
+	dec           *json.Decoder
+	documentArray bool
+	pending       []interface{}
+}
+
+func (j *jsonReader) Read() (*record, error) {
+	for len(j.pending) == 0 {
+		value, err := decodeValue(j.dec)
+		if err != nil {
+			return nil, err
+		}
+		if array, ok := value.([]interface{}); ok && j.documentArray {
+			j.pending = array
+			continue
+		}
+		j.pending = []interface{}{value}
+	}
+
+	value := j.pending[0]
+	j.pending = j.pending[1:]
+
+	obj, ok := value.(*object)
+	if !ok {
+		obj = &object{keys: []string{"_1"}, values: []interface{}{value}}
+	}
+	return &record{obj: obj}, nil
+}
+
+// decodeValue reads one JSON value with objects decoded as *object and
+// numbers as int64 or float64
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, jsonError(err)
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := &object{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, jsonError(err)
+				}
+				key, ok := keyTok.(string)
+				if !ok {
+					return nil, errorf("JSONParsingError", "Expected an object key")
+				}
+				value, err := decodeValue(dec)
+				if err != nil {
+					return nil, jsonError(err)
+				}
+				obj.keys = append(obj.keys, key)
+				obj.values = append(obj.values, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, jsonError(err)
+			}
+			return obj, nil
+		case '[':
+			array := []interface{}{}
+			for dec.More() {
+				value, err := decodeValue(dec)
+				if err != nil {
+					return nil, jsonError(err)
+				}
+				array = append(array, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, jsonError(err)
+			}
+			return array, nil
+		}
+		return nil, errorf("JSONParsingError", "Unexpected %q", t.String())
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, errorf("JSONParsingError", "Invalid number %s", t)
+		}
+		return f, nil
+	}
+	return tok, nil
+}
+
+func jsonError(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errorf("JSONParsingError", "%s", err.Error())
+}
@@ -0,0 +1,149 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordWriter serializes result records
+type recordWriter interface {
+	write(out *bytes.Buffer, names []string, values []interface{})
+}
+
+func newRecordWriter(output *OutputSerialization) (recordWriter, error) {
+	switch {
+	case output.CSV != nil && output.JSON != nil, output.CSV == nil && output.JSON == nil:
+		return nil, errorf("InvalidRequest", "Exactly one output serialization must be specified")
+	case output.JSON != nil:
+		delimiter := output.JSON.RecordDelimiter
+		if delimiter == "" {
+			delimiter = "\n"
+		}
+		return &jsonWriter{recordDelimiter: delimiter}, nil
+	}
+
+	csv := output.CSV
+	w := &csvWriter{
+		fieldDelimiter:  csv.FieldDelimiter,
+		recordDelimiter: csv.RecordDelimiter,
+		quote:           csv.QuoteCharacter,
+		quoteEscape:     csv.QuoteEscapeCharacter,
+	}
+	if w.fieldDelimiter == "" {
+		w.fieldDelimiter = ","
+	}
+	if w.recordDelimiter == "" {
+		w.recordDelimiter = "\n"
+	}
+	if w.quote == "" {
+		w.quote = `"`
+	}
+	if w.quoteEscape == "" {
+		w.quoteEscape = w.quote
+	}
+
+	switch strings.ToUpper(csv.QuoteFields) {
+	case "", "ASNEEDED":
+	case "ALWAYS":
+		w.alwaysQuote = true
+	default:
+		return nil, errorf("InvalidQuoteFields", "The QuoteFields is invalid. Only ALWAYS and ASNEEDED are supported.")
+	}
+	return w, nil
+}
+
+type csvWriter struct {
+	fieldDelimiter  string
+	recordDelimiter string
+	quote           string
+	quoteEscape     string
+	alwaysQuote     bool
+}
+
+func (c *csvWriter) write(out *bytes.Buffer, names []string, values []interface{}) {
+	for i, v := range values {
+		if i > 0 {
+			out.WriteString(c.fieldDelimiter)
+		}
+
+		field := formatValue(v)
+		if !c.alwaysQuote && !c.needsQuotes(field) {
+			out.WriteString(field)
+			continue
+		}
+		out.WriteString(c.quote)
+		out.WriteString(strings.ReplaceAll(field, c.quote, c.quoteEscape+c.quote))
+		out.WriteString(c.quote)
+	}
+	out.WriteString(c.recordDelimiter)
+}
+
+func (c *csvWriter) needsQuotes(field string) bool {
+	return strings.Contains(field, c.fieldDelimiter) ||
+		strings.Contains(field, c.recordDelimiter) ||
+		strings.Contains(field, c.quote) ||
+		strings.ContainsAny(field, "\r\n")
+}
+
+type jsonWriter struct {
+	recordDelimiter string
+}
+
+func (j *jsonWriter) write(out *bytes.Buffer, names []string, values []interface{}) {
+	out.Write(appendJSON(nil, &object{keys: names, values: values}))
+	out.WriteString(j.recordDelimiter)
+}
+
+// appendJSON encodes a value as JSON, keeping the field order of objects
+func appendJSON(buf []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		return appendJSONString(buf, x)
+	case int64:
+		return strconv.AppendInt(buf, x, 10)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return append(buf, "null"...)
+		}
+		return strconv.AppendFloat(buf, x, 'f', -1, 64)
+	case bool:
+		return strconv.AppendBool(buf, x)
+	case time.Time:
+		return appendJSONString(buf, x.Format(time.RFC3339Nano))
+	case *object:
+		buf = append(buf, '{')
+		for i, key := range x.keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ':')
+			buf = appendJSON(buf, x.values[i])
+		}
+		return append(buf, '}')
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, item := range x {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSON(buf, item)
+		}
+		return append(buf, ']')
+	}
+	return append(buf, "null"...)
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	var encoded bytes.Buffer
+	enc := json.NewEncoder(&encoded)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return append(buf, bytes.TrimRight(encoded.Bytes(), "\n")...)
+}
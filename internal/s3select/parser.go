@@ -0,0 +1,704 @@
+package s3select
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// symbols lists the operators and punctuation of the SQL subset, longest
+// first so that "<=" wins over "<"
+var symbols = []string{"<=", ">=", "<>", "!=", "||", "(", ")", ",", ".", "[", "]", "*", "+", "-", "/", "%", "=", "<", ">"}
+
+// reservedWords cannot be used as an unquoted table alias
+var reservedWords = map[string]bool{
+	"WHERE": true, "LIMIT": true, "FROM": true, "AS": true, "AND": true, "OR": true, "NOT": true,
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '\'' || r == '"':
+			kind := tokenString
+			if r == '"' {
+				kind = tokenQuotedIdent
+			}
+			start := i
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, errorf("ParseInvalidTypeParam", "Unterminated literal at position %d", start)
+				}
+				if runes[i] == r {
+					// A doubled quote is an escaped quote
+					if i+1 < len(runes) && runes[i+1] == r {
+						text.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String(), pos: start})
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+
+		default:
+			matched := false
+			for _, sym := range symbols {
+				if strings.HasPrefix(string(runes[i:]), sym) {
+					tokens = append(tokens, token{kind: tokenSymbol, text: sym, pos: i})
+					i += len([]rune(sym))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errorf("ParseInvalidTypeParam", "Unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// statement is a parsed SELECT query
+type statement struct {
+	star          bool
+	projections   []projection
+	alias         string
+	documentArray bool
+	where         expr
+	limit         int64
+	aggregate     bool
+	aggregates    []*aggregateExpr
+}
+
+// projection is one item of the SELECT list
+type projection struct {
+	expr expr
+	name string
+}
+
+// accumulate feeds a matching record to every aggregate of the query
+func (s *statement) accumulate(rec *record) error {
+	for _, agg := range s.aggregates {
+		if err := agg.accumulate(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(sql string) (*statement, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stmt.resolve(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return nil
+}
+
+func (p *parser) isSymbol(sym string) bool {
+	t := p.peek()
+	return t.kind == tokenSymbol && t.text == sym
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	if p.isSymbol(sym) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return p.unexpected(sym)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errorf("ParseUnexpectedToken", "Expected %s at end of expression", expected)
+	}
+	return errorf("ParseUnexpectedToken", "Expected %s but found %q at position %d", expected, t.text, t.pos)
+}
+
+func (p *parser) parseStatement() (*statement, error) {
+	stmt := &statement{limit: -1}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	if p.acceptSymbol("*") {
+		stmt.star = true
+	} else {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := projection{expr: e}
+			if p.acceptKeyword("AS") {
+				t := p.next()
+				if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+					return nil, errorf("ParseExpectedIdentForAlias", "Expected an alias after AS")
+				}
+				item.name = t.text
+			}
+			stmt.projections = append(stmt.projections, item)
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokenIdent || !strings.EqualFold(t.text, "S3Object") {
+		return nil, errorf("ParseUnexpectedToken", "Only S3Object can be queried")
+	}
+	if p.acceptSymbol("[") {
+		if err := p.expectSymbol("*"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("]"); err != nil {
+			return nil, err
+		}
+		stmt.documentArray = true
+	}
+
+	if p.acceptKeyword("AS") {
+		t := p.next()
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+			return nil, errorf("ParseExpectedIdentForAlias", "Expected an alias after AS")
+		}
+		stmt.alias = t.text
+	} else if t := p.peek(); (t.kind == tokenIdent && !reservedWords[strings.ToUpper(t.text)]) || t.kind == tokenQuotedIdent {
+		stmt.alias = p.next().text
+	}
+
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.where = where
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		t := p.next()
+		limit, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tokenNumber || err != nil || limit < 0 {
+			return nil, errorf("ParseInvalidTypeParam", "LIMIT must be a non-negative integer")
+		}
+		stmt.limit = limit
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errorf("ParseUnexpectedToken", "Unexpected token %q at position %d", t.text, t.pos)
+	}
+
+	return stmt, nil
+}
+
+// resolve strips the table alias from column references, names the
+// projections and checks how aggregates are used
+func (s *statement) resolve() error {
+	stripAlias := func(e expr) {
+		ref, ok := e.(*columnRef)
+		if !ok || len(ref.path) == 0 || ref.path[0].isIndex {
+			return
+		}
+		first := ref.path[0].name
+		if strings.EqualFold(first, "S3Object") || (s.alias != "" && strings.EqualFold(first, s.alias)) {
+			ref.path = ref.path[1:]
+		}
+	}
+
+	for i := range s.projections {
+		walk(s.projections[i].expr, stripAlias)
+	}
+	if s.where != nil {
+		walk(s.where, stripAlias)
+
+		var whereAggregate bool
+		walk(s.where, func(e expr) {
+			if _, ok := e.(*aggregateExpr); ok {
+				whereAggregate = true
+			}
+		})
+		if whereAggregate {
+			return errorf("InvalidQuery", "Aggregate functions are not allowed in the WHERE clause")
+		}
+	}
+
+	for i := range s.projections {
+		p := &s.projections[i]
+		if p.name == "" {
+			p.name = fmt.Sprintf("_%d", i+1)
+			if ref, ok := p.expr.(*columnRef); ok {
+				for j := len(ref.path) - 1; j >= 0; j-- {
+					if !ref.path[j].isIndex {
+						p.name = ref.path[j].name
+						break
+					}
+				}
+			}
+		}
+
+		aggregates := aggregatesOf(p.expr)
+		if len(aggregates) > 0 {
+			s.aggregate = true
+			s.aggregates = append(s.aggregates, aggregates...)
+		}
+	}
+
+	if s.aggregate {
+		for _, p := range s.projections {
+			if columnOutsideAggregate(p.expr) {
+				return errorf("InvalidQuery", "Columns must be used inside aggregate functions in an aggregate query")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.acceptSymbol(op) {
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			if op == "<>" {
+				op = "!="
+			}
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if p.acceptKeyword("NULL") || p.acceptKeyword("MISSING") {
+			return &isNullExpr{operand: left, not: not}, nil
+		}
+		return nil, p.unexpected("NULL")
+	}
+
+	not := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		like := &likeExpr{operand: left, pattern: pattern, not: not}
+		if p.acceptKeyword("ESCAPE") {
+			if like.escape, err = p.parseAdditive(); err != nil {
+				return nil, err
+			}
+		}
+		return like, nil
+
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{operand: left, low: low, high: high, not: not}, nil
+
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		in := &inExpr{operand: left, not: not}
+		for {
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+
+	if not {
+		return nil, p.unexpected("LIKE, BETWEEN or IN")
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptSymbol("+"):
+			op = "+"
+		case p.acceptSymbol("-"):
+			op = "-"
+		case p.acceptSymbol("||"):
+			op = "||"
+		default:
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptSymbol("*"):
+			op = "*"
+		case p.acceptSymbol("/"):
+			op = "/"
+		case p.acceptSymbol("%"):
+			op = "%"
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.acceptSymbol("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{value: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorf("ParseInvalidTypeParam", "Invalid number %q", t.text)
+		}
+		return &literal{value: f}, nil
+
+	case tokenString:
+		p.next()
+		return &literal{value: t.text}, nil
+
+	case tokenSymbol:
+		if p.acceptSymbol("(") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+		return nil, p.unexpected("an expression")
+
+	case tokenQuotedIdent:
+		return p.parsePath()
+
+	case tokenIdent:
+		upper := strings.ToUpper(t.text)
+		switch upper {
+		case "TRUE", "FALSE":
+			p.next()
+			return &literal{value: upper == "TRUE"}, nil
+		case "NULL", "MISSING":
+			p.next()
+			return &literal{value: nil}, nil
+		case "CAST":
+			return p.parseCast()
+		}
+
+		if p.tokens[p.pos+1].kind == tokenSymbol && p.tokens[p.pos+1].text == "(" {
+			return p.parseFunction()
+		}
+		return p.parsePath()
+	}
+
+	return nil, p.unexpected("an expression")
+}
+
+func (p *parser) parseCast() (expr, error) {
+	p.next()
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	typeName := strings.ToUpper(t.text)
+	if t.kind != tokenIdent || !castTypes[typeName] {
+		return nil, errorf("ParseInvalidTypeParam", "Unsupported CAST type %q", t.text)
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return &castExpr{operand: operand, typeName: typeName}, nil
+}
+
+func (p *parser) parseFunction() (expr, error) {
+	name := strings.ToUpper(p.next().text)
+	p.next() // "("
+
+	if aggregateFunctions[name] {
+		agg := &aggregateExpr{name: name}
+		if name == "COUNT" && p.acceptSymbol("*") {
+			// COUNT(*) counts records
+		} else {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			agg.arg = arg
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return agg, nil
+	}
+
+	if !scalarFunctions[name] {
+		return nil, errorf("UnsupportedFunction", "Function %s is not supported", name)
+	}
+
+	fn := &funcExpr{name: name}
+	if !p.isSymbol(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			fn.args = append(fn.args, arg)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if err := fn.checkArity(); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+func (p *parser) parsePath() (expr, error) {
+	ref := &columnRef{}
+	for {
+		t := p.next()
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+			return nil, errorf("ParseUnexpectedToken", "Expected a column name at position %d", t.pos)
+		}
+		ref.path = append(ref.path, pathElement{name: t.text, quoted: t.kind == tokenQuotedIdent})
+
+		for p.acceptSymbol("[") {
+			t := p.next()
+			index, err := strconv.Atoi(t.text)
+			if t.kind != tokenNumber || err != nil || index < 0 {
+				return nil, errorf("ParseInvalidPathComponent", "Invalid array index at position %d", t.pos)
+			}
+			if err := p.expectSymbol("]"); err != nil {
+				return nil, err
+			}
+			ref.path = append(ref.path, pathElement{index: index, isIndex: true})
+		}
+
+		if !p.acceptSymbol(".") {
+			return ref, nil
+		}
+	}
+}
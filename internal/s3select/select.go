@@ -0,0 +1,283 @@
+// Package s3select implements the S3 Select SQL subset over CSV and JSON
+// objects, and the event stream framing used by SelectObjectContent.
+package s3select
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// recordsChunkSize is the amount of output buffered before a Records event
+// is sent
+const recordsChunkSize = 256 * 1024
+
+// CSVInput describes how CSV objects are parsed
+type CSVInput struct {
+	FileHeaderInfo             string `xml:"FileHeaderInfo"`
+	Comments                   string `xml:"Comments"`
+	QuoteEscapeCharacter       string `xml:"QuoteEscapeCharacter"`
+	RecordDelimiter            string `xml:"RecordDelimiter"`
+	FieldDelimiter             string `xml:"FieldDelimiter"`
+	QuoteCharacter             string `xml:"QuoteCharacter"`
+	AllowQuotedRecordDelimiter bool   `xml:"AllowQuotedRecordDelimiter"`
+}
+
+// JSONInput describes how JSON objects are parsed
+type JSONInput struct {
+	Type string `xml:"Type"`
+}
+
+// InputSerialization describes the format of the queried object
+type InputSerialization struct {
+	CompressionType string     `xml:"CompressionType"`
+	CSV             *CSVInput  `xml:"CSV"`
+	JSON            *JSONInput `xml:"JSON"`
+	Parquet         *struct{}  `xml:"Parquet"`
+}
+
+// CSVOutput describes how result records are written as CSV
+type CSVOutput struct {
+	QuoteFields          string `xml:"QuoteFields"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter"`
+	RecordDelimiter      string `xml:"RecordDelimiter"`
+	FieldDelimiter       string `xml:"FieldDelimiter"`
+	QuoteCharacter       string `xml:"QuoteCharacter"`
+}
+
+// JSONOutput describes how result records are written as JSON
+type JSONOutput struct {
+	RecordDelimiter string `xml:"RecordDelimiter"`
+}
+
+// OutputSerialization describes the format of the result records
+type OutputSerialization struct {
+	CSV  *CSVOutput  `xml:"CSV"`
+	JSON *JSONOutput `xml:"JSON"`
+}
+
+// Request is a SelectObjectContent request
+type Request struct {
+	Expression string
+	Input      InputSerialization
+	Output     OutputSerialization
+	Progress   bool
+}
+
+// Error is a SelectObjectContent failure reported to the client with an S3
+// error code
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Query is a parsed and validated select request
+type Query struct {
+	req    *Request
+	stmt   *statement
+	output recordWriter
+}
+
+// Prepare parses and validates a request. Errors returned here happen before
+// any response is written and are sent as ordinary S3 error responses.
+func Prepare(req *Request) (*Query, error) {
+	switch {
+	case req.Input.Parquet != nil:
+		return nil, errorf("UnsupportedSyntax", "Parquet input is not supported")
+	case req.Input.CSV != nil && req.Input.JSON != nil, req.Input.CSV == nil && req.Input.JSON == nil:
+		return nil, errorf("InvalidRequest", "Exactly one input serialization must be specified")
+	}
+
+	switch strings.ToUpper(req.Input.CompressionType) {
+	case "", "NONE", "GZIP", "BZIP2":
+	default:
+		return nil, errorf("InvalidCompressionFormat", "The file is not in a supported compression format. Only GZIP and BZIP2 are supported.")
+	}
+
+	if json := req.Input.JSON; json != nil {
+		switch strings.ToUpper(json.Type) {
+		case "DOCUMENT", "LINES":
+		default:
+			return nil, errorf("InvalidJsonType", "The JsonType is invalid. Only DOCUMENT and LINES are supported.")
+		}
+	}
+
+	if csv := req.Input.CSV; csv != nil {
+		switch strings.ToUpper(csv.FileHeaderInfo) {
+		case "", "NONE", "IGNORE", "USE":
+		default:
+			return nil, errorf("InvalidFileHeaderInfo", "The FileHeaderInfo is invalid. Only NONE, USE, and IGNORE are supported.")
+		}
+	}
+
+	output, err := newRecordWriter(&req.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := parse(req.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{req: req, stmt: stmt, output: output}, nil
+}
+
+// Run evaluates the query over the object data and streams the result to w
+// as Records, Progress, Stats and End events. Query errors that happen once
+// streaming has started are sent as error events; the returned error only
+// reports failures to write the response.
+func (q *Query) Run(data io.Reader, w io.Writer) error {
+	events := newEventWriter(w)
+	scanned := &countingReader{r: data}
+	processed := &countingReader{}
+
+	decompressed, err := decompress(q.req.Input.CompressionType, scanned)
+	if err != nil {
+		return events.writeError(err)
+	}
+	processed.r = decompressed
+
+	records, err := newRecordReader(&q.req.Input, processed, q.stmt.documentArray)
+	if err != nil {
+		return events.writeError(err)
+	}
+
+	var out bytes.Buffer
+	var returned int64
+	flush := func() error {
+		if out.Len() == 0 {
+			return nil
+		}
+		returned += int64(out.Len())
+		err := events.writeRecords(out.Bytes())
+		out.Reset()
+		return err
+	}
+	fail := func(err error) error {
+		if flushErr := flush(); flushErr != nil {
+			return flushErr
+		}
+		return events.writeError(err)
+	}
+
+	var emitted int64
+	for q.stmt.aggregate || q.stmt.limit < 0 || emitted < q.stmt.limit {
+		rec, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if q.stmt.where != nil {
+			matched, err := q.stmt.where.eval(rec)
+			if err != nil {
+				return fail(err)
+			}
+			if matched != true {
+				continue
+			}
+		}
+
+		if q.stmt.aggregate {
+			if err := q.stmt.accumulate(rec); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		if err := q.writeRecord(&out, rec); err != nil {
+			return fail(err)
+		}
+		emitted++
+
+		if out.Len() >= recordsChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if q.stmt.aggregate && q.stmt.limit != 0 {
+		if err := q.writeRecord(&out, nil); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	stats := &stats{BytesScanned: scanned.n, BytesProcessed: processed.n, BytesReturned: returned}
+	if q.req.Progress {
+		if err := events.writeProgress(stats); err != nil {
+			return err
+		}
+	}
+	if err := events.writeStats(stats); err != nil {
+		return err
+	}
+	return events.writeEnd()
+}
+
+// writeRecord evaluates the projections for one record and appends the
+// result to out
+func (q *Query) writeRecord(out *bytes.Buffer, rec *record) error {
+	if q.stmt.star {
+		q.output.write(out, rec.obj.keys, rec.obj.values)
+		return nil
+	}
+
+	names := make([]string, len(q.stmt.projections))
+	values := make([]interface{}, len(q.stmt.projections))
+	for i, p := range q.stmt.projections {
+		v, err := p.expr.eval(rec)
+		if err != nil {
+			return err
+		}
+		names[i] = p.name
+		values[i] = v
+	}
+
+	q.output.write(out, names, values)
+	return nil
+}
+
+func decompress(compressionType string, r io.Reader) (io.Reader, error) {
+	switch strings.ToUpper(compressionType) {
+	case "GZIP":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errorf("InvalidCompressionFormat", "The file is not in a supported compression format.")
+		}
+		return gz, nil
+	case "BZIP2":
+		return bzip2.NewReader(r), nil
+	}
+	return r, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,248 @@
+package s3select
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testCSV = "name,age,city\n" +
+	"alice,30,Paris\n" +
+	"bob,25,\"New York, NY\"\n" +
+	"carol,41,Berlin\n"
+
+const testJSONLines = `{"name":"alice","age":30,"tags":["a","b"],"address":{"city":"Paris"}}
+{"name":"bob","age":25,"tags":[],"address":{"city":"New York"}}
+{"name":"carol","age":41.5,"address":null}
+`
+
+type event struct {
+	headers map[string]string
+	payload []byte
+}
+
+// decodeEvents parses an event stream, checking the prelude and message CRCs
+func decodeEvents(t *testing.T, data []byte) []event {
+	t.Helper()
+
+	var events []event
+	for len(data) > 0 {
+		if len(data) < 16 {
+			t.Fatalf("Truncated message of %d bytes", len(data))
+		}
+		total := binary.BigEndian.Uint32(data[0:4])
+		headersLen := binary.BigEndian.Uint32(data[4:8])
+		if crc32.ChecksumIEEE(data[0:8]) != binary.BigEndian.Uint32(data[8:12]) {
+			t.Fatalf("Invalid prelude CRC")
+		}
+		message := data[:total]
+		if crc32.ChecksumIEEE(message[:total-4]) != binary.BigEndian.Uint32(message[total-4:]) {
+			t.Fatalf("Invalid message CRC")
+		}
+
+		e := event{headers: map[string]string{}}
+		headers := message[12 : 12+headersLen]
+		for len(headers) > 0 {
+			nameLen := int(headers[0])
+			name := string(headers[1 : 1+nameLen])
+			valueLen := int(binary.BigEndian.Uint16(headers[2+nameLen : 4+nameLen]))
+			e.headers[name] = string(headers[4+nameLen : 4+nameLen+valueLen])
+			headers = headers[4+nameLen+valueLen:]
+		}
+		e.payload = message[12+headersLen : total-4]
+
+		events = append(events, e)
+		data = data[total:]
+	}
+	return events
+}
+
+// runQuery runs a query and returns the concatenated records and the events
+func runQuery(t *testing.T, req *Request, data []byte) (string, []event) {
+	t.Helper()
+
+	query, err := Prepare(req)
+	if err != nil {
+		t.Fatalf("Failed to prepare %q: %v", req.Expression, err)
+	}
+
+	var out bytes.Buffer
+	if err := query.Run(bytes.NewReader(data), &out); err != nil {
+		t.Fatalf("Failed to run %q: %v", req.Expression, err)
+	}
+
+	events := decodeEvents(t, out.Bytes())
+	var records strings.Builder
+	for _, e := range events {
+		if e.headers[":message-type"] == "error" {
+			t.Fatalf("Query %q failed: %s: %s", req.Expression, e.headers[":error-code"], e.headers[":error-message"])
+		}
+		if e.headers[":event-type"] == "Records" {
+			records.Write(e.payload)
+		}
+	}
+	return records.String(), events
+}
+
+func csvRequest(expression string) *Request {
+	return &Request{
+		Expression: expression,
+		Input:      InputSerialization{CSV: &CSVInput{FileHeaderInfo: "USE"}},
+		Output:     OutputSerialization{CSV: &CSVOutput{}},
+	}
+}
+
+func jsonRequest(expression string) *Request {
+	return &Request{
+		Expression: expression,
+		Input:      InputSerialization{JSON: &JSONInput{Type: "LINES"}},
+		Output:     OutputSerialization{JSON: &JSONOutput{}},
+	}
+}
+
+func TestSelectCSV(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"SELECT * FROM S3Object", "alice,30,Paris\nbob,25,\"New York, NY\"\ncarol,41,Berlin\n"},
+		{"SELECT s.name FROM S3Object s WHERE s.age > 28", "alice\ncarol\n"},
+		{"SELECT name, city FROM S3Object WHERE city LIKE 'New%'", "bob,\"New York, NY\"\n"},
+		{"SELECT _1 FROM S3Object LIMIT 2", "alice\nbob\n"},
+		{"SELECT UPPER(name) FROM S3Object WHERE age BETWEEN 25 AND 30 AND name <> 'bob'", "ALICE\n"},
+		{"SELECT name FROM S3Object WHERE name IN ('bob', 'carol') AND NOT age = 25", "carol\n"},
+		{"SELECT COUNT(*), SUM(CAST(age AS INT)), MAX(age) FROM S3Object", "3,96,41\n"},
+		{"SELECT AVG(CAST(age AS INT)) FROM S3Object WHERE city = 'nowhere'", "\n"},
+		{"SELECT name || '-' || city FROM S3Object WHERE CAST(age AS INT) % 2 = 1", "\"bob-New York, NY\"\ncarol-Berlin\n"},
+		{"SELECT name FROM S3Object WHERE missing IS NULL LIMIT 1", "alice\n"},
+	}
+
+	for _, tt := range tests {
+		records, _ := runQuery(t, csvRequest(tt.expression), []byte(testCSV))
+		if records != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.expression, tt.expected, records)
+		}
+	}
+}
+
+func TestSelectCSVOptions(t *testing.T) {
+	data := "# comment\r\n1;'it''s';x\r\n2;'semi;colon';y\r\n"
+	req := &Request{
+		Expression: "SELECT _2, _3 FROM S3Object WHERE _1 = 2 OR _3 = 'x'",
+		Input: InputSerialization{CSV: &CSVInput{
+			FileHeaderInfo: "NONE",
+			Comments:       "#",
+			FieldDelimiter: ";",
+			QuoteCharacter: "'",
+		}},
+		Output: OutputSerialization{CSV: &CSVOutput{QuoteFields: "ALWAYS", FieldDelimiter: "|"}},
+	}
+
+	records, _ := runQuery(t, req, []byte(data))
+	expected := "\"it's\"|\"x\"\n\"semi;colon\"|\"y\"\n"
+	if records != expected {
+		t.Errorf("Expected %q, got %q", expected, records)
+	}
+}
+
+func TestSelectJSON(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"SELECT s.name, s.address.city FROM S3Object s WHERE s.age < 40", `{"name":"alice","city":"Paris"}` + "\n" + `{"name":"bob","city":"New York"}` + "\n"},
+		{"SELECT s.tags[1] AS second FROM S3Object s WHERE s.tags[1] IS NOT MISSING", `{"second":"b"}` + "\n"},
+		{"SELECT * FROM S3Object s WHERE s.address IS NULL", `{"name":"carol","age":41.5,"address":null}` + "\n"},
+		{"SELECT SUM(s.age) AS total, MIN(s.name) FROM S3Object s", `{"total":96.5,"_2":"alice"}` + "\n"},
+		{"SELECT s.age * 2 FROM S3Object s WHERE s.name = 'bob'", `{"_1":50}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		records, _ := runQuery(t, jsonRequest(tt.expression), []byte(testJSONLines))
+		if records != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.expression, tt.expected, records)
+		}
+	}
+}
+
+func TestSelectJSONDocumentArray(t *testing.T) {
+	req := jsonRequest("SELECT s.id FROM S3Object[*] s WHERE s.id >= 2")
+	req.Input.JSON.Type = "DOCUMENT"
+
+	records, _ := runQuery(t, req, []byte(`[{"id":1},{"id":2},{"id":3}]`))
+	expected := `{"id":2}` + "\n" + `{"id":3}` + "\n"
+	if records != expected {
+		t.Errorf("Expected %q, got %q", expected, records)
+	}
+}
+
+func TestSelectGzipAndStats(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(testCSV))
+	gz.Close()
+
+	req := csvRequest("SELECT name FROM S3Object WHERE city = 'Berlin'")
+	req.Input.CompressionType = "GZIP"
+	req.Progress = true
+
+	records, events := runQuery(t, req, compressed.Bytes())
+	if records != "carol\n" {
+		t.Errorf("Expected carol, got %q", records)
+	}
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.headers[":event-type"])
+	}
+	if strings.Join(types, ",") != "Records,Progress,Stats,End" {
+		t.Fatalf("Unexpected events %v", types)
+	}
+
+	stats := string(events[2].payload)
+	if !strings.Contains(stats, "<BytesScanned>"+strconv.Itoa(compressed.Len())+"</BytesScanned>") ||
+		!strings.Contains(stats, "<BytesProcessed>"+strconv.Itoa(len(testCSV))+"</BytesProcessed>") ||
+		!strings.Contains(stats, "<BytesReturned>6</BytesReturned>") {
+		t.Errorf("Unexpected stats %s", stats)
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	prepareTests := []struct {
+		req  *Request
+		code string
+	}{
+		{csvRequest("SELECT FROM S3Object"), "ParseUnexpectedToken"},
+		{csvRequest("SELECT name FROM S3Object WHERE COUNT(*) > 1"), "InvalidQuery"},
+		{csvRequest("SELECT name, COUNT(*) FROM S3Object"), "InvalidQuery"},
+		{csvRequest("SELECT FOO(name) FROM S3Object"), "UnsupportedFunction"},
+		{&Request{Expression: "SELECT * FROM S3Object", Input: InputSerialization{Parquet: &struct{}{}}, Output: OutputSerialization{CSV: &CSVOutput{}}}, "UnsupportedSyntax"},
+	}
+
+	for _, tt := range prepareTests {
+		_, err := Prepare(tt.req)
+		selectErr, ok := err.(*Error)
+		if !ok || selectErr.Code != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.req.Expression, tt.code, err)
+		}
+	}
+
+	// Errors found while scanning are reported as error events
+	query, err := Prepare(csvRequest("SELECT CAST(city AS INT) FROM S3Object"))
+	if err != nil {
+		t.Fatalf("Failed to prepare: %v", err)
+	}
+	var out bytes.Buffer
+	if err := query.Run(strings.NewReader(testCSV), &out); err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	events := decodeEvents(t, out.Bytes())
+	last := events[len(events)-1]
+	if last.headers[":message-type"] != "error" || last.headers[":error-code"] != "CastFailed" {
+		t.Errorf("Expected CastFailed error event, got %v", last.headers)
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// MetadataACL holds the canned ACL of an object
+const MetadataACL = InternalMetadataPrefix + "Acl"
+
+// Canned ACLs that grant access to everyone
+const (
+	ACLPublicRead      = "public-read"
+	ACLPublicReadWrite = "public-read-write"
+)
+
+// cannedACLs are the canned ACLs accepted in x-amz-acl
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	ACLPublicRead:               true,
+	ACLPublicReadWrite:          true,
+	"authenticated-read":        true,
+	"aws-exec-read":             true,
+	"bucket-owner-read":         true,
+	"bucket-owner-full-control": true,
+	"log-delivery-write":        true,
+}
+
+// ValidCannedACL reports whether acl is a known canned ACL
+func ValidCannedACL(acl string) bool {
+	return cannedACLs[acl]
+}
+
+// IsPublicACL reports whether a canned ACL grants access to everyone
+func IsPublicACL(acl string) bool {
+	return acl == ACLPublicRead || acl == ACLPublicReadWrite
+}
+
+// PublicAccessBlockConfiguration is the Block Public Access setting of a
+// bucket
+type PublicAccessBlockConfiguration struct {
+	// BlockPublicAcls rejects requests that set a public ACL
+	BlockPublicAcls bool `json:"blockPublicAcls"`
+
+	// IgnorePublicAcls ignores public ACLs when authorizing requests
+	IgnorePublicAcls bool `json:"ignorePublicAcls"`
+
+	// BlockPublicPolicy rejects bucket policies that grant public access
+	BlockPublicPolicy bool `json:"blockPublicPolicy"`
+
+	// RestrictPublicBuckets ignores public bucket policies when authorizing
+	// anonymous requests
+	RestrictPublicBuckets bool `json:"restrictPublicBuckets"`
+}
+
+// GetPublicAccessBlock returns the Block Public Access setting of a bucket,
+// or nil if it has none
+func (fs *FileSystemStorage) GetPublicAccessBlock(bucket string) (*PublicAccessBlockConfiguration, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return nil, ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.PublicAccessBlock, nil
+}
+
+func (fs *FileSystemStorage) PutPublicAccessBlock(bucket string, block *PublicAccessBlockConfiguration) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.PublicAccessBlock = block
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+func (fs *FileSystemStorage) DeletePublicAccessBlock(bucket string) error {
+	return fs.PutPublicAccessBlock(bucket, nil)
+}
+
+// GetBucketPolicy returns the policy document of a bucket, or "" if it has
+// none
+func (fs *FileSystemStorage) GetBucketPolicy(bucket string) (string, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return "", ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Policy, nil
+}
+
+func (fs *FileSystemStorage) PutBucketPolicy(bucket, policy string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Policy = policy
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+func (fs *FileSystemStorage) DeleteBucketPolicy(bucket string) error {
+	return fs.PutBucketPolicy(bucket, "")
+}
+
+// GetBucketACL returns the canned ACL of a bucket, "private" unless set
+func (fs *FileSystemStorage) GetBucketACL(bucket string) (string, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return "", ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	if cfg.ACL == "" {
+		return "private", nil
+	}
+	return cfg.ACL, nil
+}
+
+func (fs *FileSystemStorage) PutBucketACL(bucket, acl string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+	if !ValidCannedACL(acl) {
+		return fmt.Errorf("%w: invalid canned ACL %q", ErrInvalidArgument, acl)
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.ACL = acl
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+// GetObjectACL returns the canned ACL of an object, "private" unless set
+func (fs *FileSystemStorage) GetObjectACL(bucket, key string) (string, error) {
+	defer fs.rlockObject(bucket, key)()
+
+	info, err := fs.headObject(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if acl := info.Metadata[MetadataACL]; acl != "" {
+		return acl, nil
+	}
+	return "private", nil
+}
+
+func (fs *FileSystemStorage) PutObjectACL(bucket, key, acl string) error {
+	defer fs.lockObject(bucket, key)()
+
+	if !ValidCannedACL(acl) {
+		return fmt.Errorf("%w: invalid canned ACL %q", ErrInvalidArgument, acl)
+	}
+
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(objectPath); err != nil || info.IsDir() {
+		return ErrNoSuchKey
+	}
+
+	metadata := fs.loadMetadata(objectPath)
+	metadata[MetadataACL] = acl
+	return fs.rewriteMetadata(bucket, key, metadata)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPublicAccessBlock(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	if err := fs.CreateBucket("guarded"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	block, err := fs.GetPublicAccessBlock("guarded")
+	if err != nil || block != nil {
+		t.Fatalf("Expected no public access block, got %+v, %v", block, err)
+	}
+
+	if err := fs.PutPublicAccessBlock("guarded", &PublicAccessBlockConfiguration{BlockPublicAcls: true, RestrictPublicBuckets: true}); err != nil {
+		t.Fatalf("PutPublicAccessBlock failed: %v", err)
+	}
+	block, err = fs.GetPublicAccessBlock("guarded")
+	if err != nil || block == nil || !block.BlockPublicAcls || block.IgnorePublicAcls || !block.RestrictPublicBuckets {
+		t.Errorf("Unexpected public access block %+v, %v", block, err)
+	}
+
+	if err := fs.DeletePublicAccessBlock("guarded"); err != nil {
+		t.Fatalf("DeletePublicAccessBlock failed: %v", err)
+	}
+	if block, _ := fs.GetPublicAccessBlock("guarded"); block != nil {
+		t.Errorf("Expected public access block to be removed, got %+v", block)
+	}
+
+	if err := fs.PutPublicAccessBlock("missing", &PublicAccessBlockConfiguration{}); err == nil {
+		t.Error("Expected missing bucket to be rejected")
+	}
+}
+
+func TestBucketPolicyAndACL(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	if err := fs.CreateBucket("shared"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	if err := fs.PutBucketPolicy("shared", `{"Statement": []}`); err != nil {
+		t.Fatalf("PutBucketPolicy failed: %v", err)
+	}
+	if policy, err := fs.GetBucketPolicy("shared"); err != nil || policy != `{"Statement": []}` {
+		t.Errorf("Unexpected policy %q, %v", policy, err)
+	}
+	fs.DeleteBucketPolicy("shared")
+	if policy, _ := fs.GetBucketPolicy("shared"); policy != "" {
+		t.Errorf("Expected policy to be removed, got %q", policy)
+	}
+
+	if acl, err := fs.GetBucketACL("shared"); err != nil || acl != "private" {
+		t.Errorf("Expected private bucket by default, got %q, %v", acl, err)
+	}
+	if err := fs.PutBucketACL("shared", "public-read"); err != nil {
+		t.Fatalf("PutBucketACL failed: %v", err)
+	}
+	if acl, _ := fs.GetBucketACL("shared"); acl != "public-read" {
+		t.Errorf("Expected public-read, got %q", acl)
+	}
+	if err := fs.PutBucketACL("shared", "everyone"); err == nil {
+		t.Error("Expected unknown canned ACL to be rejected")
+	}
+
+	if _, err := fs.PutObject("shared", "doc.txt", bytes.NewReader([]byte("data")), 4, map[string]string{"X-Amz-Meta-Owner": "me"}); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if acl, err := fs.GetObjectACL("shared", "doc.txt"); err != nil || acl != "private" {
+		t.Errorf("Expected private object by default, got %q, %v", acl, err)
+	}
+	if err := fs.PutObjectACL("shared", "doc.txt", "public-read"); err != nil {
+		t.Fatalf("PutObjectACL failed: %v", err)
+	}
+	info, _ := fs.HeadObject("shared", "doc.txt")
+	if info.Metadata[MetadataACL] != "public-read" || info.Metadata["X-Amz-Meta-Owner"] != "me" {
+		t.Errorf("Expected ACL to be stored alongside metadata, got %v", info.Metadata)
+	}
+	if err := fs.PutObjectACL("shared", "missing.txt", "public-read"); err == nil {
+		t.Error("Expected missing object to be rejected")
+	}
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files are never written in place. They are written to a temp file in
+// tmpDir, synced, and renamed over their final path, so readers see either
+// the old or the new file and a crash never leaves a partial one behind.
+//
+// The data file and record of an object or a part are renamed together by
+// a commit: the renames are recorded in a journal first, and Recover
+// finishes the journals of commits that were interrupted.
+
+// journalSuffix ends the names of commit journals in tmpDir
+const journalSuffix = ".journal"
+
+// commit describes the changes that put written files into place
+type commit struct {
+	// Renames move files, usually temp files, to their final paths, in order
+	Renames []fileRename `json:"renames"`
+}
+
+// fileRename moves a file. Paths are relative to the data directory.
+type fileRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (fs *FileSystemStorage) tmpDir() string {
+	return filepath.Join(fs.basePath, systemDir, "tmp")
+}
+
+// createTemp creates a temp file on the file system of the data directory,
+// so that it can be renamed into place
+func (fs *FileSystemStorage) createTemp() (*os.File, error) {
+	if err := os.MkdirAll(fs.tmpDir(), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(fs.tmpDir(), "write-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files only readable by their owner
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// writeTemp writes a temp file with the output of write and syncs it. The
+// file is removed if writing fails.
+func (fs *FileSystemStorage) writeTemp(write func(w io.Writer) error) (string, error) {
+	file, err := fs.createTemp()
+	if err != nil {
+		return "", err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// writeFileAtomic replaces the file at path with data
+func (fs *FileSystemStorage) writeFileAtomic(path string, data []byte) error {
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// commitObject moves the written data file of an object into place along
+// with its record, replacing any previous version of the object
+func (fs *FileSystemStorage) commitObject(objectPath, dataPath string, record *objectRecord) error {
+	// Fail before anything is moved if the key can't be stored
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+
+	recordPath, err := fs.writeRecordTemp(record)
+	if err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+
+	return fs.runCommit(&commit{Renames: []fileRename{
+		fs.relRename(recordPath, fs.recordPath(objectPath)),
+		fs.relRename(dataPath, objectPath),
+	}})
+}
+
+// writeRecordTemp writes a record to a temp file, to be renamed into place
+// by a commit
+func (fs *FileSystemStorage) writeRecordTemp(record *objectRecord) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return fs.writeTemp(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// runCommit journals a commit, applies it and drops the journal. If the
+// commit fails, its temp files are removed.
+func (fs *FileSystemStorage) runCommit(c *commit) error {
+	journalPath, err := fs.writeJournal(c)
+	if err != nil {
+		fs.discardCommit(c)
+		return err
+	}
+
+	err = fs.applyCommit(c)
+	if err != nil {
+		fs.discardCommit(c)
+	}
+	os.Remove(journalPath)
+	return err
+}
+
+// runDurableCommit journals a commit and applies it. Unlike runCommit, it
+// keeps the journal if the commit fails, so Recover finishes it on the next
+// start.
+func (fs *FileSystemStorage) runDurableCommit(c *commit) error {
+	journalPath, err := fs.writeJournal(c)
+	if err != nil {
+		fs.discardCommit(c)
+		return err
+	}
+
+	if err := fs.applyCommit(c); err != nil {
+		return err
+	}
+	os.Remove(journalPath)
+	return nil
+}
+
+// writeJournal records a commit in a journal in tmpDir and returns its path
+func (fs *FileSystemStorage) writeJournal(c *commit) (string, error) {
+	journal, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error {
+		_, err := w.Write(journal)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	journalPath := tmpPath + journalSuffix
+	if err := os.Rename(tmpPath, journalPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	syncDir(fs.tmpDir())
+	return journalPath, nil
+}
+
+// applyCommit performs the renames of a commit. Renames whose
+// temp file is gone were already done, so a commit can be applied again.
+func (fs *FileSystemStorage) applyCommit(c *commit) error {
+	dirs := make(map[string]bool)
+	for _, rename := range c.Renames {
+		from := filepath.Join(fs.basePath, rename.From)
+		to := filepath.Join(fs.basePath, rename.To)
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		dirs[filepath.Dir(to)] = true
+	}
+
+	for dir := range dirs {
+		syncDir(dir)
+	}
+	return nil
+}
+
+// discardCommit removes the temp files of a commit that won't be applied
+func (fs *FileSystemStorage) discardCommit(c *commit) {
+	for _, rename := range c.Renames {
+		from := filepath.Join(fs.basePath, rename.From)
+		if strings.HasPrefix(from, fs.tmpDir()+string(filepath.Separator)) {
+			os.Remove(from)
+		}
+	}
+}
+
+// Recover finishes the commits that were interrupted when the server last
+// stopped, and removes the temp files of writes that never got committed.
+// It must run before the storage serves requests.
+func (fs *FileSystemStorage) Recover() error {
+	entries, err := os.ReadDir(fs.tmpDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Journals first, as they move temp files out of the directory
+	var errs []error
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), journalSuffix) {
+			continue
+		}
+		journalPath := filepath.Join(fs.tmpDir(), entry.Name())
+		if err := fs.replayJournal(journalPath); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		os.Remove(journalPath)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	entries, err = os.ReadDir(fs.tmpDir())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(fs.tmpDir(), entry.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (fs *FileSystemStorage) replayJournal(journalPath string) error {
+	data, err := os.ReadFile(journalPath)
+	if err != nil {
+		return err
+	}
+
+	// Journals are renamed into place once complete, so one that can't be
+	// read, or that points outside the data directory, wasn't written by a
+	// commit and is dropped
+	c := &commit{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil
+	}
+	for _, rename := range c.Renames {
+		if !filepath.IsLocal(rename.From) || !filepath.IsLocal(rename.To) {
+			return nil
+		}
+	}
+	return fs.applyCommit(c)
+}
+
+func (fs *FileSystemStorage) relPath(path string) string {
+	rel, err := filepath.Rel(fs.basePath, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+func (fs *FileSystemStorage) relRename(from, to string) fileRename {
+	return fileRename{From: fs.relPath(from), To: fs.relPath(to)}
+}
+
+// syncDir flushes the entries of a directory, making renames in it durable.
+// Errors are ignored, as not every platform can sync directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingReader returns some data and then an error, like an upload whose
+// client went away
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readObject(t *testing.T, fs *FileSystemStorage, bucket, key string) string {
+	t.Helper()
+	reader, _, err := fs.GetObject(bucket, key)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestFailedWriteKeepsObject(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	fs.PutObject("test-bucket", "key", bytes.NewReader([]byte("old")), 3, map[string]string{"X-Amz-Meta-Version": "1"})
+	if _, err := fs.PutObject("test-bucket", "key", &failingReader{data: []byte("new data")}, 100, map[string]string{"X-Amz-Meta-Version": "2"}); err == nil {
+		t.Fatal("Expected the failed upload to return an error")
+	}
+
+	if data := readObject(t, fs, "test-bucket", "key"); data != "old" {
+		t.Errorf("Expected the old object to survive, got %q", data)
+	}
+	if info, _ := fs.HeadObject("test-bucket", "key"); info.Metadata["X-Amz-Meta-Version"] != "1" {
+		t.Errorf("Expected the old metadata to survive, got %v", info.Metadata)
+	}
+	if entries, _ := os.ReadDir(fs.tmpDir()); len(entries) != 0 {
+		t.Errorf("Expected no temp files, got %d", len(entries))
+	}
+}
+
+func TestWriteIsNotVisibleUntilComplete(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+	fs.PutObject("test-bucket", "key", bytes.NewReader([]byte("old")), 3, nil)
+
+	reader, writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := fs.PutObject("test-bucket", "key", reader, 8, nil)
+		done <- err
+	}()
+
+	writer.Write([]byte("new "))
+	if data := readObject(t, fs, "test-bucket", "key"); data != "old" {
+		t.Errorf("Expected the old object during the upload, got %q", data)
+	}
+
+	writer.Write([]byte("data"))
+	writer.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if data := readObject(t, fs, "test-bucket", "key"); data != "new data" {
+		t.Errorf("Expected the new object, got %q", data)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+	fs.PutObject("test-bucket", "key", bytes.NewReader([]byte("old")), 3, map[string]string{"X-Amz-Meta-Version": "1"})
+	objectPath, _ := fs.objectPath("test-bucket", "key")
+
+	// A commit interrupted after its record was moved into place
+	dataPath, _ := fs.writeTemp(func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	fs.storeRecord(fs.recordPath(objectPath), newRecord(map[string]string{"X-Amz-Meta-Version": "2"}))
+	journal, _ := json.Marshal(&commit{Renames: []fileRename{
+		fs.relRename(filepath.Join(fs.tmpDir(), "gone"), fs.recordPath(objectPath)),
+		fs.relRename(dataPath, objectPath),
+	}})
+	os.WriteFile(filepath.Join(fs.tmpDir(), "write-1"+journalSuffix), journal, 0644)
+
+	// A write that never got committed, and a journal pointing outside the
+	// data directory
+	os.WriteFile(filepath.Join(fs.tmpDir(), "write-2"), []byte("partial"), 0644)
+	outside, _ := json.Marshal(&commit{Renames: []fileRename{{From: "../outside", To: "test-bucket/outside"}}})
+	os.WriteFile(filepath.Join(fs.tmpDir(), "write-3"+journalSuffix), outside, 0644)
+	os.WriteFile(filepath.Join(filepath.Dir(tempDir), "outside"), nil, 0644)
+	defer os.Remove(filepath.Join(filepath.Dir(tempDir), "outside"))
+
+	fs = NewFileSystemStorage(tempDir)
+	if err := fs.Recover(); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+
+	if data := readObject(t, fs, "test-bucket", "key"); data != "new" {
+		t.Errorf("Expected the interrupted commit to be finished, got %q", data)
+	}
+	if info, _ := fs.HeadObject("test-bucket", "key"); info.Metadata["X-Amz-Meta-Version"] != "2" {
+		t.Errorf("Expected the new metadata, got %v", info.Metadata)
+	}
+	if entries, _ := os.ReadDir(fs.tmpDir()); len(entries) != 0 {
+		t.Errorf("Expected no leftovers, got %d files", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tempDir), "outside")); err != nil {
+		t.Errorf("Expected files outside the data directory to be left alone: %v", err)
+	}
+
+	// Recovering a data directory without writes is a no-op
+	if err := NewFileSystemStorage(t.TempDir()).Recover(); err != nil {
+		t.Errorf("Failed to recover empty data directory: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemDir holds LocalS3's own state inside the data directory. Bucket names
+// cannot start with a dot, so it never collides with a bucket.
+const systemDir = ".locals3"
+
+// bucketConfig holds the per-bucket settings that live outside the bucket's
+// object namespace
+type bucketConfig struct {
+	ObjectLock        *ObjectLockConfiguration        `json:"objectLock,omitempty"`
+	Website           *WebsiteConfiguration           `json:"website,omitempty"`
+	PublicAccessBlock *PublicAccessBlockConfiguration `json:"publicAccessBlock,omitempty"`
+	Policy            string                          `json:"policy,omitempty"`
+	ACL               string                          `json:"acl,omitempty"`
+	Owner             string                          `json:"owner,omitempty"`
+	Region            string                          `json:"region,omitempty"`
+}
+
+func (fs *FileSystemStorage) bucketConfigPath(bucket string) string {
+	return filepath.Join(fs.basePath, systemDir, "buckets", bucket+".json")
+}
+
+// loadBucketConfig returns the stored configuration of a bucket, or an empty
+// configuration if none has been written yet
+func (fs *FileSystemStorage) loadBucketConfig(bucket string) (*bucketConfig, error) {
+	cfg := &bucketConfig{}
+
+	data, err := os.ReadFile(fs.bucketConfigPath(bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (fs *FileSystemStorage) storeBucketConfig(bucket string, cfg *bucketConfig) error {
+	configPath := fs.bucketConfigPath(bucket)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fs.writeFileAtomic(configPath, data)
+}
+
+func (fs *FileSystemStorage) removeBucketConfig(bucket string) {
+	os.Remove(fs.bucketConfigPath(bucket))
+}
+
+// Reserved prefixes and suffixes of bucket names
+var (
+	reservedBucketPrefixes = []string{"xn--", "sthree-", "amzn-s3-demo-"}
+	reservedBucketSuffixes = []string{"-s3alias", "--ol-s3", ".mrap", "--x-s3", "--table-s3"}
+)
+
+// ValidBucketName reports whether name follows the S3 naming rules for new
+// buckets: 3 to 63 lowercase letters, digits, dots and hyphens, starting and
+// ending with a letter or digit, without adjacent dots, not formatted as an
+// IP address and without a reserved prefix or suffix
+func ValidBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !isBucketAlnum(c) && c != '.' && c != '-' {
+			return false
+		}
+	}
+	if !isBucketAlnum(name[0]) || !isBucketAlnum(name[len(name)-1]) {
+		return false
+	}
+	if strings.Contains(name, "..") || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, prefix := range reservedBucketPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	for _, suffix := range reservedBucketSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func isBucketAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// GetBucketOwner returns the ID of the owner that created a bucket, or an
+// empty string for buckets created before owners were recorded
+func (fs *FileSystemStorage) GetBucketOwner(bucket string) (string, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return "", ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Owner, nil
+}
+
+func (fs *FileSystemStorage) PutBucketOwner(bucket, owner string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Owner = owner
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+// GetBucketLocation returns the region a bucket was created in, or an empty
+// string if none was recorded
+func (fs *FileSystemStorage) GetBucketLocation(bucket string) (string, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return "", ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Region, nil
+}
+
+func (fs *FileSystemStorage) PutBucketLocation(bucket, region string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Region = region
+	return fs.storeBucketConfig(bucket, cfg)
+}
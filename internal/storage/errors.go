@@ -0,0 +1,51 @@
+package storage
+
+import "errors"
+
+// Errors returned by storage backends. Handlers map them to S3 error codes,
+// so backends must return (or wrap) these rather than their own errors for
+// the conditions they describe.
+var (
+	// ErrInvalidBucketName is returned for bucket names that can't be used
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+
+	// ErrNoSuchBucket is returned for operations on a bucket that doesn't exist
+	ErrNoSuchBucket = errors.New("bucket does not exist")
+
+	// ErrBucketNotEmpty is returned when deleting a bucket that holds objects
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+
+	// ErrNoSuchKey is returned for objects that don't exist
+	ErrNoSuchKey = errors.New("object does not exist")
+
+	// ErrKeyTooLong is returned for keys longer than MaxKeyLength
+	ErrKeyTooLong = errors.New("object key is too long")
+
+	// ErrNoSuchUpload is returned for multipart uploads that don't exist or
+	// were already completed or aborted
+	ErrNoSuchUpload = errors.New("upload does not exist")
+
+	// ErrInvalidPart is returned when completing an upload with a part that
+	// wasn't uploaded or whose ETag doesn't match
+	ErrInvalidPart = errors.New("part does not exist or its ETag does not match")
+
+	// ErrInvalidPartOrder is returned when the parts of a completed upload are
+	// not in ascending order
+	ErrInvalidPartOrder = errors.New("parts are not in ascending order")
+
+	// ErrEntityTooSmall is returned when a part other than the last is
+	// smaller than MinPartSize
+	ErrEntityTooSmall = errors.New("part is smaller than the minimum part size")
+
+	// ErrStorageFull is returned when storing data would exceed the memory
+	// limit of a MemoryStorage
+	ErrStorageFull = errors.New("storage is full")
+
+	// ErrInvalidArgument is returned for malformed arguments, such as an
+	// unknown canned ACL or retention mode
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// MinPartSize is the smallest size of any part of a multipart upload but the
+// last
+const MinPartSize = 5 * 1024 * 1024
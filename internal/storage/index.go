@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The object index is a bbolt database at .locals3/index.db that maps the
+// keys of every bucket, in order, to the size, modification time and record
+// of their object. Writes update it under the key lock right after the file
+// system, so ListObjects and HeadObject can be served from it without
+// walking the bucket or reading records.
+//
+// The file system stays the source of truth. The index is marked clean when
+// it is closed, and rebuilt from the data files when it is opened after a
+// crash, when its format changed, or when it doesn't exist yet. If an update
+// of the index fails, it is no longer used until it has been rebuilt.
+
+// indexVersion is the version of the index format written by this build.
+// Indexes of another version are rebuilt.
+const indexVersion = 1
+
+// indexRebuildBatch is the number of objects written per transaction when
+// the index is rebuilt
+const indexRebuildBatch = 1000
+
+var (
+	// indexObjects holds a nested bucket of objects for every bucket
+	indexObjects = []byte("objects")
+
+	// indexState holds the version of the index and whether it was closed
+	// cleanly
+	indexState = []byte("state")
+
+	indexVersionKey = []byte("version")
+	indexCleanKey   = []byte("clean")
+)
+
+// objectIndex is the index of the objects of a FileSystemStorage
+type objectIndex struct {
+	db *bolt.DB
+
+	// failed is set when an update of the index failed, after which it no
+	// longer matches the file system
+	failed atomic.Bool
+}
+
+// indexEntry is the value stored in the index for an object
+type indexEntry struct {
+	Size    int64         `json:"size"`
+	ModTime time.Time     `json:"modTime"`
+	Record  *objectRecord `json:"record"`
+}
+
+func (fs *FileSystemStorage) indexPath() string {
+	return filepath.Join(fs.basePath, systemDir, "index.db")
+}
+
+// openIndexDB opens the index database, waiting a little for another
+// process that has it open
+func (fs *FileSystemStorage) openIndexDB() (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(fs.indexPath()), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(fs.indexPath(), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object index %s: %w", fs.indexPath(), err)
+	}
+	return db, nil
+}
+
+// OpenIndex opens the object index, rebuilding it first if it can't be
+// trusted, and serves listings and object lookups from it until Close. It
+// must run after Recover and MigrateMetadata, before the storage serves
+// requests. It returns whether the index was rebuilt.
+func (fs *FileSystemStorage) OpenIndex() (bool, error) {
+	db, err := fs.openIndexDB()
+	if err != nil {
+		return false, err
+	}
+
+	rebuilt := false
+	if !indexClean(db) {
+		if _, err := fs.rebuildIndex(db); err != nil {
+			db.Close()
+			return false, err
+		}
+		rebuilt = true
+	}
+
+	// Until it is closed again, the index is only clean as long as every
+	// update reaches it
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexState).Delete(indexCleanKey)
+	})
+	if err != nil {
+		db.Close()
+		return false, err
+	}
+
+	// An index left behind by a crash is rebuilt anyway, so updates needn't
+	// be synced to disk one by one
+	db.NoSync = true
+
+	fs.index = &objectIndex{db: db}
+	return rebuilt, nil
+}
+
+// Close closes the object index, marking it clean if it matches the file
+// system. It must run after the storage has stopped serving requests.
+func (fs *FileSystemStorage) Close() error {
+	index := fs.index
+	if index == nil {
+		return nil
+	}
+	fs.index = nil
+
+	if !index.failed.Load() {
+		// Committing with sync flushes the earlier updates too
+		index.db.NoSync = false
+		err := index.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(indexState).Put(indexCleanKey, []byte("true"))
+		})
+		if err != nil {
+			index.db.Close()
+			return err
+		}
+	}
+	return index.db.Close()
+}
+
+// RebuildIndex recreates the object index from the data files and returns
+// the number of objects in it. It fails if the index is in use by a running
+// server.
+func (fs *FileSystemStorage) RebuildIndex() (int, error) {
+	db, err := fs.openIndexDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	count, err := fs.rebuildIndex(db)
+	if err != nil {
+		return 0, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexState).Put(indexCleanKey, []byte("true"))
+	})
+	return count, err
+}
+
+// indexClean reports whether the index in db was closed cleanly by a build
+// writing the same format
+func indexClean(db *bolt.DB) bool {
+	clean := false
+	db.View(func(tx *bolt.Tx) error {
+		state := tx.Bucket(indexState)
+		if state == nil || tx.Bucket(indexObjects) == nil {
+			return nil
+		}
+		clean = string(state.Get(indexVersionKey)) == fmt.Sprint(indexVersion) &&
+			state.Get(indexCleanKey) != nil
+		return nil
+	})
+	return clean
+}
+
+// rebuildIndex replaces the contents of the index in db with the objects
+// found in the data directory, and returns their number
+func (fs *FileSystemStorage) rebuildIndex(db *bolt.DB) (int, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{indexObjects, indexState} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(indexState).Put(indexVersionKey, []byte(fmt.Sprint(indexVersion)))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(fs.basePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !validBucketDir(entry.Name()) {
+			continue
+		}
+		n, err := fs.rebuildBucketIndex(db, entry.Name())
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// rebuildBucketIndex adds the objects of a bucket to the index in db, and
+// returns their number
+func (fs *FileSystemStorage) rebuildBucketIndex(db *bolt.DB, bucket string) (int, error) {
+	keys, err := listKeys(filepath.Join(fs.basePath, bucket), "")
+	if err != nil {
+		return 0, err
+	}
+
+	count := len(keys)
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > indexRebuildBatch {
+			batch = batch[:indexRebuildBatch]
+		}
+		keys = keys[len(batch):]
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			objects, err := tx.Bucket(indexObjects).CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			// Keys are added in order, so pages can be filled up
+			objects.FillPercent = 1
+			for _, key := range batch {
+				entry := &indexEntry{Size: key.info.Size(), ModTime: key.info.ModTime(), Record: fs.loadRecord(key.path)}
+				if err := putIndexEntry(objects, key.key, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func putIndexEntry(objects *bolt.Bucket, key string, entry *indexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return objects.Put([]byte(key), data)
+}
+
+// indexed returns the index if it can be used
+func (fs *FileSystemStorage) indexed() *objectIndex {
+	if index := fs.index; index != nil && !index.failed.Load() {
+		return index
+	}
+	return nil
+}
+
+// indexObject records the object stored under key in the index
+func (fs *FileSystemStorage) indexObject(bucket, key string, size int64, modTime time.Time, record *objectRecord) {
+	index := fs.indexed()
+	if index == nil {
+		return
+	}
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		objects, err := tx.Bucket(indexObjects).CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return putIndexEntry(objects, key, &indexEntry{Size: size, ModTime: modTime, Record: record})
+	})
+	index.check(err)
+}
+
+// unindexObject removes the object stored under key from the index
+func (fs *FileSystemStorage) unindexObject(bucket, key string) {
+	index := fs.indexed()
+	if index == nil {
+		return
+	}
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		if objects := tx.Bucket(indexObjects).Bucket([]byte(bucket)); objects != nil {
+			return objects.Delete([]byte(key))
+		}
+		return nil
+	})
+	index.check(err)
+}
+
+// unindexBucket removes a deleted bucket from the index
+func (fs *FileSystemStorage) unindexBucket(bucket string) {
+	index := fs.indexed()
+	if index == nil {
+		return
+	}
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(indexObjects).DeleteBucket([]byte(bucket))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	index.check(err)
+}
+
+// check stops using the index if an update failed. The file system already
+// holds the change, so the operation itself has succeeded; the index is
+// rebuilt when it is next opened.
+func (index *objectIndex) check(err error) {
+	if err != nil {
+		index.failed.Store(true)
+	}
+}
+
+// lookup returns the object stored under key
+func (index *objectIndex) lookup(bucket, key string) (*ObjectInfo, error) {
+	var info *ObjectInfo
+	err := index.db.View(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(indexObjects).Bucket([]byte(bucket))
+		if objects == nil {
+			return ErrNoSuchKey
+		}
+		data := objects.Get([]byte(key))
+		if data == nil {
+			return ErrNoSuchKey
+		}
+		entry, err := decodeIndexEntry(data)
+		if err != nil {
+			return err
+		}
+		info = newObjectInfo(key, entry.Size, entry.ModTime, entry.Record)
+		return nil
+	})
+	return info, err
+}
+
+// list returns a page of the keys of a bucket, like listObjects, reading
+// only the entries that make up the page. Keys under a common prefix are
+// skipped over rather than read.
+func (index *objectIndex) list(bucket, prefix, delimiter, marker string, maxKeys int) (*ListObjectsResult, error) {
+	result := &ListObjectsResult{}
+	err := index.db.View(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(indexObjects).Bucket([]byte(bucket))
+		if objects == nil {
+			return nil
+		}
+
+		start := prefix
+		if marker > start {
+			start = marker
+		}
+		c := objects.Cursor()
+		count := 0
+		for k, v := c.Seek([]byte(start)); k != nil; {
+			key := string(k)
+			if !strings.HasPrefix(key, prefix) {
+				break
+			}
+			if marker != "" && key <= marker {
+				k, v = c.Next()
+				continue
+			}
+
+			var commonPrefix string
+			if delimiter != "" {
+				remaining := strings.TrimPrefix(key, prefix)
+				if idx := strings.Index(remaining, delimiter); idx >= 0 {
+					commonPrefix = prefix + remaining[:idx+len(delimiter)]
+				}
+			}
+			// The marker may be a prefix returned by the previous page
+			if commonPrefix != "" && marker != "" && commonPrefix <= marker {
+				k, v = seekPast(c, commonPrefix)
+				continue
+			}
+
+			if maxKeys > 0 && count == maxKeys {
+				result.IsTruncated = true
+				break
+			}
+			count++
+
+			if commonPrefix != "" {
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+				result.NextMarker = commonPrefix
+				k, v = seekPast(c, commonPrefix)
+				continue
+			}
+
+			entry, err := decodeIndexEntry(v)
+			if err != nil {
+				return err
+			}
+			result.Objects = append(result.Objects, *newObjectInfo(key, entry.Size, entry.ModTime, entry.Record))
+			result.NextMarker = key
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+	return result, nil
+}
+
+// seekPast moves the cursor to the first key that doesn't start with prefix
+// and sorts after it
+func seekPast(c *bolt.Cursor, prefix string) ([]byte, []byte) {
+	next := []byte(prefix)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i] < 0xff {
+			next[i]++
+			return c.Seek(next[:i+1])
+		}
+	}
+	// Every key starting with prefix sorts last
+	return nil, nil
+}
+
+func decodeIndexEntry(data []byte) (*indexEntry, error) {
+	entry := &indexEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("invalid object index entry: %w", err)
+	}
+	if entry.Record == nil {
+		entry.Record = newRecord(nil)
+	}
+	return entry, nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// listAll pages through a listing of a bucket one entry at a time, and
+// returns the keys and common prefixes in the order they were listed
+func listAll(t *testing.T, s Storage, bucket, prefix, delimiter string) []string {
+	var listed []string
+	marker := ""
+	for {
+		page, err := s.ListObjects(bucket, prefix, delimiter, marker, 1)
+		if err != nil {
+			t.Fatalf("Failed to list: %v", err)
+		}
+		for _, obj := range page.Objects {
+			listed = append(listed, obj.Key)
+		}
+		listed = append(listed, page.CommonPrefixes...)
+		if !page.IsTruncated {
+			return listed
+		}
+		marker = page.NextMarker
+	}
+}
+
+func TestObjectIndex(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	if rebuilt, err := fs.OpenIndex(); err != nil || !rebuilt {
+		t.Fatalf("Expected a new index to be built, got %v, %v", rebuilt, err)
+	}
+	for _, key := range []string{"logs/2", "logs/1", "b", "a", "logs\xff/x", "z/y/x"} {
+		fs.PutObject("test-bucket", key, strings.NewReader(key), int64(len(key)), nil)
+	}
+
+	if got, want := listAll(t, fs, "test-bucket", "", "/"), []string{"a", "b", "logs/", "logs\xff/", "z/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := listAll(t, fs, "test-bucket", "logs/", ""), []string{"logs/1", "logs/2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Metadata changes and deletes reach the index
+	fs.PutObjectACL("test-bucket", "a", "public-read")
+	fs.DeleteObject("test-bucket", "b", false)
+	result, _ := fs.ListObjects("test-bucket", "", "", "", 2)
+	if len(result.Objects) != 2 || result.Objects[0].Metadata[MetadataACL] != "public-read" || result.Objects[1].Key != "logs/1" {
+		t.Errorf("Unexpected listing %+v", result.Objects)
+	}
+
+	// Lookups are served from the index, not the data directory
+	os.WriteFile(filepath.Join(tempDir, "test-bucket", "outside"+objectSuffix), []byte("x"), 0644)
+	if _, err := fs.HeadObject("test-bucket", "outside"); err != ErrNoSuchKey {
+		t.Errorf("Expected files unknown to the index to be missing, got %v", err)
+	}
+	if info, err := fs.HeadObject("test-bucket", "a"); err != nil || info.Size != 1 || info.ETag == "" {
+		t.Errorf("Unexpected object %+v, %v", info, err)
+	}
+
+	// A cleanly closed index is reused, and one left open is rebuilt
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Failed to close index: %v", err)
+	}
+	if rebuilt, err := fs.OpenIndex(); err != nil || rebuilt {
+		t.Errorf("Expected the index to be reused, got %v, %v", rebuilt, err)
+	}
+	fs.index.db.Close()
+	fs.index = nil
+	if rebuilt, err := fs.OpenIndex(); err != nil || !rebuilt {
+		t.Errorf("Expected the index to be rebuilt after a crash, got %v, %v", rebuilt, err)
+	}
+	if _, err := fs.HeadObject("test-bucket", "outside"); err != nil {
+		t.Errorf("Expected the rebuilt index to hold all files, got %v", err)
+	}
+
+	// Deleted buckets leave nothing behind
+	for _, obj := range listAll(t, fs, "test-bucket", "", "") {
+		fs.DeleteObject("test-bucket", obj, false)
+	}
+	fs.DeleteBucket("test-bucket")
+	fs.CreateBucket("test-bucket")
+	if got := listAll(t, fs, "test-bucket", "", ""); len(got) != 0 {
+		t.Errorf("Expected a new bucket to be empty, got %q", got)
+	}
+	fs.Close()
+}
+
+func TestObjectIndexFailure(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+	fs.OpenIndex()
+	fs.PutObject("test-bucket", "a", strings.NewReader("a"), 1, nil)
+
+	// Once an update fails, the file system is used instead
+	fs.index.db.Close()
+	if _, err := fs.PutObject("test-bucket", "b", strings.NewReader("b"), 1, nil); err != nil {
+		t.Fatalf("Expected the write to succeed, got %v", err)
+	}
+	if got, want := listAll(t, fs, "test-bucket", "", ""), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if _, err := fs.HeadObject("test-bucket", "b"); err != nil {
+		t.Errorf("Expected the object to be found, got %v", err)
+	}
+
+	fs.Close()
+	if rebuilt, err := fs.OpenIndex(); err != nil || !rebuilt {
+		t.Errorf("Expected a failed index to be rebuilt, got %v, %v", rebuilt, err)
+	}
+	fs.Close()
+}
+
+func TestRebuildIndex(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("first")
+	fs.CreateBucket("second")
+	fs.PutObject("first", "a", strings.NewReader("a"), 1, map[string]string{"Content-Type": "text/csv"})
+	fs.PutObject("second", "dir/b", strings.NewReader("b"), 1, nil)
+	uploadID, _ := fs.InitiateMultipartUpload("second", "c", nil)
+	fs.UploadPart("second", "c", uploadID, 1, strings.NewReader("c"), 1, nil)
+
+	count, err := fs.RebuildIndex()
+	if err != nil {
+		t.Fatalf("Failed to rebuild index: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 objects to be indexed, got %d", count)
+	}
+
+	if rebuilt, err := fs.OpenIndex(); err != nil || rebuilt {
+		t.Errorf("Expected the rebuilt index to be used, got %v, %v", rebuilt, err)
+	}
+	defer fs.Close()
+	if info, err := fs.HeadObject("first", "a"); err != nil || info.ContentType != "text/csv" {
+		t.Errorf("Unexpected object %+v, %v", info, err)
+	}
+
+	// The index can't be rebuilt while it is in use
+	if _, err := NewFileSystemStorage(tempDir).RebuildIndex(); err == nil {
+		t.Error("Expected rebuilding an open index to fail")
+	}
+}
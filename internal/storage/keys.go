@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// MaxKeyLength is the longest object key S3 accepts, in bytes
+const MaxKeyLength = 1024
+
+// maxNameLength bounds the file names that keys are stored under, keeping
+// them below NAME_MAX (255 bytes) on every common file system
+const maxNameLength = 200
+
+// Object keys are stored at a path made of their "/"-separated segments, each
+// escaped into a name that can't step out of its directory or be mistaken for
+// LocalS3's own files:
+//
+//   - "%", "\", control characters, a leading "." and the "." of a
+//     ".metadata" suffix are written as %XX, so names are never "." or "..",
+//     dot files (.uploads) or metadata files
+//   - an empty segment, as in "a//b" or the folder marker "a/", is written
+//     as "%"
+//   - a segment whose escaped form is longer than maxNameLength is split
+//     over nested directories, all but the last ending in "%-"
+//   - the file holding the object ends in "%o", which no directory name
+//     does, so "a" and "a/b" can be stored side by side
+//
+// A key such as "photos/2024/cat.jpg" is stored at "photos/2024/cat.jpg%o".
+const (
+	emptySegment = "%"
+	continuation = "%-"
+	objectSuffix = "%o"
+)
+
+// metadataSuffix ends the files that held object metadata before records.
+// Keys ending in it are still escaped, so these files can be told apart from
+// objects until they are migrated.
+const metadataSuffix = ".metadata"
+
+// checkKey returns an error if key can't name an object
+func checkKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty object key", ErrInvalidArgument)
+	}
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLong
+	}
+	return nil
+}
+
+// validBucketDir reports whether a bucket name can be used as a directory
+// name inside the data directory. Names starting with a dot are reserved for
+// LocalS3's own state.
+func validBucketDir(bucket string) bool {
+	return bucket != "" && !strings.HasPrefix(bucket, ".") && !strings.ContainsAny(bucket, "/\\\x00")
+}
+
+// bucketPath returns the directory of a bucket
+func (fs *FileSystemStorage) bucketPath(bucket string) (string, error) {
+	if !validBucketDir(bucket) {
+		return "", ErrInvalidBucketName
+	}
+	return filepath.Join(fs.basePath, bucket), nil
+}
+
+// objectPath returns the file that holds key in bucket, which is always
+// inside the bucket's directory
+func (fs *FileSystemStorage) objectPath(bucket, key string) (string, error) {
+	bucketPath, err := fs.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	objectPath := filepath.Join(bucketPath, encodeKey(key))
+	if !strings.HasPrefix(objectPath, bucketPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: object key %q", ErrInvalidArgument, key)
+	}
+	return objectPath, nil
+}
+
+// encodeKey returns the path of key relative to its bucket's directory
+func encodeKey(key string) string {
+	return filepath.Join(encodeSegments(strings.Split(key, "/"))...) + objectSuffix
+}
+
+// encodeSegments returns the names that the segments of a key are stored
+// under
+func encodeSegments(segments []string) []string {
+	var names []string
+	for _, segment := range segments {
+		names = append(names, encodeSegment(segment)...)
+	}
+	return names
+}
+
+func encodeSegment(segment string) []string {
+	if segment == "" {
+		return []string{emptySegment}
+	}
+
+	var names []string
+	var name strings.Builder
+	for i := 0; i < len(segment); {
+		r, size := utf8.DecodeRuneInString(segment[i:])
+		token := segment[i : i+size]
+		if escapeRune(segment, i, r, size) {
+			token = fmt.Sprintf("%%%02X", segment[i])
+		}
+
+		// Runes and escapes are never split across names. The last name
+		// leaves room for objectSuffix, which is as long as continuation.
+		if name.Len()+len(token) > maxNameLength-len(continuation) {
+			names = append(names, name.String()+continuation)
+			name.Reset()
+		}
+		name.WriteString(token)
+		i += size
+	}
+	return append(names, name.String())
+}
+
+// escapeRune reports whether the rune r of size bytes at offset i of segment
+// must be escaped
+func escapeRune(segment string, i int, r rune, size int) bool {
+	if size != 1 {
+		return false
+	}
+	c := segment[i]
+	switch {
+	case r == utf8.RuneError:
+		// A byte that isn't valid UTF-8
+		return true
+	case c == '%' || c == '\\' || c < 0x20 || c == 0x7f:
+		return true
+	case c == '.':
+		return i == 0 || (strings.HasSuffix(segment, metadataSuffix) && i == len(segment)-len(metadataSuffix))
+	}
+	return false
+}
+
+// decodeKey returns the key stored at rel, a path relative to its bucket's
+// directory. It reports false for paths that encodeKey doesn't produce, such
+// as metadata files.
+func decodeKey(rel string) (string, bool) {
+	encoded, ok := strings.CutSuffix(filepath.ToSlash(rel), objectSuffix)
+	if !ok {
+		return "", false
+	}
+	names := strings.Split(encoded, "/")
+
+	var segments []string
+	var segment strings.Builder
+	for i, name := range names {
+		continued := strings.HasSuffix(name, continuation)
+		if continued {
+			if i == len(names)-1 {
+				return "", false
+			}
+			name = strings.TrimSuffix(name, continuation)
+		}
+
+		if name == emptySegment && !continued {
+			segments = append(segments, "")
+			continue
+		}
+		decoded, err := url.PathUnescape(name)
+		if err != nil {
+			return "", false
+		}
+		segment.WriteString(decoded)
+		if !continued {
+			segments = append(segments, segment.String())
+			segment.Reset()
+		}
+	}
+
+	key := strings.Join(segments, "/")
+	if checkKey(key) != nil || encodeKey(key) != filepath.FromSlash(rel) {
+		return "", false
+	}
+	return key, true
+}
+
+// keyDir returns the directory that holds every key starting with prefix,
+// relative to the bucket's directory
+func keyDir(prefix string) string {
+	idx := strings.LastIndex(prefix, "/")
+	if idx < 0 {
+		return ""
+	}
+	return filepath.Join(encodeSegments(strings.Split(prefix[:idx], "/"))...)
+}
+
+// isNotExist reports whether err means there is no file at a path, including
+// paths that run through a file as if it were a directory
+func isNotExist(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		path string
+	}{
+		{"object.txt", "object.txt%o"},
+		{"photos/2024/cat.jpg", "photos/2024/cat.jpg%o"},
+		{"../../etc/passwd", "%2E./%2E./etc/passwd%o"},
+		{"./a", "%2E/a%o"},
+		{"folder/", "folder/%%o"},
+		{"a//b", "a/%/b%o"},
+		{"/leading", "%/leading%o"},
+		{".uploads/1/part-1", "%2Euploads/1/part-1%o"},
+		{"object.txt.metadata", "object.txt%2Emetadata%o"},
+		{"100%", "100%25%o"},
+		{`a\b`, "a%5Cb%o"},
+		{"tab\there", "tab%09here%o"},
+		{"日本語/ファイル", "日本語/ファイル%o"},
+	}
+	for _, tt := range tests {
+		if got := encodeKey(tt.key); got != filepath.FromSlash(tt.path) {
+			t.Errorf("%q: expected %q, got %q", tt.key, tt.path, got)
+		}
+	}
+}
+
+func TestEncodeLongSegment(t *testing.T) {
+	key := strings.Repeat("a", 300) + "/" + strings.Repeat("é", 300)
+	path := encodeKey(key)
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if len(name) > maxNameLength {
+			t.Errorf("Name of %d bytes exceeds %d", len(name), maxNameLength)
+		}
+		if !strings.HasSuffix(name, continuation) && !strings.HasPrefix(name, "é") && !strings.HasPrefix(name, "a") {
+			t.Errorf("Unexpected name %q", name)
+		}
+	}
+	if decoded, ok := decodeKey(path); !ok || decoded != key {
+		t.Errorf("Long key didn't round-trip")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	keys := []string{
+		"object.txt", "../../etc/passwd", "..", ".", "/", "//", "a/", "a//b/", "%", "%-", "a%-/b",
+		".metadata", "x.metadata/y", "\x00\xff", "é", strings.Repeat("%", MaxKeyLength),
+		strings.Repeat("ab/", 300),
+	}
+	for _, key := range keys {
+		if decoded, ok := decodeKey(encodeKey(key)); !ok || decoded != key {
+			t.Errorf("%q: decoded as %q, %v", key, decoded, ok)
+		}
+	}
+
+	// Files encodeKey doesn't produce aren't objects
+	for _, path := range []string{"object.txt", "object.txt.metadata", ".uploads/1/part-1%o", "a%%o", "a%-%o", "%41%o", "%zz%o", "a%-/%%o", "a%o/b%o"} {
+		if key, ok := decodeKey(filepath.FromSlash(path)); ok {
+			t.Errorf("%q: expected no key, got %q", path, key)
+		}
+	}
+}
+
+func TestKeyContainment(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	for _, key := range []string{"../escaped", "../../escaped", "a/../../escaped", "/escaped"} {
+		if _, err := fs.PutObject("test-bucket", key, bytes.NewReader([]byte("data")), 4, nil); err != nil {
+			t.Fatalf("%q: failed to put object: %v", key, err)
+		}
+		reader, _, err := fs.GetObject("test-bucket", key)
+		if err != nil {
+			t.Fatalf("%q: failed to get object: %v", key, err)
+		}
+		reader.Close()
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "escaped"+objectSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected no file outside the bucket, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tempDir), "escaped"+objectSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected no file outside the data directory, got %v", err)
+	}
+
+	for _, bucket := range []string{"..", ".", ".locals3", "a/b", ""} {
+		if fs.BucketExists(bucket) {
+			t.Errorf("%q: expected bucket not to exist", bucket)
+		}
+		if err := fs.CreateBucket(bucket); !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("%q: expected ErrInvalidBucketName, got %v", bucket, err)
+		}
+	}
+
+	if _, err := fs.PutObject("test-bucket", strings.Repeat("k", MaxKeyLength+1), bytes.NewReader(nil), 0, nil); !errors.Is(err, ErrKeyTooLong) {
+		t.Errorf("Expected ErrKeyTooLong, got %v", err)
+	}
+}
+
+func TestListObjectsKeys(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	keys := []string{
+		"a-b", "a//b", "a/b", "a/b.metadata", ".hidden", "..", "100%", "c/",
+		strings.Repeat("x", 400), strings.Repeat("y/", 100) + "z",
+	}
+	for _, key := range keys {
+		if _, err := fs.PutObject("test-bucket", key, bytes.NewReader([]byte(key)), int64(len(key)), map[string]string{"k": "v"}); err != nil {
+			t.Fatalf("%q: failed to put object: %v", key, err)
+		}
+	}
+
+	result, err := fs.ListObjects("test-bucket", "", "", "", 1000)
+	if err != nil {
+		t.Fatalf("Failed to list objects: %v", err)
+	}
+	var listed []string
+	for _, obj := range result.Objects {
+		listed = append(listed, obj.Key)
+	}
+	want := []string{"..", ".hidden", "100%", "a-b", "a//b", "a/b", "a/b.metadata", "c/", strings.Repeat("x", 400), strings.Repeat("y/", 100) + "z"}
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("Expected keys %q, got %q", want, listed)
+	}
+
+	// Pages follow key order
+	var paged []string
+	marker := ""
+	for {
+		page, err := fs.ListObjects("test-bucket", "", "", marker, 3)
+		if err != nil {
+			t.Fatalf("Failed to list page: %v", err)
+		}
+		for _, obj := range page.Objects {
+			paged = append(paged, obj.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		marker = page.NextMarker
+	}
+	if !reflect.DeepEqual(paged, want) {
+		t.Errorf("Expected paged keys %q, got %q", want, paged)
+	}
+
+	result, err = fs.ListObjects("test-bucket", "a/", "/", "", 1000)
+	if err != nil {
+		t.Fatalf("Failed to list prefix: %v", err)
+	}
+	listed = nil
+	for _, obj := range result.Objects {
+		listed = append(listed, obj.Key)
+	}
+	if want := []string{"a/b", "a/b.metadata"}; !reflect.DeepEqual(listed, want) || !reflect.DeepEqual(result.CommonPrefixes, []string{"a//"}) {
+		t.Errorf("Unexpected listing of a/: %q %q", listed, result.CommonPrefixes)
+	}
+
+	// Common prefixes count towards max-keys and aren't repeated on the
+	// next page
+	result, _ = fs.ListObjects("test-bucket", "", "/", "", 5)
+	if !result.IsTruncated || result.NextMarker != "a/" || !reflect.DeepEqual(result.CommonPrefixes, []string{"a/"}) {
+		t.Errorf("Unexpected first page %+v", result)
+	}
+	result, _ = fs.ListObjects("test-bucket", "", "/", result.NextMarker, 5)
+	if !reflect.DeepEqual(result.CommonPrefixes, []string{"c/", "y/"}) || len(result.Objects) != 1 || result.IsTruncated {
+		t.Errorf("Unexpected second page %+v", result)
+	}
+
+	obj, err := fs.HeadObject("test-bucket", "a/b.metadata")
+	if err != nil || obj.Size != int64(len("a/b.metadata")) || obj.Metadata["k"] != "v" {
+		t.Errorf("Unexpected object %+v, %v", obj, err)
+	}
+}
+
+func TestNestedKeys(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	// A key can be the prefix of other keys, in either order of writing
+	for _, key := range []string{"a", "a/b", "c/d", "c", "c/"} {
+		if _, err := fs.PutObject("test-bucket", key, strings.NewReader(key), int64(len(key)), nil); err != nil {
+			t.Fatalf("%q: failed to put object: %v", key, err)
+		}
+	}
+	for _, key := range []string{"a", "a/b", "c", "c/", "c/d"} {
+		reader, _, err := fs.GetObject("test-bucket", key)
+		if err != nil {
+			t.Fatalf("%q: failed to get object: %v", key, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != key {
+			t.Errorf("%q: unexpected content %q", key, data)
+		}
+	}
+	if got, want := listAll(t, fs, "test-bucket", "", ""), []string{"a", "a/b", "c", "c/", "c/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if err := fs.DeleteObject("test-bucket", "a", false); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if _, err := fs.HeadObject("test-bucket", "a/b"); err != nil {
+		t.Errorf("Expected the keys below a deleted object to remain, got %v", err)
+	}
+	if _, _, err := fs.GetObject("test-bucket", "a/b/c"); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Expected ErrNoSuchKey below an object, got %v", err)
+	}
+}
+
+func TestDeleteBucketAfterDeletes(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	fs.PutObject("test-bucket", "nested/dir/object", bytes.NewReader([]byte("data")), 4, nil)
+	uploadID, _ := fs.InitiateMultipartUpload("test-bucket", "upload", map[string]string{"Content-Type": "text/plain"})
+	if err := fs.DeleteObject("test-bucket", "nested/dir/object", false); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if err := fs.DeleteBucket("test-bucket"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected in-progress uploads to keep the bucket, got %v", err)
+	}
+
+	fs.AbortMultipartUpload("test-bucket", "upload", uploadID)
+	if err := fs.DeleteBucket("test-bucket"); err != nil {
+		t.Errorf("Expected bucket with only empty directories to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+)
+
+// FileSystemStorage serializes its operations with reader/writer locks:
+//
+//   - a bucket lock, held for reading by every object operation and for
+//     writing by bucket configuration changes and by CreateBucket and
+//     DeleteBucket, so a bucket can't be deleted while objects are written
+//     to it
+//   - a key lock, held for reading by reads of an object and for writing by
+//     anything that changes it, so concurrent writes replace the object one
+//     after the other
+//   - an upload lock, held for reading by UploadPart and for writing by
+//     CompleteMultipartUpload and AbortMultipartUpload
+//   - a part lock, held by UploadPart while it replaces the data and record
+//     of a part
+//
+// Locks are taken in the order bucket, upload, part, key. Object data is written
+// to a temp file before the key lock is taken, so slow uploads don't hold up
+// readers; the lock covers checks against the current object and the commit.
+// Exported methods take the locks they need and must not call each other.
+
+// lockManager hands out reader/writer locks by name. A lock exists only
+// while it is held or waited for. The zero value is ready to use.
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*namedLock
+}
+
+type namedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// acquire returns the lock of name, counting the caller as a user
+func (m *lockManager) acquire(name string) *namedLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locks == nil {
+		m.locks = make(map[string]*namedLock)
+	}
+	l, ok := m.locks[name]
+	if !ok {
+		l = &namedLock{}
+		m.locks[name] = l
+	}
+	l.refs++
+	return l
+}
+
+// release drops the caller's use of the lock of name
+func (m *lockManager) release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.locks[name]
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, name)
+	}
+}
+
+// Lock locks name for writing and returns the function that unlocks it
+func (m *lockManager) Lock(name string) func() {
+	l := m.acquire(name)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.release(name)
+	}
+}
+
+// RLock locks name for reading and returns the function that unlocks it
+func (m *lockManager) RLock(name string) func() {
+	l := m.acquire(name)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		m.release(name)
+	}
+}
+
+// lockBucket locks a bucket for writing
+func (fs *FileSystemStorage) lockBucket(bucket string) func() {
+	return fs.bucketLocks.Lock(bucket)
+}
+
+// rlockBucket locks a bucket for reading
+func (fs *FileSystemStorage) rlockBucket(bucket string) func() {
+	return fs.bucketLocks.RLock(bucket)
+}
+
+// lockKey locks an object for writing, for callers that hold the bucket
+// lock already
+func (fs *FileSystemStorage) lockKey(bucket, key string) func() {
+	return fs.keyLocks.Lock(bucket + "/" + key)
+}
+
+// lockObject locks an object for writing, and its bucket for reading
+func (fs *FileSystemStorage) lockObject(bucket, key string) func() {
+	unlockBucket := fs.bucketLocks.RLock(bucket)
+	unlockKey := fs.lockKey(bucket, key)
+	return func() {
+		unlockKey()
+		unlockBucket()
+	}
+}
+
+// rlockObject locks an object and its bucket for reading
+func (fs *FileSystemStorage) rlockObject(bucket, key string) func() {
+	unlockBucket := fs.bucketLocks.RLock(bucket)
+	unlockKey := fs.keyLocks.RLock(bucket + "/" + key)
+	return func() {
+		unlockKey()
+		unlockBucket()
+	}
+}
+
+// lockUpload locks a multipart upload for writing
+func (fs *FileSystemStorage) lockUpload(bucket, uploadID string) func() {
+	return fs.uploadLocks.Lock(bucket + "/" + uploadID)
+}
+
+// lockPart locks a part of a multipart upload for writing, for callers that
+// hold the upload lock already
+func (fs *FileSystemStorage) lockPart(bucket, uploadID string, partNumber int) func() {
+	return fs.uploadLocks.Lock(bucket + "/" + uploadID + "/" + strconv.Itoa(partNumber))
+}
+
+// rlockUpload locks a multipart upload for reading
+func (fs *FileSystemStorage) rlockUpload(bucket, uploadID string) func() {
+	return fs.uploadLocks.RLock(bucket + "/" + uploadID)
+}
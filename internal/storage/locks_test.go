@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockManager(t *testing.T) {
+	var m lockManager
+
+	// Readers share a lock
+	unlock1 := m.RLock("a")
+	unlock2 := m.RLock("a")
+	unlock1()
+	unlock2()
+
+	// Writers exclude each other
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := m.Lock("a")
+			defer unlock()
+			n := atomic.AddInt32(&holders, 1)
+			if n > atomic.LoadInt32(&maxHolders) {
+				atomic.StoreInt32(&maxHolders, n)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Errorf("Expected one writer at a time, got %d", maxHolders)
+	}
+
+	// Other names aren't held up
+	unlock := m.Lock("a")
+	m.Lock("b")()
+	unlock()
+
+	if len(m.locks) != 0 {
+		t.Errorf("Expected released locks to be dropped, got %d", len(m.locks))
+	}
+}
+
+// writerData is the content written by writer i in the stress tests
+func writerData(i int) string {
+	return strings.Repeat(fmt.Sprintf("writer-%02d;", i), i+1)
+}
+
+// checkConsistent reads an object and fails if its data and metadata come
+// from different writes
+func checkConsistent(t *testing.T, fs *FileSystemStorage, key string) bool {
+	reader, info, err := fs.GetObject("test-bucket", key)
+	if err != nil {
+		if !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("Failed to get object: %v", err)
+		}
+		return false
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("Failed to read object: %v", err)
+		return false
+	}
+	var writer int
+	fmt.Sscanf(info.Metadata["X-Amz-Meta-Writer"], "%d", &writer)
+	if string(data) != writerData(writer) || info.Size != int64(len(data)) {
+		t.Errorf("Object data doesn't match the metadata of writer %d", writer)
+	}
+	return true
+}
+
+func TestConcurrentWritesToOneKey(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			data := writerData(i)
+			metadata := map[string]string{"X-Amz-Meta-Writer": fmt.Sprint(i)}
+			for j := 0; j < 20; j++ {
+				if _, err := fs.PutObject("test-bucket", "hot-key", strings.NewReader(data), int64(len(data)), metadata); err != nil {
+					t.Errorf("Failed to put object: %v", err)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				checkConsistent(t, fs, "hot-key")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !checkConsistent(t, fs, "hot-key") {
+		t.Error("Expected the object to exist")
+	}
+}
+
+func TestDeleteRacingPut(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			data := writerData(i)
+			metadata := map[string]string{"X-Amz-Meta-Writer": fmt.Sprint(i)}
+			for j := 0; j < 10; j++ {
+				fs.PutObject("test-bucket", "hot-key", strings.NewReader(data), int64(len(data)), metadata)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := fs.DeleteObject("test-bucket", "hot-key", false); err != nil && !errors.Is(err, ErrNoSuchKey) {
+					t.Errorf("Failed to delete object: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A surviving object still has its own metadata
+	checkConsistent(t, fs, "hot-key")
+}
+
+func TestDeleteBucketRacingPut(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	for i := 0; i < 200; i++ {
+		fs.CreateBucket("test-bucket")
+
+		var putErr, deleteErr error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, putErr = fs.PutObject("test-bucket", "key", bytes.NewReader([]byte("data")), 4, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			deleteErr = fs.DeleteBucket("test-bucket")
+		}()
+		wg.Wait()
+
+		switch {
+		case putErr == nil && deleteErr == nil:
+			t.Fatal("Expected the bucket not to be deleted with an object in it")
+		case putErr == nil:
+			if !errors.Is(deleteErr, ErrBucketNotEmpty) {
+				t.Fatalf("Expected ErrBucketNotEmpty, got %v", deleteErr)
+			}
+			if !fs.ObjectExists("test-bucket", "key") {
+				t.Fatal("Expected the object to exist")
+			}
+			fs.DeleteObject("test-bucket", "key", false)
+			fs.DeleteBucket("test-bucket")
+		case !errors.Is(putErr, ErrNoSuchBucket):
+			t.Fatalf("Expected ErrNoSuchBucket, got %v", putErr)
+		}
+	}
+}
+
+func TestConcurrentMetadataUpdates(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	setupLockedBucket(t, fs, "locked")
+	putTestObject(t, fs, "locked", "doc.txt", nil)
+
+	// Each update rewrites the whole metadata file, so without the key lock
+	// one of two concurrent updates gets lost
+	acls := []string{"private", "public-read"}
+	holds := []string{"ON", "OFF"}
+	for i := 0; i < 200; i++ {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := fs.PutObjectACL("locked", "doc.txt", acls[i%2]); err != nil {
+				t.Errorf("Failed to put ACL: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := fs.PutObjectLegalHold("locked", "doc.txt", holds[i%2]); err != nil {
+				t.Errorf("Failed to put legal hold: %v", err)
+			}
+		}()
+		wg.Wait()
+
+		acl, _ := fs.GetObjectACL("locked", "doc.txt")
+		hold, _ := fs.GetObjectLegalHold("locked", "doc.txt")
+		if acl != acls[i%2] || hold != holds[i%2] {
+			t.Fatalf("Round %d: expected %s and %s, got %s and %s", i, acls[i%2], holds[i%2], acl, hold)
+		}
+	}
+}
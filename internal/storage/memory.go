@@ -0,0 +1,795 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage implements the Storage interface in memory, for tests and
+// throwaway servers. It behaves like FileSystemStorage.
+type MemoryStorage struct {
+	// limit is the most object and part data held at once, or 0 for no limit
+	limit int64
+
+	mu      sync.RWMutex
+	used    int64
+	buckets map[string]*memoryBucket
+	uploads int64
+}
+
+type memoryBucket struct {
+	created time.Time
+
+	// config is the encoded bucketConfig, so that callers never share its
+	// values with the storage
+	config  []byte
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+	record  *objectRecord
+}
+
+type memoryUpload struct {
+	record *objectRecord
+	parts  map[int]*memoryPart
+}
+
+type memoryPart struct {
+	data   []byte
+	etag   string
+	sum    []byte
+	record *objectRecord
+}
+
+// NewMemoryStorage creates an empty in-memory storage backend that holds at
+// most limit bytes of object data, or any amount if limit is 0
+func NewMemoryStorage(limit int64) *MemoryStorage {
+	return &MemoryStorage{
+		limit:   limit,
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// bucket returns a bucket. The caller must hold mu.
+func (ms *MemoryStorage) bucket(bucket string) (*memoryBucket, error) {
+	b, ok := ms.buckets[bucket]
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+	return b, nil
+}
+
+// object returns an object. The caller must hold mu.
+func (ms *MemoryStorage) object(bucket, key string) (*memoryObject, error) {
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return obj, nil
+}
+
+// upload returns a multipart upload. The caller must hold mu.
+func (ms *MemoryStorage) upload(bucket, uploadID string) (*memoryUpload, error) {
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	upload, ok := b.uploads[uploadID]
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// reserve accounts for size more bytes of data, failing with ErrStorageFull
+// if that exceeds the limit. The caller must hold mu.
+func (ms *MemoryStorage) reserve(size int64) error {
+	if ms.limit > 0 && ms.used+size > ms.limit {
+		return ErrStorageFull
+	}
+	ms.used += size
+	return nil
+}
+
+// readData reads the data of an object or part, up to the limit. It doesn't
+// hold mu, so that slow uploads don't hold up other requests.
+func (ms *MemoryStorage) readData(data io.Reader) ([]byte, error) {
+	if ms.limit > 0 {
+		data = io.LimitReader(data, ms.limit+1)
+	}
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if ms.limit > 0 && int64(len(buf)) > ms.limit {
+		return nil, ErrStorageFull
+	}
+	return buf, nil
+}
+
+func (ms *MemoryStorage) CreateBucket(bucket string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ValidBucketName(bucket) {
+		return ErrInvalidBucketName
+	}
+	if _, ok := ms.buckets[bucket]; !ok {
+		ms.buckets[bucket] = &memoryBucket{
+			created: time.Now(),
+			objects: make(map[string]*memoryObject),
+			uploads: make(map[string]*memoryUpload),
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) DeleteBucket(bucket string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	// In-progress uploads keep a bucket, as they do on disk
+	if len(b.objects) > 0 || len(b.uploads) > 0 {
+		return ErrBucketNotEmpty
+	}
+	delete(ms.buckets, bucket)
+	return nil
+}
+
+func (ms *MemoryStorage) ListBuckets() ([]BucketInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	buckets := make([]BucketInfo, 0, len(ms.buckets))
+	for name, b := range ms.buckets {
+		buckets = append(buckets, BucketInfo{Name: name, CreationDate: b.created})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets, nil
+}
+
+func (ms *MemoryStorage) BucketExists(bucket string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.buckets[bucket]
+	return ok
+}
+
+// loadBucketConfig returns a copy of the configuration of a bucket. The
+// caller must hold mu.
+func (ms *MemoryStorage) loadBucketConfig(bucket string) (*bucketConfig, error) {
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &bucketConfig{}
+	if b.config != nil {
+		if err := json.Unmarshal(b.config, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// storeBucketConfig replaces the configuration of a bucket. The caller must
+// hold mu.
+func (ms *MemoryStorage) storeBucketConfig(bucket string, cfg *bucketConfig) error {
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	b.config = data
+	return nil
+}
+
+// getBucketConfig returns a copy of the configuration of a bucket
+func (ms *MemoryStorage) getBucketConfig(bucket string) (*bucketConfig, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.loadBucketConfig(bucket)
+}
+
+// updateBucketConfig changes the configuration of a bucket with update
+func (ms *MemoryStorage) updateBucketConfig(bucket string, update func(cfg *bucketConfig) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cfg, err := ms.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	if err := update(cfg); err != nil {
+		return err
+	}
+	return ms.storeBucketConfig(bucket, cfg)
+}
+
+func (ms *MemoryStorage) GetBucketOwner(bucket string) (string, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Owner, nil
+}
+
+func (ms *MemoryStorage) PutBucketOwner(bucket, owner string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		cfg.Owner = owner
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) GetBucketLocation(bucket string) (string, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Region, nil
+}
+
+func (ms *MemoryStorage) PutBucketLocation(bucket, region string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		cfg.Region = region
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) PutObject(bucket, key string, data io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	if !ms.BucketExists(bucket) {
+		return nil, ErrNoSuchBucket
+	}
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	buf, err := ms.readData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// The bucket may have been deleted in the meantime
+	cfg, err := ms.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	b := ms.buckets[bucket]
+
+	// Locked objects may not be overwritten
+	var oldSize int64
+	if old, ok := b.objects[key]; ok {
+		if err := checkLocked(old.record.metadata(), false); err != nil {
+			return nil, err
+		}
+		oldSize = int64(len(old.data))
+	}
+
+	if err := ms.reserve(int64(len(buf)) - oldSize); err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	setDefaultRetention(cfg.ObjectLock, metadata)
+
+	record := newRecord(metadata)
+	sum := md5.Sum(buf)
+	record.ETag = hex.EncodeToString(sum[:])
+	record.setMD5(sum[:])
+	obj := &memoryObject{data: buf, modTime: time.Now(), record: record}
+	b.objects[key] = obj
+
+	return newObjectInfo(key, int64(len(buf)), obj.modTime, record), nil
+}
+
+func (ms *MemoryStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	obj, err := ms.object(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Object data is replaced rather than changed, so it can be read after
+	// the lock is released
+	return io.NopCloser(bytes.NewReader(obj.data)), newObjectInfo(key, int64(len(obj.data)), obj.modTime, obj.record), nil
+}
+
+func (ms *MemoryStorage) DeleteObject(bucket, key string, bypassGovernance bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	obj, err := ms.object(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := checkLocked(obj.record.metadata(), bypassGovernance); err != nil {
+		return err
+	}
+
+	delete(ms.buckets[bucket].objects, key)
+	ms.used -= int64(len(obj.data))
+	return nil
+}
+
+func (ms *MemoryStorage) ListObjects(bucket, prefix, delimiter, marker string, maxKeys int) (*ListObjectsResult, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return listObjects(keys, prefix, delimiter, marker, maxKeys, func(i int) ObjectInfo {
+		obj := b.objects[keys[i]]
+		return *newObjectInfo(keys[i], int64(len(obj.data)), obj.modTime, obj.record)
+	}), nil
+}
+
+func (ms *MemoryStorage) HeadObject(bucket, key string) (*ObjectInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	obj, err := ms.object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(key, int64(len(obj.data)), obj.modTime, obj.record), nil
+}
+
+func (ms *MemoryStorage) ObjectExists(bucket, key string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, err := ms.object(bucket, key)
+	return err == nil
+}
+
+func (ms *MemoryStorage) InitiateMultipartUpload(bucket, key string, metadata map[string]string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	b, err := ms.bucket(bucket)
+	if err != nil {
+		return "", err
+	}
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	// Upload IDs look like those of FileSystemStorage, but never repeat
+	ms.uploads++
+	uploadID := strconv.FormatInt(time.Now().UnixNano(), 10) + strconv.FormatInt(ms.uploads, 10)
+	b.uploads[uploadID] = &memoryUpload{
+		record: newRecord(metadata),
+		parts:  make(map[int]*memoryPart),
+	}
+	return uploadID, nil
+}
+
+func (ms *MemoryStorage) GetMultipartUploadMetadata(bucket, key, uploadID string) (map[string]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	upload, err := ms.upload(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return upload.record.metadata(), nil
+}
+
+func (ms *MemoryStorage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64, metadata map[string]string) (*PartInfo, error) {
+	ms.mu.RLock()
+	_, err := ms.upload(bucket, uploadID)
+	ms.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ms.readData(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(buf)
+	part := &memoryPart{data: buf, etag: fmt.Sprintf("\"%x\"", sum), sum: sum[:], record: newRecord(metadata)}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// The upload may have been completed or aborted in the meantime
+	upload, err := ms.upload(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	var oldSize int64
+	if old, ok := upload.parts[partNumber]; ok {
+		oldSize = int64(len(old.data))
+	}
+	if err := ms.reserve(int64(len(buf)) - oldSize); err != nil {
+		return nil, err
+	}
+	upload.parts[partNumber] = part
+
+	return &PartInfo{
+		PartNumber: partNumber,
+		ETag:       part.etag,
+		Size:       int64(len(buf)),
+	}, nil
+}
+
+func (ms *MemoryStorage) CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletePart) (*ObjectInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	upload, err := ms.upload(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMemoryParts(upload, parts); err != nil {
+		return nil, err
+	}
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	b := ms.buckets[bucket]
+
+	// Locked objects may not be overwritten
+	var oldSize int64
+	if old, ok := b.objects[key]; ok {
+		if err := checkLocked(old.record.metadata(), false); err != nil {
+			return nil, err
+		}
+		oldSize = int64(len(old.data))
+	}
+
+	var data []byte
+	var sums []byte
+	layout := make([]string, 0, len(parts))
+	for _, completed := range parts {
+		part := upload.parts[completed.PartNumber]
+		data = append(data, part.data...)
+		sums = append(sums, part.sum...)
+		layout = append(layout, strconv.Itoa(completed.PartNumber)+":"+strconv.Itoa(len(part.data)))
+	}
+
+	// Carry the metadata given at initiation over to the assembled object
+	metadata := upload.record.metadata()
+	metadata[MetadataParts] = strings.Join(layout, ",")
+	for _, completed := range parts {
+		for name, value := range upload.parts[completed.PartNumber].record.metadata() {
+			metadata[PartMetadataName(name, completed.PartNumber)] = value
+		}
+	}
+	cfg, err := ms.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	setDefaultRetention(cfg.ObjectLock, metadata)
+
+	// Like S3, the ETag of an assembled object is the digest of the digests
+	// of its parts, followed by the number of parts
+	record := newRecord(metadata)
+	record.ETag = fmt.Sprintf("%x-%d", md5.Sum(sums), len(parts))
+	sum := md5.Sum(data)
+	record.setMD5(sum[:])
+	obj := &memoryObject{data: data, modTime: time.Now(), record: record}
+
+	// The assembled object takes the place of the parts
+	var uploaded int64
+	for _, part := range upload.parts {
+		uploaded += int64(len(part.data))
+	}
+	ms.used += int64(len(data)) - oldSize - uploaded
+	b.objects[key] = obj
+	delete(b.uploads, uploadID)
+
+	return newObjectInfo(key, int64(len(data)), obj.modTime, record), nil
+}
+
+// checkMemoryParts validates the part list of a completed upload like
+// checkParts does for uploads on disk
+func checkMemoryParts(upload *memoryUpload, parts []CompletePart) error {
+	for i := 1; i < len(parts); i++ {
+		if parts[i].PartNumber <= parts[i-1].PartNumber {
+			return ErrInvalidPartOrder
+		}
+	}
+
+	for i, completed := range parts {
+		part, ok := upload.parts[completed.PartNumber]
+		if !ok {
+			return fmt.Errorf("%w: part %d", ErrInvalidPart, completed.PartNumber)
+		}
+		if completed.ETag != "" && strings.Trim(completed.ETag, `"`) != strings.Trim(part.etag, `"`) {
+			return fmt.Errorf("%w: part %d", ErrInvalidPart, completed.PartNumber)
+		}
+		if i < len(parts)-1 && len(part.data) < MinPartSize {
+			return fmt.Errorf("%w: part %d", ErrEntityTooSmall, completed.PartNumber)
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	upload, err := ms.upload(bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	for _, part := range upload.parts {
+		ms.used -= int64(len(part.data))
+	}
+	delete(ms.buckets[bucket].uploads, uploadID)
+	return nil
+}
+
+func (ms *MemoryStorage) EnableObjectLock(bucket string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		if cfg.ObjectLock == nil {
+			cfg.ObjectLock = &ObjectLockConfiguration{}
+		}
+		cfg.ObjectLock.Enabled = true
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) GetObjectLockConfiguration(bucket string) (*ObjectLockConfiguration, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ObjectLock == nil {
+		return &ObjectLockConfiguration{}, nil
+	}
+	return cfg.ObjectLock, nil
+}
+
+func (ms *MemoryStorage) PutObjectLockConfiguration(bucket string, lockConfig *ObjectLockConfiguration) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		if cfg.ObjectLock == nil || !cfg.ObjectLock.Enabled {
+			return ErrObjectLockNotEnabled
+		}
+		if lockConfig.DefaultRetention != nil {
+			if err := lockConfig.DefaultRetention.Validate(); err != nil {
+				return err
+			}
+		}
+		cfg.ObjectLock = &ObjectLockConfiguration{
+			Enabled:          true,
+			DefaultRetention: lockConfig.DefaultRetention,
+		}
+		return nil
+	})
+}
+
+// objectLockObject returns an object in a bucket that has object lock
+// enabled. The caller must hold mu.
+func (ms *MemoryStorage) objectLockObject(bucket, key string) (*memoryObject, error) {
+	cfg, err := ms.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ObjectLock == nil || !cfg.ObjectLock.Enabled {
+		return nil, ErrObjectLockNotEnabled
+	}
+	return ms.object(bucket, key)
+}
+
+// updateObjectLockMetadata changes the metadata of an object in a bucket
+// that has object lock enabled with update
+func (ms *MemoryStorage) updateObjectLockMetadata(bucket, key string, update func(metadata map[string]string) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	obj, err := ms.objectLockObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	metadata := obj.record.metadata()
+	if err := update(metadata); err != nil {
+		return err
+	}
+	obj.record.setMetadata(metadata)
+	return nil
+}
+
+func (ms *MemoryStorage) GetObjectRetention(bucket, key string) (*ObjectRetention, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	obj, err := ms.objectLockObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return retentionFromMetadata(obj.record.metadata()), nil
+}
+
+func (ms *MemoryStorage) PutObjectRetention(bucket, key string, retention *ObjectRetention, bypassGovernance bool) error {
+	return ms.updateObjectLockMetadata(bucket, key, func(metadata map[string]string) error {
+		return updateRetention(metadata, retention, bypassGovernance)
+	})
+}
+
+func (ms *MemoryStorage) GetObjectLegalHold(bucket, key string) (string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	obj, err := ms.objectLockObject(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return obj.record.metadata()[MetadataObjectLockLegalHold], nil
+}
+
+func (ms *MemoryStorage) PutObjectLegalHold(bucket, key, status string) error {
+	return ms.updateObjectLockMetadata(bucket, key, func(metadata map[string]string) error {
+		return updateLegalHold(metadata, status)
+	})
+}
+
+func (ms *MemoryStorage) GetBucketWebsite(bucket string) (*WebsiteConfiguration, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Website, nil
+}
+
+func (ms *MemoryStorage) PutBucketWebsite(bucket string, website *WebsiteConfiguration) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		if err := website.Validate(); err != nil {
+			return err
+		}
+		cfg.Website = website
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) DeleteBucketWebsite(bucket string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		cfg.Website = nil
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) GetPublicAccessBlock(bucket string) (*PublicAccessBlockConfiguration, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.PublicAccessBlock, nil
+}
+
+func (ms *MemoryStorage) PutPublicAccessBlock(bucket string, block *PublicAccessBlockConfiguration) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		cfg.PublicAccessBlock = block
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) DeletePublicAccessBlock(bucket string) error {
+	return ms.PutPublicAccessBlock(bucket, nil)
+}
+
+func (ms *MemoryStorage) GetBucketPolicy(bucket string) (string, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Policy, nil
+}
+
+func (ms *MemoryStorage) PutBucketPolicy(bucket, policy string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		cfg.Policy = policy
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) DeleteBucketPolicy(bucket string) error {
+	return ms.PutBucketPolicy(bucket, "")
+}
+
+func (ms *MemoryStorage) GetBucketACL(bucket string) (string, error) {
+	cfg, err := ms.getBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+	if cfg.ACL == "" {
+		return "private", nil
+	}
+	return cfg.ACL, nil
+}
+
+func (ms *MemoryStorage) PutBucketACL(bucket, acl string) error {
+	return ms.updateBucketConfig(bucket, func(cfg *bucketConfig) error {
+		if !ValidCannedACL(acl) {
+			return fmt.Errorf("%w: invalid canned ACL %q", ErrInvalidArgument, acl)
+		}
+		cfg.ACL = acl
+		return nil
+	})
+}
+
+func (ms *MemoryStorage) GetObjectACL(bucket, key string) (string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	obj, err := ms.object(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if acl := obj.record.metadata()[MetadataACL]; acl != "" {
+		return acl, nil
+	}
+	return "private", nil
+}
+
+func (ms *MemoryStorage) PutObjectACL(bucket, key, acl string) error {
+	if !ValidCannedACL(acl) {
+		return fmt.Errorf("%w: invalid canned ACL %q", ErrInvalidArgument, acl)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	obj, err := ms.object(bucket, key)
+	if err != nil {
+		// Like on disk, objects of missing buckets are missing objects
+		if errors.Is(err, ErrNoSuchBucket) {
+			return ErrNoSuchKey
+		}
+		return err
+	}
+	metadata := obj.record.metadata()
+	metadata[MetadataACL] = acl
+	obj.record.setMetadata(metadata)
+	return nil
+}
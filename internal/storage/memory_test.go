@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBackends returns a fresh instance of every backend, so that tests can
+// check they behave the same
+func testBackends(t *testing.T) map[string]Storage {
+	indexed := NewFileSystemStorage(t.TempDir())
+	if _, err := indexed.OpenIndex(); err != nil {
+		t.Fatalf("Failed to open index: %v", err)
+	}
+	t.Cleanup(func() { indexed.Close() })
+
+	return map[string]Storage{
+		"filesystem": NewFileSystemStorage(t.TempDir()),
+		"indexed":    indexed,
+		"memory":     NewMemoryStorage(0),
+	}
+}
+
+func TestBackendsListObjects(t *testing.T) {
+	keys := []string{"b", "a/2", "a/1", "c/d/e", "é", "A", "a-b", "c/"}
+	for name, s := range testBackends(t) {
+		s.CreateBucket("test-bucket")
+		for _, key := range keys {
+			if _, err := s.PutObject("test-bucket", key, strings.NewReader(key), int64(len(key)), nil); err != nil {
+				t.Fatalf("%s: failed to put %q: %v", name, key, err)
+			}
+		}
+
+		var listed, prefixes []string
+		marker := ""
+		for {
+			page, err := s.ListObjects("test-bucket", "", "/", marker, 2)
+			if err != nil {
+				t.Fatalf("%s: failed to list: %v", name, err)
+			}
+			for _, obj := range page.Objects {
+				listed = append(listed, obj.Key)
+			}
+			prefixes = append(prefixes, page.CommonPrefixes...)
+			if !page.IsTruncated {
+				break
+			}
+			marker = page.NextMarker
+		}
+		if want := []string{"A", "a-b", "b", "é"}; !reflect.DeepEqual(listed, want) {
+			t.Errorf("%s: expected objects %q, got %q", name, want, listed)
+		}
+		if want := []string{"a/", "c/"}; !reflect.DeepEqual(prefixes, want) {
+			t.Errorf("%s: expected prefixes %q, got %q", name, want, prefixes)
+		}
+
+		result, _ := s.ListObjects("test-bucket", "a/", "", "", 1000)
+		if len(result.Objects) != 2 || result.Objects[0].Key != "a/1" || result.Objects[0].Size != 3 || result.IsTruncated {
+			t.Errorf("%s: unexpected listing of a/: %+v", name, result)
+		}
+	}
+}
+
+func TestBackendsObjects(t *testing.T) {
+	for name, s := range testBackends(t) {
+		if _, err := s.PutObject("test-bucket", "key", strings.NewReader("x"), 1, nil); !errors.Is(err, ErrNoSuchBucket) {
+			t.Errorf("%s: expected ErrNoSuchBucket, got %v", name, err)
+		}
+		if err := s.CreateBucket("Invalid_Bucket"); !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("%s: expected ErrInvalidBucketName, got %v", name, err)
+		}
+		s.CreateBucket("test-bucket")
+
+		metadata := map[string]string{"Content-Type": "text/csv", "X-Amz-Meta-Note": "a\nb"}
+		info, err := s.PutObject("test-bucket", "data.txt", strings.NewReader("hello"), 5, metadata)
+		if err != nil {
+			t.Fatalf("%s: failed to put object: %v", name, err)
+		}
+		if info.ETag != `"5d41402abc4b2a76b9719d911017c592"` || info.ContentType != "text/csv" {
+			t.Errorf("%s: unexpected object %+v", name, info)
+		}
+
+		reader, got, err := s.GetObject("test-bucket", "data.txt")
+		if err != nil {
+			t.Fatalf("%s: failed to get object: %v", name, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != "hello" || !reflect.DeepEqual(got.Metadata, metadata) || got.ETag != info.ETag || got.Size != 5 {
+			t.Errorf("%s: unexpected object %q %+v", name, data, got)
+		}
+
+		// Returned metadata isn't shared with the stored object
+		got.Metadata["X-Amz-Meta-Note"] = "changed"
+		if head, _ := s.HeadObject("test-bucket", "data.txt"); head.Metadata["X-Amz-Meta-Note"] != "a\nb" {
+			t.Errorf("%s: expected stored metadata to be unchanged, got %v", name, head.Metadata)
+		}
+
+		if err := s.PutObjectACL("test-bucket", "data.txt", "public-read"); err != nil {
+			t.Fatalf("%s: failed to put ACL: %v", name, err)
+		}
+		if acl, _ := s.GetObjectACL("test-bucket", "data.txt"); acl != "public-read" {
+			t.Errorf("%s: expected public-read, got %q", name, acl)
+		}
+		if err := s.PutObjectACL("test-bucket", "missing", "private"); !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("%s: expected ErrNoSuchKey, got %v", name, err)
+		}
+
+		if _, err := s.HeadObject("test-bucket", strings.Repeat("k", MaxKeyLength+1)); !errors.Is(err, ErrKeyTooLong) {
+			t.Errorf("%s: expected ErrKeyTooLong, got %v", name, err)
+		}
+		if _, _, err := s.GetObject("test-bucket", "missing"); !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("%s: expected ErrNoSuchKey, got %v", name, err)
+		}
+		if err := s.DeleteBucket("test-bucket"); !errors.Is(err, ErrBucketNotEmpty) {
+			t.Errorf("%s: expected ErrBucketNotEmpty, got %v", name, err)
+		}
+		if err := s.DeleteObject("test-bucket", "data.txt", false); err != nil {
+			t.Errorf("%s: failed to delete object: %v", name, err)
+		}
+		if s.ObjectExists("test-bucket", "data.txt") {
+			t.Errorf("%s: expected the object to be deleted", name)
+		}
+		if err := s.DeleteObject("test-bucket", "data.txt", false); !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("%s: expected ErrNoSuchKey, got %v", name, err)
+		}
+		if err := s.DeleteBucket("test-bucket"); err != nil {
+			t.Errorf("%s: failed to delete bucket: %v", name, err)
+		}
+		if buckets, _ := s.ListBuckets(); len(buckets) != 0 {
+			t.Errorf("%s: expected no buckets, got %v", name, buckets)
+		}
+	}
+}
+
+func TestBackendsMultipart(t *testing.T) {
+	for name, s := range testBackends(t) {
+		s.CreateBucket("test-bucket")
+		if _, err := s.UploadPart("test-bucket", "big", "missing", 1, strings.NewReader("x"), 1, nil); !errors.Is(err, ErrNoSuchUpload) {
+			t.Errorf("%s: expected ErrNoSuchUpload, got %v", name, err)
+		}
+
+		uploadID, err := s.InitiateMultipartUpload("test-bucket", "big", map[string]string{"Content-Type": "video/mp4"})
+		if err != nil {
+			t.Fatalf("%s: failed to initiate upload: %v", name, err)
+		}
+		part1 := bytes.Repeat([]byte("a"), MinPartSize)
+		info1, _ := s.UploadPart("test-bucket", "big", uploadID, 1, bytes.NewReader(part1), int64(len(part1)), map[string]string{"X-Locals3-Note": "one"})
+		s.UploadPart("test-bucket", "big", uploadID, 2, strings.NewReader("tail"), 4, map[string]string{"X-Locals3-Note": "replaced"})
+		info2, _ := s.UploadPart("test-bucket", "big", uploadID, 2, strings.NewReader("tail"), 4, nil)
+		small, _ := s.UploadPart("test-bucket", "big", uploadID, 3, strings.NewReader("small"), 5, nil)
+		if info2.ETag != `"7aea2552dfe7eb84b9443b6fc9ba6e01"` {
+			t.Errorf("%s: expected the MD5 of the part as its ETag, got %s", name, info2.ETag)
+		}
+
+		tests := []struct {
+			parts []CompletePart
+			err   error
+		}{
+			{[]CompletePart{{2, info2.ETag}, {1, info1.ETag}}, ErrInvalidPartOrder},
+			{[]CompletePart{{4, ""}}, ErrInvalidPart},
+			{[]CompletePart{{1, `"0"`}}, ErrInvalidPart},
+			{[]CompletePart{{2, info2.ETag}, {3, small.ETag}}, ErrEntityTooSmall},
+		}
+		for _, tt := range tests {
+			if _, err := s.CompleteMultipartUpload("test-bucket", "big", uploadID, tt.parts); !errors.Is(err, tt.err) {
+				t.Errorf("%s: %v: expected %v, got %v", name, tt.parts, tt.err, err)
+			}
+		}
+
+		info, err := s.CompleteMultipartUpload("test-bucket", "big", uploadID, []CompletePart{{1, info1.ETag}, {2, info2.ETag}})
+		if err != nil {
+			t.Fatalf("%s: failed to complete upload: %v", name, err)
+		}
+		if info.Size != int64(len(part1))+4 || !strings.HasSuffix(info.ETag, `-2"`) || info.ContentType != "video/mp4" {
+			t.Errorf("%s: unexpected object %+v", name, info)
+		}
+		if head, _ := s.HeadObject("test-bucket", "big"); head.ETag != info.ETag || head.Metadata[MetadataParts] != "1:5242880,2:4" {
+			t.Errorf("%s: unexpected object %+v", name, head)
+		}
+		if head, _ := s.HeadObject("test-bucket", "big"); head.Metadata["X-Locals3-Note-1"] != "one" || head.Metadata["X-Locals3-Note-2"] != "" {
+			t.Errorf("%s: expected the metadata of the parts, got %v", name, head.Metadata)
+		}
+		if err := s.AbortMultipartUpload("test-bucket", "big", uploadID); !errors.Is(err, ErrNoSuchUpload) {
+			t.Errorf("%s: expected the upload to be gone, got %v", name, err)
+		}
+	}
+}
+
+func TestBackendsObjectLock(t *testing.T) {
+	for name, s := range testBackends(t) {
+		s.CreateBucket("locked")
+		if _, err := s.GetObjectLegalHold("locked", "doc"); !errors.Is(err, ErrObjectLockNotEnabled) {
+			t.Errorf("%s: expected ErrObjectLockNotEnabled, got %v", name, err)
+		}
+		s.EnableObjectLock("locked")
+		s.PutObjectLockConfiguration("locked", &ObjectLockConfiguration{DefaultRetention: &DefaultRetention{Mode: RetentionModeGovernance, Days: 1}})
+
+		s.PutObject("locked", "doc", strings.NewReader("v1"), 2, nil)
+		retention, err := s.GetObjectRetention("locked", "doc")
+		if err != nil || retention == nil || retention.Mode != RetentionModeGovernance || retention.RetainUntilDate.Before(time.Now()) {
+			t.Errorf("%s: expected default retention, got %+v, %v", name, retention, err)
+		}
+		if err := s.DeleteObject("locked", "doc", false); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("%s: expected ErrObjectLocked, got %v", name, err)
+		}
+		if err := s.PutObjectRetention("locked", "doc", &ObjectRetention{}, false); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("%s: expected ErrObjectLocked, got %v", name, err)
+		}
+		if err := s.PutObjectLegalHold("locked", "doc", "ON"); err != nil {
+			t.Errorf("%s: failed to put legal hold: %v", name, err)
+		}
+		if err := s.DeleteObject("locked", "doc", true); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("%s: expected the legal hold to protect the object, got %v", name, err)
+		}
+		s.PutObjectLegalHold("locked", "doc", "OFF")
+		if err := s.DeleteObject("locked", "doc", true); err != nil {
+			t.Errorf("%s: expected bypassing governance to delete the object, got %v", name, err)
+		}
+	}
+}
+
+func TestBackendsBucketConfig(t *testing.T) {
+	for name, s := range testBackends(t) {
+		s.CreateBucket("test-bucket")
+
+		website := &WebsiteConfiguration{IndexDocument: "index.html"}
+		if err := s.PutBucketWebsite("test-bucket", website); err != nil {
+			t.Fatalf("%s: failed to put website: %v", name, err)
+		}
+		website.IndexDocument = "changed.html"
+		if got, _ := s.GetBucketWebsite("test-bucket"); got.IndexDocument != "index.html" {
+			t.Errorf("%s: expected the stored website to be unchanged, got %+v", name, got)
+		}
+		if err := s.PutBucketWebsite("test-bucket", &WebsiteConfiguration{}); err == nil {
+			t.Errorf("%s: expected an invalid website to be rejected", name)
+		}
+
+		if err := s.PutBucketACL("test-bucket", "bogus"); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: expected ErrInvalidArgument, got %v", name, err)
+		}
+		if acl, _ := s.GetBucketACL("test-bucket"); acl != "private" {
+			t.Errorf("%s: expected private, got %q", name, acl)
+		}
+		s.PutBucketPolicy("test-bucket", `{"Statement":[]}`)
+		s.PutBucketOwner("test-bucket", "owner-id")
+		s.PutBucketLocation("test-bucket", "eu-west-1")
+		policy, _ := s.GetBucketPolicy("test-bucket")
+		owner, _ := s.GetBucketOwner("test-bucket")
+		region, _ := s.GetBucketLocation("test-bucket")
+		if policy != `{"Statement":[]}` || owner != "owner-id" || region != "eu-west-1" {
+			t.Errorf("%s: unexpected configuration %q %q %q", name, policy, owner, region)
+		}
+
+		// Deleting a bucket drops its configuration
+		s.DeleteBucket("test-bucket")
+		s.CreateBucket("test-bucket")
+		if policy, _ := s.GetBucketPolicy("test-bucket"); policy != "" {
+			t.Errorf("%s: expected no policy on the new bucket, got %q", name, policy)
+		}
+	}
+}
+
+func TestMemoryStorageLimit(t *testing.T) {
+	s := NewMemoryStorage(10)
+	s.CreateBucket("test-bucket")
+
+	if _, err := s.PutObject("test-bucket", "a", strings.NewReader("123456"), 6, nil); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if _, err := s.PutObject("test-bucket", "b", strings.NewReader("123456"), 6, nil); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull, got %v", err)
+	}
+	if _, err := s.PutObject("test-bucket", "c", strings.NewReader("12345678901"), 11, nil); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull for an object over the limit, got %v", err)
+	}
+
+	// Replacing an object frees its old data
+	if _, err := s.PutObject("test-bucket", "a", strings.NewReader("1234567890"), 10, nil); err != nil {
+		t.Errorf("Expected the replacement to fit, got %v", err)
+	}
+	s.DeleteObject("test-bucket", "a", false)
+
+	uploadID, _ := s.InitiateMultipartUpload("test-bucket", "big", nil)
+	s.UploadPart("test-bucket", "big", uploadID, 1, strings.NewReader("12345678"), 8, nil)
+	if _, err := s.UploadPart("test-bucket", "big", uploadID, 2, strings.NewReader("123"), 3, nil); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected parts to count towards the limit, got %v", err)
+	}
+	if _, err := s.CompleteMultipartUpload("test-bucket", "big", uploadID, []CompletePart{{1, ""}}); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	if s.used != 8 {
+		t.Errorf("Expected 8 bytes in use, got %d", s.used)
+	}
+}
@@ -0,0 +1,506 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The metadata of an object is kept in a record at <bucket>/.meta/<path>,
+// where <path> is the encoded key the object data is stored at. The records
+// form a tree next to the objects that no key maps into, and are written
+// together with the object data by commitObject.
+
+// metaDir holds the records of the objects of a bucket
+const metaDir = ".meta"
+
+// recordVersion is the version of the record format written by this build.
+// Records of a later version are not read.
+const recordVersion = 1
+
+// uploadRecordName is the record of a multipart upload in its directory,
+// holding the metadata the assembled object is stored with
+const uploadRecordName = "upload.json"
+
+// objectRecord is the stored metadata of an object
+type objectRecord struct {
+	Version int `json:"version"`
+
+	// ETag is the entity tag of the object, without quotes
+	ETag string `json:"etag,omitempty"`
+
+	// Checksums of the object data by algorithm, base64 encoded
+	Checksums map[string]string `json:"checksums,omitempty"`
+
+	// User holds the x-amz-meta-* entries
+	User map[string]string `json:"user,omitempty"`
+
+	// System holds the headers listed in SystemMetadata
+	System map[string]string `json:"system,omitempty"`
+
+	// Other holds the remaining entries, such as object lock settings and
+	// the entries LocalS3 keeps for itself
+	Other map[string]string `json:"other,omitempty"`
+}
+
+// newRecord returns a record holding metadata
+func newRecord(metadata map[string]string) *objectRecord {
+	record := &objectRecord{Version: recordVersion}
+	record.setMetadata(metadata)
+	return record
+}
+
+// setMetadata replaces the metadata of a record, keeping its ETag and
+// checksums
+func (r *objectRecord) setMetadata(metadata map[string]string) {
+	r.User, r.System, r.Other = nil, nil, nil
+	for name, value := range metadata {
+		group := &r.Other
+		if isUserMetadata(name) {
+			group = &r.User
+		} else if isSystemMetadata(name) {
+			group = &r.System
+		}
+		if *group == nil {
+			*group = make(map[string]string)
+		}
+		(*group)[name] = value
+	}
+}
+
+// setMD5 records the MD5 digest of the object data
+func (r *objectRecord) setMD5(sum []byte) {
+	if r.Checksums == nil {
+		r.Checksums = make(map[string]string)
+	}
+	r.Checksums["MD5"] = base64.StdEncoding.EncodeToString(sum)
+}
+
+// metadata returns all metadata of a record in one map
+func (r *objectRecord) metadata() map[string]string {
+	metadata := make(map[string]string)
+	for _, group := range []map[string]string{r.User, r.System, r.Other} {
+		for name, value := range group {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// etag returns the quoted ETag of an object last modified at modTime.
+// Objects without a recorded ETag derive it from their modification time, as
+// all objects did before records existed.
+func (r *objectRecord) etag(modTime time.Time) string {
+	if r.ETag != "" {
+		return `"` + r.ETag + `"`
+	}
+	return fmt.Sprintf("\"%x\"", modTime.Unix())
+}
+
+func isUserMetadata(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "x-amz-meta-")
+}
+
+func isSystemMetadata(name string) bool {
+	for _, header := range SystemMetadata {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// newObjectInfo describes the object stored under key
+func newObjectInfo(key string, size int64, modTime time.Time, record *objectRecord) *ObjectInfo {
+	metadata := record.metadata()
+	return &ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ETag:         record.etag(modTime),
+		LastModified: modTime,
+		ContentType:  contentType(key, metadata),
+		Metadata:     metadata,
+	}
+}
+
+// recordPath returns the path of the record of the object stored at
+// objectPath
+func (fs *FileSystemStorage) recordPath(objectPath string) string {
+	bucket, path, _ := strings.Cut(fs.relPath(objectPath), string(filepath.Separator))
+	return filepath.Join(fs.basePath, bucket, metaDir, path)
+}
+
+// readRecord reads the record at path
+func readRecord(path string) (*objectRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	record := &objectRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("invalid metadata record %s: %w", path, err)
+	}
+	if record.Version > recordVersion {
+		return nil, fmt.Errorf("metadata record %s has unsupported version %d", path, record.Version)
+	}
+	return record, nil
+}
+
+// storeRecord replaces the record at path
+func (fs *FileSystemStorage) storeRecord(path string, record *objectRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return fs.writeFileAtomic(path, data)
+}
+
+// loadRecord returns the record of the object stored at objectPath. Objects
+// without a readable record have no metadata.
+func (fs *FileSystemStorage) loadRecord(objectPath string) *objectRecord {
+	record, err := readRecord(fs.recordPath(objectPath))
+	if err != nil {
+		return newRecord(nil)
+	}
+	return record
+}
+
+func (fs *FileSystemStorage) loadMetadata(objectPath string) map[string]string {
+	return fs.loadRecord(objectPath).metadata()
+}
+
+// rewriteMetadata replaces all stored metadata of an object
+func (fs *FileSystemStorage) rewriteMetadata(bucket, key string, metadata map[string]string) error {
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		return err
+	}
+
+	record := fs.loadRecord(objectPath)
+	record.setMetadata(metadata)
+	if err := fs.storeRecord(fs.recordPath(objectPath), record); err != nil {
+		return err
+	}
+	fs.indexObject(bucket, key, info.Size(), info.ModTime(), record)
+	return nil
+}
+
+// loadPartRecord returns the record of the part of a multipart upload stored
+// at partPath, holding its ETag and the metadata given with it. Parts
+// uploaded by earlier versions have no record.
+func loadPartRecord(partPath string) (*objectRecord, error) {
+	record, err := readRecord(partPath + ".json")
+	if os.IsNotExist(err) {
+		return newRecord(nil), nil
+	}
+	return record, err
+}
+
+// loadUploadMetadata returns the metadata of the multipart upload in
+// uploadDir
+func loadUploadMetadata(uploadDir string) (map[string]string, error) {
+	record, err := readRecord(filepath.Join(uploadDir, uploadRecordName))
+	if err != nil {
+		// Earlier versions stored no file for uploads without metadata
+		if os.IsNotExist(err) {
+			return make(map[string]string), nil
+		}
+		return nil, err
+	}
+	return record.metadata(), nil
+}
+
+func (fs *FileSystemStorage) removeRecord(objectPath string) {
+	os.Remove(fs.recordPath(objectPath))
+}
+
+// Earlier versions stored each object at its raw key, with its metadata in a
+// <key>.metadata file next to it, and the metadata of multipart uploads in
+// upload.metadata files. MigrateMetadata moves a bucket to the current
+// layout in two journaled commits: the first stages its files in a
+// directory where their paths are still their raw keys, the second moves
+// them to the paths encodeKey gives their keys. Staging keeps the two layouts
+// apart, so an interrupted migration never takes a file it moved for one it
+// still has to move.
+
+// layoutVersion is the layout of the data directory written by this build,
+// recorded in layoutMarker once the data directory is migrated
+const layoutVersion = "2"
+
+func (fs *FileSystemStorage) layoutMarker() string {
+	return filepath.Join(fs.basePath, systemDir, "layout")
+}
+
+func (fs *FileSystemStorage) migrationDir() string {
+	return filepath.Join(fs.basePath, systemDir, "migration")
+}
+
+// stagingDir holds the files of a bucket between the commits of its
+// migration
+func (fs *FileSystemStorage) stagingDir(bucket string) string {
+	return filepath.Join(fs.migrationDir(), "staging", bucket)
+}
+
+// stagedMarker is written by the commit that stages a bucket
+func (fs *FileSystemStorage) stagedMarker(bucket string) string {
+	return filepath.Join(fs.migrationDir(), "staged", bucket)
+}
+
+// migratedMarker is written by the commit that finishes the migration of a
+// bucket
+func (fs *FileSystemStorage) migratedMarker(bucket string) string {
+	return filepath.Join(fs.migrationDir(), "migrated", bucket)
+}
+
+// MigrateMetadata brings a data directory written by an earlier version up
+// to date. It returns the number of files migrated, and must run after
+// Recover, before the storage serves requests. A bucket with a file that
+// can't be migrated is left as it is, and the error names the file.
+func (fs *FileSystemStorage) MigrateMetadata() (int, error) {
+	if _, err := os.Stat(fs.layoutMarker()); err == nil {
+		return 0, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(fs.basePath)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	migrated := 0
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !validBucketDir(entry.Name()) {
+			continue
+		}
+		n, err := fs.migrateBucket(entry.Name())
+		migrated += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", entry.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return migrated, errors.Join(errs...)
+	}
+
+	if err := fs.writeFileAtomic(fs.layoutMarker(), []byte(layoutVersion+"\n")); err != nil {
+		return migrated, err
+	}
+	os.RemoveAll(fs.migrationDir())
+	return migrated, nil
+}
+
+func (fs *FileSystemStorage) migrateBucket(bucket string) (int, error) {
+	if _, err := os.Stat(fs.migratedMarker(bucket)); err == nil {
+		return 0, nil
+	}
+	if _, err := os.Stat(fs.stagedMarker(bucket)); os.IsNotExist(err) {
+		if err := fs.stageBucket(bucket); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	return fs.unstageBucket(bucket)
+}
+
+// stageBucket moves the object files and metadata files of a bucket to its
+// staging directory. Nothing is moved if any object can't be stored at its
+// key.
+func (fs *FileSystemStorage) stageBucket(bucket string) error {
+	bucketPath := filepath.Join(fs.basePath, bucket)
+	uploadsPath := filepath.Join(bucketPath, ".uploads")
+	stagingDir := fs.stagingDir(bucket)
+
+	c := &commit{}
+	var errs []error
+	err := filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == uploadsPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		// Files ending in .metadata were never listed as objects
+		if key := filepath.ToSlash(rel); !strings.HasSuffix(key, metadataSuffix) {
+			if _, err := fs.objectPath(bucket, key); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				return nil
+			}
+		}
+		c.Renames = append(c.Renames, fs.relRename(path, filepath.Join(stagingDir, "objects", rel)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	uploads, err := os.ReadDir(uploadsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, upload := range uploads {
+		path := filepath.Join(uploadsPath, upload.Name(), "upload"+metadataSuffix)
+		if _, err := os.Stat(path); err == nil {
+			c.Renames = append(c.Renames, fs.relRename(path, filepath.Join(stagingDir, "uploads", upload.Name())))
+		}
+	}
+
+	return fs.runMigrationCommit(c, fs.stagedMarker(bucket))
+}
+
+// unstageBucket moves the staged objects of a bucket to the paths of their
+// keys, and replaces the staged metadata files with records
+func (fs *FileSystemStorage) unstageBucket(bucket string) (int, error) {
+	bucketPath := filepath.Join(fs.basePath, bucket)
+	objectsPath := filepath.Join(fs.stagingDir(bucket), "objects")
+	uploadsPath := filepath.Join(fs.stagingDir(bucket), "uploads")
+
+	c := &commit{}
+	objectPaths := make(map[string]string)
+	var metadataFiles []string
+	err := filepath.WalkDir(objectsPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == objectsPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, metadataSuffix) {
+			metadataFiles = append(metadataFiles, path)
+			return nil
+		}
+
+		rel, err := filepath.Rel(objectsPath, path)
+		if err != nil {
+			return err
+		}
+		objectPath, err := fs.objectPath(bucket, filepath.ToSlash(rel))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		objectPaths[path] = objectPath
+		c.Renames = append(c.Renames, fs.relRename(path, objectPath))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	migrated := len(c.Renames) + len(metadataFiles)
+
+	// Metadata files of objects that are gone are dropped with the staging
+	// directory
+	for _, path := range metadataFiles {
+		stagedPath := strings.TrimSuffix(path, metadataSuffix)
+		objectPath, ok := objectPaths[stagedPath]
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(stagedPath)
+		if err != nil {
+			fs.discardCommit(c)
+			return 0, err
+		}
+		if err := fs.stageRecord(c, path, fs.recordPath(objectPath), info.ModTime()); err != nil {
+			fs.discardCommit(c)
+			return 0, err
+		}
+	}
+
+	uploads, err := os.ReadDir(uploadsPath)
+	if err != nil && !os.IsNotExist(err) {
+		fs.discardCommit(c)
+		return 0, err
+	}
+	for _, upload := range uploads {
+		recordPath := filepath.Join(bucketPath, ".uploads", upload.Name(), uploadRecordName)
+		if err := fs.stageRecord(c, filepath.Join(uploadsPath, upload.Name()), recordPath, time.Time{}); err != nil {
+			fs.discardCommit(c)
+			return 0, err
+		}
+		migrated++
+	}
+
+	if err := fs.runMigrationCommit(c, fs.migratedMarker(bucket)); err != nil {
+		return 0, err
+	}
+	os.RemoveAll(fs.stagingDir(bucket))
+	return migrated, nil
+}
+
+// stageRecord adds the rename of a record read from a metadata file to a
+// commit. Objects get the ETag earlier versions derived from their
+// modification time.
+func (fs *FileSystemStorage) stageRecord(c *commit, metadataPath, recordPath string, modTime time.Time) error {
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	record := newRecord(decodeLegacyMetadata(data))
+	if !modTime.IsZero() {
+		record.ETag = fmt.Sprintf("%x", modTime.Unix())
+	}
+
+	tmpPath, err := fs.writeRecordTemp(record)
+	if err != nil {
+		return err
+	}
+	c.Renames = append(c.Renames, fs.relRename(tmpPath, recordPath))
+	return nil
+}
+
+// runMigrationCommit applies a commit of the migration along with the
+// marker that records it was applied
+func (fs *FileSystemStorage) runMigrationCommit(c *commit, marker string) error {
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error { return nil })
+	if err != nil {
+		fs.discardCommit(c)
+		return err
+	}
+	c.Renames = append(c.Renames, fs.relRename(tmpPath, marker))
+	return fs.runDurableCommit(c)
+}
+
+// decodeLegacyMetadata reads the key=value lines of a metadata file written
+// by an earlier version
+func decodeLegacyMetadata(data []byte) map[string]string {
+	metadata := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
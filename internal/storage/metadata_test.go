@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectRecord(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	metadata := map[string]string{
+		"X-Amz-Meta-Note":  "first line\nsecond=line",
+		"Content-Type":     "text/plain",
+		MetadataACL:        "public-read",
+		"X-Amz-Meta-Empty": "",
+	}
+	content := []byte("hello")
+	info, err := fs.PutObject("test-bucket", "notes.metadata", bytes.NewReader(content), int64(len(content)), metadata)
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if want := fmt.Sprintf("\"%x\"", md5.Sum(content)); info.ETag != want {
+		t.Errorf("Expected ETag %s, got %s", want, info.ETag)
+	}
+
+	head, err := fs.HeadObject("test-bucket", "notes.metadata")
+	if err != nil {
+		t.Fatalf("Failed to head object: %v", err)
+	}
+	if !reflect.DeepEqual(head.Metadata, metadata) || head.ETag != info.ETag {
+		t.Errorf("Expected metadata %q and ETag %s, got %q and %s", metadata, info.ETag, head.Metadata, head.ETag)
+	}
+
+	objectPath, _ := fs.objectPath("test-bucket", "notes.metadata")
+	record, err := readRecord(fs.recordPath(objectPath))
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if record.Version != recordVersion || len(record.User) != 2 || len(record.System) != 1 || len(record.Other) != 1 || record.Checksums["MD5"] == "" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+
+	// Records are kept apart from the keys of the bucket
+	result, _ := fs.ListObjects("test-bucket", "", "", "", 1000)
+	if len(result.Objects) != 1 || result.Objects[0].Key != "notes.metadata" || result.Objects[0].ETag != info.ETag {
+		t.Errorf("Expected only the object to be listed, got %+v", result.Objects)
+	}
+
+	// Changing metadata keeps the ETag, and deleting the object drops its
+	// record
+	if err := fs.PutObjectACL("test-bucket", "notes.metadata", "private"); err != nil {
+		t.Fatalf("Failed to put ACL: %v", err)
+	}
+	if head, _ := fs.HeadObject("test-bucket", "notes.metadata"); head.ETag != info.ETag || head.Metadata["X-Amz-Meta-Note"] != metadata["X-Amz-Meta-Note"] {
+		t.Errorf("Expected the ACL change to keep the rest of the record, got %+v", head)
+	}
+	fs.DeleteObject("test-bucket", "notes.metadata", false)
+	if _, err := os.Stat(fs.recordPath(objectPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the record to be removed, got %v", err)
+	}
+
+	// Records of a later version aren't read
+	fs.PutObject("test-bucket", "future", bytes.NewReader(content), int64(len(content)), metadata)
+	objectPath, _ = fs.objectPath("test-bucket", "future")
+	os.WriteFile(fs.recordPath(objectPath), []byte(`{"version":99,"user":{"X-Amz-Meta-Note":"x"}}`), 0644)
+	if _, err := readRecord(fs.recordPath(objectPath)); err == nil {
+		t.Error("Expected a record of a later version to be rejected")
+	}
+}
+
+func TestMultipartETag(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	uploadID, _ := fs.InitiateMultipartUpload("test-bucket", "big", map[string]string{"Content-Type": "video/mp4"})
+	part1 := bytes.Repeat([]byte("a"), MinPartSize)
+	part2 := []byte("tail")
+	info1, _ := fs.UploadPart("test-bucket", "big", uploadID, 1, bytes.NewReader(part1), int64(len(part1)), nil)
+	info2, _ := fs.UploadPart("test-bucket", "big", uploadID, 2, bytes.NewReader(part2), int64(len(part2)), nil)
+	info, err := fs.CompleteMultipartUpload("test-bucket", "big", uploadID, []CompletePart{{1, info1.ETag}, {2, info2.ETag}})
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+
+	sum1, sum2 := md5.Sum(part1), md5.Sum(part2)
+	want := fmt.Sprintf("\"%x-2\"", md5.Sum(append(sum1[:], sum2[:]...)))
+	if info.ETag != want {
+		t.Errorf("Expected ETag %s, got %s", want, info.ETag)
+	}
+	if head, _ := fs.HeadObject("test-bucket", "big"); head.ETag != want || head.ContentType != "video/mp4" {
+		t.Errorf("Unexpected object %+v", head)
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	// A data directory written by an earlier version
+	bucketPath := filepath.Join(tempDir, "test-bucket")
+	os.MkdirAll(filepath.Join(bucketPath, "docs"), 0755)
+	os.WriteFile(filepath.Join(bucketPath, "docs", "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(bucketPath, "docs", "a.txt.metadata"), []byte("Content-Type=text/markdown\nX-Amz-Meta-Owner=alice\n"), 0644)
+	os.WriteFile(filepath.Join(bucketPath, "plain"), []byte("p"), 0644)
+	os.WriteFile(filepath.Join(bucketPath, "gone.metadata"), []byte("X-Amz-Meta-Owner=bob\n"), 0644)
+	// Keys were stored raw, so these are the keys 100%, .hidden and %41
+	rawKeys := []string{"100%", ".hidden", "%41"}
+	for _, key := range rawKeys {
+		os.WriteFile(filepath.Join(bucketPath, key), []byte(key), 0644)
+	}
+	uploadDir := filepath.Join(bucketPath, ".uploads", "1")
+	os.MkdirAll(uploadDir, 0755)
+	os.WriteFile(filepath.Join(uploadDir, "upload.metadata"), []byte("Content-Type=image/png\n"), 0644)
+	legacyETag := func(key string) string {
+		info, _ := os.Stat(filepath.Join(bucketPath, filepath.FromSlash(key)))
+		return fmt.Sprintf("\"%x\"", info.ModTime().Unix())
+	}
+	wantETag, plainETag := legacyETag("docs/a.txt"), legacyETag("plain")
+
+	migrated, err := fs.MigrateMetadata()
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if migrated != 8 {
+		t.Errorf("Expected 8 files to be migrated, got %d", migrated)
+	}
+
+	head, err := fs.HeadObject("test-bucket", "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Failed to head object: %v", err)
+	}
+	if head.ContentType != "text/markdown" || head.Metadata["X-Amz-Meta-Owner"] != "alice" || head.ETag != wantETag {
+		t.Errorf("Unexpected migrated object %+v", head)
+	}
+	if head, _ := fs.HeadObject("test-bucket", "plain"); head == nil || head.ETag != plainETag {
+		t.Errorf("Expected objects without metadata to keep their ETag, got %+v", head)
+	}
+	for _, key := range rawKeys {
+		if data := readObject(t, fs, "test-bucket", key); data != key {
+			t.Errorf("Expected %q to hold %q, got %q", key, key, data)
+		}
+	}
+	if _, err := fs.HeadObject("test-bucket", "A"); err == nil {
+		t.Errorf("Expected %%41 not to be decoded to A")
+	}
+	if metadata, _ := fs.GetMultipartUploadMetadata("test-bucket", "upload", "1"); metadata["Content-Type"] != "image/png" {
+		t.Errorf("Expected upload metadata to be migrated, got %v", metadata)
+	}
+
+	filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+		if strings.HasSuffix(path, metadataSuffix) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+		return nil
+	})
+
+	// Migrating again finds nothing to do, as the files are current
+	if migrated, err := fs.MigrateMetadata(); err != nil || migrated != 0 {
+		t.Errorf("Expected nothing to migrate, got %d, %v", migrated, err)
+	}
+	if data := readObject(t, fs, "test-bucket", "100%"); data != "100%" {
+		t.Errorf("Expected migrated objects to stay, got %q", data)
+	}
+}
+
+func TestMigrateMetadataUnmappableKey(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	// A key longer than MaxKeyLength, which only earlier versions stored
+	bucketPath := filepath.Join(tempDir, "test-bucket")
+	dir := bucketPath
+	for i := 0; i < 5; i++ {
+		dir = filepath.Join(dir, strings.Repeat("d", 250))
+	}
+	os.MkdirAll(dir, 0755)
+	longPath := filepath.Join(dir, "long")
+	os.WriteFile(longPath, []byte("l"), 0644)
+	os.WriteFile(filepath.Join(bucketPath, "plain"), []byte("p"), 0644)
+
+	if _, err := fs.MigrateMetadata(); err == nil || !strings.Contains(err.Error(), longPath) {
+		t.Fatalf("Expected the migration to fail on %s, got %v", longPath, err)
+	}
+	// Nothing in the bucket is moved, and the next start fails again
+	for _, path := range []string{longPath, filepath.Join(bucketPath, "plain")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to stay in place: %v", path, err)
+		}
+	}
+	if _, err := fs.MigrateMetadata(); err == nil {
+		t.Errorf("Expected the migration to fail again")
+	}
+}
+
+func TestMigrateMetadataResumesStagedBucket(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+	os.WriteFile(filepath.Join(tempDir, "test-bucket", "a%o"), []byte("a"), 0644)
+
+	// The server stopped after the files were staged
+	if err := fs.stageBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to stage: %v", err)
+	}
+	if migrated, err := fs.MigrateMetadata(); err != nil || migrated != 1 {
+		t.Fatalf("Expected 1 file to be migrated, got %d, %v", migrated, err)
+	}
+	if data := readObject(t, fs, "test-bucket", "a%o"); data != "a" {
+		t.Errorf("Expected a%%o to hold a, got %q", data)
+	}
+	if _, err := fs.HeadObject("test-bucket", "a"); err == nil {
+		t.Errorf("Expected no object a")
+	}
+}
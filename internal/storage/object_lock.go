@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Object lock retention modes
+const (
+	RetentionModeGovernance = "GOVERNANCE"
+	RetentionModeCompliance = "COMPLIANCE"
+)
+
+// Object lock state is kept in the object metadata under the header names S3
+// uses to report it, so GET and HEAD return it like any other metadata
+const (
+	MetadataObjectLockMode            = "X-Amz-Object-Lock-Mode"
+	MetadataObjectLockRetainUntilDate = "X-Amz-Object-Lock-Retain-Until-Date"
+	MetadataObjectLockLegalHold       = "X-Amz-Object-Lock-Legal-Hold"
+)
+
+// RetainUntilDateFormat is the timestamp layout S3 uses for retention dates
+const RetainUntilDateFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	// ErrObjectLocked is returned when a delete or overwrite would destroy an
+	// object protected by a retention period or legal hold
+	ErrObjectLocked = errors.New("object is protected by object lock")
+
+	// ErrObjectLockNotEnabled is returned for object lock operations on a
+	// bucket created without object lock
+	ErrObjectLockNotEnabled = errors.New("bucket is missing object lock configuration")
+)
+
+// ObjectLockConfiguration is the object lock setting of a bucket
+type ObjectLockConfiguration struct {
+	Enabled          bool              `json:"enabled"`
+	DefaultRetention *DefaultRetention `json:"defaultRetention,omitempty"`
+}
+
+// DefaultRetention is applied to new objects that don't specify their own
+// retention. Exactly one of Days and Years is set.
+type DefaultRetention struct {
+	Mode  string `json:"mode"`
+	Days  int    `json:"days,omitempty"`
+	Years int    `json:"years,omitempty"`
+}
+
+// ObjectRetention is the retention setting of a single object
+type ObjectRetention struct {
+	Mode            string
+	RetainUntilDate time.Time
+}
+
+// Validate checks the default retention rule
+func (d *DefaultRetention) Validate() error {
+	if d.Mode != RetentionModeGovernance && d.Mode != RetentionModeCompliance {
+		return fmt.Errorf("invalid retention mode %q", d.Mode)
+	}
+	if (d.Days > 0) == (d.Years > 0) {
+		return fmt.Errorf("default retention must specify either days or years")
+	}
+	if d.Days < 0 || d.Years < 0 {
+		return fmt.Errorf("default retention period must be positive")
+	}
+	return nil
+}
+
+func (d *DefaultRetention) retainUntil(from time.Time) time.Time {
+	return from.AddDate(d.Years, 0, d.Days)
+}
+
+// EnableObjectLock turns on object lock for a bucket. It cannot be turned off.
+func (fs *FileSystemStorage) EnableObjectLock(bucket string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	if cfg.ObjectLock == nil {
+		cfg.ObjectLock = &ObjectLockConfiguration{}
+	}
+	cfg.ObjectLock.Enabled = true
+
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+func (fs *FileSystemStorage) GetObjectLockConfiguration(bucket string) (*ObjectLockConfiguration, error) {
+	defer fs.rlockBucket(bucket)()
+
+	return fs.objectLockConfiguration(bucket)
+}
+
+func (fs *FileSystemStorage) objectLockConfiguration(bucket string) (*ObjectLockConfiguration, error) {
+	if !fs.BucketExists(bucket) {
+		return nil, ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ObjectLock == nil {
+		return &ObjectLockConfiguration{}, nil
+	}
+	return cfg.ObjectLock, nil
+}
+
+func (fs *FileSystemStorage) PutObjectLockConfiguration(bucket string, lockConfig *ObjectLockConfiguration) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	if cfg.ObjectLock == nil || !cfg.ObjectLock.Enabled {
+		return ErrObjectLockNotEnabled
+	}
+
+	if lockConfig.DefaultRetention != nil {
+		if err := lockConfig.DefaultRetention.Validate(); err != nil {
+			return err
+		}
+	}
+
+	cfg.ObjectLock = &ObjectLockConfiguration{
+		Enabled:          true,
+		DefaultRetention: lockConfig.DefaultRetention,
+	}
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+func (fs *FileSystemStorage) GetObjectRetention(bucket, key string) (*ObjectRetention, error) {
+	defer fs.rlockObject(bucket, key)()
+
+	metadata, err := fs.objectLockMetadata(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return retentionFromMetadata(metadata), nil
+}
+
+func (fs *FileSystemStorage) PutObjectRetention(bucket, key string, retention *ObjectRetention, bypassGovernance bool) error {
+	defer fs.lockObject(bucket, key)()
+
+	metadata, err := fs.objectLockMetadata(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err := updateRetention(metadata, retention, bypassGovernance); err != nil {
+		return err
+	}
+
+	return fs.rewriteMetadata(bucket, key, metadata)
+}
+
+// GetObjectLegalHold returns the legal hold status ("ON" or "OFF") of an
+// object, or an empty string if none was ever set
+func (fs *FileSystemStorage) GetObjectLegalHold(bucket, key string) (string, error) {
+	defer fs.rlockObject(bucket, key)()
+
+	metadata, err := fs.objectLockMetadata(bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	return metadata[MetadataObjectLockLegalHold], nil
+}
+
+func (fs *FileSystemStorage) PutObjectLegalHold(bucket, key, status string) error {
+	defer fs.lockObject(bucket, key)()
+
+	metadata, err := fs.objectLockMetadata(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err := updateLegalHold(metadata, status); err != nil {
+		return err
+	}
+
+	return fs.rewriteMetadata(bucket, key, metadata)
+}
+
+// objectLockMetadata loads the metadata of an object in a bucket that has
+// object lock enabled
+func (fs *FileSystemStorage) objectLockMetadata(bucket, key string) (map[string]string, error) {
+	lockConfig, err := fs.objectLockConfiguration(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !lockConfig.Enabled {
+		return nil, ErrObjectLockNotEnabled
+	}
+
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(objectPath); err != nil {
+		if isNotExist(err) {
+			return nil, ErrNoSuchKey
+		}
+		return nil, err
+	}
+
+	return fs.loadMetadata(objectPath), nil
+}
+
+// checkObjectLock returns ErrObjectLocked if the object at objectPath may not
+// be deleted or overwritten
+func (fs *FileSystemStorage) checkObjectLock(objectPath string, bypassGovernance bool) error {
+	if _, err := os.Stat(objectPath); err != nil {
+		return nil
+	}
+
+	return checkLocked(fs.loadMetadata(objectPath), bypassGovernance)
+}
+
+// checkLocked returns ErrObjectLocked if the object with metadata may not be
+// deleted or overwritten
+func checkLocked(metadata map[string]string, bypassGovernance bool) error {
+	if metadata[MetadataObjectLockLegalHold] == "ON" {
+		return ErrObjectLocked
+	}
+
+	retention := retentionFromMetadata(metadata)
+	if retention == nil || !retention.RetainUntilDate.After(time.Now()) {
+		return nil
+	}
+	if retention.Mode == RetentionModeGovernance && bypassGovernance {
+		return nil
+	}
+	return ErrObjectLocked
+}
+
+// applyDefaultRetention sets the bucket's default retention on a new object
+// that doesn't carry an explicit retention
+func (fs *FileSystemStorage) applyDefaultRetention(bucket string, metadata map[string]string) error {
+	if metadata[MetadataObjectLockMode] != "" {
+		return nil
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	setDefaultRetention(cfg.ObjectLock, metadata)
+	return nil
+}
+
+// setDefaultRetention sets the default retention of a bucket's object lock
+// configuration on a new object that doesn't carry an explicit retention
+func setDefaultRetention(lockConfig *ObjectLockConfiguration, metadata map[string]string) {
+	if metadata[MetadataObjectLockMode] != "" || lockConfig == nil || lockConfig.DefaultRetention == nil {
+		return
+	}
+
+	retention := lockConfig.DefaultRetention
+	metadata[MetadataObjectLockMode] = retention.Mode
+	metadata[MetadataObjectLockRetainUntilDate] = retention.retainUntil(time.Now()).UTC().Format(RetainUntilDateFormat)
+}
+
+// updateRetention sets the retention of an object in its metadata. Active
+// retention may only be extended, unless governance mode is bypassed
+// explicitly.
+func updateRetention(metadata map[string]string, retention *ObjectRetention, bypassGovernance bool) error {
+	if retention.Mode != "" && retention.Mode != RetentionModeGovernance && retention.Mode != RetentionModeCompliance {
+		return fmt.Errorf("%w: invalid retention mode %q", ErrInvalidArgument, retention.Mode)
+	}
+
+	if current := retentionFromMetadata(metadata); current != nil && current.RetainUntilDate.After(time.Now()) {
+		weakened := retention.Mode == "" || retention.RetainUntilDate.Before(current.RetainUntilDate)
+		if current.Mode == RetentionModeCompliance && (weakened || retention.Mode != RetentionModeCompliance) {
+			return ErrObjectLocked
+		}
+		if current.Mode == RetentionModeGovernance && weakened && !bypassGovernance {
+			return ErrObjectLocked
+		}
+	}
+
+	if retention.Mode == "" {
+		delete(metadata, MetadataObjectLockMode)
+		delete(metadata, MetadataObjectLockRetainUntilDate)
+	} else {
+		metadata[MetadataObjectLockMode] = retention.Mode
+		metadata[MetadataObjectLockRetainUntilDate] = retention.RetainUntilDate.UTC().Format(RetainUntilDateFormat)
+	}
+
+	return nil
+}
+
+// updateLegalHold sets the legal hold status of an object in its metadata
+func updateLegalHold(metadata map[string]string, status string) error {
+	if status != "ON" && status != "OFF" {
+		return fmt.Errorf("%w: invalid legal hold status %q", ErrInvalidArgument, status)
+	}
+	metadata[MetadataObjectLockLegalHold] = status
+	return nil
+}
+
+func retentionFromMetadata(metadata map[string]string) *ObjectRetention {
+	mode := metadata[MetadataObjectLockMode]
+	if mode == "" {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, metadata[MetadataObjectLockRetainUntilDate])
+	if err != nil {
+		return nil
+	}
+	return &ObjectRetention{Mode: mode, RetainUntilDate: until}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupLockedBucket(t *testing.T, fs *FileSystemStorage, bucket string) {
+	if err := fs.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := fs.EnableObjectLock(bucket); err != nil {
+		t.Fatalf("Failed to enable object lock: %v", err)
+	}
+}
+
+func putTestObject(t *testing.T, fs *FileSystemStorage, bucket, key string, metadata map[string]string) {
+	content := []byte("locked content")
+	if _, err := fs.PutObject(bucket, key, bytes.NewReader(content), int64(len(content)), metadata); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+}
+
+func TestObjectLockConfiguration(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	if err := fs.CreateBucket("plain"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	err := fs.PutObjectLockConfiguration("plain", &ObjectLockConfiguration{Enabled: true})
+	if !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+
+	setupLockedBucket(t, fs, "locked")
+	retention := &DefaultRetention{Mode: RetentionModeGovernance, Days: 1}
+	if err := fs.PutObjectLockConfiguration("locked", &ObjectLockConfiguration{Enabled: true, DefaultRetention: retention}); err != nil {
+		t.Fatalf("Failed to put object lock configuration: %v", err)
+	}
+
+	lockConfig, err := fs.GetObjectLockConfiguration("locked")
+	if err != nil {
+		t.Fatalf("Failed to get object lock configuration: %v", err)
+	}
+	if !lockConfig.Enabled || lockConfig.DefaultRetention == nil || lockConfig.DefaultRetention.Days != 1 {
+		t.Errorf("Unexpected configuration %+v", lockConfig)
+	}
+
+	// The bucket configuration must not show up as a bucket
+	buckets, err := fs.ListBuckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %v", err)
+	}
+	if len(buckets) != 2 {
+		t.Errorf("Expected 2 buckets, got %d", len(buckets))
+	}
+
+	// Default retention applies to new objects
+	putTestObject(t, fs, "locked", "doc.txt", nil)
+	objRetention, err := fs.GetObjectRetention("locked", "doc.txt")
+	if err != nil {
+		t.Fatalf("Failed to get retention: %v", err)
+	}
+	if objRetention == nil || objRetention.Mode != RetentionModeGovernance {
+		t.Fatalf("Expected default GOVERNANCE retention, got %+v", objRetention)
+	}
+
+	if err := fs.DeleteObject("locked", "doc.txt", false); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected delete to be blocked, got %v", err)
+	}
+	if err := fs.DeleteObject("locked", "doc.txt", true); err != nil {
+		t.Errorf("Expected governance bypass to allow delete, got %v", err)
+	}
+}
+
+func TestObjectRetentionCompliance(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	setupLockedBucket(t, fs, "locked")
+	until := time.Now().Add(time.Hour).UTC()
+	putTestObject(t, fs, "locked", "archive.bin", map[string]string{
+		MetadataObjectLockMode:            RetentionModeCompliance,
+		MetadataObjectLockRetainUntilDate: until.Format(RetainUntilDateFormat),
+	})
+
+	if err := fs.DeleteObject("locked", "archive.bin", true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected compliance mode to ignore bypass, got %v", err)
+	}
+
+	content := []byte("overwrite")
+	if _, err := fs.PutObject("locked", "archive.bin", bytes.NewReader(content), int64(len(content)), nil); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected overwrite to be blocked, got %v", err)
+	}
+
+	shorter := &ObjectRetention{Mode: RetentionModeCompliance, RetainUntilDate: until.Add(-time.Minute)}
+	if err := fs.PutObjectRetention("locked", "archive.bin", shorter, true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected shortening compliance retention to fail, got %v", err)
+	}
+
+	longer := &ObjectRetention{Mode: RetentionModeCompliance, RetainUntilDate: until.Add(time.Hour)}
+	if err := fs.PutObjectRetention("locked", "archive.bin", longer, false); err != nil {
+		t.Errorf("Expected extending retention to succeed, got %v", err)
+	}
+}
+
+func TestObjectLegalHold(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	setupLockedBucket(t, fs, "locked")
+	putTestObject(t, fs, "locked", "evidence.txt", nil)
+
+	if err := fs.PutObjectLegalHold("locked", "evidence.txt", "ON"); err != nil {
+		t.Fatalf("Failed to put legal hold: %v", err)
+	}
+	status, err := fs.GetObjectLegalHold("locked", "evidence.txt")
+	if err != nil || status != "ON" {
+		t.Errorf("Expected legal hold ON, got %q, %v", status, err)
+	}
+
+	if err := fs.DeleteObject("locked", "evidence.txt", true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected legal hold to block delete, got %v", err)
+	}
+
+	if err := fs.PutObjectLegalHold("locked", "evidence.txt", "OFF"); err != nil {
+		t.Fatalf("Failed to release legal hold: %v", err)
+	}
+	if err := fs.DeleteObject("locked", "evidence.txt", false); err != nil {
+		t.Errorf("Expected delete after releasing legal hold, got %v", err)
+	}
+}
@@ -1,14 +1,46 @@
 package storage
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// InternalMetadataPrefix marks metadata entries that LocalS3 keeps for its own
+// bookkeeping. They are persisted with the object but never returned to clients.
+const InternalMetadataPrefix = "X-Locals3-"
+
+// MetadataParts records the part layout ("number:size,...") of objects
+// assembled by CompleteMultipartUpload
+const MetadataParts = InternalMetadataPrefix + "Parts"
+
+// PartMetadataName returns the name under which an object assembled by
+// CompleteMultipartUpload records an entry of the metadata given with one of
+// its parts
+func PartMetadataName(name string, partNumber int) string {
+	return name + "-" + strconv.Itoa(partNumber)
+}
+
+// SystemMetadata lists the standard headers that are stored with an object
+// when it is written and returned when it is read
+var SystemMetadata = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+}
+
 // Storage defines the interface for storage backends
 type Storage interface {
 	// Bucket operations
@@ -16,20 +48,51 @@ type Storage interface {
 	DeleteBucket(bucket string) error
 	ListBuckets() ([]BucketInfo, error)
 	BucketExists(bucket string) bool
+	GetBucketOwner(bucket string) (string, error)
+	PutBucketOwner(bucket, owner string) error
+	GetBucketLocation(bucket string) (string, error)
+	PutBucketLocation(bucket, region string) error
 
 	// Object operations
 	PutObject(bucket, key string, data io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error)
 	GetObject(bucket, key string) (io.ReadCloser, *ObjectInfo, error)
-	DeleteObject(bucket, key string) error
+	DeleteObject(bucket, key string, bypassGovernance bool) error
 	ListObjects(bucket, prefix, delimiter, marker string, maxKeys int) (*ListObjectsResult, error)
 	HeadObject(bucket, key string) (*ObjectInfo, error)
 	ObjectExists(bucket, key string) bool
 
 	// Multipart operations
 	InitiateMultipartUpload(bucket, key string, metadata map[string]string) (string, error)
-	UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64) (*PartInfo, error)
+	GetMultipartUploadMetadata(bucket, key, uploadID string) (map[string]string, error)
+	UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64, metadata map[string]string) (*PartInfo, error)
 	CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletePart) (*ObjectInfo, error)
 	AbortMultipartUpload(bucket, key, uploadID string) error
+
+	// Object lock operations
+	EnableObjectLock(bucket string) error
+	GetObjectLockConfiguration(bucket string) (*ObjectLockConfiguration, error)
+	PutObjectLockConfiguration(bucket string, config *ObjectLockConfiguration) error
+	GetObjectRetention(bucket, key string) (*ObjectRetention, error)
+	PutObjectRetention(bucket, key string, retention *ObjectRetention, bypassGovernance bool) error
+	GetObjectLegalHold(bucket, key string) (string, error)
+	PutObjectLegalHold(bucket, key, status string) error
+
+	// Website operations
+	GetBucketWebsite(bucket string) (*WebsiteConfiguration, error)
+	PutBucketWebsite(bucket string, website *WebsiteConfiguration) error
+	DeleteBucketWebsite(bucket string) error
+
+	// Access control operations
+	GetPublicAccessBlock(bucket string) (*PublicAccessBlockConfiguration, error)
+	PutPublicAccessBlock(bucket string, block *PublicAccessBlockConfiguration) error
+	DeletePublicAccessBlock(bucket string) error
+	GetBucketPolicy(bucket string) (string, error)
+	PutBucketPolicy(bucket, policy string) error
+	DeleteBucketPolicy(bucket string) error
+	GetBucketACL(bucket string) (string, error)
+	PutBucketACL(bucket, acl string) error
+	GetObjectACL(bucket, key string) (string, error)
+	PutObjectACL(bucket, key, acl string) error
 }
 
 // BucketInfo represents bucket information
@@ -72,6 +135,13 @@ type CompletePart struct {
 // FileSystemStorage implements Storage interface using local filesystem
 type FileSystemStorage struct {
 	basePath string
+
+	bucketLocks lockManager
+	keyLocks    lockManager
+	uploadLocks lockManager
+
+	// index, when opened, serves listings and object lookups
+	index *objectIndex
 }
 
 // NewFileSystemStorage creates a new filesystem storage backend
@@ -82,27 +152,43 @@ func NewFileSystemStorage(basePath string) *FileSystemStorage {
 }
 
 func (fs *FileSystemStorage) CreateBucket(bucket string) error {
-	bucketPath := filepath.Join(fs.basePath, bucket)
+	defer fs.lockBucket(bucket)()
+
+	if !ValidBucketName(bucket) {
+		return ErrInvalidBucketName
+	}
+	bucketPath, err := fs.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
 	return os.MkdirAll(bucketPath, 0755)
 }
 
 func (fs *FileSystemStorage) DeleteBucket(bucket string) error {
-	bucketPath := filepath.Join(fs.basePath, bucket)
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+	bucketPath, _ := fs.bucketPath(bucket)
 
-	// Check if bucket is empty
-	entries, err := os.ReadDir(bucketPath)
+	// Directories left behind by deleted objects don't count, but objects
+	// and in-progress uploads do
+	empty, err := isEmptyTree(bucketPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("bucket does not exist")
-		}
 		return err
 	}
+	if !empty {
+		return ErrBucketNotEmpty
+	}
 
-	if len(entries) > 0 {
-		return fmt.Errorf("bucket is not empty")
+	if err := os.RemoveAll(bucketPath); err != nil {
+		return err
 	}
 
-	return os.Remove(bucketPath)
+	fs.unindexBucket(bucket)
+	fs.removeBucketConfig(bucket)
+	return nil
 }
 
 func (fs *FileSystemStorage) ListBuckets() ([]BucketInfo, error) {
@@ -116,6 +202,11 @@ func (fs *FileSystemStorage) ListBuckets() ([]BucketInfo, error) {
 
 	var buckets []BucketInfo
 	for _, entry := range entries {
+		// Skip LocalS3's own system directory
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
 		if entry.IsDir() {
 			info, err := entry.Info()
 			if err != nil {
@@ -132,71 +223,90 @@ func (fs *FileSystemStorage) ListBuckets() ([]BucketInfo, error) {
 }
 
 func (fs *FileSystemStorage) BucketExists(bucket string) bool {
-	bucketPath := filepath.Join(fs.basePath, bucket)
+	bucketPath, err := fs.bucketPath(bucket)
+	if err != nil {
+		return false
+	}
 	info, err := os.Stat(bucketPath)
 	return err == nil && info.IsDir()
 }
 
 func (fs *FileSystemStorage) PutObject(bucket, key string, data io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
 	if !fs.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, ErrNoSuchBucket
 	}
 
-	objectPath := filepath.Join(fs.basePath, bucket, key)
-
-	// Create directory if needed
-	dir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
 		return nil, err
 	}
 
-	// Create file
-	file, err := os.Create(objectPath)
+	// The object is written aside, without holding its lock, and replaces
+	// the previous version with its metadata once complete
+	hash := md5.New()
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(w, hash), data)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	// Copy data
-	written, err := io.Copy(file, data)
+	info, err := os.Stat(tmpPath)
 	if err != nil {
+		os.Remove(tmpPath)
 		return nil, err
 	}
 
-	// Get file info
-	info, err := file.Stat()
-	if err != nil {
+	defer fs.lockObject(bucket, key)()
+
+	// The bucket may have been deleted in the meantime
+	if !fs.BucketExists(bucket) {
+		os.Remove(tmpPath)
+		return nil, ErrNoSuchBucket
+	}
+
+	// Locked objects may not be overwritten
+	if err := fs.checkObjectLock(objectPath, false); err != nil {
+		os.Remove(tmpPath)
 		return nil, err
 	}
 
-	// Store metadata if provided
-	if len(metadata) > 0 {
-		if err := fs.storeMetadata(objectPath, metadata); err != nil {
-			// Log error but don't fail
-		}
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	if err := fs.applyDefaultRetention(bucket, metadata); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
 	}
 
-	return &ObjectInfo{
-		Key:          key,
-		Size:         written,
-		ETag:         fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
-		LastModified: info.ModTime(),
-		ContentType:  getContentType(key),
-		Metadata:     metadata,
-	}, nil
+	record := newRecord(metadata)
+	sum := hash.Sum(nil)
+	record.ETag = hex.EncodeToString(sum)
+	record.setMD5(sum)
+	if err := fs.commitObject(objectPath, tmpPath, record); err != nil {
+		return nil, err
+	}
+	fs.indexObject(bucket, key, info.Size(), info.ModTime(), record)
+
+	return newObjectInfo(key, info.Size(), info.ModTime(), record), nil
 }
 
 func (fs *FileSystemStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
+	defer fs.rlockObject(bucket, key)()
+
 	if !fs.BucketExists(bucket) {
-		return nil, nil, fmt.Errorf("bucket does not exist")
+		return nil, nil, ErrNoSuchBucket
 	}
 
-	objectPath := filepath.Join(fs.basePath, bucket, key)
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	file, err := os.Open(objectPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil, fmt.Errorf("object does not exist")
+		if isNotExist(err) {
+			return nil, nil, ErrNoSuchKey
 		}
 		return nil, nil, err
 	}
@@ -206,165 +316,231 @@ func (fs *FileSystemStorage) GetObject(bucket, key string) (io.ReadCloser, *Obje
 		file.Close()
 		return nil, nil, err
 	}
-
-	metadata := fs.loadMetadata(objectPath)
-
-	objectInfo := &ObjectInfo{
-		Key:          key,
-		Size:         info.Size(),
-		ETag:         fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
-		LastModified: info.ModTime(),
-		ContentType:  getContentType(key),
-		Metadata:     metadata,
+	if info.IsDir() {
+		// Directories only hold the objects under a key prefix
+		file.Close()
+		return nil, nil, ErrNoSuchKey
 	}
 
-	return file, objectInfo, nil
+	return file, newObjectInfo(key, info.Size(), info.ModTime(), fs.loadRecord(objectPath)), nil
 }
 
-func (fs *FileSystemStorage) DeleteObject(bucket, key string) error {
+func (fs *FileSystemStorage) DeleteObject(bucket, key string, bypassGovernance bool) error {
+	defer fs.lockObject(bucket, key)()
+
 	if !fs.BucketExists(bucket) {
-		return fmt.Errorf("bucket does not exist")
+		return ErrNoSuchBucket
 	}
 
-	objectPath := filepath.Join(fs.basePath, bucket, key)
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
 
-	// Remove metadata file if exists
-	fs.removeMetadata(objectPath)
+	if err := fs.checkObjectLock(objectPath, bypassGovernance); err != nil {
+		return err
+	}
 
-	return os.Remove(objectPath)
+	if info, err := os.Stat(objectPath); err != nil || info.IsDir() {
+		return ErrNoSuchKey
+	}
+
+	// The data goes first, so the object never exists without its metadata
+	if err := os.Remove(objectPath); err != nil {
+		return err
+	}
+	fs.removeRecord(objectPath)
+	fs.unindexObject(bucket, key)
+	return nil
 }
 
 func (fs *FileSystemStorage) ListObjects(bucket, prefix, delimiter, marker string, maxKeys int) (*ListObjectsResult, error) {
+	defer fs.rlockBucket(bucket)()
+
 	if !fs.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, ErrNoSuchBucket
+	}
+	if index := fs.indexed(); index != nil {
+		return index.list(bucket, prefix, delimiter, marker, maxKeys)
 	}
 
-	bucketPath := filepath.Join(fs.basePath, bucket)
+	bucketPath, _ := fs.bucketPath(bucket)
+	entries, err := listKeys(bucketPath, prefix)
+	if err != nil {
+		return nil, err
+	}
 
-	var objects []ObjectInfo
-	var commonPrefixes []string
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.key
+	}
+	return listObjects(keys, prefix, delimiter, marker, maxKeys, func(i int) ObjectInfo {
+		entry := entries[i]
+		return *newObjectInfo(entry.key, entry.info.Size(), entry.info.ModTime(), fs.loadRecord(entry.path))
+	}), nil
+}
+
+// listObjects returns a page of a listing of the keys that start with
+// prefix, given in key order, for ListObjects. object returns the object
+// stored under keys[i].
+func listObjects(keys []string, prefix, delimiter, marker string, maxKeys int, object func(i int) ObjectInfo) *ListObjectsResult {
+	result := &ListObjectsResult{}
 	prefixMap := make(map[string]bool)
+	count := 0
+	for i, key := range keys {
+		if marker != "" && key <= marker {
+			continue
+		}
 
-	err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip errors
+		// Handle delimiter
+		var commonPrefix string
+		if delimiter != "" {
+			remaining := strings.TrimPrefix(key, prefix)
+			if idx := strings.Index(remaining, delimiter); idx >= 0 {
+				commonPrefix = prefix + remaining[:idx+len(delimiter)]
+				// The marker may be a prefix returned by the previous page
+				if prefixMap[commonPrefix] || (marker != "" && commonPrefix <= marker) {
+					continue
+				}
+			}
 		}
 
-		if info.IsDir() {
-			return nil
+		if maxKeys > 0 && count == maxKeys {
+			result.IsTruncated = true
+			break
 		}
+		count++
 
-		// Get relative path from bucket
-		relPath, err := filepath.Rel(bucketPath, path)
-		if err != nil {
-			return nil
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+			prefixMap[commonPrefix] = true
+			result.NextMarker = commonPrefix
+			continue
 		}
 
-		// Convert to forward slashes for S3 compatibility
-		key := strings.ReplaceAll(relPath, "\\", "/")
+		result.Objects = append(result.Objects, object(i))
+		result.NextMarker = key
+	}
 
-		// Skip metadata files
-		if strings.HasSuffix(key, ".metadata") {
-			return nil
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+	return result
+}
+
+// keyEntry is an object found on disk by listKeys
+type keyEntry struct {
+	key  string
+	path string
+	info os.FileInfo
+}
+
+// listKeys returns the objects of the bucket at bucketPath whose keys start
+// with prefix, ordered by key
+func listKeys(bucketPath, prefix string) ([]keyEntry, error) {
+	var entries []keyEntry
+
+	root := filepath.Join(bucketPath, keyDir(prefix))
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && isNotExist(err) {
+				return filepath.SkipDir
+			}
+			return nil // Skip errors
 		}
 
-		// Apply prefix filter
-		if prefix != "" && !strings.HasPrefix(key, prefix) {
+		// Names starting with a dot, such as the in-progress multipart
+		// uploads in .uploads, are never objects
+		if path != bucketPath && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-
-		// Apply marker filter
-		if marker != "" && key <= marker {
+		if info.IsDir() {
 			return nil
 		}
 
-		// Handle delimiter
-		if delimiter != "" {
-			remaining := strings.TrimPrefix(key, prefix)
-			if idx := strings.Index(remaining, delimiter); idx >= 0 {
-				commonPrefix := prefix + remaining[:idx+len(delimiter)]
-				if !prefixMap[commonPrefix] {
-					commonPrefixes = append(commonPrefixes, commonPrefix)
-					prefixMap[commonPrefix] = true
-				}
-				return nil
-			}
+		relPath, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return nil
 		}
 
-		metadata := fs.loadMetadata(path)
-
-		objects = append(objects, ObjectInfo{
-			Key:          key,
-			Size:         info.Size(),
-			ETag:         fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
-			LastModified: info.ModTime(),
-			ContentType:  getContentType(key),
-			Metadata:     metadata,
-		})
+		// Metadata files and anything else not stored by encodeKey is
+		// skipped
+		key, ok := decodeKey(relPath)
+		if !ok || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
 
+		entries = append(entries, keyEntry{key: key, path: path, info: info})
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
 
-	// Apply maxKeys limit
-	isTruncated := false
-	nextMarker := ""
-	if maxKeys > 0 && len(objects) > maxKeys {
-		isTruncated = true
-		if len(objects) > maxKeys {
-			nextMarker = objects[maxKeys-1].Key
-			objects = objects[:maxKeys]
-		}
-	}
-
-	return &ListObjectsResult{
-		Objects:        objects,
-		CommonPrefixes: commonPrefixes,
-		IsTruncated:    isTruncated,
-		NextMarker:     nextMarker,
-	}, nil
+	// Escaped names don't sort like the keys they stand for
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
 }
 
 func (fs *FileSystemStorage) HeadObject(bucket, key string) (*ObjectInfo, error) {
+	defer fs.rlockObject(bucket, key)()
+
+	return fs.headObject(bucket, key)
+}
+
+func (fs *FileSystemStorage) headObject(bucket, key string) (*ObjectInfo, error) {
 	if !fs.BucketExists(bucket) {
-		return nil, fmt.Errorf("bucket does not exist")
+		return nil, ErrNoSuchBucket
 	}
 
-	objectPath := filepath.Join(fs.basePath, bucket, key)
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if index := fs.indexed(); index != nil {
+		return index.lookup(bucket, key)
+	}
 
 	info, err := os.Stat(objectPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("object does not exist")
+		if isNotExist(err) {
+			return nil, ErrNoSuchKey
 		}
 		return nil, err
 	}
+	if info.IsDir() {
+		return nil, ErrNoSuchKey
+	}
 
-	metadata := fs.loadMetadata(objectPath)
-
-	return &ObjectInfo{
-		Key:          key,
-		Size:         info.Size(),
-		ETag:         fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
-		LastModified: info.ModTime(),
-		ContentType:  getContentType(key),
-		Metadata:     metadata,
-	}, nil
+	return newObjectInfo(key, info.Size(), info.ModTime(), fs.loadRecord(objectPath)), nil
 }
 
 func (fs *FileSystemStorage) ObjectExists(bucket, key string) bool {
-	objectPath := filepath.Join(fs.basePath, bucket, key)
-	_, err := os.Stat(objectPath)
-	return err == nil
+	defer fs.rlockObject(bucket, key)()
+
+	objectPath, err := fs.objectPath(bucket, key)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(objectPath)
+	return err == nil && !info.IsDir()
 }
 
 // Multipart upload methods (simplified implementation)
 func (fs *FileSystemStorage) InitiateMultipartUpload(bucket, key string, metadata map[string]string) (string, error) {
+	defer fs.rlockBucket(bucket)()
+
 	if !fs.BucketExists(bucket) {
-		return "", fmt.Errorf("bucket does not exist")
+		return "", ErrNoSuchBucket
+	}
+
+	if err := checkKey(key); err != nil {
+		return "", err
 	}
 
 	uploadID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -374,167 +550,252 @@ func (fs *FileSystemStorage) InitiateMultipartUpload(bucket, key string, metadat
 		return "", err
 	}
 
+	if err := fs.storeRecord(filepath.Join(uploadDir, uploadRecordName), newRecord(metadata)); err != nil {
+		os.RemoveAll(uploadDir)
+		return "", err
+	}
+
 	return uploadID, nil
 }
 
-func (fs *FileSystemStorage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64) (*PartInfo, error) {
+func (fs *FileSystemStorage) GetMultipartUploadMetadata(bucket, key, uploadID string) (map[string]string, error) {
+	defer fs.rlockBucket(bucket)()
+	defer fs.rlockUpload(bucket, uploadID)()
+
+	uploadDir, err := fs.uploadDir(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadUploadMetadata(uploadDir)
+}
+
+// uploadDir returns the directory holding the parts of an upload
+func (fs *FileSystemStorage) uploadDir(bucket, uploadID string) (string, error) {
+	if !fs.BucketExists(bucket) {
+		return "", ErrNoSuchBucket
+	}
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return "", ErrNoSuchUpload
+	}
+
 	uploadDir := filepath.Join(fs.basePath, bucket, ".uploads", uploadID)
-	partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%d", partNumber))
+	if info, err := os.Stat(uploadDir); err != nil || !info.IsDir() {
+		return "", ErrNoSuchUpload
+	}
+	return uploadDir, nil
+}
+
+func (fs *FileSystemStorage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, size int64, metadata map[string]string) (*PartInfo, error) {
+	defer fs.rlockBucket(bucket)()
+	defer fs.rlockUpload(bucket, uploadID)()
 
-	file, err := os.Create(partPath)
+	uploadDir, err := fs.uploadDir(bucket, uploadID)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%d", partNumber))
 
-	written, err := io.Copy(file, data)
+	var written int64
+	hash := md5.New()
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error {
+		n, err := io.Copy(io.MultiWriter(w, hash), data)
+		written = n
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	info, err := file.Stat()
+	// Like that of an object, the ETag of a part is the MD5 of its data.
+	// The record and data of a part are committed together, so a part
+	// uploaded again never pairs its data with the record of another
+	// upload of it, even across a crash.
+	record := newRecord(metadata)
+	record.ETag = hex.EncodeToString(hash.Sum(nil))
+	recordPath, err := fs.writeRecordTemp(record)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	defer fs.lockPart(bucket, uploadID, partNumber)()
+	err = fs.runCommit(&commit{Renames: []fileRename{
+		fs.relRename(recordPath, partPath+".json"),
+		fs.relRename(tmpPath, partPath),
+	}})
 	if err != nil {
 		return nil, err
 	}
 
 	return &PartInfo{
 		PartNumber: partNumber,
-		ETag:       fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
+		ETag:       `"` + record.ETag + `"`,
 		Size:       written,
 	}, nil
 }
 
 func (fs *FileSystemStorage) CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletePart) (*ObjectInfo, error) {
-	uploadDir := filepath.Join(fs.basePath, bucket, ".uploads", uploadID)
-	objectPath := filepath.Join(fs.basePath, bucket, key)
+	defer fs.rlockBucket(bucket)()
+	defer fs.lockUpload(bucket, uploadID)()
 
-	// Create directory if needed
-	dir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	uploadDir, err := fs.uploadDir(bucket, uploadID)
+	if err != nil {
 		return nil, err
 	}
-
-	// Create final file
-	file, err := os.Create(objectPath)
+	if err := checkParts(uploadDir, parts); err != nil {
+		return nil, err
+	}
+	objectPath, err := fs.objectPath(bucket, key)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	var totalSize int64
 
 	// Concatenate parts
-	for _, part := range parts {
-		partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%d", part.PartNumber))
-		partFile, err := os.Open(partPath)
-		if err != nil {
-			return nil, err
-		}
+	layout := make([]string, 0, len(parts))
+	partMetadata := make(map[string]string)
+	hash, partHashes := md5.New(), md5.New()
+	tmpPath, err := fs.writeTemp(func(w io.Writer) error {
+		for _, part := range parts {
+			partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%d", part.PartNumber))
+			partFile, err := os.Open(partPath)
+			if err != nil {
+				return err
+			}
 
-		written, err := io.Copy(file, partFile)
-		partFile.Close()
-		if err != nil {
-			return nil, err
+			partHash := md5.New()
+			written, err := io.Copy(io.MultiWriter(w, hash, partHash), partFile)
+			partFile.Close()
+			if err != nil {
+				return err
+			}
+			partHashes.Write(partHash.Sum(nil))
+			layout = append(layout, strconv.Itoa(part.PartNumber)+":"+strconv.FormatInt(written, 10))
+
+			record, err := loadPartRecord(partPath)
+			if err != nil {
+				return err
+			}
+			for name, value := range record.metadata() {
+				partMetadata[PartMetadataName(name, part.PartNumber)] = value
+			}
 		}
-		totalSize += written
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	// Clean up upload directory
-	os.RemoveAll(uploadDir)
-
-	info, err := file.Stat()
+	info, err := os.Stat(tmpPath)
 	if err != nil {
+		os.Remove(tmpPath)
 		return nil, err
 	}
 
-	return &ObjectInfo{
-		Key:          key,
-		Size:         totalSize,
-		ETag:         fmt.Sprintf("\"%x\"", info.ModTime().Unix()),
-		LastModified: info.ModTime(),
-		ContentType:  getContentType(key),
-	}, nil
-}
-
-func (fs *FileSystemStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
-	uploadDir := filepath.Join(fs.basePath, bucket, ".uploads", uploadID)
-	return os.RemoveAll(uploadDir)
-}
+	// The object itself is only locked once it is assembled
+	defer fs.lockKey(bucket, key)()
 
-// Helper methods
-func (fs *FileSystemStorage) storeMetadata(objectPath string, metadata map[string]string) error {
-	if len(metadata) == 0 {
-		return nil
+	// Locked objects may not be overwritten
+	if err := fs.checkObjectLock(objectPath, false); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
 	}
 
-	metadataPath := objectPath + ".metadata"
-	file, err := os.Create(metadataPath)
+	// Carry the metadata given at initiation over to the assembled object
+	metadata, err := loadUploadMetadata(uploadDir)
 	if err != nil {
-		return err
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	metadata[MetadataParts] = strings.Join(layout, ",")
+	for name, value := range partMetadata {
+		metadata[name] = value
+	}
+	if err := fs.applyDefaultRetention(bucket, metadata); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
 	}
-	defer file.Close()
 
-	for key, value := range metadata {
-		fmt.Fprintf(file, "%s=%s\n", key, value)
+	// Like S3, the ETag of an assembled object is the digest of the digests
+	// of its parts, followed by the number of parts
+	record := newRecord(metadata)
+	record.ETag = fmt.Sprintf("%x-%d", partHashes.Sum(nil), len(parts))
+	record.setMD5(hash.Sum(nil))
+	if err := fs.commitObject(objectPath, tmpPath, record); err != nil {
+		return nil, err
 	}
+	fs.indexObject(bucket, key, info.Size(), info.ModTime(), record)
 
-	return nil
+	// Clean up upload directory
+	os.RemoveAll(uploadDir)
+
+	return newObjectInfo(key, info.Size(), info.ModTime(), record), nil
 }
 
-func (fs *FileSystemStorage) loadMetadata(objectPath string) map[string]string {
-	metadata := make(map[string]string)
-	metadataPath := objectPath + ".metadata"
+func (fs *FileSystemStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
+	defer fs.rlockBucket(bucket)()
+	defer fs.lockUpload(bucket, uploadID)()
 
-	data, err := os.ReadFile(metadataPath)
+	uploadDir, err := fs.uploadDir(bucket, uploadID)
 	if err != nil {
-		return metadata
+		return err
 	}
+	return os.RemoveAll(uploadDir)
+}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// isEmptyTree reports whether there are no files in the directory tree at
+// dir
+func isEmptyTree(dir string) (bool, error) {
+	empty := true
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			empty = false
+			return filepath.SkipAll
 		}
+		return nil
+	})
+	return empty, err
+}
 
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			metadata[parts[0]] = parts[1]
+// checkParts validates the part list of a completed upload: part numbers must
+// ascend, every part must have been uploaded with a matching ETag when one is
+// given, and all parts but the last must be at least MinPartSize
+func checkParts(uploadDir string, parts []CompletePart) error {
+	for i := 1; i < len(parts); i++ {
+		if parts[i].PartNumber <= parts[i-1].PartNumber {
+			return ErrInvalidPartOrder
+		}
+	}
+
+	for i, part := range parts {
+		partPath := filepath.Join(uploadDir, fmt.Sprintf("part-%d", part.PartNumber))
+		info, err := os.Stat(partPath)
+		if err != nil {
+			return fmt.Errorf("%w: part %d", ErrInvalidPart, part.PartNumber)
+		}
+		record, err := loadPartRecord(partPath)
+		if err != nil {
+			return err
+		}
+		if part.ETag != "" && strings.Trim(part.ETag, `"`) != strings.Trim(record.etag(info.ModTime()), `"`) {
+			return fmt.Errorf("%w: part %d", ErrInvalidPart, part.PartNumber)
+		}
+		if i < len(parts)-1 && info.Size() < MinPartSize {
+			return fmt.Errorf("%w: part %d", ErrEntityTooSmall, part.PartNumber)
 		}
 	}
+	return nil
+}
 
-	return metadata
-}
-
-func (fs *FileSystemStorage) removeMetadata(objectPath string) {
-	metadataPath := objectPath + ".metadata"
-	os.Remove(metadataPath)
-}
-
-func getContentType(key string) string {
-	ext := strings.ToLower(filepath.Ext(key))
-	switch ext {
-	case ".json":
-		return "application/json"
-	case ".xml":
-		return "application/xml"
-	case ".html", ".htm":
-		return "text/html"
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".png":
-		return "image/png"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".gif":
-		return "image/gif"
-	case ".pdf":
-		return "application/pdf"
-	case ".txt":
-		return "text/plain"
-	default:
-		return "application/octet-stream"
+// contentType returns the Content-Type stored with an object, or guesses it
+// from the extension of its key
+func contentType(key string, metadata map[string]string) string {
+	if value := metadata["Content-Type"]; value != "" {
+		return value
+	}
+	if value := mime.TypeByExtension(path.Ext(key)); value != "" {
+		return value
 	}
+	return "application/octet-stream"
 }
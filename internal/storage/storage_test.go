@@ -2,8 +2,10 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -151,7 +153,7 @@ func TestObjectOperations(t *testing.T) {
 	}
 
 	// Test DeleteObject
-	err = fs.DeleteObject(bucketName, objectKey)
+	err = fs.DeleteObject(bucketName, objectKey, false)
 	if err != nil {
 		t.Fatalf("Failed to delete object: %v", err)
 	}
@@ -186,15 +188,16 @@ func TestMultipartOperations(t *testing.T) {
 	}
 
 	// Test UploadPart
-	partContent1 := []byte("part 1 content")
+	// Every part but the last must be at least MinPartSize
+	partContent1 := bytes.Repeat([]byte("1"), MinPartSize)
 	partContent2 := []byte("part 2 content")
 
-	part1, err := fs.UploadPart(bucketName, objectKey, uploadID, 1, bytes.NewReader(partContent1), int64(len(partContent1)))
+	part1, err := fs.UploadPart(bucketName, objectKey, uploadID, 1, bytes.NewReader(partContent1), int64(len(partContent1)), nil)
 	if err != nil {
 		t.Fatalf("Failed to upload part 1: %v", err)
 	}
 
-	part2, err := fs.UploadPart(bucketName, objectKey, uploadID, 2, bytes.NewReader(partContent2), int64(len(partContent2)))
+	part2, err := fs.UploadPart(bucketName, objectKey, uploadID, 2, bytes.NewReader(partContent2), int64(len(partContent2)), nil)
 	if err != nil {
 		t.Fatalf("Failed to upload part 2: %v", err)
 	}
@@ -248,3 +251,144 @@ func TestMultipartOperations(t *testing.T) {
 		t.Errorf("Failed to abort multipart upload: %v", err)
 	}
 }
+
+func TestStorageErrors(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	if _, _, err := fs.GetObject("missing", "key"); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected ErrNoSuchBucket, got %v", err)
+	}
+	if err := fs.DeleteBucket("missing"); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected ErrNoSuchBucket, got %v", err)
+	}
+
+	fs.CreateBucket("test-bucket")
+	if _, err := fs.HeadObject("test-bucket", "missing"); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Expected ErrNoSuchKey, got %v", err)
+	}
+	if err := fs.DeleteObject("test-bucket", "missing", false); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Expected ErrNoSuchKey for delete, got %v", err)
+	}
+
+	fs.PutObject("test-bucket", "key", bytes.NewReader([]byte("data")), 4, nil)
+	if err := fs.DeleteBucket("test-bucket"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v", err)
+	}
+
+	for _, uploadID := range []string{"missing", "", "../../key"} {
+		if _, err := fs.UploadPart("test-bucket", "key", uploadID, 1, bytes.NewReader(nil), 0, nil); !errors.Is(err, ErrNoSuchUpload) {
+			t.Errorf("%q: expected ErrNoSuchUpload, got %v", uploadID, err)
+		}
+	}
+	if err := fs.AbortMultipartUpload("test-bucket", "key", "missing"); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("Expected ErrNoSuchUpload for abort, got %v", err)
+	}
+}
+
+func TestCompleteMultipartUploadErrors(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+	fs.CreateBucket("test-bucket")
+
+	uploadID, err := fs.InitiateMultipartUpload("test-bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("Failed to initiate multipart upload: %v", err)
+	}
+	part1, _ := fs.UploadPart("test-bucket", "key", uploadID, 1, bytes.NewReader([]byte("small")), 5, nil)
+	part2, _ := fs.UploadPart("test-bucket", "key", uploadID, 2, bytes.NewReader([]byte("last")), 4, nil)
+
+	tests := []struct {
+		name  string
+		parts []CompletePart
+		want  error
+	}{
+		{"descending", []CompletePart{{2, part2.ETag}, {1, part1.ETag}}, ErrInvalidPartOrder},
+		{"duplicate", []CompletePart{{1, part1.ETag}, {1, part1.ETag}}, ErrInvalidPartOrder},
+		{"missing part", []CompletePart{{3, ""}}, ErrInvalidPart},
+		{"wrong ETag", []CompletePart{{1, `"0"`}}, ErrInvalidPart},
+		{"small part", []CompletePart{{1, part1.ETag}, {2, part2.ETag}}, ErrEntityTooSmall},
+	}
+	for _, tt := range tests {
+		if _, err := fs.CompleteMultipartUpload("test-bucket", "key", uploadID, tt.parts); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// A single part may be of any size
+	if _, err := fs.CompleteMultipartUpload("test-bucket", "key", uploadID, []CompletePart{{2, part2.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	if _, err := fs.CompleteMultipartUpload("test-bucket", "key", uploadID, []CompletePart{{2, part2.ETag}}); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("Expected completed upload to be gone, got %v", err)
+	}
+}
+
+func TestValidBucketName(t *testing.T) {
+	valid := []string{"abc", "my-bucket", "my.bucket.1", "1bucket", strings.Repeat("a", 63)}
+	for _, name := range valid {
+		if !ValidBucketName(name) {
+			t.Errorf("Expected %q to be valid", name)
+		}
+	}
+
+	invalid := []string{
+		"ab", strings.Repeat("a", 64), "MyBucket", "my_bucket", ".uploads", "-bucket", "bucket-", "bucket.",
+		"my..bucket", "192.168.5.4", "xn--bucket", "sthree-bucket", "bucket-s3alias", "bucket--ol-s3", "..", "a/b",
+	}
+	for _, name := range invalid {
+		if ValidBucketName(name) {
+			t.Errorf("Expected %q to be invalid", name)
+		}
+		if err := NewFileSystemStorage(t.TempDir()).CreateBucket(name); !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("%q: expected ErrInvalidBucketName, got %v", name, err)
+		}
+	}
+}
+
+func TestBucketOwnerAndLocation(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	fs.CreateBucket("test-bucket")
+	if owner, err := fs.GetBucketOwner("test-bucket"); err != nil || owner != "" {
+		t.Errorf("Expected no owner, got %q, %v", owner, err)
+	}
+	if err := fs.PutBucketOwner("test-bucket", "alice"); err != nil {
+		t.Fatalf("Failed to put owner: %v", err)
+	}
+	if err := fs.PutBucketLocation("test-bucket", "eu-west-1"); err != nil {
+		t.Fatalf("Failed to put location: %v", err)
+	}
+
+	// Settings survive a restart
+	fs = NewFileSystemStorage(tempDir)
+	if owner, _ := fs.GetBucketOwner("test-bucket"); owner != "alice" {
+		t.Errorf("Expected owner alice, got %q", owner)
+	}
+	if region, _ := fs.GetBucketLocation("test-bucket"); region != "eu-west-1" {
+		t.Errorf("Expected eu-west-1, got %q", region)
+	}
+	if _, err := fs.GetBucketLocation("missing"); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected ErrNoSuchBucket, got %v", err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		key      string
+		metadata map[string]string
+		want     string
+	}{
+		{"data.json", map[string]string{"Content-Type": "text/csv"}, "text/csv"},
+		{"data.json", nil, "application/json"},
+		{"images/cat.PNG", nil, "image/png"},
+		{"dir.json/file", nil, "application/octet-stream"},
+		{"no-extension", nil, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := contentType(tt.key, tt.metadata); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.key, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetadataWebsiteRedirectLocation makes the website endpoint redirect
+// requests for an object to another object or URL
+const MetadataWebsiteRedirectLocation = "X-Amz-Website-Redirect-Location"
+
+// WebsiteConfiguration is the static website hosting setting of a bucket
+type WebsiteConfiguration struct {
+	IndexDocument         string           `json:"indexDocument,omitempty"`
+	ErrorDocument         string           `json:"errorDocument,omitempty"`
+	RedirectAllRequestsTo *WebsiteRedirect `json:"redirectAllRequestsTo,omitempty"`
+	RoutingRules          []RoutingRule    `json:"routingRules,omitempty"`
+}
+
+// WebsiteRedirect describes where a website request is redirected to
+type WebsiteRedirect struct {
+	HostName             string `json:"hostName,omitempty"`
+	Protocol             string `json:"protocol,omitempty"`
+	HTTPRedirectCode     string `json:"httpRedirectCode,omitempty"`
+	ReplaceKeyPrefixWith string `json:"replaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string `json:"replaceKeyWith,omitempty"`
+}
+
+// RoutingRule redirects requests that match its condition. A rule without a
+// condition matches every request.
+type RoutingRule struct {
+	KeyPrefixEquals             string          `json:"keyPrefixEquals,omitempty"`
+	HTTPErrorCodeReturnedEquals string          `json:"httpErrorCodeReturnedEquals,omitempty"`
+	Redirect                    WebsiteRedirect `json:"redirect"`
+}
+
+// Validate checks the website configuration
+func (c *WebsiteConfiguration) Validate() error {
+	if c.RedirectAllRequestsTo != nil {
+		if c.IndexDocument != "" || c.ErrorDocument != "" || len(c.RoutingRules) > 0 {
+			return fmt.Errorf("RedirectAllRequestsTo cannot be combined with other website settings")
+		}
+		if c.RedirectAllRequestsTo.HostName == "" {
+			return fmt.Errorf("RedirectAllRequestsTo requires a host name")
+		}
+		return c.RedirectAllRequestsTo.validateProtocol()
+	}
+
+	if c.IndexDocument == "" {
+		return fmt.Errorf("an index document must be specified")
+	}
+	if strings.Contains(c.IndexDocument, "/") {
+		return fmt.Errorf("the index document suffix must not contain a slash")
+	}
+
+	for _, rule := range c.RoutingRules {
+		redirect := rule.Redirect
+		if redirect.ReplaceKeyPrefixWith != "" && redirect.ReplaceKeyWith != "" {
+			return fmt.Errorf("a redirect cannot specify both ReplaceKeyPrefixWith and ReplaceKeyWith")
+		}
+		if code := redirect.HTTPRedirectCode; code != "" && (len(code) != 3 || code[0] != '3') {
+			return fmt.Errorf("invalid redirect code %q", code)
+		}
+		if code := rule.HTTPErrorCodeReturnedEquals; code != "" && (len(code) != 3 || (code[0] != '4' && code[0] != '5')) {
+			return fmt.Errorf("invalid error code condition %q", code)
+		}
+		if err := redirect.validateProtocol(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *WebsiteRedirect) validateProtocol() error {
+	if r.Protocol != "" && r.Protocol != "http" && r.Protocol != "https" {
+		return fmt.Errorf("invalid protocol %q", r.Protocol)
+	}
+	return nil
+}
+
+// GetBucketWebsite returns the website configuration of a bucket, or nil if
+// website hosting is not configured
+func (fs *FileSystemStorage) GetBucketWebsite(bucket string) (*WebsiteConfiguration, error) {
+	defer fs.rlockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return nil, ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Website, nil
+}
+
+func (fs *FileSystemStorage) PutBucketWebsite(bucket string, website *WebsiteConfiguration) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+	if err := website.Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Website = website
+	return fs.storeBucketConfig(bucket, cfg)
+}
+
+func (fs *FileSystemStorage) DeleteBucketWebsite(bucket string) error {
+	defer fs.lockBucket(bucket)()
+
+	if !fs.BucketExists(bucket) {
+		return ErrNoSuchBucket
+	}
+
+	cfg, err := fs.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Website = nil
+	return fs.storeBucketConfig(bucket, cfg)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import "testing"
+
+func TestBucketWebsite(t *testing.T) {
+	fs, tempDir := setupTestStorage(t)
+	defer cleanupTestStorage(tempDir)
+
+	if err := fs.CreateBucket("site"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	website, err := fs.GetBucketWebsite("site")
+	if err != nil || website != nil {
+		t.Fatalf("Expected no website configuration, got %+v, %v", website, err)
+	}
+
+	invalid := []*WebsiteConfiguration{
+		{},
+		{IndexDocument: "docs/index.html"},
+		{IndexDocument: "index.html", RedirectAllRequestsTo: &WebsiteRedirect{HostName: "example.com"}},
+		{IndexDocument: "index.html", RoutingRules: []RoutingRule{{Redirect: WebsiteRedirect{HTTPRedirectCode: "200"}}}},
+	}
+	for _, cfg := range invalid {
+		if err := fs.PutBucketWebsite("site", cfg); err == nil {
+			t.Errorf("Expected configuration %+v to be rejected", cfg)
+		}
+	}
+
+	cfg := &WebsiteConfiguration{
+		IndexDocument: "index.html",
+		ErrorDocument: "error.html",
+		RoutingRules: []RoutingRule{
+			{KeyPrefixEquals: "old/", Redirect: WebsiteRedirect{ReplaceKeyPrefixWith: "new/"}},
+		},
+	}
+	if err := fs.PutBucketWebsite("site", cfg); err != nil {
+		t.Fatalf("Failed to put website configuration: %v", err)
+	}
+
+	website, err = fs.GetBucketWebsite("site")
+	if err != nil {
+		t.Fatalf("Failed to get website configuration: %v", err)
+	}
+	if website.IndexDocument != "index.html" || website.ErrorDocument != "error.html" || len(website.RoutingRules) != 1 {
+		t.Errorf("Unexpected website configuration %+v", website)
+	}
+
+	if err := fs.DeleteBucketWebsite("site"); err != nil {
+		t.Fatalf("Failed to delete website configuration: %v", err)
+	}
+	if website, _ := fs.GetBucketWebsite("site"); website != nil {
+		t.Errorf("Expected website configuration to be deleted, got %+v", website)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -28,19 +29,71 @@ func main() {
 	// Setup logging
 	setupLogging(cfg.LogLevel)
 
+	// "locals3 rebuild-index" recreates the object index and exits
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-index" {
+		rebuildIndex(cfg)
+		return
+	}
+
 	// Initialize storage backend
-	storageBackend := storage.NewFileSystemStorage(cfg.DataDir)
+	storageBackend := newStorage(cfg)
 
 	// Initialize auth provider
-	authProvider := auth.NewAWSV4Auth(cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	credentials, err := auth.LoadCredentialStore(cfg.CredentialsFile, cfg.AccessKey, cfg.SecretKey)
+	if err != nil {
+		logrus.Fatalf("Failed to load credentials: %v", err)
+	}
+	authProvider := auth.NewAWSV4AuthWithStore(credentials, cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	authProvider.SetSignatureV2(cfg.SignatureV2)
+	authProvider.SetMaxClockSkew(cfg.MaxClockSkew)
+	authProvider.SetDebug(cfg.SignatureDebug)
+
+	// Temporary credentials are issued into the same credential store
+	var jwks *auth.JWKS
+	if cfg.STSJWKSFile != "" {
+		jwks, err = auth.LoadJWKS(cfg.STSJWKSFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load STS JWKS: %v", err)
+		}
+	}
+	var stsMapping *auth.ClaimMapping
+	if cfg.STSPolicyFile != "" {
+		stsMapping, err = auth.LoadClaimMapping(cfg.STSPolicyFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load STS claim mapping: %v", err)
+		}
+	}
+	sts := auth.NewSTS(credentials, jwks, cfg.STSIssuer, cfg.STSAudience, stsMapping)
+
+	// Bearer tokens are checked first; every other request falls through to
+	// the AWS signature checks
+	var provider auth.AuthProvider = authProvider
+	if verifier := bearerVerifier(cfg); verifier != nil {
+		var mapping *auth.ClaimMapping
+		if cfg.BearerPolicyFile != "" {
+			mapping, err = auth.LoadClaimMapping(cfg.BearerPolicyFile)
+			if err != nil {
+				logrus.Fatalf("Failed to load bearer claim mapping: %v", err)
+			}
+		}
+		provider = auth.NewBearerAuth(verifier, authProvider, cfg.BearerIssuer, cfg.BearerAudience, mapping)
+	}
+
+	var authorizer auth.Authorizer
+	if cfg.AuthzWebhookURL != "" {
+		authorizer = auth.NewWebhookAuthorizer(cfg.AuthzWebhookURL, cfg.AuthzTimeout, cfg.AuthzCacheTTL, cfg.AuthzFailOpen)
+	}
 
 	// Initialize handlers
 	handlerConfig := &handlers.Config{
-		Storage:     storageBackend,
-		Auth:        authProvider,
-		Region:      cfg.Region,
-		BaseDomain:  cfg.BaseDomain,
-		DisableAuth: cfg.DisableAuth,
+		Storage:       storageBackend,
+		Auth:          provider,
+		Region:        cfg.Region,
+		BaseDomain:    cfg.BaseDomain,
+		DisableAuth:   cfg.DisableAuth,
+		WebsiteDomain: cfg.WebsiteDomain,
+		STS:           sts,
+		Authorizer:    authorizer,
 	}
 	h := handlers.New(handlerConfig)
 
@@ -58,15 +111,38 @@ func main() {
 	// Start server
 	go func() {
 		logrus.Infof("Starting LocalS3 server on port %d", cfg.Port)
-		logrus.Infof("Data directory: %s", cfg.DataDir)
+		if cfg.StorageBackend == "memory" {
+			logrus.Infof("Storage: memory (limit %d bytes, 0 for none)", cfg.MemoryLimit)
+		} else {
+			logrus.Infof("Data directory: %s", cfg.DataDir)
+		}
 		logrus.Infof("Region: %s", cfg.Region)
-		logrus.Infof("Access Key: %s", cfg.AccessKey)
+		logrus.Infof("Credentials: %s (%d users)", cfg.CredentialsFile, len(credentials.Users()))
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logrus.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
+	// Start the website endpoint on its own port if configured
+	var websiteServer *http.Server
+	if cfg.WebsitePort != 0 {
+		websiteServer = &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.WebsitePort),
+			Handler:      setupWebsiteRouter(h),
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+
+		go func() {
+			logrus.Infof("Starting website endpoint on port %d", cfg.WebsitePort)
+
+			if err := websiteServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.Fatalf("Failed to start website endpoint: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -81,10 +157,91 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		logrus.Errorf("Server shutdown error: %v", err)
 	}
+	if websiteServer != nil {
+		if err := websiteServer.Shutdown(ctx); err != nil {
+			logrus.Errorf("Website endpoint shutdown error: %v", err)
+		}
+	}
+
+	if closer, ok := storageBackend.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logrus.Errorf("Storage shutdown error: %v", err)
+		}
+	}
 
 	logrus.Info("Server stopped")
 }
 
+// newStorage returns the configured storage backend. The filesystem backend
+// first finishes interrupted writes, migrates metadata of earlier versions
+// and opens its object index.
+func newStorage(cfg *config.Config) storage.Storage {
+	if cfg.StorageBackend == "memory" {
+		return storage.NewMemoryStorage(cfg.MemoryLimit)
+	}
+
+	fs := storage.NewFileSystemStorage(cfg.DataDir)
+	if err := fs.Recover(); err != nil {
+		logrus.Fatalf("Failed to recover interrupted writes: %v", err)
+	}
+	if migrated, err := fs.MigrateMetadata(); err != nil {
+		logrus.Fatalf("Failed to migrate the data directory: %v", err)
+	} else if migrated > 0 {
+		logrus.Infof("Migrated %d files to the current format", migrated)
+		// Migrated records may not be in the index yet
+		if _, err := fs.RebuildIndex(); err != nil {
+			logrus.Fatalf("Failed to rebuild object index: %v", err)
+		}
+	}
+
+	start := time.Now()
+	if rebuilt, err := fs.OpenIndex(); err != nil {
+		logrus.Fatalf("Failed to open object index: %v", err)
+	} else if rebuilt {
+		logrus.Infof("Rebuilt the object index in %v", time.Since(start).Round(time.Millisecond))
+	}
+	return fs
+}
+
+// rebuildIndex recreates the object index of the filesystem backend from the
+// data files. The server must not be running.
+func rebuildIndex(cfg *config.Config) {
+	if cfg.StorageBackend != "filesystem" {
+		logrus.Fatalf("The %s storage backend has no object index", cfg.StorageBackend)
+	}
+
+	fs := storage.NewFileSystemStorage(cfg.DataDir)
+	if err := fs.Recover(); err != nil {
+		logrus.Fatalf("Failed to recover interrupted writes: %v", err)
+	}
+	if _, err := fs.MigrateMetadata(); err != nil {
+		logrus.Fatalf("Failed to migrate the data directory: %v", err)
+	}
+
+	start := time.Now()
+	count, err := fs.RebuildIndex()
+	if err != nil {
+		logrus.Fatalf("Failed to rebuild object index: %v", err)
+	}
+	logrus.Infof("Indexed %d objects in %v", count, time.Since(start).Round(time.Millisecond))
+}
+
+// bearerVerifier returns the verifier for bearer tokens, or nil if bearer
+// authentication is not configured. A JWKS file takes precedence over a URL.
+func bearerVerifier(cfg *config.Config) auth.TokenVerifier {
+	switch {
+	case cfg.BearerJWKSFile != "":
+		jwks, err := auth.LoadJWKS(cfg.BearerJWKSFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load bearer JWKS: %v", err)
+		}
+		return jwks
+	case cfg.BearerJWKSURL != "":
+		return auth.NewRemoteJWKS(cfg.BearerJWKSURL)
+	}
+	return nil
+}
+
 func setupLogging(level string) {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
@@ -110,34 +267,82 @@ func setupRouter(h *handlers.Handler) *mux.Router {
 	// Health check endpoint
 	router.HandleFunc("/health", h.HealthCheck).Methods("GET")
 
+	// Website endpoints, addressed as <bucket>.<website domain>
+	router.MatcherFunc(h.IsWebsiteRequest).HandlerFunc(h.ServeWebsite)
+
+	// STS endpoint, POST / with a form body or /?Action=
+	router.MatcherFunc(h.IsSTSRequest).HandlerFunc(h.STS)
+
 	// S3 API endpoints
 	s3Router := router.PathPrefix("/").Subrouter()
 
+	// Bucket configuration operations
+	s3Router.HandleFunc("/{bucket}", h.GetObjectLockConfiguration).Methods("GET").Queries("object-lock", "")
+	s3Router.HandleFunc("/{bucket}", h.PutObjectLockConfiguration).Methods("PUT").Queries("object-lock", "")
+	s3Router.HandleFunc("/{bucket}", h.GetBucketWebsite).Methods("GET").Queries("website", "")
+	s3Router.HandleFunc("/{bucket}", h.PutBucketWebsite).Methods("PUT").Queries("website", "")
+	s3Router.HandleFunc("/{bucket}", h.DeleteBucketWebsite).Methods("DELETE").Queries("website", "")
+	s3Router.HandleFunc("/{bucket}", h.GetPublicAccessBlock).Methods("GET").Queries("publicAccessBlock", "")
+	s3Router.HandleFunc("/{bucket}", h.PutPublicAccessBlock).Methods("PUT").Queries("publicAccessBlock", "")
+	s3Router.HandleFunc("/{bucket}", h.DeletePublicAccessBlock).Methods("DELETE").Queries("publicAccessBlock", "")
+	s3Router.HandleFunc("/{bucket}", h.GetBucketPolicy).Methods("GET").Queries("policy", "")
+	s3Router.HandleFunc("/{bucket}", h.PutBucketPolicy).Methods("PUT").Queries("policy", "")
+	s3Router.HandleFunc("/{bucket}", h.DeleteBucketPolicy).Methods("DELETE").Queries("policy", "")
+	s3Router.HandleFunc("/{bucket}", h.GetBucketLocation).Methods("GET").Queries("location", "")
+	s3Router.HandleFunc("/{bucket}", h.GetBucketAcl).Methods("GET").Queries("acl", "")
+	s3Router.HandleFunc("/{bucket}", h.PutBucketAcl).Methods("PUT").Queries("acl", "")
+
 	// Bucket operations
 	s3Router.HandleFunc("/", h.ListBuckets).Methods("GET")
 	s3Router.HandleFunc("/{bucket}", h.CreateBucket).Methods("PUT")
 	s3Router.HandleFunc("/{bucket}", h.DeleteBucket).Methods("DELETE")
+	s3Router.HandleFunc("/{bucket}", h.PostObject).Methods("POST")
+	s3Router.HandleFunc("/{bucket}/", h.PostObject).Methods("POST")
 	s3Router.HandleFunc("/{bucket}", h.ListObjects).Methods("GET")
 	s3Router.HandleFunc("/{bucket}/", h.ListObjects).Methods("GET")
 
+	// Multipart upload operations (registered before the plain object routes,
+	// which would otherwise match them first)
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.InitiateMultipartUpload).Methods("POST").Queries("uploads", "")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.UploadPart).Methods("PUT").Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.CompleteMultipartUpload).Methods("POST").Queries("uploadId", "{uploadId}")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.AbortMultipartUpload).Methods("DELETE").Queries("uploadId", "{uploadId}")
+
+	// S3 Select
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.SelectObjectContent).Methods("POST").Queries("select", "", "select-type", "2")
+
+	// Object lock operations
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.GetObjectRetention).Methods("GET").Queries("retention", "")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.PutObjectRetention).Methods("PUT").Queries("retention", "")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.GetObjectLegalHold).Methods("GET").Queries("legal-hold", "")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.PutObjectLegalHold).Methods("PUT").Queries("legal-hold", "")
+
+	// Object ACL operations
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.GetObjectAcl).Methods("GET").Queries("acl", "")
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.PutObjectAcl).Methods("PUT").Queries("acl", "")
+
 	// Object operations
+	s3Router.HandleFunc("/{bucket}/{key:.*}", h.CopyObject).Methods("PUT").Headers("X-Amz-Copy-Source", "")
 	s3Router.HandleFunc("/{bucket}/{key:.*}", h.PutObject).Methods("PUT")
 	s3Router.HandleFunc("/{bucket}/{key:.*}", h.GetObject).Methods("GET")
 	s3Router.HandleFunc("/{bucket}/{key:.*}", h.DeleteObject).Methods("DELETE")
 	s3Router.HandleFunc("/{bucket}/{key:.*}", h.HeadObject).Methods("HEAD")
 
-	// Multipart upload operations
-	s3Router.HandleFunc("/{bucket}/{key:.*}", h.InitiateMultipartUpload).Methods("POST").Queries("uploads", "")
-	s3Router.HandleFunc("/{bucket}/{key:.*}", h.UploadPart).Methods("PUT").Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}")
-	s3Router.HandleFunc("/{bucket}/{key:.*}", h.CompleteMultipartUpload).Methods("POST").Queries("uploadId", "{uploadId}")
-	s3Router.HandleFunc("/{bucket}/{key:.*}", h.AbortMultipartUpload).Methods("DELETE").Queries("uploadId", "{uploadId}")
-
 	// CORS middleware
 	router.Use(corsMiddleware)
 
 	return router
 }
 
+// setupWebsiteRouter serves only website endpoints, with the bucket taken from
+// the host name or the first path segment
+func setupWebsiteRouter(h *handlers.Handler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/health", h.HealthCheck).Methods("GET")
+	router.PathPrefix("/").HandlerFunc(h.ServeWebsite)
+	return router
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")